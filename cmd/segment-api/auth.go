@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/auth"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/config"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/storage"
+)
+
+// newAuthenticator creates the authenticator of API keys and, when auth.jwt.jwks is set, bearer tokens.
+// It returns nil when authentication is disabled.
+func newAuthenticator(ctx context.Context, cfg config.Config, log *slog.Logger, keys storage.APIKeyStorage) (*auth.Authenticator, error) {
+	if !cfg.Auth.Enabled {
+		log.Warn("authentication is disabled")
+		return nil, nil
+	}
+
+	if cfg.Auth.AdminKey == "" {
+		return nil, errors.New("auth.admin-key (AUTH_ADMIN_KEY) must be set when authentication is enabled")
+	}
+
+	var tokens *auth.TokenVerifier
+	if jwtCfg := cfg.Auth.JWT; jwtCfg.JWKS != "" {
+		keySet, err := auth.NewKeySet(ctx, jwtCfg.JWKS, jwtCfg.JWKSRefresh)
+		if err != nil {
+			return nil, err
+		}
+		tokens = auth.NewTokenVerifier(keySet, auth.TokenConfig{
+			Issuer:     jwtCfg.Issuer,
+			Audience:   jwtCfg.Audience,
+			RolesClaim: jwtCfg.RolesClaim,
+		})
+		log.Info("bearer tokens enabled", slog.String("jwks", jwtCfg.JWKS), slog.String("issuer", jwtCfg.Issuer))
+	}
+
+	return auth.New(keys, cfg.Auth.AdminKey, tokens), nil
+}
@@ -2,8 +2,10 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -13,13 +15,23 @@ import (
 	_ "github.com/lolwhatvvw/backend-trainee-assignment-2023/docs"
 	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/config"
 	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/handler"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/health"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/idempotency"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/logger"
 	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/router"
-	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/storage/postgres"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/tracing"
 	httpswagger "github.com/swaggo/http-swagger/v2"
 )
 
 // @title Segment service API
 // @version 1.0
+// @securityDefinitions.apikey ApiKeyAuth
+// @in header
+// @name X-API-Key
+// @securityDefinitions.apikey BearerAuth
+// @in header
+// @name Authorization
+// @description OIDC access token as "Bearer <token>"
 
 const GracefulShutdownTimeout = 10 * time.Second
 
@@ -27,30 +39,83 @@ func main() {
 
 	cfg := config.MustLoad()
 
-	log := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	log, err := logger.New(os.Stdout, cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create logger: %v\n", err)
+		os.Exit(1)
+	}
+	slog.SetDefault(log)
 
-	log.Info(fmt.Sprintf("starting %s on address %s and env=%s",
-		cfg.Application.Name,
-		fmt.Sprintf("%s", cfg.Server.Port),
-		cfg.Env,
-	))
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(runMigrate(cfg, log, os.Args[2:]))
+	}
+
+	log.Info("starting",
+		slog.String("application", cfg.Application.Name),
+		slog.String("port", cfg.Server.Port),
+		slog.String("env", cfg.Env),
+	)
+
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg)
+	if err != nil {
+		log.Error("failed to set up tracing", slog.String("exporter", cfg.Tracing.Exporter), slog.Any("error", err))
+		os.Exit(1)
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			log.Error("failed to flush traces", slog.Any("error", err))
+		}
+	}()
 
-	conn, err := postgres.NewConnection(cfg)
+	h := health.New()
 
-	userStorage, err := postgres.NewUserStorage(conn)
+	stores, err := newStorages(cfg, log, h)
 	if err != nil {
-		log.Error(fmt.Sprintf("failed to connect to database %#v", cfg.Database), err)
+		log.Error("failed to create storage", slog.String("driver", cfg.Storage.Driver), slog.Any("error", err))
+		os.Exit(1)
 	}
 
-	segmentStorage, err := postgres.NewSegmentStorage(conn, userStorage)
+	// the cache wraps the instrumented storages, so storage latencies are of lookups missing the cache
+	userStorage, segmentStorage, err := withMetrics(stores.users, stores.segments)
 	if err != nil {
-		log.Error(fmt.Sprintf("failed to connect to database %#v", cfg.Database), err)
+		log.Error("failed to instrument storage", slog.Any("error", err))
+		os.Exit(1)
+	}
+
+	userStorage, segmentStorage, cacheLayer, err := withCache(cfg, userStorage, segmentStorage)
+	if err != nil {
+		log.Error("failed to create cache", slog.Any("error", err))
+		os.Exit(1)
+	}
+
+	if err := registerMetrics(cfg, segmentStorage, cacheLayer); err != nil {
+		log.Error("failed to register metrics", slog.Any("error", err))
+		os.Exit(1)
+	}
+
+	reaperCtx, stopReaper := context.WithCancel(context.Background())
+	defer stopReaper()
+	if cfg.Reaper.Interval > 0 {
+		go runReaper(reaperCtx, log, userStorage, segmentStorage, stores.idempotencyKeys, cfg.Reaper.Interval)
+	} else {
+		log.Warn("reaper is disabled, expired memberships, segment aliases and idempotency keys are kept")
 	}
 
 	userController := handler.NewUserHandler(userStorage)
 	segmentController := handler.NewSegmentHandler(segmentStorage)
+	apiKeyController := handler.NewAPIKeyHandler(stores.apiKeys)
+	auditController := handler.NewAuditHandler(stores.audit)
 
-	r := router.GetRouter(userController, segmentController)
+	authenticator, err := newAuthenticator(context.Background(), cfg, log, stores.apiKeys)
+	if err != nil {
+		log.Error("failed to create authenticator", slog.Any("error", err))
+		os.Exit(1)
+	}
+
+	idempotent := idempotency.Middleware(stores.idempotencyKeys, cfg.Idempotency.TTL)
+	preconditions := handler.RequireIfMatch(cfg.Server.RequireIfMatch)
+
+	r := router.GetRouter(log, h, authenticator, idempotent, preconditions, userController, segmentController, apiKeyController, auditController)
 
 	r.Get("/swagger/*", httpswagger.Handler(
 		httpswagger.URL(fmt.Sprintf("http://localhost:%s/swagger/doc.json", cfg.Server.Port)),
@@ -59,30 +124,44 @@ func main() {
 	done := make(chan os.Signal, 1)
 	signal.Notify(done, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
 
+	// requests context is cancelled when graceful shutdown times out, aborting their queries
+	requestsCtx, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+
 	srv := &http.Server{
 		Addr:         fmt.Sprintf(":%s", cfg.Server.Port),
 		Handler:      r,
 		ReadTimeout:  cfg.Server.Timeout,
 		WriteTimeout: cfg.Server.Timeout,
 		IdleTimeout:  cfg.Server.IdleTimeout,
+		BaseContext: func(net.Listener) context.Context {
+			return requestsCtx
+		},
 	}
 
 	go func() {
-		if err := srv.ListenAndServe(); err != nil {
-			log.Error("server shutting down")
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error("server failed", slog.Any("error", err))
+			done <- syscall.SIGTERM
 		}
 	}()
 
 	log.Info("server started")
 
 	<-done
-	log.Info("gracefully stopping server")
+	log.Info("gracefully stopping server", slog.Duration("delay", cfg.Server.ShutdownDelay))
+
+	h.ShutDown()
+	time.Sleep(cfg.Server.ShutdownDelay)
+
+	stopReaper()
 
 	ctx, cancel := context.WithTimeout(context.Background(), GracefulShutdownTimeout)
 	defer cancel()
 
 	if err := srv.Shutdown(ctx); err != nil {
-		log.Error("failed to stop server")
+		cancelRequests()
+		log.Error("failed to stop server", slog.Any("error", err))
 		os.Exit(1)
 	}
 
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/config"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/storage/postgres"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/schema"
+)
+
+const migrateUsage = "usage: segment-api migrate up | down [steps] | status"
+
+// runMigrate runs the migrate subcommand and returns the exit code.
+func runMigrate(cfg config.Config, log *slog.Logger, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	steps := 1
+	if args[0] == "down" && len(args) > 1 {
+		value, err := strconv.Atoi(args[1])
+		if err != nil || value < 1 {
+			fmt.Fprintln(os.Stderr, migrateUsage)
+			return 2
+		}
+		steps = value
+	}
+
+	conn, err := postgres.NewConnection(cfg)
+	if err != nil {
+		log.Error("failed to connect to database", slog.Any("error", err))
+		return 1
+	}
+
+	migrator, err := postgres.NewMigrator(conn, schema.Migrations)
+	if err != nil {
+		log.Error("failed to load migrations", slog.Any("error", err))
+		return 1
+	}
+
+	ctx := context.Background()
+
+	switch args[0] {
+	case "up":
+		err = migrateUp(ctx, log, migrator)
+	case "down":
+		var reverted []postgres.Migration
+		reverted, err = migrator.Down(ctx, steps)
+		for _, migration := range reverted {
+			log.Info("reverted migration", slog.Int64("version", migration.Version), slog.String("name", migration.Name))
+		}
+	case "status":
+		err = printMigrationStatus(ctx, migrator)
+	default:
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	if err != nil {
+		log.Error("failed to migrate", slog.String("command", args[0]), slog.Any("error", err))
+		return 1
+	}
+
+	return 0
+}
+
+// migrateUp applies the pending embedded migrations.
+func migrateUp(ctx context.Context, log *slog.Logger, migrator *postgres.Migrator) error {
+	applied, err := migrator.Up(ctx)
+	for _, migration := range applied {
+		log.Info("applied migration", slog.Int64("version", migration.Version), slog.String("name", migration.Name))
+	}
+
+	return err
+}
+
+func printMigrationStatus(ctx context.Context, migrator *postgres.Migrator) error {
+	statuses, err := migrator.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+	for _, status := range statuses {
+		appliedAt := "pending"
+		if status.AppliedAt != nil {
+			appliedAt = status.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%04d\t%s\t%s\n", status.Version, status.Name, appliedAt)
+	}
+
+	return w.Flush()
+}
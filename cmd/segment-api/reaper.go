@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/storage"
+)
+
+// runReaper removes user memberships, segment aliases and idempotency keys whose TTL has passed every interval
+// until ctx is done. The interval must be positive.
+func runReaper(ctx context.Context, log *slog.Logger, us storage.UserStorage, ss storage.SegmentStorage, keys storage.IdempotencyStorage, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			deleted, err := us.DeleteExpiredSegments(ctx, now)
+			if err != nil {
+				log.Error("failed to delete expired segments", slog.Any("error", err))
+			} else if deleted > 0 {
+				log.Info("deleted expired segments", slog.Int64("count", deleted))
+			}
+
+			deleted, err = ss.DeleteExpiredAliases(ctx, now)
+			if err != nil {
+				log.Error("failed to delete expired segment aliases", slog.Any("error", err))
+			} else if deleted > 0 {
+				log.Info("deleted expired segment aliases", slog.Int64("count", deleted))
+			}
+
+			deleted, err = keys.DeleteExpiredIdempotencyKeys(ctx, now)
+			if err != nil {
+				log.Error("failed to delete expired idempotency keys", slog.Any("error", err))
+			} else if deleted > 0 {
+				log.Debug("deleted expired idempotency keys", slog.Int64("count", deleted))
+			}
+		}
+	}
+}
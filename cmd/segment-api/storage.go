@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/config"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/health"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/metrics"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/storage"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/storage/cache"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/storage/memory"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/storage/postgres"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/schema"
+)
+
+// storages are the storages of the configured driver.
+type storages struct {
+	users    storage.UserStorage
+	segments storage.SegmentStorage
+	apiKeys  storage.APIKeyStorage
+	audit    storage.AuditStorage
+
+	idempotencyKeys storage.IdempotencyStorage
+}
+
+// newStorages creates the storages of the configured driver.
+// Pending migrations are applied to PostgreSQL unless database.auto-migrate is disabled,
+// and the readiness checks of the database are added to h.
+func newStorages(cfg config.Config, log *slog.Logger, h *health.Health) (*storages, error) {
+	switch cfg.Storage.Driver {
+	case "memory":
+		db := memory.NewDB()
+
+		userStorage, err := memory.NewUserStorage(db)
+		if err != nil {
+			return nil, err
+		}
+
+		segmentStorage, err := memory.NewSegmentStorage(db, userStorage)
+		if err != nil {
+			return nil, err
+		}
+
+		apiKeyStorage, err := memory.NewAPIKeyStorage(db)
+		if err != nil {
+			return nil, err
+		}
+
+		auditStorage, err := memory.NewAuditStorage(db)
+		if err != nil {
+			return nil, err
+		}
+
+		idempotencyStorage, err := memory.NewIdempotencyStorage(db)
+		if err != nil {
+			return nil, err
+		}
+
+		return &storages{
+			users:           userStorage,
+			segments:        segmentStorage,
+			apiKeys:         apiKeyStorage,
+			audit:           auditStorage,
+			idempotencyKeys: idempotencyStorage,
+		}, nil
+	case "postgres":
+		conn, err := postgres.NewConnection(cfg)
+		if err != nil {
+			return nil, err
+		}
+
+		migrator, err := postgres.NewMigrator(conn, schema.Migrations)
+		if err != nil {
+			return nil, err
+		}
+
+		if cfg.Database.AutoMigrate {
+			if err := migrateUp(context.Background(), log, migrator); err != nil {
+				return nil, err
+			}
+		}
+		h.AddCheck("postgres", postgres.Ping(conn))
+		h.AddCheck("migrations", migrator.CheckApplied)
+
+		sqlDB, err := conn.DB()
+		if err != nil {
+			return nil, err
+		}
+		if err := metrics.RegisterDB(sqlDB, cfg.Database.DbName); err != nil {
+			return nil, err
+		}
+
+		userStorage, err := postgres.NewUserStorage(conn)
+		if err != nil {
+			return nil, err
+		}
+
+		segmentStorage, err := postgres.NewSegmentStorage(conn, userStorage)
+		if err != nil {
+			return nil, err
+		}
+
+		apiKeyStorage, err := postgres.NewAPIKeyStorage(conn)
+		if err != nil {
+			return nil, err
+		}
+
+		auditStorage, err := postgres.NewAuditStorage(conn)
+		if err != nil {
+			return nil, err
+		}
+
+		idempotencyStorage, err := postgres.NewIdempotencyStorage(conn)
+		if err != nil {
+			return nil, err
+		}
+
+		return &storages{
+			users:           userStorage,
+			segments:        segmentStorage,
+			apiKeys:         apiKeyStorage,
+			audit:           auditStorage,
+			idempotencyKeys: idempotencyStorage,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown storage driver '%s'", cfg.Storage.Driver)
+	}
+}
+
+// withMetrics decorates the storages recording the latency of their methods.
+func withMetrics(us storage.UserStorage, ss storage.SegmentStorage) (storage.UserStorage, storage.SegmentStorage, error) {
+	instrumentedUsers, err := metrics.NewUserStorage(us)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	instrumentedSegments, err := metrics.NewSegmentStorage(ss)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return instrumentedUsers, instrumentedSegments, nil
+}
+
+// withCache decorates the storages with the cache of user segment lookups unless cache.size is 0,
+// the returned layer is nil then.
+func withCache(cfg config.Config, us storage.UserStorage, ss storage.SegmentStorage) (storage.UserStorage, storage.SegmentStorage, *cache.Layer, error) {
+	if cfg.Cache.Size <= 0 {
+		return us, ss, nil, nil
+	}
+
+	layer := cache.NewLayer(cache.NewLRU(cfg.Cache.Size), cfg.Cache.TTL)
+
+	cachedUsers, err := cache.NewUserStorage(us, layer)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	cachedSegments, err := cache.NewSegmentStorage(ss, layer)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return cachedUsers, cachedSegments, layer, nil
+}
+
+// registerMetrics exposes the domain gauges and the cache counters when the cache is enabled.
+func registerMetrics(cfg config.Config, ss storage.SegmentStorage, layer *cache.Layer) error {
+	if err := metrics.RegisterSegments(ss, cfg.Metrics.SegmentUsersTTL); err != nil {
+		return err
+	}
+	if layer != nil {
+		return metrics.RegisterCache(layer)
+	}
+	return nil
+}
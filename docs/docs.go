@@ -5,7 +5,7 @@ import "github.com/swaggo/swag"
 
 const docTemplate = `{
     "schemes": {{ marshal .Schemes }},
-    "swagger.jpg": "2.0",
+    "swagger": "2.0",
     "info": {
         "description": "{{escape .Description}}",
         "title": "{{.Title}}",
@@ -15,9 +15,17 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
-        "/api/v1/segments": {
+        "/api/v1/api-keys": {
             "get": {
-                "description": "Returns a list of all segments in the system",
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns all API keys sorted by ID, keys are identified by their prefix",
                 "consumes": [
                     "application/json"
                 ],
@@ -25,21 +33,24 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "segments"
+                    "api-keys"
                 ],
-                "summary": "List all segments",
+                "summary": "List API keys",
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "type": "array",
-                            "items": {
-                                "$ref": "#/definitions/models.Segment"
-                            }
+                            "$ref": "#/definitions/handler.APIKeysResponse"
                         }
                     },
-                    "400": {
-                        "description": "Bad Request",
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
                         "schema": {
                             "$ref": "#/definitions/handler.ErrorResponse"
                         }
@@ -47,7 +58,15 @@ const docTemplate = `{
                 }
             },
             "post": {
-                "description": "Creates a new segment in the system",
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Creates an API key with the scopes. The key is returned only in this response, only its hash is stored.\nThe \"admin\" scope grants every other scope and managing API keys.",
                 "consumes": [
                     "application/json"
                 ],
@@ -55,17 +74,17 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "segments"
+                    "api-keys"
                 ],
-                "summary": "Create a new segment",
+                "summary": "Create an API key",
                 "parameters": [
                     {
-                        "description": "The segment to create",
-                        "name": "segment",
+                        "description": "The API key to create",
+                        "name": "key",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/handler.CreateSegmentRequest"
+                            "$ref": "#/definitions/handler.CreateAPIKeyRequest"
                         }
                     }
                 ],
@@ -73,7 +92,7 @@ const docTemplate = `{
                     "201": {
                         "description": "Created",
                         "schema": {
-                            "$ref": "#/definitions/models.Segment"
+                            "$ref": "#/definitions/handler.CreateAPIKeyResponse"
                         }
                     },
                     "400": {
@@ -81,13 +100,33 @@ const docTemplate = `{
                         "schema": {
                             "$ref": "#/definitions/handler.ErrorResponse"
                         }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
                     }
                 }
             }
         },
-        "/api/v1/segments/{slug}": {
-            "get": {
-                "description": "Returns a single segment by slug",
+        "/api/v1/api-keys/{id}": {
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Deletes an API key, requests with it are rejected right away",
                 "consumes": [
                     "application/json"
                 ],
@@ -95,24 +134,21 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "segments"
+                    "api-keys"
                 ],
-                "summary": "Get a segment",
+                "summary": "Revoke an API key",
                 "parameters": [
                     {
-                        "type": "string",
-                        "description": "Slug of the segment to retrieve",
-                        "name": "slug",
+                        "type": "integer",
+                        "description": "ID of the API key to delete",
+                        "name": "id",
                         "in": "path",
                         "required": true
                     }
                 ],
                 "responses": {
-                    "200": {
-                        "description": "OK",
-                        "schema": {
-                            "$ref": "#/definitions/models.Segment"
-                        }
+                    "204": {
+                        "description": "No Content"
                     },
                     "400": {
                         "description": "Bad Request",
@@ -120,6 +156,18 @@ const docTemplate = `{
                             "$ref": "#/definitions/handler.ErrorResponse"
                         }
                     },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
                     "404": {
                         "description": "Not Found",
                         "schema": {
@@ -127,9 +175,19 @@ const docTemplate = `{
                         }
                     }
                 }
-            },
-            "put": {
-                "description": "Updates an existing segment by slug",
+            }
+        },
+        "/api/v1/audit": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns a page of audit entries of changes made through the API in the order of the changes.\nEvery entry has the actor, the action, the resource and its JSON before and after the change.\nMemberships are identified by \"\u003csegment\u003e:\u003cuser ID\u003e\", changes of all user segments are recorded on the user.",
                 "consumes": [
                     "application/json"
                 ],
@@ -137,30 +195,100 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "segments"
+                    "audit"
                 ],
-                "summary": "Update a segment",
+                "summary": "List audit entries",
                 "parameters": [
+                    {
+                        "maximum": 1000,
+                        "type": "integer",
+                        "default": 100,
+                        "description": "Page size",
+                        "name": "limit",
+                        "in": "query"
+                    },
                     {
                         "type": "string",
-                        "description": "Slug of the segment to update",
-                        "name": "slug",
-                        "in": "path",
-                        "required": true
+                        "description": "Cursor of the next page returned with the previous page",
+                        "name": "cursor",
+                        "in": "query"
                     },
                     {
-                        "description": "The segment data to update",
-                        "name": "segment",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/models.Segment"
-                        }
+                        "enum": [
+                            "asc",
+                            "desc"
+                        ],
+                        "type": "string",
+                        "default": "asc",
+                        "description": "Sort order",
+                        "name": "order",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Count all entries matching the filter",
+                        "name": "with_total",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Actor, such as api-key:3 or user:\u003csubject\u003e",
+                        "name": "actor",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "user.create",
+                            "user.update",
+                            "user.delete",
+                            "user.segments.update",
+                            "segment.create",
+                            "segment.update",
+                            "segment.delete",
+                            "membership.add",
+                            "membership.remove"
+                        ],
+                        "type": "string",
+                        "description": "Action",
+                        "name": "action",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "user",
+                            "segment",
+                            "membership"
+                        ],
+                        "type": "string",
+                        "description": "Resource type",
+                        "name": "resource_type",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Resource ID",
+                        "name": "resource_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only entries created at or after the time in RFC 3339 format",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only entries created before the time in RFC 3339 format",
+                        "name": "to",
+                        "in": "query"
                     }
                 ],
                 "responses": {
-                    "204": {
-                        "description": "No Content"
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handler.AuditPage"
+                        }
                     },
                     "400": {
                         "description": "Bad Request",
@@ -168,16 +296,38 @@ const docTemplate = `{
                             "$ref": "#/definitions/handler.ErrorResponse"
                         }
                     },
-                    "404": {
-                        "description": "Not Found",
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
                         "schema": {
                             "$ref": "#/definitions/handler.ErrorResponse"
                         }
                     }
                 }
-            },
-            "delete": {
-                "description": "Deletes an existing segment by slug",
+            }
+        },
+        "/api/v1/evaluate": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns the slugs of the active segments of each user like GET /api/v1/evaluate/{user_id} does,\nat most 1000 users at once. Fails with 404 when any of the users does not exist.",
                 "consumes": [
                     "application/json"
                 ],
@@ -185,21 +335,26 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "segments"
+                    "evaluate"
                 ],
-                "summary": "Delete a segment",
+                "summary": "Evaluate the segments of many users",
                 "parameters": [
                     {
-                        "type": "string",
-                        "description": "Slug of the segment to delete",
-                        "name": "slug",
-                        "in": "path",
-                        "required": true
+                        "description": "IDs of the users to evaluate",
+                        "name": "users",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handler.EvaluateRequest"
+                        }
                     }
                 ],
                 "responses": {
-                    "204": {
-                        "description": "No Content"
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handler.EvaluateResponse"
+                        }
                     },
                     "400": {
                         "description": "Bad Request",
@@ -207,33 +362,56 @@ const docTemplate = `{
                             "$ref": "#/definitions/handler.ErrorResponse"
                         }
                     },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
                     "404": {
                         "description": "Not Found",
                         "schema": {
                             "$ref": "#/definitions/handler.ErrorResponse"
                         }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
                     }
                 }
             }
         },
-        "/api/v1/segments/{slug}/users": {
+        "/api/v1/evaluate/{user_id}": {
             "get": {
-                "description": "Returns a list of all users in the specified segment",
-                "consumes": [
-                    "application/json"
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
                 ],
+                "description": "Returns the slugs of the active segments of the user: explicit memberships whose TTL has not passed,\nexperiments the user falls into and segments whose rule the user matches, with the variant of each\nsegment with variants. Unlike the user endpoints it reads only what is needed to resolve the slugs.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "segments"
+                    "evaluate"
                 ],
-                "summary": "List all users in a segment",
+                "summary": "Evaluate the segments of a user",
                 "parameters": [
                     {
-                        "type": "string",
-                        "description": "Slug of the segment to retrieve users for",
-                        "name": "slug",
+                        "type": "integer",
+                        "description": "ID of the user to evaluate",
+                        "name": "user_id",
                         "in": "path",
                         "required": true
                     }
@@ -242,10 +420,7 @@ const docTemplate = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "type": "array",
-                            "items": {
-                                "$ref": "#/definitions/models.User"
-                            }
+                            "$ref": "#/definitions/models.Evaluation"
                         }
                     },
                     "400": {
@@ -254,18 +429,44 @@ const docTemplate = `{
                             "$ref": "#/definitions/handler.ErrorResponse"
                         }
                     },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
                     "404": {
                         "description": "Not Found",
                         "schema": {
                             "$ref": "#/definitions/handler.ErrorResponse"
                         }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
                     }
                 }
             }
         },
-        "/api/v1/segments/{slug}/users/{id}": {
-            "put": {
-                "description": "Adds a user to the specified segment",
+        "/api/v1/segments": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns a page of segments sorted by name or created_at, segments are returned without users",
                 "consumes": [
                     "application/json"
                 ],
@@ -275,26 +476,69 @@ const docTemplate = `{
                 "tags": [
                     "segments"
                 ],
-                "summary": "Add a user to a segment",
+                "summary": "List segments",
                 "parameters": [
+                    {
+                        "maximum": 1000,
+                        "type": "integer",
+                        "default": 100,
+                        "description": "Page size",
+                        "name": "limit",
+                        "in": "query"
+                    },
                     {
                         "type": "string",
-                        "description": "Slug of the segment to add the user to",
-                        "name": "slug",
-                        "in": "path",
-                        "required": true
+                        "description": "Cursor of the next page returned with the previous page",
+                        "name": "cursor",
+                        "in": "query"
                     },
                     {
-                        "type": "integer",
-                        "description": "ID of the user to add to the segment",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
+                        "enum": [
+                            "name",
+                            "created_at"
+                        ],
+                        "type": "string",
+                        "default": "name",
+                        "description": "Sort field",
+                        "name": "sort",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "asc",
+                            "desc"
+                        ],
+                        "type": "string",
+                        "default": "asc",
+                        "description": "Sort order",
+                        "name": "order",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Count all segments matching the filter",
+                        "name": "with_total",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Name prefix",
+                        "name": "name",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only segments created after the time in RFC 3339 format",
+                        "name": "created_after",
+                        "in": "query"
                     }
                 ],
                 "responses": {
-                    "204": {
-                        "description": "No Content"
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handler.SegmentsPage"
+                        }
                     },
                     "400": {
                         "description": "Bad Request",
@@ -302,45 +546,69 @@ const docTemplate = `{
                             "$ref": "#/definitions/handler.ErrorResponse"
                         }
                     },
-                    "404": {
-                        "description": "Not Found",
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
                         "schema": {
                             "$ref": "#/definitions/handler.ErrorResponse"
                         }
                     }
                 }
             },
-            "delete": {
-                "description": "Removes a user from the specified segment",
-                "consumes": [
-                    "application/json"
-                ],
-                "produces": [
-                    "application/json"
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Creates a new segment in the system.\nWith \"auto_percent\" the segment gets a random sample of existing users rounded to the nearest integer,\nand every user created later gets into the segment with the same probability.\nAn \"experiment\" segment stores no memberships for its \"percent\": a user is in it when the hash of\nthe salt and the user ID falls into the first \"percent\" of buckets, so raising it keeps existing members.\nWith \"variants\" every member is put into exactly one of them chosen by the hash of the user ID\nproportionally to the weights, the type, the salt and the variants cannot be changed later.\nWith \"rule\" every user whose attributes match the rule is in a static segment,\nand only such users can fall into an experiment.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
                 ],
                 "tags": [
                     "segments"
                 ],
-                "summary": "Remove a user from a segment",
+                "summary": "Create a new segment",
                 "parameters": [
                     {
-                        "type": "string",
-                        "description": "Slug of the segment to remove the user from",
-                        "name": "slug",
-                        "in": "path",
-                        "required": true
+                        "description": "The segment to create",
+                        "name": "segment",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handler.CreateSegmentRequest"
+                        }
                     },
                     {
-                        "type": "integer",
-                        "description": "ID of the user to remove from the segment",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
+                        "type": "string",
+                        "description": "Client generated key, retries with it get the response of the first request",
+                        "name": "Idempotency-Key",
+                        "in": "header"
                     }
                 ],
                 "responses": {
-                    "204": {
-                        "description": "No Content"
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/models.Segment"
+                        }
                     },
                     "400": {
                         "description": "Bad Request",
@@ -348,54 +616,38 @@ const docTemplate = `{
                             "$ref": "#/definitions/handler.ErrorResponse"
                         }
                     },
-                    "404": {
-                        "description": "Not Found",
+                    "401": {
+                        "description": "Unauthorized",
                         "schema": {
                             "$ref": "#/definitions/handler.ErrorResponse"
                         }
-                    }
-                }
-            }
-        },
-        "/api/v1/users": {
-            "get": {
-                "description": "Returns a list of all users in the system",
-                "consumes": [
-                    "application/json"
-                ],
-                "produces": [
-                    "application/json"
-                ],
-                "tags": [
-                    "users"
-                ],
-                "summary": "List all users",
-                "responses": {
-                    "200": {
-                        "description": "OK",
-                        "schema": {
-                            "type": "array",
-                            "items": {
-                                "$ref": "#/definitions/models.User"
-                            }
-                        }
                     },
-                    "404": {
-                        "description": "Not Found",
+                    "403": {
+                        "description": "Forbidden",
                         "schema": {
                             "$ref": "#/definitions/handler.ErrorResponse"
                         }
                     },
-                    "422": {
-                        "description": "Unprocessable Entity",
+                    "409": {
+                        "description": "Conflict",
                         "schema": {
                             "$ref": "#/definitions/handler.ErrorResponse"
                         }
                     }
                 }
-            },
+            }
+        },
+        "/api/v1/segments/preview": {
             "post": {
-                "description": "Creates a new user in the system",
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns a page of users whose attributes match the rule without creating a segment",
                 "consumes": [
                     "application/json"
                 ],
@@ -403,25 +655,68 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "users"
+                    "segments"
                 ],
-                "summary": "Create a new user",
+                "summary": "Preview a targeting rule",
                 "parameters": [
                     {
-                        "description": "The user to create",
-                        "name": "user",
+                        "description": "The rule to preview",
+                        "name": "rule",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/handler.CreateUserRequest"
+                            "$ref": "#/definitions/handler.PreviewRuleRequest"
                         }
+                    },
+                    {
+                        "maximum": 1000,
+                        "type": "integer",
+                        "default": 100,
+                        "description": "Page size",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Cursor of the next page returned with the previous page",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "id",
+                            "username",
+                            "created_at"
+                        ],
+                        "type": "string",
+                        "default": "id",
+                        "description": "Sort field",
+                        "name": "sort",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "asc",
+                            "desc"
+                        ],
+                        "type": "string",
+                        "default": "asc",
+                        "description": "Sort order",
+                        "name": "order",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Count all users matching the rule",
+                        "name": "with_total",
+                        "in": "query"
                     }
                 ],
                 "responses": {
-                    "201": {
-                        "description": "Created",
+                    "200": {
+                        "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/models.User"
+                            "$ref": "#/definitions/handler.UsersPage"
                         }
                     },
                     "400": {
@@ -429,13 +724,33 @@ const docTemplate = `{
                         "schema": {
                             "$ref": "#/definitions/handler.ErrorResponse"
                         }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
                     }
                 }
             }
         },
-        "/api/v1/users/{id}": {
+        "/api/v1/segments/{slug}": {
             "get": {
-                "description": "Returns a single user by ID",
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns a single segment by slug",
                 "consumes": [
                     "application/json"
                 ],
@@ -443,23 +758,56 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "users"
+                    "segments"
                 ],
-                "summary": "Get a user",
+                "summary": "Get a segment",
                 "parameters": [
                     {
-                        "type": "integer",
-                        "description": "ID of the user to retrieve",
-                        "name": "id",
+                        "type": "string",
+                        "description": "Slug of the segment to retrieve",
+                        "name": "slug",
                         "in": "path",
                         "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag of the version the client has",
+                        "name": "If-None-Match",
+                        "in": "header"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/models.User"
+                            "$ref": "#/definitions/models.Segment"
+                        },
+                        "headers": {
+                            "ETag": {
+                                "type": "string",
+                                "description": "Entity tag of the segment with its users"
+                            }
+                        }
+                    },
+                    "304": {
+                        "description": "Not Modified"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
                         }
                     },
                     "404": {
@@ -471,7 +819,15 @@ const docTemplate = `{
                 }
             },
             "put": {
-                "description": "Updates an existing user by ID",
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Updates an existing segment by slug. With If-Match the segment is updated only when it is of that version.\nThe name can't be changed here, segments are renamed by POST /api/v1/segments/{slug}/rename.",
                 "consumes": [
                     "application/json"
                 ],
@@ -479,41 +835,91 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "users"
+                    "segments"
                 ],
-                "summary": "Update a user",
+                "summary": "Update a segment",
                 "parameters": [
                     {
-                        "type": "integer",
-                        "description": "ID of the user to update",
-                        "name": "id",
+                        "type": "string",
+                        "description": "Slug of the segment to update",
+                        "name": "slug",
                         "in": "path",
                         "required": true
                     },
                     {
-                        "description": "The user data to update",
-                        "name": "user",
+                        "description": "The segment data to update",
+                        "name": "segment",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/models.User"
+                            "$ref": "#/definitions/handler.UpdateSegmentRequest"
                         }
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag of the version to update",
+                        "name": "If-Match",
+                        "in": "header"
                     }
                 ],
                 "responses": {
                     "204": {
-                        "description": "No Content"
+                        "description": "No Content",
+                        "headers": {
+                            "ETag": {
+                                "type": "string",
+                                "description": "Entity tag of the changed segment"
+                            }
+                        }
                     },
                     "400": {
                         "description": "Bad Request",
                         "schema": {
                             "$ref": "#/definitions/handler.ErrorResponse"
                         }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "412": {
+                        "description": "Precondition Failed",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "428": {
+                        "description": "Precondition Required",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
                     }
                 }
             },
             "delete": {
-                "description": "Deletes an existing user by ID",
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Deletes an existing segment by slug. With If-Match the segment is deleted only when it is of that version.",
                 "consumes": [
                     "application/json"
                 ],
@@ -521,65 +927,1281 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "users"
+                    "segments"
                 ],
-                "summary": "Delete a user",
+                "summary": "Delete a segment",
                 "parameters": [
                     {
-                        "type": "integer",
-                        "description": "ID of the user to delete",
-                        "name": "id",
+                        "type": "string",
+                        "description": "Slug of the segment to delete",
+                        "name": "slug",
                         "in": "path",
                         "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag of the version to delete",
+                        "name": "If-Match",
+                        "in": "header"
                     }
                 ],
                 "responses": {
                     "204": {
                         "description": "No Content"
                     },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
                     "404": {
                         "description": "Not Found",
                         "schema": {
                             "$ref": "#/definitions/handler.ErrorResponse"
                         }
+                    },
+                    "412": {
+                        "description": "Precondition Failed",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "428": {
+                        "description": "Precondition Required",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
                     }
                 }
-            }
-        },
-        "/api/v1/users/{id}/segments": {
-            "put": {
-                "description": "Updates the segments of an existing user by ID",
+            },
+            "patch": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Applies a JSON merge patch (RFC 7396) to the auto percent, the percent and the rule of an existing segment\nby slug. Members set to null are cleared, so \"rule\": null removes the targeting rule.\nWith If-Match the segment is patched only when it is of that version.",
                 "consumes": [
-                    "application/json"
+                    "application/merge-patch+json"
                 ],
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "users"
+                    "segments"
                 ],
-                "summary": "Update the segments of a user",
+                "summary": "Patch a segment",
                 "parameters": [
                     {
-                        "type": "integer",
-                        "description": "ID of the user to update segments for",
-                        "name": "id",
+                        "type": "string",
+                        "description": "Slug of the segment to patch",
+                        "name": "slug",
                         "in": "path",
                         "required": true
                     },
                     {
-                        "description": "The segments to add or remove",
-                        "name": "update",
+                        "description": "The merge patch",
+                        "name": "patch",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/handler.updateUserSegments"
+                            "$ref": "#/definitions/handler.SegmentPatch"
                         }
-                    }
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag of the version to patch",
+                        "name": "If-Match",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.Segment"
+                        },
+                        "headers": {
+                            "ETag": {
+                                "type": "string",
+                                "description": "Entity tag of the changed segment"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "412": {
+                        "description": "Precondition Failed",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "415": {
+                        "description": "Unsupported Media Type",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "428": {
+                        "description": "Precondition Required",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/segments/{slug}/rename": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Renames a segment by slug. Memberships, history and variants of the users follow the segment.\nWith alias_ttl the old name stays an alias of the segment for that long, so existing clients keep working.\nWith If-Match the segment is renamed only when it is of that version.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "segments"
+                ],
+                "summary": "Rename a segment",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Slug of the segment to rename",
+                        "name": "slug",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "The new name and the alias TTL",
+                        "name": "rename",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handler.RenameSegmentRequest"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag of the version to rename",
+                        "name": "If-Match",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.Segment"
+                        },
+                        "headers": {
+                            "ETag": {
+                                "type": "string",
+                                "description": "Entity tag of the changed segment"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "412": {
+                        "description": "Precondition Failed",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "428": {
+                        "description": "Precondition Required",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/segments/{slug}/users": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns a page of users in the specified segment sorted by id, username or created_at",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "segments"
+                ],
+                "summary": "List users in a segment",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Slug of the segment to retrieve users for",
+                        "name": "slug",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "maximum": 1000,
+                        "type": "integer",
+                        "default": 100,
+                        "description": "Page size",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Cursor of the next page returned with the previous page",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "id",
+                            "username",
+                            "created_at"
+                        ],
+                        "type": "string",
+                        "default": "id",
+                        "description": "Sort field",
+                        "name": "sort",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "asc",
+                            "desc"
+                        ],
+                        "type": "string",
+                        "default": "asc",
+                        "description": "Sort order",
+                        "name": "order",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Count all users matching the filter",
+                        "name": "with_total",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Username prefix",
+                        "name": "username",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only users created after the time in RFC 3339 format",
+                        "name": "created_after",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handler.UsersPage"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/segments/{slug}/users/{id}": {
+            "put": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Adds a user to the specified segment, optionally for a limited time.\nAdding a user who is already in the segment replaces the expiration of the membership\nand the variant when it is given.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "segments"
+                ],
+                "summary": "Add a user to a segment",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Slug of the segment to add the user to",
+                        "name": "slug",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "ID of the user to add to the segment",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Expiration and variant of the membership",
+                        "name": "membership",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/handler.addUserToSegment"
+                        }
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Removes a user from the specified segment",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "segments"
+                ],
+                "summary": "Remove a user from a segment",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Slug of the segment to remove the user from",
+                        "name": "slug",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "ID of the user to remove from the segment",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/users": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns a page of users sorted by id, username or created_at",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "List users",
+                "parameters": [
+                    {
+                        "maximum": 1000,
+                        "type": "integer",
+                        "default": 100,
+                        "description": "Page size",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Cursor of the next page returned with the previous page",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "id",
+                            "username",
+                            "created_at"
+                        ],
+                        "type": "string",
+                        "default": "id",
+                        "description": "Sort field",
+                        "name": "sort",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "asc",
+                            "desc"
+                        ],
+                        "type": "string",
+                        "default": "asc",
+                        "description": "Sort order",
+                        "name": "order",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Count all users matching the filter",
+                        "name": "with_total",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Username prefix",
+                        "name": "username",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only users created after the time in RFC 3339 format",
+                        "name": "created_after",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only users in the segment",
+                        "name": "segment",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handler.UsersPage"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Creates a new user in the system",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Create a new user",
+                "parameters": [
+                    {
+                        "description": "The user to create",
+                        "name": "user",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handler.CreateUserRequest"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Client generated key, retries with it get the response of the first request",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/models.User"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/users/{id}": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns a single user by ID",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Get a user",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "ID of the user to retrieve",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag of the version the client has",
+                        "name": "If-None-Match",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.User"
+                        },
+                        "headers": {
+                            "ETag": {
+                                "type": "string",
+                                "description": "Entity tag of the user with its segments"
+                            }
+                        }
+                    },
+                    "304": {
+                        "description": "Not Modified"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Updates an existing user by ID. With If-Match the user is updated only when it is of that version.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Update a user",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "ID of the user to update",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "The user data to update",
+                        "name": "user",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handler.UpdateUserRequest"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag of the version to update",
+                        "name": "If-Match",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content",
+                        "headers": {
+                            "ETag": {
+                                "type": "string",
+                                "description": "Entity tag of the changed user"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "412": {
+                        "description": "Precondition Failed",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "428": {
+                        "description": "Precondition Required",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Deletes an existing user by ID. With If-Match the user is deleted only when it is of that version.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Delete a user",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "ID of the user to delete",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag of the version to delete",
+                        "name": "If-Match",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "412": {
+                        "description": "Precondition Failed",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "428": {
+                        "description": "Precondition Required",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "patch": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Applies a JSON merge patch (RFC 7396) to the first name, the last name, the username and the attributes\nof an existing user by ID. Attributes are merged key by key and removed when set to null,\nthe names can't be cleared.\nWith If-Match the user is patched only when it is of that version.",
+                "consumes": [
+                    "application/merge-patch+json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Patch a user",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "ID of the user to patch",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "The merge patch",
+                        "name": "patch",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handler.UserPatch"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag of the version to patch",
+                        "name": "If-Match",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.User"
+                        },
+                        "headers": {
+                            "ETag": {
+                                "type": "string",
+                                "description": "Entity tag of the changed user"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "412": {
+                        "description": "Precondition Failed",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "415": {
+                        "description": "Unsupported Media Type",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "428": {
+                        "description": "Precondition Required",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/users/{id}/segments": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns the active segments of the user: segments the user is put into and experiments the user falls into",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Get the segments of a user",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "ID of the user to resolve segments for",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handler.UserSegments"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Updates the segments of an existing user by ID.\nA segment to add is either a name or an object with the name, optional \"expires_at\" or \"ttl\" of the membership\nand optional \"variant\" to put the user into instead of the hashed one.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Update the segments of a user",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "ID of the user to update segments for",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "The segments to add or remove",
+                        "name": "update",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handler.updateUserSegments"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Client generated key, retries with it get the response of the first request",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/users/{id}/segments/history": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns a CSV report (user_id;segment;operation;timestamp) of the user entering and leaving segments during the given year-month",
+                "produces": [
+                    "text/csv"
+                ],
+                "tags": [
+                    "users"
+                ],
+                "summary": "Get the segments history of a user",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "ID of the user to build the report for",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "example": "2023-08",
+                        "description": "Year-month of the report",
+                        "name": "period",
+                        "in": "query",
+                        "required": true
+                    }
                 ],
                 "responses": {
-                    "204": {
-                        "description": "No Content"
+                    "200": {
+                        "description": "CSV report",
+                        "schema": {
+                            "type": "string"
+                        }
                     },
                     "400": {
                         "description": "Bad Request",
@@ -587,6 +2209,18 @@ const docTemplate = `{
                             "$ref": "#/definitions/handler.ErrorResponse"
                         }
                     },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/handler.ErrorResponse"
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -595,18 +2229,417 @@ const docTemplate = `{
                     }
                 }
             }
-        }
-    },
-    "definitions": {
-        "handler.CreateSegmentRequest": {
+        },
+        "/healthz": {
+            "get": {
+                "description": "Reports the process is running, it does not check dependencies",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "health"
+                ],
+                "summary": "Liveness probe",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/health.Response"
+                        }
+                    }
+                }
+            }
+        },
+        "/readyz": {
+            "get": {
+                "description": "Reports the service can serve requests: the database is reachable and all migrations are applied.\nFails with \"shutting_down\" once graceful shutdown starts.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "health"
+                ],
+                "summary": "Readiness probe",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/health.Response"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/health.Response"
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "handler.APIKeysResponse": {
+            "type": "object",
+            "properties": {
+                "api_keys": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.APIKey"
+                    }
+                }
+            }
+        },
+        "handler.AuditPage": {
+            "type": "object",
+            "properties": {
+                "items": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.AuditEntry"
+                    }
+                },
+                "next_cursor": {
+                    "type": "string"
+                },
+                "total": {
+                    "type": "integer"
+                }
+            }
+        },
+        "handler.CreateAPIKeyRequest": {
+            "type": "object",
+            "required": [
+                "name",
+                "scopes"
+            ],
+            "properties": {
+                "name": {
+                    "type": "string",
+                    "maxLength": 128,
+                    "example": "mobile-backend"
+                },
+                "scopes": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.Scope"
+                    },
+                    "example": [
+                        "segments:read",
+                        "evaluate"
+                    ]
+                }
+            }
+        },
+        "handler.CreateAPIKeyResponse": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "key": {
+                    "description": "returned only once",
+                    "type": "string",
+                    "example": "sk_Zx3kTqH8n1cC5cU0q2dYl4yqf1mW7HnA2oVbJkR9sPe"
+                },
+                "name": {
+                    "type": "string",
+                    "example": "mobile-backend"
+                },
+                "prefix": {
+                    "description": "first characters of the key identifying it",
+                    "type": "string",
+                    "example": "sk_Zx3kTq"
+                },
+                "scopes": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.Scope"
+                    }
+                }
+            }
+        },
+        "handler.CreateSegmentRequest": {
+            "type": "object",
+            "required": [
+                "name"
+            ],
+            "properties": {
+                "auto_percent": {
+                    "description": "percentage of users put into a static segment, including users created later",
+                    "type": "number",
+                    "maximum": 100,
+                    "minimum": 0,
+                    "example": 30
+                },
+                "name": {
+                    "type": "string",
+                    "maxLength": 128,
+                    "example": "AVITO_VOICE_MESSAGES"
+                },
+                "percent": {
+                    "description": "percentage of users falling into an experiment",
+                    "type": "number",
+                    "maximum": 100,
+                    "minimum": 0,
+                    "example": 10
+                },
+                "rule": {
+                    "description": "targeting rule on user attributes",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/models.Rule"
+                        }
+                    ]
+                },
+                "salt": {
+                    "description": "experiment hash salt, random when empty",
+                    "type": "string",
+                    "maxLength": 128
+                },
+                "type": {
+                    "enum": [
+                        "static",
+                        "experiment"
+                    ],
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/models.SegmentType"
+                        }
+                    ],
+                    "example": "static"
+                },
+                "variants": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.Variant"
+                    }
+                }
+            }
+        },
+        "handler.CreateUserRequest": {
+            "type": "object",
+            "required": [
+                "firstname",
+                "lastname",
+                "username"
+            ],
+            "properties": {
+                "attributes": {
+                    "description": "Attributes are matched by targeting rules of segments",
+                    "type": "object",
+                    "additionalProperties": {}
+                },
+                "firstname": {
+                    "type": "string",
+                    "maxLength": 128
+                },
+                "lastname": {
+                    "type": "string",
+                    "maxLength": 128
+                },
+                "username": {
+                    "type": "string",
+                    "maxLength": 128
+                }
+            }
+        },
+        "handler.ErrorResponse": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "description": "application-specific error code",
+                    "type": "integer",
+                    "example": 404
+                },
+                "error": {
+                    "description": "application-level error message, for debugging",
+                    "type": "string",
+                    "example": "The requested resource was not found on the server"
+                },
+                "status": {
+                    "description": "user-level status message",
+                    "type": "string",
+                    "example": "Resource not found."
+                },
+                "violations": {
+                    "description": "rules the request fields do not pass",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/validate.Violation"
+                    }
+                }
+            }
+        },
+        "handler.EvaluateRequest": {
+            "type": "object",
+            "required": [
+                "user_ids"
+            ],
+            "properties": {
+                "user_ids": {
+                    "type": "array",
+                    "maxItems": 1000,
+                    "items": {
+                        "type": "integer"
+                    },
+                    "example": [
+                        1,
+                        2,
+                        3
+                    ]
+                }
+            }
+        },
+        "handler.EvaluateResponse": {
+            "type": "object",
+            "properties": {
+                "users": {
+                    "description": "in the order of the requested IDs",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.Evaluation"
+                    }
+                }
+            }
+        },
+        "handler.PreviewRuleRequest": {
+            "type": "object",
+            "required": [
+                "rule"
+            ],
+            "properties": {
+                "rule": {
+                    "$ref": "#/definitions/models.Rule"
+                }
+            }
+        },
+        "handler.RenameSegmentRequest": {
+            "type": "object",
+            "required": [
+                "name"
+            ],
+            "properties": {
+                "alias_ttl": {
+                    "description": "AliasTTL keeps the old name resolving to the segment for that long, e.g. \"720h\" for 30 days.\nWithout it the old name is released immediately.",
+                    "type": "string",
+                    "example": "720h"
+                },
+                "name": {
+                    "type": "string",
+                    "maxLength": 128,
+                    "example": "AVITO_VOICE_MESSAGES_V2"
+                }
+            }
+        },
+        "handler.SegmentPatch": {
+            "type": "object",
+            "properties": {
+                "auto_percent": {
+                    "description": "percentage of users put into a static segment",
+                    "type": "number",
+                    "maximum": 100,
+                    "minimum": 0,
+                    "example": 30
+                },
+                "percent": {
+                    "description": "percentage of users falling into an experiment",
+                    "type": "number",
+                    "maximum": 100,
+                    "minimum": 0,
+                    "example": 10
+                },
+                "rule": {
+                    "description": "targeting rule on user attributes",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/models.Rule"
+                        }
+                    ]
+                }
+            }
+        },
+        "handler.SegmentsPage": {
+            "type": "object",
+            "properties": {
+                "items": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.Segment"
+                    }
+                },
+                "next_cursor": {
+                    "type": "string"
+                },
+                "total": {
+                    "type": "integer"
+                }
+            }
+        },
+        "handler.UpdateSegmentRequest": {
             "type": "object",
             "properties": {
+                "auto_percent": {
+                    "type": "number",
+                    "maximum": 100,
+                    "minimum": 0,
+                    "example": 30
+                },
                 "name": {
-                    "type": "string"
+                    "description": "must be the slug when given",
+                    "type": "string",
+                    "example": "AVITO_VOICE_MESSAGES"
+                },
+                "percent": {
+                    "type": "number",
+                    "maximum": 100,
+                    "minimum": 0,
+                    "example": 10
+                },
+                "rule": {
+                    "description": "targeting rule on user attributes, removed when absent",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/models.Rule"
+                        }
+                    ]
                 }
             }
         },
-        "handler.CreateUserRequest": {
+        "handler.UpdateUserRequest": {
+            "type": "object",
+            "required": [
+                "firstname",
+                "lastname",
+                "username"
+            ],
+            "properties": {
+                "ID": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "attributes": {
+                    "description": "Attributes are matched by targeting rules of segments",
+                    "type": "object",
+                    "additionalProperties": {}
+                },
+                "firstname": {
+                    "type": "string",
+                    "maxLength": 128
+                },
+                "lastname": {
+                    "type": "string",
+                    "maxLength": 128
+                },
+                "username": {
+                    "type": "string",
+                    "maxLength": 128
+                }
+            }
+        },
+        "handler.UserPatch": {
             "type": "object",
             "required": [
                 "firstname",
@@ -614,44 +2647,113 @@ const docTemplate = `{
                 "username"
             ],
             "properties": {
+                "attributes": {
+                    "type": "object",
+                    "additionalProperties": {}
+                },
                 "firstname": {
-                    "type": "string"
+                    "type": "string",
+                    "maxLength": 128,
+                    "example": "Petr"
                 },
                 "lastname": {
-                    "type": "string"
+                    "type": "string",
+                    "maxLength": 128,
+                    "example": "Petrov"
                 },
                 "username": {
+                    "type": "string",
+                    "maxLength": 128,
+                    "example": "petr@petr"
+                }
+            }
+        },
+        "handler.UserSegments": {
+            "type": "object",
+            "properties": {
+                "segments": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.Segment"
+                    }
+                },
+                "user_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "handler.UsersPage": {
+            "type": "object",
+            "properties": {
+                "items": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.User"
+                    }
+                },
+                "next_cursor": {
                     "type": "string"
+                },
+                "total": {
+                    "type": "integer"
                 }
             }
         },
-        "handler.ErrorResponse": {
+        "handler.addUserToSegment": {
             "type": "object",
             "properties": {
-                "code": {
-                    "description": "application-specific error code",
-                    "type": "integer",
-                    "example": 404
+                "expires_at": {
+                    "type": "string",
+                    "example": "2023-09-01T00:00:00Z"
                 },
-                "error": {
-                    "description": "application-level error message, for debugging",
+                "ttl": {
                     "type": "string",
-                    "example": "The requested resource was not found on the server"
+                    "example": "720h"
                 },
-                "status": {
-                    "description": "user-level status message",
+                "variant": {
+                    "description": "variant to put the user into instead of the hashed one",
                     "type": "string",
-                    "example": "Resource not found."
+                    "maxLength": 128,
+                    "example": "treatment_a"
+                }
+            }
+        },
+        "handler.segmentToAdd": {
+            "type": "object",
+            "required": [
+                "name"
+            ],
+            "properties": {
+                "expires_at": {
+                    "type": "string",
+                    "example": "2023-09-01T00:00:00Z"
+                },
+                "name": {
+                    "type": "string",
+                    "maxLength": 128,
+                    "example": "AVITO_DISCOUNT_30"
+                },
+                "ttl": {
+                    "type": "string",
+                    "example": "720h"
+                },
+                "variant": {
+                    "type": "string",
+                    "maxLength": 128,
+                    "example": "treatment_a"
                 }
             }
         },
         "handler.updateUserSegments": {
             "type": "object",
+            "required": [
+                "segments_to_remove"
+            ],
             "properties": {
                 "segments_to_add": {
                     "type": "array",
                     "items": {
-                        "type": "string"
+                        "$ref": "#/definitions/handler.segmentToAdd"
                     }
                 },
                 "segments_to_remove": {
@@ -662,37 +2764,336 @@ const docTemplate = `{
                 }
             }
         },
+        "health.Response": {
+            "type": "object",
+            "properties": {
+                "checks": {
+                    "description": "\"ok\" or \"unavailable\", errors are only logged",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "status": {
+                    "type": "string",
+                    "example": "ok"
+                }
+            }
+        },
+        "models.APIKey": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "name": {
+                    "type": "string",
+                    "example": "mobile-backend"
+                },
+                "prefix": {
+                    "description": "first characters of the key identifying it",
+                    "type": "string",
+                    "example": "sk_Zx3kTq"
+                },
+                "scopes": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.Scope"
+                    }
+                }
+            }
+        },
+        "models.AuditAction": {
+            "type": "string",
+            "enum": [
+                "user.create",
+                "user.update",
+                "user.delete",
+                "user.segments.update",
+                "segment.create",
+                "segment.update",
+                "segment.delete",
+                "segment.rename",
+                "membership.add",
+                "membership.remove"
+            ],
+            "x-enum-varnames": [
+                "AuditUserCreate",
+                "AuditUserUpdate",
+                "AuditUserDelete",
+                "AuditUserSegmentsUpdate",
+                "AuditSegmentCreate",
+                "AuditSegmentUpdate",
+                "AuditSegmentDelete",
+                "AuditSegmentRename",
+                "AuditMembershipAdd",
+                "AuditMembershipRemove"
+            ]
+        },
+        "models.AuditEntry": {
+            "type": "object",
+            "properties": {
+                "action": {
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/models.AuditAction"
+                        }
+                    ],
+                    "example": "segment.create"
+                },
+                "actor": {
+                    "description": "subject of the authenticated principal, \"system\" otherwise",
+                    "type": "string",
+                    "example": "api-key:3"
+                },
+                "actor_name": {
+                    "type": "string",
+                    "example": "mobile-backend"
+                },
+                "after": {
+                    "type": "object"
+                },
+                "before": {
+                    "type": "object"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "request_id": {
+                    "type": "string"
+                },
+                "resource_id": {
+                    "type": "string",
+                    "example": "AVITO_VOICE_MESSAGES"
+                },
+                "resource_type": {
+                    "type": "string",
+                    "example": "segment"
+                },
+                "timestamp": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.Evaluation": {
+            "type": "object",
+            "properties": {
+                "expires_at": {
+                    "description": "ExpiresAt is the earliest expiration of the user's memberships, the evaluation may change then",
+                    "type": "string"
+                },
+                "segments": {
+                    "description": "sorted slugs",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "AVITO_VOICE_MESSAGES",
+                        "AVITO_DISCOUNT_30"
+                    ]
+                },
+                "user_id": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "variants": {
+                    "description": "Variants maps the slugs of segments with variants to the variant of the user",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "models.Rule": {
+            "type": "object",
+            "properties": {
+                "and": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.Rule"
+                    }
+                },
+                "attribute": {
+                    "type": "string",
+                    "example": "country"
+                },
+                "not": {
+                    "$ref": "#/definitions/models.Rule"
+                },
+                "op": {
+                    "enum": [
+                        "eq",
+                        "ne",
+                        "gt",
+                        "gte",
+                        "lt",
+                        "lte",
+                        "in"
+                    ],
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/models.RuleOp"
+                        }
+                    ],
+                    "example": "eq"
+                },
+                "or": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.Rule"
+                    }
+                },
+                "value": {
+                    "type": "object"
+                }
+            }
+        },
+        "models.RuleOp": {
+            "type": "string",
+            "enum": [
+                "eq",
+                "ne",
+                "gt",
+                "gte",
+                "lt",
+                "lte",
+                "in"
+            ],
+            "x-enum-varnames": [
+                "RuleOpEq",
+                "RuleOpNe",
+                "RuleOpGt",
+                "RuleOpGte",
+                "RuleOpLt",
+                "RuleOpLte",
+                "RuleOpIn"
+            ]
+        },
+        "models.Scope": {
+            "type": "string",
+            "enum": [
+                "users:read",
+                "users:write",
+                "segments:read",
+                "segments:write",
+                "evaluate",
+                "admin"
+            ],
+            "x-enum-varnames": [
+                "ScopeUsersRead",
+                "ScopeUsersWrite",
+                "ScopeSegmentsRead",
+                "ScopeSegmentsWrite",
+                "ScopeEvaluate",
+                "ScopeAdmin"
+            ]
+        },
         "models.Segment": {
             "type": "object",
             "properties": {
+                "auto_percent": {
+                    "description": "percentage of all users automatically put into the segment",
+                    "type": "number",
+                    "maximum": 100,
+                    "minimum": 0
+                },
+                "expires_at": {
+                    "description": "set when the segment is loaded as a user's membership with TTL",
+                    "type": "string"
+                },
                 "name": {
                     "type": "string"
                 },
+                "percent": {
+                    "description": "percentage of users in an experiment",
+                    "type": "number",
+                    "maximum": 100,
+                    "minimum": 0
+                },
+                "rule": {
+                    "description": "users matching the rule are in the segment",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/models.Rule"
+                        }
+                    ]
+                },
+                "salt": {
+                    "description": "experiment hash salt, generated when empty",
+                    "type": "string",
+                    "maxLength": 128
+                },
+                "type": {
+                    "$ref": "#/definitions/models.SegmentType"
+                },
                 "users": {
                     "type": "array",
                     "items": {
                         "$ref": "#/definitions/models.User"
                     }
+                },
+                "variant": {
+                    "description": "set when the segment with variants is loaded as a user's membership",
+                    "type": "string"
+                },
+                "variant_counts": {
+                    "description": "VariantCounts is the number of members in each variant, set when the segment is loaded with its users",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "integer"
+                    }
+                },
+                "variants": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.Variant"
+                    }
+                },
+                "version": {
+                    "description": "Version is incremented by every update of the segment, so its entity tag changes with every update",
+                    "type": "integer"
                 }
             }
         },
+        "models.SegmentType": {
+            "type": "string",
+            "enum": [
+                "static",
+                "experiment"
+            ],
+            "x-enum-varnames": [
+                "SegmentTypeStatic",
+                "SegmentTypeExperiment"
+            ]
+        },
         "models.User": {
             "type": "object",
             "required": [
                 "firstname",
                 "lastname",
-                "segments",
                 "username"
             ],
             "properties": {
+                "attributes": {
+                    "description": "free-form properties matched by segment rules",
+                    "type": "object",
+                    "additionalProperties": {}
+                },
                 "firstname": {
-                    "type": "string"
+                    "type": "string",
+                    "maxLength": 128
                 },
                 "id": {
                     "type": "integer"
                 },
                 "lastname": {
-                    "type": "string"
+                    "type": "string",
+                    "maxLength": 128
                 },
                 "segments": {
                     "type": "array",
@@ -701,10 +3102,63 @@ const docTemplate = `{
                     }
                 },
                 "username": {
-                    "type": "string"
+                    "type": "string",
+                    "maxLength": 128
+                },
+                "version": {
+                    "description": "Version is incremented by every update of the user, so its entity tag changes with every update",
+                    "type": "integer"
+                }
+            }
+        },
+        "models.Variant": {
+            "type": "object",
+            "required": [
+                "name"
+            ],
+            "properties": {
+                "name": {
+                    "type": "string",
+                    "maxLength": 128,
+                    "example": "control"
+                },
+                "weight": {
+                    "type": "integer",
+                    "minimum": 1,
+                    "example": 50
+                }
+            }
+        },
+        "validate.Violation": {
+            "type": "object",
+            "properties": {
+                "field": {
+                    "type": "string",
+                    "example": "segments_to_add[0].name"
+                },
+                "message": {
+                    "type": "string",
+                    "example": "must contain only A-Z, 0-9 and _"
+                },
+                "rule": {
+                    "type": "string",
+                    "example": "slug"
                 }
             }
         }
+    },
+    "securityDefinitions": {
+        "ApiKeyAuth": {
+            "type": "apiKey",
+            "name": "X-API-Key",
+            "in": "header"
+        },
+        "BearerAuth": {
+            "description": "OIDC access token as \"Bearer \u003ctoken\u003e\"",
+            "type": "apiKey",
+            "name": "Authorization",
+            "in": "header"
+        }
     }
 }`
 
@@ -716,7 +3170,7 @@ var SwaggerInfo = &swag.Spec{
 	Schemes:          []string{},
 	Title:            "Segment service API",
 	Description:      "",
-	InfoInstanceName: "swagger.jpg",
+	InfoInstanceName: "swagger",
 	SwaggerTemplate:  docTemplate,
 	LeftDelim:        "{{",
 	RightDelim:       "}}",
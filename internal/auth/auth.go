@@ -0,0 +1,95 @@
+// Package auth authenticates API clients and tells which scopes they are granted.
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/models"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/storage"
+)
+
+// ErrInvalidCredentials is returned for missing, unknown or revoked credentials.
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// Principal is the authenticated caller.
+type Principal struct {
+	// Subject identifies the caller, "api-key:<id>" for stored keys, "admin-key" for the configured admin key
+	// and "user:<sub>" for bearer tokens
+	Subject string
+	Name    string
+	Scopes  []models.Scope
+}
+
+// Has reports whether the principal is granted the scope, admin is granted every scope.
+func (p *Principal) Has(scope models.Scope) bool {
+	return slices.Contains(p.Scopes, scope) || slices.Contains(p.Scopes, models.ScopeAdmin)
+}
+
+type principalKey struct{}
+
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// FromContext returns the principal of the request, nil when the request is not authenticated.
+func FromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
+
+// Authenticator verifies API keys against the stored hashes and the admin key of the configuration,
+// and bearer tokens of human operators.
+type Authenticator struct {
+	keys     storage.APIKeyStorage
+	adminKey string
+	tokens   *TokenVerifier
+}
+
+// New creates an authenticator, an empty adminKey disables the admin key and nil tokens disable bearer tokens.
+func New(keys storage.APIKeyStorage, adminKey string, tokens *TokenVerifier) *Authenticator {
+	return &Authenticator{keys: keys, adminKey: adminKey, tokens: tokens}
+}
+
+// TokensEnabled reports whether bearer tokens are accepted.
+func (a *Authenticator) TokensEnabled() bool {
+	return a.tokens != nil
+}
+
+// AuthenticateToken returns the principal of the bearer token or an error wrapping ErrInvalidCredentials.
+func (a *Authenticator) AuthenticateToken(ctx context.Context, token string) (*Principal, error) {
+	if a.tokens == nil {
+		return nil, fmt.Errorf("%w: bearer tokens are not accepted", ErrInvalidCredentials)
+	}
+	return a.tokens.Verify(ctx, token)
+}
+
+// AuthenticateKey returns the principal of the API key or ErrInvalidCredentials.
+func (a *Authenticator) AuthenticateKey(ctx context.Context, key string) (*Principal, error) {
+	if key == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	if a.adminKey != "" && subtle.ConstantTimeCompare([]byte(key), []byte(a.adminKey)) == 1 {
+		return &Principal{Subject: "admin-key", Name: "admin", Scopes: []models.Scope{models.ScopeAdmin}}, nil
+	}
+
+	// the key is looked up by its hash, so the lookup does not leak the key through timing
+	stored, err := a.keys.GetAPIKeyByHash(ctx, HashKey(key))
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to authenticate API key: %w", err)
+	}
+
+	return &Principal{
+		Subject: "api-key:" + strconv.FormatInt(stored.ID, 10),
+		Name:    stored.Name,
+		Scopes:  stored.Scopes,
+	}, nil
+}
@@ -0,0 +1,235 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jwk is a JSON Web Key of RFC 7517, only the RSA and EC P-256 signing keys are used.
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// publicKey decodes the key, ok is false for keys of other types or not used for signatures.
+func (k jwk) publicKey() (_ crypto.PublicKey, ok bool, err error) {
+	if k.Use != "" && k.Use != "sig" {
+		return nil, false, nil
+	}
+
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, false, fmt.Errorf("key '%s' modulus: %w", k.Kid, err)
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, false, fmt.Errorf("key '%s' exponent: %w", k.Kid, err)
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, false, fmt.Errorf("key '%s' exponent is too large", k.Kid)
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, true, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, false, nil
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, false, fmt.Errorf("key '%s' x: %w", k.Kid, err)
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, false, fmt.Errorf("key '%s' y: %w", k.Kid, err)
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+		if !key.Curve.IsOnCurve(x, y) {
+			return nil, false, fmt.Errorf("key '%s' point is not on P-256", k.Kid)
+		}
+		return key, true, nil
+	default:
+		return nil, false, nil
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, fmt.Errorf("empty value")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// ParseJWKS returns the signing keys of a JSON Web Key Set by key ID.
+func ParseJWKS(data []byte) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("failed to parse JWKS: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		key, ok, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse JWKS: %w", err)
+		}
+		if ok {
+			keys[k.Kid] = key
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("JWKS has no RSA or P-256 signing keys")
+	}
+	return keys, nil
+}
+
+// KeySet holds the keys of a JWKS loaded from a file or an http(s) URL.
+// Keys of a URL are reloaded when a token is signed by an unknown key, at most once per refresh interval,
+// so rotated provider keys are picked up without a restart. Lookups don't wait for a reload
+// unless they need its keys, and concurrent lookups of unknown keys share one reload.
+type KeySet struct {
+	source  string
+	refresh time.Duration
+	client  *http.Client
+
+	mu   sync.RWMutex
+	keys map[string]crypto.PublicKey
+	// attemptedAt is the time of the last reload, failed or not, reloads are rate limited by it
+	attemptedAt time.Time
+	// reloading is closed when the reload in progress finishes, it is nil without one
+	reloading chan struct{}
+	reloadErr error
+}
+
+// NewKeySet loads the JWKS from source, a file path or an http(s) URL.
+func NewKeySet(ctx context.Context, source string, refresh time.Duration) (*KeySet, error) {
+	s := &KeySet{source: source, refresh: refresh, client: &http.Client{Timeout: 10 * time.Second}}
+	keys, err := s.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.keys = keys
+	s.attemptedAt = time.Now()
+	return s, nil
+}
+
+func (s *KeySet) remote() bool {
+	return strings.HasPrefix(s.source, "http://") || strings.HasPrefix(s.source, "https://")
+}
+
+func (s *KeySet) read(ctx context.Context) ([]byte, error) {
+	if !s.remote() {
+		return os.ReadFile(s.source)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.source, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+func (s *KeySet) load(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	data, err := s.read(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load JWKS from %s: %w", s.source, err)
+	}
+	return ParseJWKS(data)
+}
+
+func (s *KeySet) lookup(kid string) (crypto.PublicKey, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	key, ok := s.keys[kid]
+	return key, ok
+}
+
+// Key returns the key with the ID.
+func (s *KeySet) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	if key, ok := s.lookup(kid); ok {
+		return key, nil
+	}
+
+	if s.remote() {
+		if err := s.reload(ctx); err != nil {
+			return nil, err
+		}
+		if key, ok := s.lookup(kid); ok {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown signing key '%s'", kid)
+}
+
+// reload loads the keys again unless they were loaded less than the refresh interval ago.
+// A reload in progress is waited for instead of starting another one.
+func (s *KeySet) reload(ctx context.Context) error {
+	s.mu.Lock()
+	if done := s.reloading; done != nil {
+		s.mu.Unlock()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		return s.reloadErr
+	}
+	if time.Since(s.attemptedAt) < s.refresh {
+		s.mu.Unlock()
+		return nil
+	}
+	done := make(chan struct{})
+	s.reloading = done
+	s.mu.Unlock()
+
+	// the reload is shared, so it is not canceled with the request which started it
+	keys, err := s.load(context.WithoutCancel(ctx))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err == nil {
+		s.keys = keys
+	}
+	s.attemptedAt = time.Now()
+	s.reloading = nil
+	s.reloadErr = err
+	close(done)
+	return err
+}
@@ -0,0 +1,251 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey: %v", err)
+	}
+	return key
+}
+
+func newECKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ecdsa.GenerateKey: %v", err)
+	}
+	return key
+}
+
+// newJWKS encodes the public keys of the private keys by key ID.
+func newJWKS(t *testing.T, keys map[string]crypto.Signer) []byte {
+	t.Helper()
+	encode := base64.RawURLEncoding.EncodeToString
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	for kid, key := range keys {
+		switch public := key.Public().(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, jwk{Kid: kid, Kty: "RSA", Use: "sig", Alg: "RS256",
+				N: encode(public.N.Bytes()), E: encode(big.NewInt(int64(public.E)).Bytes())})
+		case *ecdsa.PublicKey:
+			set.Keys = append(set.Keys, jwk{Kid: kid, Kty: "EC", Use: "sig", Alg: "ES256", Crv: "P-256",
+				X: encode(public.X.FillBytes(make([]byte, 32))), Y: encode(public.Y.FillBytes(make([]byte, 32)))})
+		default:
+			t.Fatalf("unsupported key %T", public)
+		}
+	}
+
+	data, err := json.Marshal(set)
+	if err != nil {
+		t.Fatalf("json.Marshal: %v", err)
+	}
+	return data
+}
+
+// jwksServer serves the JWKS it holds and counts the requests.
+type jwksServer struct {
+	*httptest.Server
+	requests atomic.Int64
+
+	mu   sync.Mutex
+	jwks []byte
+}
+
+func newJWKSServer(t *testing.T, jwks []byte) *jwksServer {
+	s := &jwksServer{jwks: jwks}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.requests.Add(1)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		w.Write(s.jwks)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *jwksServer) set(jwks []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jwks = jwks
+}
+
+func TestParseJWKS(t *testing.T) {
+	rsaKey, ecKey := newRSAKey(t), newECKey(t)
+	keys, err := ParseJWKS(newJWKS(t, map[string]crypto.Signer{"rsa": rsaKey, "ec": ecKey}))
+	if err != nil {
+		t.Fatalf("ParseJWKS: %v", err)
+	}
+	if public, ok := keys["rsa"].(*rsa.PublicKey); !ok || !public.Equal(rsaKey.Public()) {
+		t.Errorf("key rsa = %v, want the RSA public key", keys["rsa"])
+	}
+	if public, ok := keys["ec"].(*ecdsa.PublicKey); !ok || !public.Equal(ecKey.Public()) {
+		t.Errorf("key ec = %v, want the EC public key", keys["ec"])
+	}
+}
+
+func TestParseJWKSSkipsOtherKeys(t *testing.T) {
+	data := `{"keys": [
+		{"kid": "enc", "kty": "RSA", "use": "enc", "n": "AQAB", "e": "AQAB"},
+		{"kid": "oct", "kty": "oct", "k": "c2VjcmV0"},
+		{"kid": "p384", "kty": "EC", "crv": "P-384", "x": "AQAB", "y": "AQAB"}
+	]}`
+	if _, err := ParseJWKS([]byte(data)); err == nil {
+		t.Fatal("ParseJWKS of a set without signing keys succeeded")
+	}
+
+	data = `{"keys": [{"kid": "ec", "kty": "EC", "crv": "P-256", "x": "AQAB", "y": "AQAB"}]}`
+	if _, err := ParseJWKS([]byte(data)); err == nil {
+		t.Fatal("ParseJWKS of a point off the curve succeeded")
+	}
+}
+
+func TestKeySetFromFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, newJWKS(t, map[string]crypto.Signer{"k1": newECKey(t)}), 0o600); err != nil {
+		t.Fatalf("os.WriteFile: %v", err)
+	}
+
+	keys, err := NewKeySet(context.Background(), path, 0)
+	if err != nil {
+		t.Fatalf("NewKeySet: %v", err)
+	}
+	if _, err := keys.Key(context.Background(), "k1"); err != nil {
+		t.Fatalf("Key(k1): %v", err)
+	}
+
+	// a file is not reloaded, it is changed by restarting the service
+	if err := os.WriteFile(path, newJWKS(t, map[string]crypto.Signer{"k2": newECKey(t)}), 0o600); err != nil {
+		t.Fatalf("os.WriteFile: %v", err)
+	}
+	if _, err := keys.Key(context.Background(), "k2"); err == nil {
+		t.Fatal("Key(k2) of a reloaded file succeeded")
+	}
+}
+
+func TestKeySetReloadsUnknownKey(t *testing.T) {
+	server := newJWKSServer(t, newJWKS(t, map[string]crypto.Signer{"old": newRSAKey(t)}))
+	keys, err := NewKeySet(context.Background(), server.URL, 0)
+	if err != nil {
+		t.Fatalf("NewKeySet: %v", err)
+	}
+
+	if _, err := keys.Key(context.Background(), "old"); err != nil {
+		t.Fatalf("Key(old): %v", err)
+	}
+	if got := server.requests.Load(); got != 1 {
+		t.Fatalf("known key made %d requests, want 1", got)
+	}
+
+	server.set(newJWKS(t, map[string]crypto.Signer{"new": newRSAKey(t)}))
+	if _, err := keys.Key(context.Background(), "new"); err != nil {
+		t.Fatalf("Key(new) after the rotation: %v", err)
+	}
+	if got := server.requests.Load(); got != 2 {
+		t.Fatalf("rotated key made %d requests, want 2", got)
+	}
+	if _, err := keys.Key(context.Background(), "old"); err == nil {
+		t.Fatal("Key(old) after the rotation succeeded")
+	}
+}
+
+func TestKeySetRateLimitsReloads(t *testing.T) {
+	server := newJWKSServer(t, newJWKS(t, map[string]crypto.Signer{"k1": newRSAKey(t)}))
+	keys, err := NewKeySet(context.Background(), server.URL, time.Hour)
+	if err != nil {
+		t.Fatalf("NewKeySet: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := keys.Key(context.Background(), "unknown"); err == nil {
+			t.Fatal("Key(unknown) succeeded")
+		}
+	}
+	if got := server.requests.Load(); got != 1 {
+		t.Fatalf("unknown keys made %d requests within the refresh interval, want 1", got)
+	}
+}
+
+func TestKeySetSharesReload(t *testing.T) {
+	jwks := newJWKS(t, map[string]crypto.Signer{"k1": newRSAKey(t)})
+	release := make(chan struct{})
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the first request loads the set, reloads wait until every lookup has started
+		if requests.Add(1) > 1 {
+			<-release
+		}
+		w.Write(jwks)
+	}))
+	defer server.Close()
+
+	keys, err := NewKeySet(context.Background(), server.URL, 0)
+	if err != nil {
+		t.Fatalf("NewKeySet: %v", err)
+	}
+
+	const lookups = 10
+	var started, done sync.WaitGroup
+	started.Add(lookups)
+	done.Add(lookups)
+	for i := 0; i < lookups; i++ {
+		go func() {
+			defer done.Done()
+			started.Done()
+			keys.Key(context.Background(), "unknown")
+		}()
+	}
+
+	started.Wait()
+	time.Sleep(50 * time.Millisecond)
+
+	// known keys are looked up while the reload is in progress
+	if _, err := keys.Key(context.Background(), "k1"); err != nil {
+		t.Fatalf("Key(k1) during a reload: %v", err)
+	}
+	close(release)
+	done.Wait()
+	if got := requests.Load(); got != 2 {
+		t.Fatalf("concurrent lookups made %d requests, want 2", got)
+	}
+}
+
+func TestKeySetReloadError(t *testing.T) {
+	server := newJWKSServer(t, newJWKS(t, map[string]crypto.Signer{"k1": newRSAKey(t)}))
+	keys, err := NewKeySet(context.Background(), server.URL, 0)
+	if err != nil {
+		t.Fatalf("NewKeySet: %v", err)
+	}
+
+	server.set([]byte("not json"))
+	if _, err := keys.Key(context.Background(), "unknown"); err == nil {
+		t.Fatal("Key(unknown) with a broken JWKS succeeded")
+	}
+	// the keys loaded before stay
+	if _, err := keys.Key(context.Background(), "k1"); err != nil {
+		t.Fatalf("Key(k1) after a failed reload: %v", err)
+	}
+}
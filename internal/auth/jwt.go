@@ -0,0 +1,123 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/models"
+)
+
+// Role is a role of a human operator granted by the OIDC provider.
+type Role string
+
+const (
+	RoleViewer Role = "viewer"
+	RoleEditor Role = "editor"
+	RoleAdmin  Role = "admin"
+)
+
+// RoleScopes are the scopes granted by each role.
+var RoleScopes = map[Role][]models.Scope{
+	RoleViewer: {models.ScopeUsersRead, models.ScopeSegmentsRead, models.ScopeEvaluate},
+	RoleEditor: {models.ScopeUsersRead, models.ScopeSegmentsRead, models.ScopeEvaluate, models.ScopeUsersWrite, models.ScopeSegmentsWrite},
+	RoleAdmin:  {models.ScopeAdmin},
+}
+
+// TokenConfig configures the verification of bearer tokens.
+type TokenConfig struct {
+	Issuer   string // required iss claim, not checked when empty
+	Audience string // required aud claim, not checked when empty
+	// RolesClaim is the claim with the roles, a dotted path such as realm_access.roles reads a nested claim
+	RolesClaim string
+}
+
+// TokenVerifier verifies RS256 and ES256 signed JWTs issued by the OIDC provider.
+type TokenVerifier struct {
+	keys   *KeySet
+	cfg    TokenConfig
+	parser *jwt.Parser
+}
+
+func NewTokenVerifier(keys *KeySet, cfg TokenConfig) *TokenVerifier {
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodES256.Alg()}),
+		jwt.WithExpirationRequired(),
+	}
+	if cfg.Issuer != "" {
+		options = append(options, jwt.WithIssuer(cfg.Issuer))
+	}
+	if cfg.Audience != "" {
+		options = append(options, jwt.WithAudience(cfg.Audience))
+	}
+	if cfg.RolesClaim == "" {
+		cfg.RolesClaim = "roles"
+	}
+	return &TokenVerifier{keys: keys, cfg: cfg, parser: jwt.NewParser(options...)}
+}
+
+// Verify checks the signature and the claims of the token and returns its principal.
+func (v *TokenVerifier) Verify(ctx context.Context, token string) (*Principal, error) {
+	claims := jwt.MapClaims{}
+	_, err := v.parser.ParseWithClaims(token, claims, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		return v.keys.Key(ctx, kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCredentials, err)
+	}
+
+	subject, _ := claims.GetSubject()
+	if subject == "" {
+		return nil, fmt.Errorf("%w: token has no subject", ErrInvalidCredentials)
+	}
+
+	var scopes []models.Scope
+	for _, role := range v.roles(claims) {
+		for _, scope := range RoleScopes[role] {
+			if !slices.Contains(scopes, scope) {
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+
+	return &Principal{Subject: "user:" + subject, Name: displayName(claims, subject), Scopes: scopes}, nil
+}
+
+// roles returns the roles in the roles claim, which is either a string or an array of strings.
+func (v *TokenVerifier) roles(claims jwt.MapClaims) []Role {
+	var value any = map[string]any(claims)
+	for _, name := range strings.Split(v.cfg.RolesClaim, ".") {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return nil
+		}
+		value = object[name]
+	}
+
+	var roles []Role
+	switch value := value.(type) {
+	case string:
+		for _, role := range strings.Fields(value) {
+			roles = append(roles, Role(role))
+		}
+	case []any:
+		for _, role := range value {
+			if role, ok := role.(string); ok {
+				roles = append(roles, Role(role))
+			}
+		}
+	}
+	return roles
+}
+
+func displayName(claims jwt.MapClaims, subject string) string {
+	for _, claim := range []string{"email", "preferred_username", "name"} {
+		if name, ok := claims[claim].(string); ok && name != "" {
+			return name
+		}
+	}
+	return subject
+}
@@ -0,0 +1,196 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/models"
+)
+
+const (
+	testIssuer   = "https://id.example.com/realms/avito"
+	testAudience = "segment-api"
+)
+
+// newTestVerifier returns a verifier of tokens signed by the RSA key "rsa" and the EC key "ec" of a JWKS server.
+func newTestVerifier(t *testing.T, cfg TokenConfig) (*TokenVerifier, map[string]crypto.Signer) {
+	t.Helper()
+	signers := map[string]crypto.Signer{"rsa": newRSAKey(t), "ec": newECKey(t)}
+	server := newJWKSServer(t, newJWKS(t, signers))
+	keys, err := NewKeySet(context.Background(), server.URL, time.Minute)
+	if err != nil {
+		t.Fatalf("NewKeySet: %v", err)
+	}
+	return NewTokenVerifier(keys, cfg), signers
+}
+
+// validClaims are claims every check of the verifier with testIssuer and testAudience passes.
+func validClaims() jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss":   testIssuer,
+		"aud":   testAudience,
+		"sub":   "42",
+		"email": "analyst@example.com",
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+		"roles": []string{"viewer"},
+	}
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, kid string, key any, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("SignedString: %v", err)
+	}
+	return signed
+}
+
+func TestVerifyAcceptsSignedTokens(t *testing.T) {
+	verifier, signers := newTestVerifier(t, TokenConfig{Issuer: testIssuer, Audience: testAudience})
+
+	for _, tt := range []struct {
+		method jwt.SigningMethod
+		kid    string
+	}{
+		{jwt.SigningMethodRS256, "rsa"},
+		{jwt.SigningMethodES256, "ec"},
+	} {
+		t.Run(tt.method.Alg(), func(t *testing.T) {
+			principal, err := verifier.Verify(context.Background(), sign(t, tt.method, tt.kid, signers[tt.kid], validClaims()))
+			if err != nil {
+				t.Fatalf("Verify: %v", err)
+			}
+			if principal.Subject != "user:42" || principal.Name != "analyst@example.com" {
+				t.Fatalf("principal = %+v, want user:42 named analyst@example.com", principal)
+			}
+		})
+	}
+}
+
+func TestVerifyRejectsTokens(t *testing.T) {
+	verifier, signers := newTestVerifier(t, TokenConfig{Issuer: testIssuer, Audience: testAudience})
+	rsaKey := signers["rsa"]
+
+	public, err := x509.MarshalPKIXPublicKey(rsaKey.Public())
+	if err != nil {
+		t.Fatalf("MarshalPKIXPublicKey: %v", err)
+	}
+
+	with := func(change func(claims jwt.MapClaims)) jwt.MapClaims {
+		claims := validClaims()
+		change(claims)
+		return claims
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"alg none", sign(t, jwt.SigningMethodNone, "rsa", jwt.UnsafeAllowNoneSignatureType, validClaims())},
+		// HMAC keyed with the public key is the classic confusion of asymmetric verifiers
+		{"HS256", sign(t, jwt.SigningMethodHS256, "rsa", public, validClaims())},
+		{"RS256 by another key", sign(t, jwt.SigningMethodRS256, "rsa", newRSAKey(t), validClaims())},
+		{"key of another type", sign(t, jwt.SigningMethodES256, "rsa", signers["ec"], validClaims())},
+		{"unknown kid", sign(t, jwt.SigningMethodRS256, "unknown", rsaKey, validClaims())},
+		{"expired", sign(t, jwt.SigningMethodRS256, "rsa", rsaKey, with(func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Minute).Unix() }))},
+		{"without exp", sign(t, jwt.SigningMethodRS256, "rsa", rsaKey, with(func(c jwt.MapClaims) { delete(c, "exp") }))},
+		{"not yet valid", sign(t, jwt.SigningMethodRS256, "rsa", rsaKey, with(func(c jwt.MapClaims) { c["nbf"] = time.Now().Add(time.Minute).Unix() }))},
+		{"wrong issuer", sign(t, jwt.SigningMethodRS256, "rsa", rsaKey, with(func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" }))},
+		{"wrong audience", sign(t, jwt.SigningMethodRS256, "rsa", rsaKey, with(func(c jwt.MapClaims) { c["aud"] = []string{"billing-api"} }))},
+		{"without subject", sign(t, jwt.SigningMethodRS256, "rsa", rsaKey, with(func(c jwt.MapClaims) { delete(c, "sub") }))},
+		{"tampered", sign(t, jwt.SigningMethodRS256, "rsa", rsaKey, validClaims()) + "x"},
+		{"malformed", "not.a.token"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			principal, err := verifier.Verify(context.Background(), tt.token)
+			if !errors.Is(err, ErrInvalidCredentials) {
+				t.Fatalf("Verify = %+v, %v, want ErrInvalidCredentials", principal, err)
+			}
+		})
+	}
+}
+
+func TestVerifyWithoutIssuerAndAudience(t *testing.T) {
+	verifier, signers := newTestVerifier(t, TokenConfig{})
+	claims := validClaims()
+	claims["iss"], claims["aud"] = "https://other.example.com", "other"
+
+	if _, err := verifier.Verify(context.Background(), sign(t, jwt.SigningMethodRS256, "rsa", signers["rsa"], claims)); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+}
+
+func TestVerifyRoles(t *testing.T) {
+	read := []models.Scope{models.ScopeUsersRead, models.ScopeSegmentsRead, models.ScopeEvaluate}
+	write := append(slices.Clone(read), models.ScopeUsersWrite, models.ScopeSegmentsWrite)
+
+	tests := []struct {
+		name   string
+		claim  string
+		roles  any
+		scopes []models.Scope
+	}{
+		{"viewer", "", []string{"viewer"}, read},
+		{"editor", "", []string{"editor"}, write},
+		{"admin", "", []string{"admin"}, []models.Scope{models.ScopeAdmin}},
+		{"viewer and editor", "", []string{"viewer", "editor"}, write},
+		{"space-separated string", "", "viewer editor", write},
+		{"unknown role", "", []string{"owner"}, nil},
+		{"no roles", "", nil, nil},
+		{"nested claim", "realm_access.roles", map[string]any{"roles": []string{"admin"}}, []models.Scope{models.ScopeAdmin}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verifier, signers := newTestVerifier(t, TokenConfig{RolesClaim: tt.claim})
+			claims := validClaims()
+			delete(claims, "roles")
+			if tt.roles != nil {
+				name, _, _ := strings.Cut(tt.claim, ".")
+				if name == "" {
+					name = "roles"
+				}
+				claims[name] = tt.roles
+			}
+
+			principal, err := verifier.Verify(context.Background(), sign(t, jwt.SigningMethodRS256, "rsa", signers["rsa"], claims))
+			if err != nil {
+				t.Fatalf("Verify: %v", err)
+			}
+			if !slices.Equal(principal.Scopes, tt.scopes) {
+				t.Fatalf("scopes = %v, want %v", principal.Scopes, tt.scopes)
+			}
+		})
+	}
+}
+
+func TestVerifyReloadsRotatedKey(t *testing.T) {
+	old, rotated := newRSAKey(t), newRSAKey(t)
+	server := newJWKSServer(t, newJWKS(t, map[string]crypto.Signer{"old": old}))
+	keys, err := NewKeySet(context.Background(), server.URL, 0)
+	if err != nil {
+		t.Fatalf("NewKeySet: %v", err)
+	}
+	verifier := NewTokenVerifier(keys, TokenConfig{})
+
+	server.set(newJWKS(t, map[string]crypto.Signer{"old": old, "new": rotated}))
+	if _, err := verifier.Verify(context.Background(), sign(t, jwt.SigningMethodRS256, "new", rotated, validClaims())); err != nil {
+		t.Fatalf("Verify of a token signed by the rotated key: %v", err)
+	}
+	if got := server.requests.Load(); got != 2 {
+		t.Fatalf("JWKS requests = %d, want 2", got)
+	}
+}
@@ -0,0 +1,38 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+)
+
+const (
+	keyPrefix = "sk_"
+	// prefixLength is the number of leading characters of a key shown in listings
+	prefixLength = 10
+)
+
+// GenerateKey returns a new random API key.
+func GenerateKey() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("failed to generate API key: %w", err)
+	}
+	return keyPrefix + base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+// HashKey returns the hex SHA-256 of the key. Keys are random, so a fast hash without salt is enough.
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// KeyPrefix returns the leading characters of the key identifying it without revealing it.
+func KeyPrefix(key string) string {
+	if len(key) < prefixLength {
+		return key
+	}
+	return key[:prefixLength]
+}
@@ -18,15 +18,78 @@ type Config struct {
 		Port        string        `yaml:"port" env-default:"8080"`
 		Timeout     time.Duration `yaml:"timeout" env-default:"4s"`
 		IdleTimeout time.Duration `yaml:"idle-timeout" env-default:"60s"`
+		// ShutdownDelay is how long the server keeps serving while not ready before it stops,
+		// so load balancers stop sending it requests first
+		ShutdownDelay time.Duration `yaml:"shutdown-delay" env:"SERVER_SHUTDOWN_DELAY" env-default:"5s"`
+		// RequireIfMatch rejects updates, patches and deletes of users and segments without the If-Match header
+		RequireIfMatch bool `yaml:"require-if-match" env:"SERVER_REQUIRE_IF_MATCH" env-default:"false"`
 	} `yaml:"server"`
 
+	Storage struct {
+		Driver string `yaml:"driver" env:"STORAGE_DRIVER" env-default:"postgres"` // memory or postgres
+	} `yaml:"storage"`
+
 	Database struct {
 		Host     string `yaml:"host" env:"POSTGRES_HOST"`
 		Port     string `yaml:"port" env:"POSTGRES_PORT"`
 		Username string `yaml:"user" env:"POSTGRES_USER"`
 		Password string `yaml:"pass" env:"POSTGRES_PASSWORD"`
 		DbName   string `yaml:"db-name" env:"POSTGRES_DB"`
+		// AutoMigrate applies pending migrations on start, otherwise run segment-api migrate up
+		AutoMigrate bool `yaml:"auto-migrate" env:"POSTGRES_AUTO_MIGRATE" env-default:"true"`
 	} `yaml:"database" env-required:"true"`
+
+	Cache struct {
+		// Size is the number of keys of the in-process cache of user segment lookups, 0 disables the cache
+		Size int           `yaml:"size" env:"CACHE_SIZE" env-default:"10000"`
+		TTL  time.Duration `yaml:"ttl" env:"CACHE_TTL" env-default:"30s"`
+	} `yaml:"cache"`
+
+	Auth struct {
+		// Enabled requires an API key with the scope of the route on every API request
+		Enabled bool `yaml:"enabled" env:"AUTH_ENABLED" env-default:"true"`
+		// AdminKey is granted the admin scope, it bootstraps creating API keys
+		AdminKey string `yaml:"admin-key" env:"AUTH_ADMIN_KEY"`
+
+		// JWT verifies bearer tokens of human operators issued by the OIDC provider
+		JWT struct {
+			// JWKS is a file path or an http(s) URL of the provider keys, empty disables bearer tokens
+			JWKS        string        `yaml:"jwks" env:"AUTH_JWT_JWKS"`
+			JWKSRefresh time.Duration `yaml:"jwks-refresh" env:"AUTH_JWT_JWKS_REFRESH" env-default:"5m"`
+			Issuer      string        `yaml:"issuer" env:"AUTH_JWT_ISSUER"`
+			Audience    string        `yaml:"audience" env:"AUTH_JWT_AUDIENCE"`
+			// RolesClaim is the claim with the viewer, editor or admin roles, such as realm_access.roles
+			RolesClaim string `yaml:"roles-claim" env:"AUTH_JWT_ROLES_CLAIM" env-default:"roles"`
+		} `yaml:"jwt"`
+	} `yaml:"auth"`
+
+	Idempotency struct {
+		// TTL is how long the response of a request with an Idempotency-Key is returned to its retries
+		TTL time.Duration `yaml:"ttl" env:"IDEMPOTENCY_TTL" env-default:"24h"`
+	} `yaml:"idempotency"`
+
+	Tracing struct {
+		Exporter string `yaml:"exporter" env:"TRACING_EXPORTER" env-default:"none"` // none, stdout or otlp
+		// Endpoint is the host:port of the OTLP HTTP collector
+		Endpoint    string  `yaml:"endpoint" env:"TRACING_ENDPOINT" env-default:"localhost:4318"`
+		Insecure    bool    `yaml:"insecure" env:"TRACING_INSECURE" env-default:"true"`
+		SampleRatio float64 `yaml:"sample-ratio" env:"TRACING_SAMPLE_RATIO" env-default:"1"`
+	} `yaml:"tracing"`
+
+	Log struct {
+		Level  string `yaml:"level" env:"LOG_LEVEL" env-default:"info"`   // debug, info, warn or error
+		Format string `yaml:"format" env:"LOG_FORMAT" env-default:"text"` // text or json
+	} `yaml:"log"`
+
+	Metrics struct {
+		// SegmentUsersTTL is how long the counted users per segment are exposed before they are counted again
+		SegmentUsersTTL time.Duration `yaml:"segment-users-ttl" env:"METRICS_SEGMENT_USERS_TTL" env-default:"1m"`
+	} `yaml:"metrics"`
+
+	Reaper struct {
+		// Interval of deleting expired memberships, segment aliases and idempotency keys, 0 disables the reaper
+		Interval time.Duration `yaml:"interval" env:"REAPER_INTERVAL" env-default:"1m"`
+	} `yaml:"reaper"`
 }
 
 func MustLoad() Config {
@@ -44,5 +107,9 @@ func MustLoad() Config {
 		log.Fatalf("cannot read config: %s", err)
 	}
 
+	if cfg.Reaper.Interval < 0 {
+		log.Fatalf("invalid reaper interval %s: must not be negative", cfg.Reaper.Interval)
+	}
+
 	return cfg
 }
@@ -0,0 +1,152 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/auth"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/models"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/storage"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/validate"
+)
+
+type APIKeyHandler struct {
+	ks storage.APIKeyStorage
+}
+
+func NewAPIKeyHandler(ks storage.APIKeyStorage) *APIKeyHandler {
+	return &APIKeyHandler{ks: ks}
+}
+
+type CreateAPIKeyRequest struct {
+	Name   string         `json:"name" example:"mobile-backend" validate:"required,max=128"`
+	Scopes []models.Scope `json:"scopes" example:"segments:read,evaluate" validate:"required"`
+}
+
+// Validate rejects unknown scopes.
+func (req *CreateAPIKeyRequest) Validate() validate.Errors {
+	var violations validate.Errors
+	for i, scope := range req.Scopes {
+		if !slices.Contains(models.Scopes, scope) {
+			violations = append(violations, validate.Violation{
+				Field:   fmt.Sprintf("scopes[%d]", i),
+				Rule:    "scope",
+				Message: fmt.Sprintf("must be one of %v", models.Scopes),
+			})
+		}
+	}
+	return violations
+}
+
+type CreateAPIKeyResponse struct {
+	*models.APIKey
+	Key string `json:"key" example:"sk_Zx3kTqH8n1cC5cU0q2dYl4yqf1mW7HnA2oVbJkR9sPe"` // returned only once
+}
+
+type APIKeysResponse struct {
+	APIKeys []*models.APIKey `json:"api_keys"`
+}
+
+// CreateAPIKey godoc
+//
+// @Summary Create an API key
+// @Description Creates an API key with the scopes. The key is returned only in this response, only its hash is stored.
+// @Description The "admin" scope grants every other scope and managing API keys.
+// @Tags api-keys
+// @Accept json
+// @Produce json
+// @Param key body CreateAPIKeyRequest true "The API key to create"
+// @Success 201 {object} CreateAPIKeyResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /api/v1/api-keys [post]
+func (h *APIKeyHandler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req CreateAPIKeyRequest
+	if errResponse := decodeRequest(r, &req); errResponse != nil {
+		render.Render(w, r, errResponse)
+		return
+	}
+
+	key, err := auth.GenerateKey()
+	if err != nil {
+		render.Render(w, r, ErrInternalServer(err))
+		return
+	}
+
+	apiKey := models.APIKey{
+		Name:   req.Name,
+		Prefix: auth.KeyPrefix(key),
+		Hash:   auth.HashKey(key),
+		Scopes: req.Scopes,
+	}
+
+	if err := h.ks.CreateAPIKey(r.Context(), &apiKey); err != nil {
+		render.Render(w, r, ErrStorage(err))
+		return
+	}
+
+	render.Status(r, http.StatusCreated)
+	render.JSON(w, r, CreateAPIKeyResponse{APIKey: &apiKey, Key: key})
+}
+
+// ListAPIKeys godoc
+//
+// @Summary List API keys
+// @Description Returns all API keys sorted by ID, keys are identified by their prefix
+// @Tags api-keys
+// @Accept json
+// @Produce json
+// @Success 200 {object} APIKeysResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /api/v1/api-keys [get]
+func (h *APIKeyHandler) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.ks.GetAPIKeys(r.Context())
+	if err != nil {
+		render.Render(w, r, ErrStorage(err))
+		return
+	}
+
+	render.JSON(w, r, APIKeysResponse{APIKeys: keys})
+}
+
+// DeleteAPIKey godoc
+//
+// @Summary Revoke an API key
+// @Description Deletes an API key, requests with it are rejected right away
+// @Tags api-keys
+// @Accept json
+// @Produce json
+// @Param id path int true "ID of the API key to delete"
+// @Success 204 "No Content"
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /api/v1/api-keys/{id} [delete]
+func (h *APIKeyHandler) DeleteAPIKey(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(fmt.Errorf("invalid API key ID '%s'", idStr)))
+		return
+	}
+
+	if err := h.ks.DeleteAPIKey(r.Context(), id); err != nil {
+		render.Render(w, r, ErrStorage(err))
+		return
+	}
+
+	render.Status(r, http.StatusNoContent)
+}
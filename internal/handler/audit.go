@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/go-chi/render"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/models"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/storage"
+)
+
+type AuditHandler struct {
+	as storage.AuditStorage
+}
+
+func NewAuditHandler(as storage.AuditStorage) *AuditHandler {
+	return &AuditHandler{as: as}
+}
+
+// AuditPage is a page of audit entries. Pass NextCursor as the cursor parameter to get the next page.
+type AuditPage struct {
+	Items      []*models.AuditEntry `json:"items"`
+	NextCursor string               `json:"next_cursor,omitempty"`
+	Total      *int64               `json:"total,omitempty"`
+}
+
+func newAuditPage(page *storage.Page[models.AuditEntry]) AuditPage {
+	items := page.Items
+	if items == nil {
+		items = []*models.AuditEntry{}
+	}
+	return AuditPage{Items: items, NextCursor: page.NextCursor, Total: page.Total}
+}
+
+// ListAudit godoc
+//
+// @Summary List audit entries
+// @Description Returns a page of audit entries of changes made through the API in the order of the changes.
+// @Description Every entry has the actor, the action, the resource and its JSON before and after the change.
+// @Description Memberships are identified by "<segment>:<user ID>", changes of all user segments are recorded on the user.
+// @Tags audit
+// @Accept json
+// @Produce json
+// @Param limit query int false "Page size" default(100) maximum(1000)
+// @Param cursor query string false "Cursor of the next page returned with the previous page"
+// @Param order query string false "Sort order" Enums(asc, desc) default(asc)
+// @Param with_total query bool false "Count all entries matching the filter"
+// @Param actor query string false "Actor, such as api-key:3 or user:<subject>"
+// @Param action query string false "Action" Enums(user.create, user.update, user.delete, user.segments.update, segment.create, segment.update, segment.delete, membership.add, membership.remove)
+// @Param resource_type query string false "Resource type" Enums(user, segment, membership)
+// @Param resource_id query string false "Resource ID"
+// @Param from query string false "Only entries created at or after the time in RFC 3339 format"
+// @Param to query string false "Only entries created before the time in RFC 3339 format"
+// @Success 200 {object} AuditPage
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /api/v1/audit [get]
+func (h *AuditHandler) ListAudit(w http.ResponseWriter, r *http.Request) {
+	params, errResponse := parseListParams(r)
+	if errResponse != nil {
+		render.Render(w, r, errResponse)
+		return
+	}
+
+	from, errResponse := parseTimeParam(r, "from")
+	if errResponse != nil {
+		render.Render(w, r, errResponse)
+		return
+	}
+	to, errResponse := parseTimeParam(r, "to")
+	if errResponse != nil {
+		render.Render(w, r, errResponse)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := storage.AuditFilter{
+		Actor:        query.Get("actor"),
+		Action:       models.AuditAction(query.Get("action")),
+		ResourceType: query.Get("resource_type"),
+		ResourceID:   query.Get("resource_id"),
+		From:         from,
+		To:           to,
+	}
+
+	page, err := h.as.GetAuditEntries(r.Context(), filter, params)
+	if err != nil {
+		render.Render(w, r, ErrStorage(err))
+		return
+	}
+
+	render.JSON(w, r, newAuditPage(page))
+}
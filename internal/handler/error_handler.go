@@ -1,10 +1,32 @@
 package handler
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 
 	"github.com/go-chi/render"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/storage"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/validate"
+)
+
+// Application error codes returned in ErrorResponse.AppCode. They are part of the API,
+// so existing values must never change.
+const (
+	CodeInternal       int64 = 1000
+	CodeInvalidRequest int64 = 1001
+	CodeNotFound       int64 = 1002
+	CodeAlreadyExists  int64 = 1003
+	CodeConflict       int64 = 1004
+	CodeValidation     int64 = 1005
+	CodeUnauthorized   int64 = 1006
+	CodeForbidden      int64 = 1007
+	// CodeIdempotencyConflict is returned for an Idempotency-Key of another request or of a request in progress
+	CodeIdempotencyConflict int64 = 1008
+	// CodePreconditionFailed is returned when the resource was changed since the representation in If-Match
+	CodePreconditionFailed int64 = 1009
+	// CodePreconditionRequired is returned for a change without If-Match when it is required
+	CodePreconditionRequired int64 = 1010
 )
 
 type ErrorResponse struct {
@@ -14,6 +36,8 @@ type ErrorResponse struct {
 	StatusText string `json:"status" example:"Resource not found."`                                         // user-level status message
 	AppCode    int64  `json:"code,omitempty" example:"404"`                                                 // application-specific error code
 	ErrorText  string `json:"error,omitempty" example:"The requested resource was not found on the server"` // application-level error message, for debugging
+
+	Violations validate.Errors `json:"violations,omitempty"` // rules the request fields do not pass
 }
 
 func (e *ErrorResponse) Render(w http.ResponseWriter, r *http.Request) error {
@@ -26,6 +50,7 @@ func ErrInvalidRequest(err error) render.Renderer {
 		Err:            err,
 		HTTPStatusCode: http.StatusBadRequest,
 		StatusText:     "Invalid request.",
+		AppCode:        CodeInvalidRequest,
 		ErrorText:      err.Error(),
 	}
 }
@@ -43,6 +68,7 @@ func ErrNotFound() render.Renderer {
 	return &ErrorResponse{
 		HTTPStatusCode: http.StatusNotFound,
 		StatusText:     "Resource not found.",
+		AppCode:        CodeNotFound,
 	}
 }
 
@@ -51,14 +77,87 @@ func ErrInternalServer(err error) render.Renderer {
 		Err:            err,
 		HTTPStatusCode: http.StatusInternalServerError,
 		StatusText:     "Internal Server Error.",
+		AppCode:        CodeInternal,
+		ErrorText:      err.Error(),
+	}
+}
+
+func ErrUnauthorized(err error) render.Renderer {
+	return &ErrorResponse{
+		Err:            err,
+		HTTPStatusCode: http.StatusUnauthorized,
+		StatusText:     "Unauthorized.",
+		AppCode:        CodeUnauthorized,
+		ErrorText:      err.Error(),
+	}
+}
+
+func ErrForbidden(scope string) render.Renderer {
+	return &ErrorResponse{
+		HTTPStatusCode: http.StatusForbidden,
+		StatusText:     "Forbidden.",
+		AppCode:        CodeForbidden,
+		ErrorText:      fmt.Sprintf("missing scope '%s'", scope),
+	}
+}
+
+func ErrIdempotencyConflict(err error) render.Renderer {
+	return &ErrorResponse{
+		Err:            err,
+		HTTPStatusCode: http.StatusConflict,
+		StatusText:     "Idempotency key conflict.",
+		AppCode:        CodeIdempotencyConflict,
 		ErrorText:      err.Error(),
 	}
 }
 
+func ErrPreconditionFailed(err error) render.Renderer {
+	return &ErrorResponse{
+		Err:            err,
+		HTTPStatusCode: http.StatusPreconditionFailed,
+		StatusText:     "Precondition failed.",
+		AppCode:        CodePreconditionFailed,
+		ErrorText:      err.Error(),
+	}
+}
+
+func ErrPreconditionRequired() render.Renderer {
+	return &ErrorResponse{
+		HTTPStatusCode: http.StatusPreconditionRequired,
+		StatusText:     "Precondition required.",
+		AppCode:        CodePreconditionRequired,
+		ErrorText:      "missing required header 'If-Match'",
+	}
+}
+
+func ErrUnsupportedMediaType(contentType string) render.Renderer {
+	return &ErrorResponse{
+		HTTPStatusCode: http.StatusUnsupportedMediaType,
+		StatusText:     http.StatusText(http.StatusUnsupportedMediaType),
+		AppCode:        CodeInvalidRequest,
+		ErrorText:      fmt.Sprintf("unsupported content type '%s'", contentType),
+	}
+}
+
+// ErrValidationFailed lists the violations of validate.Errors in err.
+func ErrValidationFailed(err error) render.Renderer {
+	var violations validate.Errors
+	errors.As(err, &violations)
+	return &ErrorResponse{
+		Err:            err,
+		HTTPStatusCode: http.StatusBadRequest,
+		StatusText:     "Validation failed.",
+		AppCode:        CodeValidation,
+		ErrorText:      err.Error(),
+		Violations:     violations,
+	}
+}
+
 func ErrMissingField(field string) render.Renderer {
 	return &ErrorResponse{
 		HTTPStatusCode: http.StatusBadRequest,
 		StatusText:     http.StatusText(http.StatusBadRequest),
+		AppCode:        CodeInvalidRequest,
 		ErrorText:      fmt.Sprintf("missing required field '%s'", field),
 	}
 }
@@ -67,6 +166,51 @@ func ErrInvalidField(fieldName string, fieldValue string) render.Renderer {
 	return &ErrorResponse{
 		HTTPStatusCode: http.StatusBadRequest,
 		StatusText:     http.StatusText(http.StatusBadRequest),
+		AppCode:        CodeInvalidRequest,
 		ErrorText:      fmt.Sprintf("invalid value '%s' for field '%s'", fieldValue, fieldName),
 	}
 }
+
+// ErrStorage maps an error returned by a storage to the response status and application code.
+func ErrStorage(err error) render.Renderer {
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		return &ErrorResponse{
+			Err:            err,
+			HTTPStatusCode: http.StatusNotFound,
+			StatusText:     "Resource not found.",
+			AppCode:        CodeNotFound,
+			ErrorText:      err.Error(),
+		}
+	case errors.Is(err, storage.ErrAlreadyExists):
+		return &ErrorResponse{
+			Err:            err,
+			HTTPStatusCode: http.StatusConflict,
+			StatusText:     "Resource already exists.",
+			AppCode:        CodeAlreadyExists,
+			ErrorText:      err.Error(),
+		}
+	case errors.Is(err, storage.ErrConflict):
+		return &ErrorResponse{
+			Err:            err,
+			HTTPStatusCode: http.StatusConflict,
+			StatusText:     "Conflict with the current state of the resource.",
+			AppCode:        CodeConflict,
+			ErrorText:      err.Error(),
+		}
+	case errors.Is(err, storage.ErrVersionMismatch):
+		return ErrPreconditionFailed(err)
+	case errors.As(err, new(validate.Errors)):
+		return ErrValidationFailed(err)
+	case errors.Is(err, storage.ErrValidation):
+		return &ErrorResponse{
+			Err:            err,
+			HTTPStatusCode: http.StatusBadRequest,
+			StatusText:     "Validation failed.",
+			AppCode:        CodeValidation,
+			ErrorText:      err.Error(),
+		}
+	default:
+		return ErrInternalServer(err)
+	}
+}
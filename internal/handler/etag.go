@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/render"
+)
+
+// ifMatchTag returns the entity tag the If-Match header requires, an empty string when the header is absent or "*".
+// Storages compare it with the tag of the representation while the resource is locked for the change.
+func ifMatchTag(r *http.Request) (string, render.Renderer) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" || header == "*" {
+		return "", nil
+	}
+
+	tags := strings.Split(header, ",")
+	if len(tags) > 1 {
+		return "", ErrInvalidField("If-Match", header)
+	}
+	return strings.TrimSpace(tags[0]), nil
+}
+
+// notModified responds 304 when the If-None-Match header of the request matches the entity tag.
+// The entity tag is set on the response either way.
+func notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	if etag == "" {
+		return false
+	}
+	w.Header().Set("ETag", etag)
+
+	header := r.Header.Get("If-None-Match")
+	if header == "" {
+		return false
+	}
+
+	for _, tag := range strings.Split(header, ",") {
+		// If-None-Match uses the weak comparison
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+// RequireIfMatch rejects requests without the If-Match header with 428 when required,
+// so clients cannot overwrite changes they have not seen.
+func RequireIfMatch(required bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if !required {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("If-Match") == "" {
+				render.Render(w, r, ErrPreconditionRequired())
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/models"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/storage"
+)
+
+// newETagAPI returns the test API with the user 1 named ivan and the segment SEGMENT.
+func newETagAPI(t *testing.T) *testAPI {
+	t.Helper()
+	api := newTestAPI(t)
+	if err := api.users.CreateUser(context.Background(), &models.User{FirstName: "Ivan", LastName: "Ivanov", Username: "ivan"}); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	if err := api.segments.CreateSegment(context.Background(), &models.Segment{Name: "SEGMENT"}); err != nil {
+		t.Fatalf("CreateSegment: %v", err)
+	}
+	return api
+}
+
+// etag returns the entity tag GET responds with for the path.
+func (a *testAPI) etag(t *testing.T, path string) string {
+	t.Helper()
+	rec := a.do(t, http.MethodGet, path, "", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET %s = %d: %s", path, rec.Code, rec.Body)
+	}
+	return rec.Header().Get("ETag")
+}
+
+func requireStatus(t *testing.T, rec *httptest.ResponseRecorder, status int, code int64) {
+	t.Helper()
+	if rec.Code != status {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, status, rec.Body)
+	}
+	if response := decodeResponse[ErrorResponse](t, rec); response.AppCode != code {
+		t.Fatalf("code = %d, want %d", response.AppCode, code)
+	}
+}
+
+func TestNotModified(t *testing.T) {
+	api := newETagAPI(t)
+
+	for _, path := range []string{"/users/1", "/segments/SEGMENT"} {
+		etag := api.etag(t, path)
+		if etag == "" {
+			t.Fatalf("GET %s has no ETag", path)
+		}
+
+		tests := []struct {
+			ifNoneMatch string
+			status      int
+		}{
+			{etag, http.StatusNotModified},
+			{"W/" + etag, http.StatusNotModified},
+			{`"other", ` + etag, http.StatusNotModified},
+			{"*", http.StatusNotModified},
+			{`"other"`, http.StatusOK},
+		}
+
+		for _, tt := range tests {
+			rec := api.do(t, http.MethodGet, path, "", http.Header{"If-None-Match": {tt.ifNoneMatch}})
+			if rec.Code != tt.status {
+				t.Fatalf("GET %s with If-None-Match %s = %d, want %d", path, tt.ifNoneMatch, rec.Code, tt.status)
+			}
+			if rec.Header().Get("ETag") != etag {
+				t.Fatalf("ETag = %s, want %s", rec.Header().Get("ETag"), etag)
+			}
+			if tt.status == http.StatusNotModified && rec.Body.Len() != 0 {
+				t.Fatalf("304 has the body %s", rec.Body)
+			}
+		}
+	}
+}
+
+func TestIfMatchUser(t *testing.T) {
+	api := newETagAPI(t)
+	etag := api.etag(t, "/users/1")
+	update := `{"ID": 1, "firstname": "Petr", "lastname": "Petrov", "username": "petr"}`
+
+	rec := api.do(t, http.MethodPut, "/users/1", update, http.Header{"If-Match": {etag}})
+	if rec.Code >= http.StatusMultipleChoices {
+		t.Fatalf("PUT with the current ETag = %d: %s", rec.Code, rec.Body)
+	}
+	updated := rec.Header().Get("ETag")
+	if updated == etag || updated != api.etag(t, "/users/1") {
+		t.Fatalf("ETag of PUT = %s, want the new ETag of GET", updated)
+	}
+
+	requireStatus(t, api.do(t, http.MethodPut, "/users/1", update, http.Header{"If-Match": {etag}}), http.StatusPreconditionFailed, CodePreconditionFailed)
+	requireStatus(t, api.do(t, http.MethodPatch, "/users/1", `{"firstname": "Ivan"}`, http.Header{
+		"Content-Type": {"application/merge-patch+json"},
+		"If-Match":     {etag},
+	}), http.StatusPreconditionFailed, CodePreconditionFailed)
+	requireStatus(t, api.do(t, http.MethodDelete, "/users/1", "", http.Header{"If-Match": {etag}}), http.StatusPreconditionFailed, CodePreconditionFailed)
+	// weak tags never match If-Match
+	requireStatus(t, api.do(t, http.MethodDelete, "/users/1", "", http.Header{"If-Match": {"W/" + updated}}), http.StatusPreconditionFailed, CodePreconditionFailed)
+	requireStatus(t, api.do(t, http.MethodDelete, "/users/1", "", http.Header{"If-Match": {updated + `, "other"`}}), http.StatusBadRequest, CodeInvalidRequest)
+
+	// a membership changes the tag without changing the fields of the user
+	if err := api.segments.AddUserToSegment(context.Background(), "SEGMENT", 1, nil, ""); err != nil {
+		t.Fatalf("AddUserToSegment: %v", err)
+	}
+	rec = api.do(t, http.MethodPatch, "/users/1", `{"firstname": "Ivan"}`, http.Header{
+		"Content-Type": {"application/merge-patch+json"},
+		"If-Match":     {updated},
+	})
+	requireStatus(t, rec, http.StatusPreconditionFailed, CodePreconditionFailed)
+
+	current := api.etag(t, "/users/1")
+	rec = api.do(t, http.MethodPatch, "/users/1", `{"firstname": "Ivan"}`, http.Header{
+		"Content-Type": {"application/merge-patch+json"},
+		"If-Match":     {current},
+	})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("PATCH with the current ETag = %d: %s", rec.Code, rec.Body)
+	}
+	patched := decodeResponse[models.User](t, rec)
+	if len(patched.Segments) != 1 || rec.Header().Get("ETag") != storage.ETag(patched) || rec.Header().Get("ETag") != api.etag(t, "/users/1") {
+		t.Fatalf("PATCH = %s with ETag %s, want the user with its segment and the ETag of GET", rec.Body, rec.Header().Get("ETag"))
+	}
+
+	if rec := api.do(t, http.MethodDelete, "/users/1", "", http.Header{"If-Match": {"*"}}); rec.Code >= http.StatusMultipleChoices {
+		t.Fatalf("DELETE with If-Match * = %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestIfMatchSegment(t *testing.T) {
+	api := newETagAPI(t)
+	etag := api.etag(t, "/segments/SEGMENT")
+
+	rec := api.do(t, http.MethodPut, "/segments/SEGMENT", `{"auto_percent": 10}`, http.Header{"If-Match": {etag}})
+	if rec.Code >= http.StatusMultipleChoices {
+		t.Fatalf("PUT with the current ETag = %d: %s", rec.Code, rec.Body)
+	}
+	updated := rec.Header().Get("ETag")
+	if updated == etag || updated != api.etag(t, "/segments/SEGMENT") {
+		t.Fatalf("ETag of PUT = %s, want the new ETag of GET", updated)
+	}
+
+	requireStatus(t, api.do(t, http.MethodPut, "/segments/SEGMENT", `{"auto_percent": 20}`, http.Header{"If-Match": {etag}}), http.StatusPreconditionFailed, CodePreconditionFailed)
+	requireStatus(t, api.do(t, http.MethodPost, "/segments/SEGMENT/rename", `{"name": "RENAMED"}`, http.Header{"If-Match": {etag}}), http.StatusPreconditionFailed, CodePreconditionFailed)
+	requireStatus(t, api.do(t, http.MethodDelete, "/segments/SEGMENT", "", http.Header{"If-Match": {etag}}), http.StatusPreconditionFailed, CodePreconditionFailed)
+
+	// a member changes the tag without changing the fields of the segment
+	if err := api.segments.AddUserToSegment(context.Background(), "SEGMENT", 1, nil, ""); err != nil {
+		t.Fatalf("AddUserToSegment: %v", err)
+	}
+	requireStatus(t, api.do(t, http.MethodDelete, "/segments/SEGMENT", "", http.Header{"If-Match": {updated}}), http.StatusPreconditionFailed, CodePreconditionFailed)
+
+	rec = api.do(t, http.MethodPost, "/segments/SEGMENT/rename", `{"name": "RENAMED"}`, http.Header{"If-Match": {api.etag(t, "/segments/SEGMENT")}})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("rename with the current ETag = %d: %s", rec.Code, rec.Body)
+	}
+	renamed := decodeResponse[models.Segment](t, rec)
+	if renamed.Name != "RENAMED" || len(renamed.Users) != 1 || rec.Header().Get("ETag") != api.etag(t, "/segments/RENAMED") {
+		t.Fatalf("rename = %s with ETag %s, want the renamed segment with its user and the ETag of GET", rec.Body, rec.Header().Get("ETag"))
+	}
+}
+
+func TestRequireIfMatch(t *testing.T) {
+	api := newETagAPI(t)
+	required := RequireIfMatch(true)(api)
+
+	req := httptest.NewRequest(http.MethodDelete, "/segments/SEGMENT", nil)
+	rec := httptest.NewRecorder()
+	required.ServeHTTP(rec, req)
+	requireStatus(t, rec, http.StatusPreconditionRequired, CodePreconditionRequired)
+
+	req = httptest.NewRequest(http.MethodDelete, "/segments/SEGMENT", nil)
+	req.Header.Set("If-Match", api.etag(t, "/segments/SEGMENT"))
+	rec = httptest.NewRecorder()
+	required.ServeHTTP(rec, req)
+	if rec.Code >= http.StatusMultipleChoices {
+		t.Fatalf("DELETE with If-Match = %d: %s", rec.Code, rec.Body)
+	}
+
+	if RequireIfMatch(false)(api) != http.Handler(api) {
+		t.Fatal("RequireIfMatch(false) wraps the handler")
+	}
+}
@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/models"
+)
+
+// Evaluate godoc
+//
+// @Summary Evaluate the segments of a user
+// @Description Returns the slugs of the active segments of the user: explicit memberships whose TTL has not passed,
+// @Description experiments the user falls into and segments whose rule the user matches, with the variant of each
+// @Description segment with variants. Unlike the user endpoints it reads only what is needed to resolve the slugs.
+// @Tags evaluate
+// @Produce json
+// @Param user_id path int true "ID of the user to evaluate"
+// @Success 200 {object} models.Evaluation
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /api/v1/evaluate/{user_id} [get]
+func (h *UserHandler) Evaluate(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "user_id")
+	if idStr == "" {
+		render.Render(w, r, ErrInvalidRequest(fmt.Errorf(ErrMissingUserID)))
+		return
+	}
+
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(fmt.Errorf(ErrInvalidUserID)))
+		return
+	}
+
+	evaluations, err := h.us.EvaluateUsers(r.Context(), []int64{id})
+	if err != nil {
+		render.Render(w, r, ErrStorage(err))
+		return
+	}
+
+	render.JSON(w, r, evaluations[0])
+}
+
+type EvaluateRequest struct {
+	UserIDs []int64 `json:"user_ids" example:"1,2,3" validate:"required,max=1000"`
+}
+
+type EvaluateResponse struct {
+	Users []models.Evaluation `json:"users"` // in the order of the requested IDs
+}
+
+// EvaluateBatch godoc
+//
+// @Summary Evaluate the segments of many users
+// @Description Returns the slugs of the active segments of each user like GET /api/v1/evaluate/{user_id} does,
+// @Description at most 1000 users at once. Fails with 404 when any of the users does not exist.
+// @Tags evaluate
+// @Accept json
+// @Produce json
+// @Param users body EvaluateRequest true "IDs of the users to evaluate"
+// @Success 200 {object} EvaluateResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /api/v1/evaluate [post]
+func (h *UserHandler) EvaluateBatch(w http.ResponseWriter, r *http.Request) {
+	var req EvaluateRequest
+	if errResponse := decodeRequest(r, &req); errResponse != nil {
+		render.Render(w, r, errResponse)
+		return
+	}
+
+	evaluations, err := h.us.EvaluateUsers(r.Context(), req.UserIDs)
+	if err != nil {
+		render.Render(w, r, ErrStorage(err))
+		return
+	}
+
+	render.JSON(w, r, EvaluateResponse{Users: evaluations})
+}
@@ -0,0 +1,122 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/render"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/models"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/storage"
+)
+
+// UsersPage is a page of users. Pass NextCursor as the cursor parameter to get the next page.
+type UsersPage struct {
+	Items      []*models.User `json:"items"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+	Total      *int64         `json:"total,omitempty"`
+}
+
+func newUsersPage(page *storage.Page[models.User]) UsersPage {
+	items := page.Items
+	if items == nil {
+		items = []*models.User{}
+	}
+	return UsersPage{Items: items, NextCursor: page.NextCursor, Total: page.Total}
+}
+
+// SegmentsPage is a page of segments. Pass NextCursor as the cursor parameter to get the next page.
+type SegmentsPage struct {
+	Items      []*models.Segment `json:"items"`
+	NextCursor string            `json:"next_cursor,omitempty"`
+	Total      *int64            `json:"total,omitempty"`
+}
+
+func newSegmentsPage(page *storage.Page[models.Segment]) SegmentsPage {
+	items := page.Items
+	if items == nil {
+		items = []*models.Segment{}
+	}
+	return SegmentsPage{Items: items, NextCursor: page.NextCursor, Total: page.Total}
+}
+
+// parseListParams reads the limit, cursor, sort, order and with_total query parameters.
+func parseListParams(r *http.Request) (storage.ListParams, render.Renderer) {
+	query := r.URL.Query()
+	params := storage.ListParams{
+		Cursor: query.Get("cursor"),
+		Sort:   query.Get("sort"),
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		value, err := strconv.Atoi(limit)
+		if err != nil || value < 1 || value > storage.MaxLimit {
+			return params, ErrInvalidField("limit", limit)
+		}
+		params.Limit = value
+	}
+
+	switch order := query.Get("order"); order {
+	case "", "asc":
+	case "desc":
+		params.Desc = true
+	default:
+		return params, ErrInvalidField("order", order)
+	}
+
+	if withTotal := query.Get("with_total"); withTotal != "" {
+		value, err := strconv.ParseBool(withTotal)
+		if err != nil {
+			return params, ErrInvalidField("with_total", withTotal)
+		}
+		params.WithTotal = value
+	}
+
+	return params, nil
+}
+
+// parseTimeParam reads the optional query parameter in RFC 3339 format.
+func parseTimeParam(r *http.Request, name string) (*time.Time, render.Renderer) {
+	param := r.URL.Query().Get(name)
+	if param == "" {
+		return nil, nil
+	}
+
+	value, err := time.Parse(time.RFC3339, param)
+	if err != nil {
+		return nil, ErrInvalidField(name, param)
+	}
+	return &value, nil
+}
+
+// parseCreatedAfter reads the optional created_after query parameter in RFC 3339 format.
+func parseCreatedAfter(r *http.Request) (*time.Time, render.Renderer) {
+	return parseTimeParam(r, "created_after")
+}
+
+// parseUserFilter reads the username, created_after and segment query parameters.
+func parseUserFilter(r *http.Request) (storage.UserFilter, render.Renderer) {
+	createdAfter, errResponse := parseCreatedAfter(r)
+	if errResponse != nil {
+		return storage.UserFilter{}, errResponse
+	}
+
+	return storage.UserFilter{
+		UsernamePrefix: r.URL.Query().Get("username"),
+		CreatedAfter:   createdAfter,
+		Segment:        r.URL.Query().Get("segment"),
+	}, nil
+}
+
+// parseSegmentFilter reads the name and created_after query parameters.
+func parseSegmentFilter(r *http.Request) (storage.SegmentFilter, render.Renderer) {
+	createdAfter, errResponse := parseCreatedAfter(r)
+	if errResponse != nil {
+		return storage.SegmentFilter{}, errResponse
+	}
+
+	return storage.SegmentFilter{
+		NamePrefix:   r.URL.Query().Get("name"),
+		CreatedAfter: createdAfter,
+	}, nil
+}
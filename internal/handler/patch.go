@@ -0,0 +1,153 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/go-chi/render"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/mergepatch"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/models"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/storage"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/validate"
+)
+
+// maxPatchSize limits merge patch documents.
+const maxPatchSize = 1 << 20
+
+// UserPatch is the part of a user a merge patch changes. Null clears the attributes and removes an attribute
+// inside the attributes, the names are required like in a created user.
+type UserPatch struct {
+	FirstName  string         `json:"firstname" example:"Petr" validate:"required,max=128"`
+	LastName   string         `json:"lastname" example:"Petrov" validate:"required,max=128"`
+	Username   string         `json:"username" example:"petr@petr" validate:"required,max=128"`
+	Attributes map[string]any `json:"attributes,omitempty"`
+}
+
+// SegmentPatch is the part of a segment a merge patch changes, null clears a field.
+type SegmentPatch struct {
+	AutoPercent float64      `json:"auto_percent" example:"30" validate:"min=0,max=100"` // percentage of users put into a static segment
+	Percent     float64      `json:"percent" example:"10" validate:"min=0,max=100"`      // percentage of users falling into an experiment
+	Rule        *models.Rule `json:"rule,omitempty"`                                     // targeting rule on user attributes
+}
+
+// readMergePatch reads the merge patch document of the request body, which must be a JSON object.
+func readMergePatch(w http.ResponseWriter, r *http.Request) ([]byte, render.Renderer) {
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil || (mediaType != mergepatch.ContentType && mediaType != "application/json") {
+			return nil, ErrUnsupportedMediaType(contentType)
+		}
+	}
+
+	patch, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPatchSize))
+	if err != nil {
+		return nil, ErrInvalidRequest(err)
+	}
+	if err := mergepatch.Validate(patch); err != nil {
+		return nil, ErrInvalidRequest(err)
+	}
+	return patch, nil
+}
+
+// applyMergePatch applies the merge patch to the JSON of the current value and decodes the result into it.
+// Members the value does not have, such as the ID or the version, cannot be patched.
+func applyMergePatch[T any](current *T, patch []byte) error {
+	document, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+
+	merged, err := mergepatch.Apply(document, patch)
+	if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(merged))
+	decoder.DisallowUnknownFields()
+
+	var patched T
+	if err := decoder.Decode(&patched); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return storage.Invalid(typeErr.Field, "type", fmt.Sprintf("must be %s", jsonType(typeErr.Type)))
+		}
+		// the decoder has no error type for unknown fields
+		if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+			return storage.Invalid(strings.Trim(field, `"`), "unknown", "can't be patched")
+		}
+		return storage.Invalid("patch", "json", err.Error())
+	}
+	if err := validate.Struct(&patched); err != nil {
+		return err
+	}
+
+	*current = patched
+	return nil
+}
+
+// patchUser returns a patch function of storage.UserStorage.PatchUser applying the merge patch.
+func patchUser(patch []byte) func(user *models.User) error {
+	return func(user *models.User) error {
+		fields := UserPatch{
+			FirstName:  user.FirstName,
+			LastName:   user.LastName,
+			Username:   user.Username,
+			Attributes: user.Attributes,
+		}
+		if err := applyMergePatch(&fields, patch); err != nil {
+			return err
+		}
+
+		user.FirstName = fields.FirstName
+		user.LastName = fields.LastName
+		user.Username = fields.Username
+		user.Attributes = fields.Attributes
+		return nil
+	}
+}
+
+// patchSegment returns a patch function of storage.SegmentStorage.PatchSegment applying the merge patch.
+func patchSegment(patch []byte) func(segment *models.Segment) error {
+	return func(segment *models.Segment) error {
+		fields := SegmentPatch{
+			AutoPercent: segment.AutoPercent,
+			Percent:     segment.Percent,
+			Rule:        segment.Rule,
+		}
+		if err := applyMergePatch(&fields, patch); err != nil {
+			return err
+		}
+
+		segment.AutoPercent = fields.AutoPercent
+		segment.Percent = fields.Percent
+		segment.Rule = fields.Rule
+		return nil
+	}
+}
+
+// jsonType names the JSON type a value of the Go type is decoded from.
+func jsonType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Pointer:
+		return jsonType(t.Elem())
+	default:
+		return "an object"
+	}
+}
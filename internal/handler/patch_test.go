@@ -0,0 +1,218 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/mergepatch"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/models"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/storage"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/storage/memory"
+)
+
+// testAPI serves the user and segment handlers over memory storages.
+type testAPI struct {
+	http.Handler
+	users    storage.UserStorage
+	segments storage.SegmentStorage
+}
+
+func newTestAPI(t *testing.T) *testAPI {
+	t.Helper()
+	db := memory.NewDB()
+	us, err := memory.NewUserStorage(db)
+	if err != nil {
+		t.Fatalf("NewUserStorage: %v", err)
+	}
+	ss, err := memory.NewSegmentStorage(db, us)
+	if err != nil {
+		t.Fatalf("NewSegmentStorage: %v", err)
+	}
+
+	users, segments := NewUserHandler(us), NewSegmentHandler(ss)
+	r := chi.NewRouter()
+	r.Get("/users/{id}", users.ReadUser)
+	r.Put("/users/{id}", users.UpdateUser)
+	r.Patch("/users/{id}", users.PatchUser)
+	r.Delete("/users/{id}", users.DeleteUser)
+	r.Get("/segments/{slug}", segments.ReadSegment)
+	r.Put("/segments/{slug}", segments.UpdateSegment)
+	r.Patch("/segments/{slug}", segments.PatchSegment)
+	r.Delete("/segments/{slug}", segments.DeleteSegment)
+	r.Post("/segments/{slug}/rename", segments.RenameSegment)
+	return &testAPI{Handler: r, users: us, segments: ss}
+}
+
+func (a *testAPI) do(t *testing.T, method, path, body string, header http.Header) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	rec := httptest.NewRecorder()
+	a.ServeHTTP(rec, req)
+	return rec
+}
+
+func (a *testAPI) patch(t *testing.T, path, patch string) *httptest.ResponseRecorder {
+	t.Helper()
+	return a.do(t, http.MethodPatch, path, patch, http.Header{"Content-Type": {mergepatch.ContentType}})
+}
+
+func decodeResponse[T any](t *testing.T, rec *httptest.ResponseRecorder) T {
+	t.Helper()
+	var value T
+	if err := json.Unmarshal(rec.Body.Bytes(), &value); err != nil {
+		t.Fatalf("failed to decode %s: %v", rec.Body, err)
+	}
+	return value
+}
+
+// requireViolation fails unless the response is 400 with the only violation of the rule by the field.
+func requireViolation(t *testing.T, rec *httptest.ResponseRecorder, field, rule string) {
+	t.Helper()
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400: %s", rec.Code, rec.Body)
+	}
+	response := decodeResponse[ErrorResponse](t, rec)
+	if response.AppCode != CodeValidation || len(response.Violations) != 1 ||
+		response.Violations[0].Field != field || response.Violations[0].Rule != rule {
+		t.Fatalf("response = %s, want the violation of %s by %s", rec.Body, rule, field)
+	}
+}
+
+func TestPatchSegment(t *testing.T) {
+	api := newTestAPI(t)
+	err := api.segments.CreateSegment(context.Background(), &models.Segment{
+		Name:        "SEGMENT",
+		AutoPercent: 10,
+		Rule:        &models.Rule{Attribute: "country", Op: models.RuleOpEq, Value: "RU"},
+	})
+	if err != nil {
+		t.Fatalf("CreateSegment: %v", err)
+	}
+
+	rec := api.patch(t, "/segments/SEGMENT", `{"auto_percent": 20}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	segment := decodeResponse[models.Segment](t, rec)
+	if segment.AutoPercent != 20 || segment.Rule == nil {
+		t.Fatalf("patched segment = %s, want auto percent 20 and the rule kept", rec.Body)
+	}
+	if rec.Header().Get("ETag") != storage.ETag(segment) {
+		t.Fatalf("ETag = %s, want the tag of the returned segment", rec.Header().Get("ETag"))
+	}
+
+	rec = api.patch(t, "/segments/SEGMENT", `{"rule": null}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	if segment := decodeResponse[models.Segment](t, rec); segment.Rule != nil || segment.AutoPercent != 20 {
+		t.Fatalf("patched segment = %s, want the rule cleared and auto percent 20", rec.Body)
+	}
+	stored, err := api.segments.GetSegmentByName(context.Background(), "SEGMENT")
+	if err != nil {
+		t.Fatalf("GetSegmentByName: %v", err)
+	}
+	if stored.Rule != nil {
+		t.Fatalf("stored rule = %+v, want none", stored.Rule)
+	}
+}
+
+func TestPatchSegmentRejected(t *testing.T) {
+	api := newTestAPI(t)
+	if err := api.segments.CreateSegment(context.Background(), &models.Segment{Name: "SEGMENT"}); err != nil {
+		t.Fatalf("CreateSegment: %v", err)
+	}
+
+	tests := []struct {
+		name, patch, field, rule string
+	}{
+		{"wrong type", `{"auto_percent": "ten"}`, "auto_percent", "type"},
+		{"wrong nested type", `{"rule": {"attribute": 1}}`, "rule.attribute", "type"},
+		{"out of range", `{"auto_percent": 101}`, "auto_percent", "max"},
+		{"type", `{"type": "experiment"}`, "type", "unknown"},
+		{"name", `{"name": "RENAMED"}`, "name", "unknown"},
+		{"version", `{"version": 7}`, "version", "unknown"},
+		{"malformed rule", `{"rule": {}}`, "rule", "exactly_one"},
+		{"percent of a static segment", `{"percent": 10}`, "percent", "type"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requireViolation(t, api.patch(t, "/segments/SEGMENT", tt.patch), tt.field, tt.rule)
+		})
+	}
+
+	stored, err := api.segments.GetSegmentByName(context.Background(), "SEGMENT")
+	if err != nil {
+		t.Fatalf("GetSegmentByName: %v", err)
+	}
+	if stored.Version != 1 {
+		t.Fatalf("version = %d after rejected patches, want 1", stored.Version)
+	}
+}
+
+func TestPatchUser(t *testing.T) {
+	api := newTestAPI(t)
+	user := &models.User{FirstName: "Ivan", LastName: "Ivanov", Username: "ivan", Attributes: map[string]any{"country": "RU", "plan": "pro"}}
+	if err := api.users.CreateUser(context.Background(), user); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	rec := api.patch(t, "/users/1", `{"firstname": "Petr", "attributes": {"plan": null, "age": 30}}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	patched := decodeResponse[models.User](t, rec)
+	if patched.FirstName != "Petr" || patched.LastName != "Ivanov" {
+		t.Fatalf("patched user = %s, want only the first name changed", rec.Body)
+	}
+	if _, ok := patched.Attributes["plan"]; ok || patched.Attributes["country"] != "RU" || patched.Attributes["age"] != float64(30) {
+		t.Fatalf("attributes = %v, want plan removed, age added and country kept", patched.Attributes)
+	}
+
+	requireViolation(t, api.patch(t, "/users/1", `{"username": null}`), "username", "required")
+	requireViolation(t, api.patch(t, "/users/1", `{"ID": 2}`), "ID", "unknown")
+	requireViolation(t, api.patch(t, "/users/1", `{"segments": []}`), "segments", "unknown")
+	requireViolation(t, api.patch(t, "/users/1", `{"firstname": ["Petr"]}`), "firstname", "type")
+}
+
+func TestPatchRequest(t *testing.T) {
+	api := newTestAPI(t)
+	if err := api.segments.CreateSegment(context.Background(), &models.Segment{Name: "SEGMENT"}); err != nil {
+		t.Fatalf("CreateSegment: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		contentType string
+		patch       string
+		status      int
+	}{
+		{"merge patch", mergepatch.ContentType, `{}`, http.StatusOK},
+		{"json", "application/json; charset=utf-8", `{}`, http.StatusOK},
+		{"json patch", "application/json-patch+json", `[]`, http.StatusUnsupportedMediaType},
+		{"not an object", mergepatch.ContentType, `[{"op": "remove", "path": "/rule"}]`, http.StatusBadRequest},
+		{"malformed", mergepatch.ContentType, `{"rule":`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := api.do(t, http.MethodPatch, "/segments/SEGMENT", tt.patch, http.Header{"Content-Type": {tt.contentType}})
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+		})
+	}
+
+	if rec := api.patch(t, "/segments/MISSING", `{}`); rec.Code != http.StatusNotFound {
+		t.Fatalf("status of a missing segment = %d, want 404: %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,34 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+
+	"github.com/go-chi/render"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/validate"
+)
+
+// decodeOption changes how decodeRequest treats the body.
+type decodeOption int
+
+// allowEmptyBody validates the zero value of v when the request has no body.
+const allowEmptyBody decodeOption = iota + 1
+
+// decodeRequest decodes the JSON body of the request into v and validates it against the `validate` tags.
+func decodeRequest(r *http.Request, v any, options ...decodeOption) render.Renderer {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		if !errors.Is(err, io.EOF) {
+			return ErrInvalidRequest(err)
+		}
+		if !slices.Contains(options, allowEmptyBody) {
+			return ErrInvalidRequest(errors.New("request body must not be empty"))
+		}
+	}
+	if err := validate.Struct(v); err != nil {
+		return ErrValidationFailed(err)
+	}
+	return nil
+}
@@ -1,14 +1,16 @@
 package handler
 
 import (
-	"encoding/json"
-	"github.com/go-chi/render"
+	"fmt"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
 	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/models"
 	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/storage"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/validate"
 )
 
 type SegmentHandler struct {
@@ -21,48 +23,102 @@ func NewSegmentHandler(ss storage.SegmentStorage) *SegmentHandler {
 
 // ListSegments godoc
 //
-// @Summary List all segments
-// @Description Returns a list of all segments in the system
+// @Summary List segments
+// @Description Returns a page of segments sorted by name or created_at, segments are returned without users
 // @Tags segments
 // @Accept json
 // @Produce json
-// @Success 200 {array} models.Segment
+// @Param limit query int false "Page size" default(100) maximum(1000)
+// @Param cursor query string false "Cursor of the next page returned with the previous page"
+// @Param sort query string false "Sort field" Enums(name, created_at) default(name)
+// @Param order query string false "Sort order" Enums(asc, desc) default(asc)
+// @Param with_total query bool false "Count all segments matching the filter"
+// @Param name query string false "Name prefix"
+// @Param created_after query string false "Only segments created after the time in RFC 3339 format"
+// @Success 200 {object} SegmentsPage
 // @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
 // @Router /api/v1/segments [get]
 func (h *SegmentHandler) ListSegments(w http.ResponseWriter, r *http.Request) {
-	segments, err := h.ss.GetSegments()
+	params, errResponse := parseListParams(r)
+	if errResponse != nil {
+		render.Render(w, r, errResponse)
+		return
+	}
+
+	filter, errResponse := parseSegmentFilter(r)
+	if errResponse != nil {
+		render.Render(w, r, errResponse)
+		return
+	}
+
+	page, err := h.ss.GetSegments(r.Context(), filter, params)
 	if err != nil {
-		render.Render(w, r, ErrRender(err))
+		render.Render(w, r, ErrStorage(err))
 		return
 	}
 
-	render.JSON(w, r, segments)
+	render.JSON(w, r, newSegmentsPage(page))
 }
 
 type CreateSegmentRequest struct {
-	Name string `json:"name"`
+	Name        string             `json:"name" example:"AVITO_VOICE_MESSAGES" validate:"required,slug,max=128"`
+	Type        models.SegmentType `json:"type,omitempty" enums:"static,experiment" example:"static" validate:"omitempty,oneof=static experiment"`
+	AutoPercent float64            `json:"auto_percent,omitempty" example:"30" validate:"min=0,max=100"` // percentage of users put into a static segment, including users created later
+	Salt        string             `json:"salt,omitempty" validate:"max=128"`                            // experiment hash salt, random when empty
+	Percent     float64            `json:"percent,omitempty" example:"10" validate:"min=0,max=100"`      // percentage of users falling into an experiment
+	Variants    []models.Variant   `json:"variants,omitempty"`
+	Rule        *models.Rule       `json:"rule,omitempty"` // targeting rule on user attributes
 }
 
 // CreateSegment godoc
 //
 // @Summary Create a new segment
-// @Description Creates a new segment in the system
+// @Description Creates a new segment in the system.
+// @Description With "auto_percent" the segment gets a random sample of existing users rounded to the nearest integer,
+// @Description and every user created later gets into the segment with the same probability.
+// @Description An "experiment" segment stores no memberships for its "percent": a user is in it when the hash of
+// @Description the salt and the user ID falls into the first "percent" of buckets, so raising it keeps existing members.
+// @Description With "variants" every member is put into exactly one of them chosen by the hash of the user ID
+// @Description proportionally to the weights, the type, the salt and the variants cannot be changed later.
+// @Description With "rule" every user whose attributes match the rule is in a static segment,
+// @Description and only such users can fall into an experiment.
 // @Tags segments
 // @Accept json
 // @Produce json
 // @Param segment body CreateSegmentRequest true "The segment to create"
+// @Param Idempotency-Key header string false "Client generated key, retries with it get the response of the first request"
 // @Success 201 {object} models.Segment
 // @Failure 400 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
 // @Router /api/v1/segments [post]
 func (h *SegmentHandler) CreateSegment(w http.ResponseWriter, r *http.Request) {
-	var segment models.Segment
-	if err := json.NewDecoder(r.Body).Decode(&segment); err != nil {
-		render.Render(w, r, ErrInvalidRequest(err))
+	var req CreateSegmentRequest
+	if errResponse := decodeRequest(r, &req); errResponse != nil {
+		render.Render(w, r, errResponse)
 		return
 	}
 
-	if err := h.ss.CreateSegment(&segment); err != nil {
-		render.Render(w, r, ErrRender(err))
+	segment := models.Segment{
+		Name:        req.Name,
+		Type:        req.Type,
+		AutoPercent: req.AutoPercent,
+		Salt:        req.Salt,
+		Percent:     req.Percent,
+		Variants:    req.Variants,
+		Rule:        req.Rule,
+	}
+
+	if err := h.ss.CreateSegment(r.Context(), &segment); err != nil {
+		render.Render(w, r, ErrStorage(err))
 		return
 	}
 
@@ -78,9 +134,16 @@ func (h *SegmentHandler) CreateSegment(w http.ResponseWriter, r *http.Request) {
 // @Accept json
 // @Produce json
 // @Param slug path string true "Slug of the segment to retrieve"
+// @Param If-None-Match header string false "ETag of the version the client has"
 // @Success 200 {object} models.Segment
+// @Header 200 {string} ETag "Entity tag of the segment with its users"
+// @Success 304 "Not Modified"
 // @Failure 400 {object} ErrorResponse
 // @Failure 404 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
 // @Router /api/v1/segments/{slug} [get]
 func (h *SegmentHandler) ReadSegment(w http.ResponseWriter, r *http.Request) {
 	slug := chi.URLParam(r, "slug")
@@ -89,32 +152,48 @@ func (h *SegmentHandler) ReadSegment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	segment, err := h.ss.GetSegmentByName(slug)
+	segment, err := h.ss.GetSegmentByName(r.Context(), slug)
 	if err != nil {
-		render.Render(w, r, ErrRender(err))
+		render.Render(w, r, ErrStorage(err))
 		return
 	}
 
-	if segment == nil {
-		render.Render(w, r, ErrNotFound())
+	if notModified(w, r, storage.ETag(segment)) {
 		return
 	}
-
 	render.JSON(w, r, segment)
 }
 
+// UpdateSegmentRequest is the body of updating a segment. The type, the salt and the variants of a segment
+// can't be changed, and its users are changed by the membership routes.
+type UpdateSegmentRequest struct {
+	Name        string       `json:"name,omitempty" example:"AVITO_VOICE_MESSAGES"` // must be the slug when given
+	AutoPercent float64      `json:"auto_percent,omitempty" example:"30" validate:"min=0,max=100"`
+	Percent     float64      `json:"percent,omitempty" example:"10" validate:"min=0,max=100"`
+	Rule        *models.Rule `json:"rule,omitempty"` // targeting rule on user attributes, removed when absent
+}
+
 // UpdateSegment godoc
 //
 // @Summary Update a segment
-// @Description Updates an existing segment by slug
+// @Description Updates an existing segment by slug. With If-Match the segment is updated only when it is of that version.
+// @Description The name can't be changed here, segments are renamed by POST /api/v1/segments/{slug}/rename.
 // @Tags segments
 // @Accept json
 // @Produce json
 // @Param slug path string true "Slug of the segment to update"
-// @Param segment body models.Segment true "The segment data to update"
+// @Param segment body UpdateSegmentRequest true "The segment data to update"
+// @Param If-Match header string false "ETag of the version to update"
 // @Success 204 "No Content"
+// @Header 204 {string} ETag "Entity tag of the changed segment"
 // @Failure 400 {object} ErrorResponse
 // @Failure 404 {object} ErrorResponse
+// @Failure 412 {object} ErrorResponse
+// @Failure 428 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
 // @Router /api/v1/segments/{slug} [put]
 func (h *SegmentHandler) UpdateSegment(w http.ResponseWriter, r *http.Request) {
 	slug := chi.URLParam(r, "slug")
@@ -123,31 +202,176 @@ func (h *SegmentHandler) UpdateSegment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var segment models.Segment
-	if err := json.NewDecoder(r.Body).Decode(&segment); err != nil {
-		render.Render(w, r, ErrInvalidRequest(err))
+	var req UpdateSegmentRequest
+	if errResponse := decodeRequest(r, &req); errResponse != nil {
+		render.Render(w, r, errResponse)
+		return
+	}
+	if req.Name != "" && req.Name != slug {
+		render.Render(w, r, ErrInvalidRequest(fmt.Errorf("name '%s' differs from slug '%s', use POST /api/v1/segments/%s/rename to rename the segment", req.Name, slug, slug)))
+		return
+	}
+
+	// only If-Match makes the update conditional
+	tag, errResponse := ifMatchTag(r)
+	if errResponse != nil {
+		render.Render(w, r, errResponse)
 		return
 	}
 
-	if err := h.ss.UpdateSegment(&segment); err != nil {
-		render.Render(w, r, ErrRender(err))
+	segment := models.Segment{
+		Name:        slug,
+		AutoPercent: req.AutoPercent,
+		Percent:     req.Percent,
+		Rule:        req.Rule,
+	}
+
+	updated, err := h.ss.UpdateSegment(r.Context(), &segment, tag)
+	if err != nil {
+		render.Render(w, r, ErrStorage(err))
 		return
 	}
 
+	w.Header().Set("ETag", storage.ETag(updated))
 	render.Status(r, http.StatusNoContent)
 }
 
+// PatchSegment godoc
+//
+// @Summary Patch a segment
+// @Description Applies a JSON merge patch (RFC 7396) to the auto percent, the percent and the rule of an existing segment
+// @Description by slug. Members set to null are cleared, so "rule": null removes the targeting rule.
+// @Description With If-Match the segment is patched only when it is of that version.
+// @Tags segments
+// @Accept application/merge-patch+json
+// @Produce json
+// @Param slug path string true "Slug of the segment to patch"
+// @Param patch body SegmentPatch true "The merge patch"
+// @Param If-Match header string false "ETag of the version to patch"
+// @Success 200 {object} models.Segment
+// @Header 200 {string} ETag "Entity tag of the changed segment"
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 412 {object} ErrorResponse
+// @Failure 415 {object} ErrorResponse
+// @Failure 428 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /api/v1/segments/{slug} [patch]
+func (h *SegmentHandler) PatchSegment(w http.ResponseWriter, r *http.Request) {
+	slug := chi.URLParam(r, "slug")
+	if slug == "" {
+		render.Render(w, r, ErrMissingField("slug"))
+		return
+	}
+
+	patch, errResponse := readMergePatch(w, r)
+	if errResponse != nil {
+		render.Render(w, r, errResponse)
+		return
+	}
+
+	tag, errResponse := ifMatchTag(r)
+	if errResponse != nil {
+		render.Render(w, r, errResponse)
+		return
+	}
+
+	// the segment is returned as GET returns it, with its users, so the entity tag matches
+	segment, err := h.ss.PatchSegment(r.Context(), slug, tag, patchSegment(patch))
+	if err != nil {
+		render.Render(w, r, ErrStorage(err))
+		return
+	}
+
+	w.Header().Set("ETag", storage.ETag(segment))
+	render.JSON(w, r, segment)
+}
+
+// RenameSegmentRequest is the body of renaming a segment.
+type RenameSegmentRequest struct {
+	Name string `json:"name" example:"AVITO_VOICE_MESSAGES_V2" validate:"required,slug,max=128"`
+	// AliasTTL keeps the old name resolving to the segment for that long, e.g. "720h" for 30 days.
+	// Without it the old name is released immediately.
+	AliasTTL string `json:"alias_ttl,omitempty" example:"720h" validate:"omitempty,duration"`
+}
+
+// RenameSegment godoc
+//
+// @Summary Rename a segment
+// @Description Renames a segment by slug. Memberships, history and variants of the users follow the segment.
+// @Description With alias_ttl the old name stays an alias of the segment for that long, so existing clients keep working.
+// @Description With If-Match the segment is renamed only when it is of that version.
+// @Tags segments
+// @Accept json
+// @Produce json
+// @Param slug path string true "Slug of the segment to rename"
+// @Param rename body RenameSegmentRequest true "The new name and the alias TTL"
+// @Param If-Match header string false "ETag of the version to rename"
+// @Success 200 {object} models.Segment
+// @Header 200 {string} ETag "Entity tag of the changed segment"
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 412 {object} ErrorResponse
+// @Failure 428 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /api/v1/segments/{slug}/rename [post]
+func (h *SegmentHandler) RenameSegment(w http.ResponseWriter, r *http.Request) {
+	slug := chi.URLParam(r, "slug")
+	if slug == "" {
+		render.Render(w, r, ErrMissingField("slug"))
+		return
+	}
+
+	var req RenameSegmentRequest
+	if errResponse := decodeRequest(r, &req); errResponse != nil {
+		render.Render(w, r, errResponse)
+		return
+	}
+
+	// the duration is validated, the zero value of an empty one means no alias
+	aliasTTL, _ := time.ParseDuration(req.AliasTTL)
+
+	tag, errResponse := ifMatchTag(r)
+	if errResponse != nil {
+		render.Render(w, r, errResponse)
+		return
+	}
+
+	segment, err := h.ss.RenameSegment(r.Context(), slug, req.Name, tag, aliasTTL)
+	if err != nil {
+		render.Render(w, r, ErrStorage(err))
+		return
+	}
+
+	w.Header().Set("ETag", storage.ETag(segment))
+	render.JSON(w, r, segment)
+}
+
 // DeleteSegment godoc
 //
 // @Summary Delete a segment
-// @Description Deletes an existing segment by slug
+// @Description Deletes an existing segment by slug. With If-Match the segment is deleted only when it is of that version.
 // @Tags segments
 // @Accept json
 // @Produce json
 // @Param slug path string true "Slug of the segment to delete"
+// @Param If-Match header string false "ETag of the version to delete"
 // @Success 204 "No Content"
 // @Failure 400 {object} ErrorResponse
 // @Failure 404 {object} ErrorResponse
+// @Failure 412 {object} ErrorResponse
+// @Failure 428 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
 // @Router /api/v1/segments/{slug} [delete]
 func (h *SegmentHandler) DeleteSegment(w http.ResponseWriter, r *http.Request) {
 	slug := chi.URLParam(r, "slug")
@@ -156,8 +380,14 @@ func (h *SegmentHandler) DeleteSegment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.ss.DeleteSegmentBySlug(slug); err != nil {
-		render.Render(w, r, ErrRender(err))
+	tag, errResponse := ifMatchTag(r)
+	if errResponse != nil {
+		render.Render(w, r, errResponse)
+		return
+	}
+
+	if err := h.ss.DeleteSegmentBySlug(r.Context(), slug, tag); err != nil {
+		render.Render(w, r, ErrStorage(err))
 		return
 	}
 
@@ -166,15 +396,26 @@ func (h *SegmentHandler) DeleteSegment(w http.ResponseWriter, r *http.Request) {
 
 // ListUsersInSegment godoc
 //
-// @Summary List all users in a segment
-// @Description Returns a list of all users in the specified segment
+// @Summary List users in a segment
+// @Description Returns a page of users in the specified segment sorted by id, username or created_at
 // @Tags segments
 // @Accept json
 // @Produce json
 // @Param slug path string true "Slug of the segment to retrieve users for"
-// @Success 200 {array} models.User
+// @Param limit query int false "Page size" default(100) maximum(1000)
+// @Param cursor query string false "Cursor of the next page returned with the previous page"
+// @Param sort query string false "Sort field" Enums(id, username, created_at) default(id)
+// @Param order query string false "Sort order" Enums(asc, desc) default(asc)
+// @Param with_total query bool false "Count all users matching the filter"
+// @Param username query string false "Username prefix"
+// @Param created_after query string false "Only users created after the time in RFC 3339 format"
+// @Success 200 {object} UsersPage
 // @Failure 400 {object} ErrorResponse
 // @Failure 404 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
 // @Router /api/v1/segments/{slug}/users [get]
 func (h *SegmentHandler) ListUsersInSegment(w http.ResponseWriter, r *http.Request) {
 	slug := chi.URLParam(r, "slug")
@@ -183,27 +424,129 @@ func (h *SegmentHandler) ListUsersInSegment(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	users, err := h.ss.GetUsersInSegment(slug)
+	params, errResponse := parseListParams(r)
+	if errResponse != nil {
+		render.Render(w, r, errResponse)
+		return
+	}
+
+	filter, errResponse := parseUserFilter(r)
+	if errResponse != nil {
+		render.Render(w, r, errResponse)
+		return
+	}
+
+	page, err := h.ss.GetUsersInSegment(r.Context(), slug, filter, params)
+	if err != nil {
+		render.Render(w, r, ErrStorage(err))
+		return
+	}
+
+	render.JSON(w, r, newUsersPage(page))
+}
+
+type PreviewRuleRequest struct {
+	Rule *models.Rule `json:"rule" validate:"required"`
+}
+
+// PreviewRule godoc
+//
+// @Summary Preview a targeting rule
+// @Description Returns a page of users whose attributes match the rule without creating a segment
+// @Tags segments
+// @Accept json
+// @Produce json
+// @Param rule body PreviewRuleRequest true "The rule to preview"
+// @Param limit query int false "Page size" default(100) maximum(1000)
+// @Param cursor query string false "Cursor of the next page returned with the previous page"
+// @Param sort query string false "Sort field" Enums(id, username, created_at) default(id)
+// @Param order query string false "Sort order" Enums(asc, desc) default(asc)
+// @Param with_total query bool false "Count all users matching the rule"
+// @Success 200 {object} UsersPage
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /api/v1/segments/preview [post]
+func (h *SegmentHandler) PreviewRule(w http.ResponseWriter, r *http.Request) {
+	var req PreviewRuleRequest
+	if errResponse := decodeRequest(r, &req); errResponse != nil {
+		render.Render(w, r, errResponse)
+		return
+	}
+
+	params, errResponse := parseListParams(r)
+	if errResponse != nil {
+		render.Render(w, r, errResponse)
+		return
+	}
+
+	page, err := h.ss.PreviewRule(r.Context(), req.Rule, params)
 	if err != nil {
-		render.Render(w, r, ErrRender(err))
+		render.Render(w, r, ErrStorage(err))
 		return
 	}
 
-	render.JSON(w, r, users)
+	render.JSON(w, r, newUsersPage(page))
+}
+
+// segmentTTL limits a user's membership in a segment either by the absolute
+// expiration time or by the duration from now, e.g. "720h" for 30 days.
+type segmentTTL struct {
+	ExpiresAt *time.Time `json:"expires_at,omitempty" example:"2023-09-01T00:00:00Z"`
+	TTL       string     `json:"ttl,omitempty" example:"720h" validate:"omitempty,duration"`
+}
+
+// Validate rejects setting both the expiration time and the TTL, and an expiration time which has passed.
+// Structs embedding segmentTTL are validated with it, as the method is promoted to them.
+func (t *segmentTTL) Validate() validate.Errors {
+	if t.ExpiresAt != nil && t.TTL != "" {
+		return validate.Errors{{Field: "ttl", Rule: "excluded_with", Message: "must not be set together with expires_at"}}
+	}
+	if t.ExpiresAt != nil && !t.ExpiresAt.After(time.Now()) {
+		return validate.Errors{{Field: "expires_at", Rule: "future", Message: "must be in the future"}}
+	}
+	return nil
+}
+
+// expiration returns the membership expiration time of a validated TTL, nil means the membership is permanent.
+func (t segmentTTL) expiration(now time.Time) *time.Time {
+	if t.TTL != "" {
+		// the duration is validated
+		ttl, _ := time.ParseDuration(t.TTL)
+		expiresAt := now.Add(ttl)
+		return &expiresAt
+	}
+	return t.ExpiresAt
+}
+
+// addUserToSegment is the optional body of adding a user to a segment.
+type addUserToSegment struct {
+	segmentTTL
+	Variant string `json:"variant,omitempty" example:"treatment_a" validate:"max=128"` // variant to put the user into instead of the hashed one
 }
 
 // AddUserToSegment godoc
 //
 // @Summary Add a user to a segment
-// @Description Adds a user to the specified segment
+// @Description Adds a user to the specified segment, optionally for a limited time.
+// @Description Adding a user who is already in the segment replaces the expiration of the membership
+// @Description and the variant when it is given.
 // @Tags segments
 // @Accept json
 // @Produce json
 // @Param slug path string true "Slug of the segment to add the user to"
 // @Param id path int true "ID of the user to add to the segment"
+// @Param membership body addUserToSegment false "Expiration and variant of the membership"
 // @Success 204 "No Content"
 // @Failure 400 {object} ErrorResponse
 // @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
 // @Router /api/v1/segments/{slug}/users/{id} [put]
 func (h *SegmentHandler) AddUserToSegment(w http.ResponseWriter, r *http.Request) {
 	slug := chi.URLParam(r, "slug")
@@ -219,8 +562,16 @@ func (h *SegmentHandler) AddUserToSegment(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	if err := h.ss.AddUserToSegment(slug, userID); err != nil {
-		render.Render(w, r, ErrRender(err))
+	// the body is optional, without it the membership has no TTL and no variant
+	var membership addUserToSegment
+	if errResponse := decodeRequest(r, &membership, allowEmptyBody); errResponse != nil {
+		render.Render(w, r, errResponse)
+		return
+	}
+
+	expiresAt := membership.expiration(time.Now())
+	if err := h.ss.AddUserToSegment(r.Context(), slug, userID, expiresAt, membership.Variant); err != nil {
+		render.Render(w, r, ErrStorage(err))
 		return
 	}
 
@@ -239,6 +590,10 @@ func (h *SegmentHandler) AddUserToSegment(w http.ResponseWriter, r *http.Request
 // @Success 204 "No Content"
 // @Failure 400 {object} ErrorResponse
 // @Failure 404 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
 // @Router /api/v1/segments/{slug}/users/{id} [delete]
 func (h *SegmentHandler) DeleteUserFromSegment(w http.ResponseWriter, r *http.Request) {
 	slug := chi.URLParam(r, "slug")
@@ -254,8 +609,8 @@ func (h *SegmentHandler) DeleteUserFromSegment(w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	if err := h.ss.DeleteUserFromSegment(slug, userID); err != nil {
-		render.Render(w, r, ErrRender(err))
+	if err := h.ss.DeleteUserFromSegment(r.Context(), slug, userID); err != nil {
+		render.Render(w, r, ErrStorage(err))
 		return
 	}
 
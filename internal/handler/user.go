@@ -1,17 +1,20 @@
 package handler
 
 import (
+	"encoding/csv"
 	"encoding/json"
-	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/render"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/logger"
 	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/models"
 	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/storage"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/validate"
 )
 
 const (
@@ -20,6 +23,9 @@ const (
 	ErrMissingUserID    = "missing user ID"
 )
 
+// historyPeriodLayout is the year-month format of the history report period.
+const historyPeriodLayout = "2006-01"
+
 type UserHandler struct {
 	us storage.UserStorage
 }
@@ -29,34 +35,65 @@ func NewUserHandler(us storage.UserStorage) *UserHandler {
 }
 
 // ListUsers godoc
-// @Summary List all users
-// @Description Returns a list of all users in the system
+// @Summary List users
+// @Description Returns a page of users sorted by id, username or created_at
 // @Tags users
 // @Accept json
 // @Produce json
-// @Success 200 {array} models.User
-// @Failure 404 {object} ErrorResponse
-// @Failure 422 {object} ErrorResponse
+// @Param limit query int false "Page size" default(100) maximum(1000)
+// @Param cursor query string false "Cursor of the next page returned with the previous page"
+// @Param sort query string false "Sort field" Enums(id, username, created_at) default(id)
+// @Param order query string false "Sort order" Enums(asc, desc) default(asc)
+// @Param with_total query bool false "Count all users matching the filter"
+// @Param username query string false "Username prefix"
+// @Param created_after query string false "Only users created after the time in RFC 3339 format"
+// @Param segment query string false "Only users in the segment"
+// @Success 200 {object} UsersPage
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
 // @Router /api/v1/users [get]
 func (h *UserHandler) ListUsers(w http.ResponseWriter, r *http.Request) {
-	users, err := h.us.GetUsers()
-	if err != nil {
-		_ = render.Render(w, r, ErrRender(err))
+	params, errResponse := parseListParams(r)
+	if errResponse != nil {
+		render.Render(w, r, errResponse)
 		return
 	}
 
-	if len(users) == 0 {
-		_ = render.Render(w, r, ErrNotFound())
+	filter, errResponse := parseUserFilter(r)
+	if errResponse != nil {
+		render.Render(w, r, errResponse)
+		return
+	}
+
+	page, err := h.us.GetUsers(r.Context(), filter, params)
+	if err != nil {
+		render.Render(w, r, ErrStorage(err))
 		return
 	}
 
-	render.JSON(w, r, users)
+	render.JSON(w, r, newUsersPage(page))
 }
 
 type CreateUserRequest struct {
-	FirstName string `json:"firstname" validate:"required"`
-	LastName  string `json:"lastname" validate:"required"`
-	Username  string `json:"username" validate:"required"`
+	FirstName string `json:"firstname" validate:"required,max=128"`
+	LastName  string `json:"lastname" validate:"required,max=128"`
+	Username  string `json:"username" validate:"required,max=128"`
+	// Attributes are matched by targeting rules of segments
+	Attributes map[string]any `json:"attributes,omitempty"`
+}
+
+// UpdateUserRequest is the body of updating a user. Segments are changed by PUT /api/v1/users/{id}/segments.
+type UpdateUserRequest struct {
+	ID        int64  `json:"ID" example:"1"`
+	FirstName string `json:"firstname" validate:"required,max=128"`
+	LastName  string `json:"lastname" validate:"required,max=128"`
+	Username  string `json:"username" validate:"required,max=128"`
+	// Attributes are matched by targeting rules of segments
+	Attributes map[string]any `json:"attributes,omitempty"`
 }
 
 // CreateUser godoc
@@ -66,18 +103,31 @@ type CreateUserRequest struct {
 // @Accept json
 // @Produce json
 // @Param user body CreateUserRequest true "The user to create"
+// @Param Idempotency-Key header string false "Client generated key, retries with it get the response of the first request"
 // @Success 201 {object} models.User
 // @Failure 400 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
 // @Router /api/v1/users [post]
 func (h *UserHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
-	var user models.User
-	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-		render.Render(w, r, ErrInvalidRequest(err))
+	var req CreateUserRequest
+	if errResponse := decodeRequest(r, &req); errResponse != nil {
+		render.Render(w, r, errResponse)
 		return
 	}
 
-	if err := h.us.CreateUser(&user); err != nil {
-		render.Render(w, r, ErrRender(err))
+	user := models.User{
+		FirstName:  req.FirstName,
+		LastName:   req.LastName,
+		Username:   req.Username,
+		Attributes: req.Attributes,
+	}
+
+	if err := h.us.CreateUser(r.Context(), &user); err != nil {
+		render.Render(w, r, ErrStorage(err))
 		return
 	}
 
@@ -92,8 +142,16 @@ func (h *UserHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
 // @Accept json
 // @Produce json
 // @Param id path int true "ID of the user to retrieve"
+// @Param If-None-Match header string false "ETag of the version the client has"
 // @Success 200 {object} models.User
+// @Header 200 {string} ETag "Entity tag of the user with its segments"
+// @Success 304 "Not Modified"
+// @Failure 400 {object} ErrorResponse
 // @Failure 404 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
 // @Router /api/v1/users/{id} [get]
 func (h *UserHandler) ReadUser(w http.ResponseWriter, r *http.Request) {
 	idStr := chi.URLParam(r, "id")
@@ -108,30 +166,38 @@ func (h *UserHandler) ReadUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := h.us.GetUserByID(id)
+	user, err := h.us.GetUserByID(r.Context(), id)
 	if err != nil {
-		render.Render(w, r, ErrRender(err))
+		render.Render(w, r, ErrStorage(err))
 		return
 	}
 
-	if user == nil {
-		render.Render(w, r, ErrNotFound())
+	if notModified(w, r, storage.ETag(user)) {
 		return
 	}
-
 	render.JSON(w, r, user)
 }
 
 // UpdateUser godoc
 // @Summary Update a user
-// @Description Updates an existing user by ID
+// @Description Updates an existing user by ID. With If-Match the user is updated only when it is of that version.
 // @Tags users
 // @Accept json
 // @Produce json
 // @Param id path int true "ID of the user to update"
-// @Param user body models.User true "The user data to update"
+// @Param user body UpdateUserRequest true "The user data to update"
+// @Param If-Match header string false "ETag of the version to update"
 // @Success 204 "No Content"
+// @Header 204 {string} ETag "Entity tag of the changed user"
 // @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 412 {object} ErrorResponse
+// @Failure 428 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
 // @Router /api/v1/users/{id} [put]
 func (h *UserHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
 	idStr := chi.URLParam(r, "id")
@@ -146,34 +212,120 @@ func (h *UserHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var user models.User
-	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-		render.Render(w, r, ErrInvalidRequest(err))
+	var req UpdateUserRequest
+	if errResponse := decodeRequest(r, &req); errResponse != nil {
+		render.Render(w, r, errResponse)
 		return
 	}
 
-	if user.ID != id {
+	if req.ID != id {
 		render.Render(w, r, ErrInvalidRequest(fmt.Errorf(ErrMismatchedUserID)))
 		return
 	}
 
-	if err := h.us.UpdateUser(&user); err != nil {
-		render.Render(w, r, ErrRender(err))
+	// only If-Match makes the update conditional
+	tag, errResponse := ifMatchTag(r)
+	if errResponse != nil {
+		render.Render(w, r, errResponse)
 		return
 	}
 
+	user := models.User{
+		ID:         req.ID,
+		FirstName:  req.FirstName,
+		LastName:   req.LastName,
+		Username:   req.Username,
+		Attributes: req.Attributes,
+	}
+
+	updated, err := h.us.UpdateUser(r.Context(), &user, tag)
+	if err != nil {
+		render.Render(w, r, ErrStorage(err))
+		return
+	}
+
+	w.Header().Set("ETag", storage.ETag(updated))
 	render.Status(r, http.StatusNoContent)
 }
 
+// PatchUser godoc
+// @Summary Patch a user
+// @Description Applies a JSON merge patch (RFC 7396) to the first name, the last name, the username and the attributes
+// @Description of an existing user by ID. Attributes are merged key by key and removed when set to null,
+// @Description the names can't be cleared.
+// @Description With If-Match the user is patched only when it is of that version.
+// @Tags users
+// @Accept application/merge-patch+json
+// @Produce json
+// @Param id path int true "ID of the user to patch"
+// @Param patch body UserPatch true "The merge patch"
+// @Param If-Match header string false "ETag of the version to patch"
+// @Success 200 {object} models.User
+// @Header 200 {string} ETag "Entity tag of the changed user"
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 412 {object} ErrorResponse
+// @Failure 415 {object} ErrorResponse
+// @Failure 428 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /api/v1/users/{id} [patch]
+func (h *UserHandler) PatchUser(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	if idStr == "" {
+		render.Render(w, r, ErrInvalidRequest(fmt.Errorf(ErrMissingUserID)))
+		return
+	}
+
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(fmt.Errorf(ErrInvalidUserID)))
+		return
+	}
+
+	patch, errResponse := readMergePatch(w, r)
+	if errResponse != nil {
+		render.Render(w, r, errResponse)
+		return
+	}
+
+	tag, errResponse := ifMatchTag(r)
+	if errResponse != nil {
+		render.Render(w, r, errResponse)
+		return
+	}
+
+	// the user is returned as GET returns it, with its segments, so the entity tag matches
+	user, err := h.us.PatchUser(r.Context(), id, tag, patchUser(patch))
+	if err != nil {
+		render.Render(w, r, ErrStorage(err))
+		return
+	}
+
+	w.Header().Set("ETag", storage.ETag(user))
+	render.JSON(w, r, user)
+}
+
 // DeleteUser godoc
 // @Summary Delete a user
-// @Description Deletes an existing user by ID
+// @Description Deletes an existing user by ID. With If-Match the user is deleted only when it is of that version.
 // @Tags users
 // @Accept json
 // @Produce json
 // @Param id path int true "ID of the user to delete"
+// @Param If-Match header string false "ETag of the version to delete"
 // @Success 204 "No Content"
+// @Failure 400 {object} ErrorResponse
 // @Failure 404 {object} ErrorResponse
+// @Failure 412 {object} ErrorResponse
+// @Failure 428 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
 // @Router /api/v1/users/{id} [delete]
 func (h *UserHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
 	idStr := chi.URLParam(r, "id")
@@ -188,8 +340,14 @@ func (h *UserHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.us.DeleteUser(id); err != nil {
-		render.Render(w, r, ErrRender(err))
+	tag, errResponse := ifMatchTag(r)
+	if errResponse != nil {
+		render.Render(w, r, errResponse)
+		return
+	}
+
+	if err := h.us.DeleteUser(r.Context(), id, tag); err != nil {
+		render.Render(w, r, ErrStorage(err))
 		return
 	}
 
@@ -197,49 +355,212 @@ func (h *UserHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
 }
 
 type updateUserSegments struct {
-	SegmentsToAdd    []string `json:"segments_to_add"`
-	SegmentsToRemove []string `json:"segments_to_remove"`
+	SegmentsToAdd    []segmentToAdd `json:"segments_to_add"`
+	SegmentsToRemove []string       `json:"segments_to_remove" validate:"dive,required,max=128"`
+}
+
+// Validate rejects an update changing nothing.
+func (u *updateUserSegments) Validate() validate.Errors {
+	if len(u.SegmentsToAdd) == 0 && len(u.SegmentsToRemove) == 0 {
+		return validate.Errors{{
+			Field:   "segments_to_add",
+			Rule:    "required_without",
+			Message: "must not be empty when segments_to_remove is empty",
+		}}
+	}
+	return nil
+}
+
+// segmentToAdd is either a plain segment name or an object with the name, the membership TTL and the variant.
+type segmentToAdd struct {
+	Name string `json:"name" example:"AVITO_DISCOUNT_30" validate:"required,max=128"`
+	segmentTTL
+	Variant string `json:"variant,omitempty" example:"treatment_a" validate:"max=128"`
+}
+
+func (s *segmentToAdd) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		return json.Unmarshal(data, &s.Name)
+	}
+
+	type plain segmentToAdd
+	return json.Unmarshal(data, (*plain)(s))
 }
 
 // UpdateUserSegments godoc
 //
 // @Summary Update the segments of a user
-// @Description Updates the segments of an existing user by ID
+// @Description Updates the segments of an existing user by ID.
+// @Description A segment to add is either a name or an object with the name, optional "expires_at" or "ttl" of the membership
+// @Description and optional "variant" to put the user into instead of the hashed one.
 // @Tags users
 // @Accept json
 // @Produce json
 // @Param id path int true "ID of the user to update segments for"
 // @Param update body updateUserSegments true "The segments to add or remove"
+// @Param Idempotency-Key header string false "Client generated key, retries with it get the response of the first request"
 // @Success 204 "No Content"
 // @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
 // @Failure 500 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
 // @Router /api/v1/users/{id}/segments [put]
 func (h *UserHandler) UpdateUserSegments(w http.ResponseWriter, r *http.Request) {
 	idStr := chi.URLParam(r, "id")
 	if idStr == "" {
-		render.Render(w, r, ErrRender(fmt.Errorf(ErrMissingUserID)))
+		render.Render(w, r, ErrInvalidRequest(fmt.Errorf(ErrMissingUserID)))
 		return
 	}
 
 	id, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
-		log.Printf("invalid user ID parameter: %v\n", idStr)
-		render.Render(w, r, ErrRender(fmt.Errorf(ErrInvalidUserID)))
+		logger.FromContext(r.Context()).DebugContext(r.Context(), "invalid user ID parameter", slog.String("id", idStr))
+		render.Render(w, r, ErrInvalidRequest(fmt.Errorf(ErrInvalidUserID)))
 		return
 	}
 
 	var update updateUserSegments
-	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
-		log.Printf("failed to decode user data from request: %v\n", err)
-		render.Render(w, r, ErrInvalidRequest(errors.New("invalid request body")))
+	if errResponse := decodeRequest(r, &update); errResponse != nil {
+		render.Render(w, r, errResponse)
 		return
 	}
 
-	if err := h.us.UpdateUserSegments(id, update.SegmentsToAdd, update.SegmentsToRemove); err != nil {
-		log.Printf("failed to update user segments: %v\n", err)
-		render.Render(w, r, ErrInternalServer(err))
+	now := time.Now()
+	segmentsToAdd := make([]models.SegmentAssignment, 0, len(update.SegmentsToAdd))
+	for _, segment := range update.SegmentsToAdd {
+		segmentsToAdd = append(segmentsToAdd, models.SegmentAssignment{
+			Name:      segment.Name,
+			ExpiresAt: segment.expiration(now),
+			Variant:   segment.Variant,
+		})
+	}
+
+	if err := h.us.UpdateUserSegments(r.Context(), id, segmentsToAdd, update.SegmentsToRemove); err != nil {
+		logger.FromContext(r.Context()).WarnContext(r.Context(), "failed to update user segments", slog.Any("error", err))
+		render.Render(w, r, ErrStorage(err))
 		return
 	}
 
 	render.Status(r, http.StatusNoContent)
 }
+
+// UserSegments are the resolved segments of a user.
+type UserSegments struct {
+	UserID   int64            `json:"user_id"`
+	Segments []models.Segment `json:"segments"`
+}
+
+// ReadUserSegments godoc
+//
+// @Summary Get the segments of a user
+// @Description Returns the active segments of the user: segments the user is put into and experiments the user falls into
+// @Tags users
+// @Produce json
+// @Param id path int true "ID of the user to resolve segments for"
+// @Success 200 {object} UserSegments
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /api/v1/users/{id}/segments [get]
+func (h *UserHandler) ReadUserSegments(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	if idStr == "" {
+		render.Render(w, r, ErrInvalidRequest(fmt.Errorf(ErrMissingUserID)))
+		return
+	}
+
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(fmt.Errorf(ErrInvalidUserID)))
+		return
+	}
+
+	segments, err := h.us.GetUserSegments(r.Context(), id)
+	if err != nil {
+		render.Render(w, r, ErrStorage(err))
+		return
+	}
+
+	if segments == nil {
+		segments = []models.Segment{}
+	}
+	render.JSON(w, r, UserSegments{UserID: id, Segments: segments})
+}
+
+// ReadUserSegmentsHistory godoc
+//
+// @Summary Get the segments history of a user
+// @Description Returns a CSV report (user_id;segment;operation;timestamp) of the user entering and leaving segments during the given year-month
+// @Tags users
+// @Produce text/csv
+// @Param id path int true "ID of the user to build the report for"
+// @Param period query string true "Year-month of the report" example(2023-08)
+// @Success 200 {string} string "CSV report"
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /api/v1/users/{id}/segments/history [get]
+func (h *UserHandler) ReadUserSegmentsHistory(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	if idStr == "" {
+		render.Render(w, r, ErrInvalidRequest(fmt.Errorf(ErrMissingUserID)))
+		return
+	}
+
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(fmt.Errorf(ErrInvalidUserID)))
+		return
+	}
+
+	period := r.URL.Query().Get("period")
+	if period == "" {
+		render.Render(w, r, ErrMissingField("period"))
+		return
+	}
+
+	from, err := time.Parse(historyPeriodLayout, period)
+	if err != nil {
+		render.Render(w, r, ErrInvalidField("period", period))
+		return
+	}
+
+	history, err := h.us.GetUserSegmentsHistory(r.Context(), id, from, from.AddDate(0, 1, 0))
+	if err != nil {
+		render.Render(w, r, ErrStorage(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition",
+		fmt.Sprintf("attachment; filename=\"user_%d_segments_%s.csv\"", id, period))
+
+	writer := csv.NewWriter(w)
+	writer.Comma = ';'
+
+	_ = writer.Write([]string{"user_id", "segment", "operation", "timestamp"})
+	for _, event := range history {
+		_ = writer.Write([]string{
+			strconv.FormatInt(event.UserID, 10),
+			event.SegmentName,
+			string(event.Operation),
+			event.CreatedAt.UTC().Format(time.RFC3339),
+		})
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		logger.FromContext(r.Context()).ErrorContext(r.Context(), "failed to write segments history report", slog.Any("error", err))
+	}
+}
@@ -0,0 +1,128 @@
+// Package health serves the liveness and readiness probes of the service.
+package health
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/render"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/logger"
+)
+
+// checkTimeout bounds every readiness check, so a hanging dependency fails the probe instead of blocking it.
+const checkTimeout = 2 * time.Second
+
+const (
+	StatusOK           = "ok"
+	StatusUnavailable  = "unavailable"
+	StatusShuttingDown = "shutting_down"
+)
+
+// Check reports whether a dependency of the service is usable.
+type Check func(ctx context.Context) error
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Health runs the readiness checks and tracks graceful shutdown.
+type Health struct {
+	mu           sync.RWMutex
+	checks       []namedCheck
+	shuttingDown atomic.Bool
+}
+
+func New() *Health {
+	return &Health{}
+}
+
+// AddCheck adds a check the service is ready only when it passes.
+func (h *Health) AddCheck(name string, check Check) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.checks = append(h.checks, namedCheck{name: name, check: check})
+}
+
+// ShutDown makes the service not ready, so load balancers stop sending it requests before the server stops.
+func (h *Health) ShutDown() {
+	h.shuttingDown.Store(true)
+}
+
+// Response is the status of the service and of each readiness check.
+type Response struct {
+	Status string            `json:"status" example:"ok"`
+	Checks map[string]string `json:"checks,omitempty"` // "ok" or "unavailable", errors are only logged
+}
+
+// Liveness godoc
+//
+// @Summary Liveness probe
+// @Description Reports the process is running, it does not check dependencies
+// @Tags health
+// @Produce json
+// @Success 200 {object} Response
+// @Router /healthz [get]
+func (h *Health) Liveness(w http.ResponseWriter, r *http.Request) {
+	render.JSON(w, r, Response{Status: StatusOK})
+}
+
+// Readiness godoc
+//
+// @Summary Readiness probe
+// @Description Reports the service can serve requests: the database is reachable and all migrations are applied.
+// @Description Fails with "shutting_down" once graceful shutdown starts.
+// @Tags health
+// @Produce json
+// @Success 200 {object} Response
+// @Failure 503 {object} Response
+// @Router /readyz [get]
+func (h *Health) Readiness(w http.ResponseWriter, r *http.Request) {
+	if h.shuttingDown.Load() {
+		render.Status(r, http.StatusServiceUnavailable)
+		render.JSON(w, r, Response{Status: StatusShuttingDown})
+		return
+	}
+
+	h.mu.RLock()
+	checks := h.checks
+	h.mu.RUnlock()
+
+	response := Response{Status: StatusOK, Checks: make(map[string]string, len(checks))}
+	results := make([]error, len(checks))
+
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func(i int, check Check) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+			defer cancel()
+			results[i] = check(ctx)
+		}(i, check.check)
+	}
+	wg.Wait()
+
+	for i, check := range checks {
+		// the probe is unauthenticated, so errors which may name hosts of dependencies are only logged
+		if results[i] != nil {
+			logger.FromContext(r.Context()).ErrorContext(r.Context(), "readiness check failed",
+				slog.String("check", check.name), slog.Any("error", results[i]))
+			response.Status = StatusUnavailable
+			response.Checks[check.name] = StatusUnavailable
+			continue
+		}
+		response.Checks[check.name] = StatusOK
+	}
+
+	if response.Status != StatusOK {
+		render.Status(r, http.StatusServiceUnavailable)
+	}
+	render.JSON(w, r, response)
+}
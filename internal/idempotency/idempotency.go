@@ -0,0 +1,166 @@
+// Package idempotency makes retries of mutating requests with the same Idempotency-Key header safe:
+// the first response is stored and returned again to retries instead of repeating the change.
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/auth"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/handler"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/logger"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/models"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/storage"
+)
+
+const (
+	// Header is the request header with the client generated key of the request.
+	Header = "Idempotency-Key"
+	// ReplayedHeader is set on responses returned from the stored response of an earlier request.
+	ReplayedHeader = "Idempotent-Replayed"
+
+	maxKeyLength = 255
+	// maxBodySize limits the request bodies read to hash them
+	maxBodySize = 1 << 20
+)
+
+// Middleware stores the responses of requests with the Idempotency-Key header for ttl. A request with the key
+// of a completed request gets its response, while a request with the key of a request in progress or with
+// another method, path or body is rejected with 409. Requests without the header are passed through.
+//
+// Only 2xx responses are stored with all their headers but the hop-by-hop ones. After any other response
+// the key is released, so a request rejected with 4xx or failed with 5xx can be retried with the same key.
+func Middleware(store storage.IdempotencyStorage, ttl time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(Header)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > maxKeyLength {
+				render.Render(w, r, handler.ErrInvalidField(Header, key))
+				return
+			}
+
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+			if err != nil {
+				render.Render(w, r, handler.ErrInvalidRequest(err))
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			record := &models.IdempotencyKey{
+				Scope:       scope(r),
+				Key:         key,
+				RequestHash: requestHash(r, body),
+				ExpiresAt:   time.Now().Add(ttl),
+			}
+
+			existing, err := store.ReserveIdempotencyKey(r.Context(), record)
+			if err != nil {
+				render.Render(w, r, handler.ErrStorage(err))
+				return
+			}
+			if existing != nil {
+				replay(w, r, existing, record.RequestHash)
+				return
+			}
+
+			serve(w, r, next, store, record)
+		})
+	}
+}
+
+// scope returns the subject of the request principal, keys of anonymous requests share the empty scope.
+func scope(r *http.Request) string {
+	if principal := auth.FromContext(r.Context()); principal != nil {
+		return principal.Subject
+	}
+	return ""
+}
+
+func requestHash(r *http.Request, body []byte) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s %s\n", r.Method, r.URL.Path)
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// replay writes the stored response of the earlier request with the key.
+func replay(w http.ResponseWriter, r *http.Request, existing *models.IdempotencyKey, requestHash string) {
+	if existing.RequestHash != requestHash {
+		render.Render(w, r, handler.ErrIdempotencyConflict(fmt.Errorf("idempotency key was used for another request")))
+		return
+	}
+	if !existing.Completed() {
+		render.Render(w, r, handler.ErrIdempotencyConflict(fmt.Errorf("request with the idempotency key is in progress")))
+		return
+	}
+
+	for name, values := range existing.Headers {
+		w.Header()[name] = values
+	}
+	w.Header().Set(ReplayedHeader, "true")
+	w.WriteHeader(existing.StatusCode)
+	w.Write(existing.Body)
+}
+
+// serve handles the request with the reserved key and stores its response, the key is released
+// when the response is not 2xx or the handler panics.
+func serve(w http.ResponseWriter, r *http.Request, next http.Handler, store storage.IdempotencyStorage, record *models.IdempotencyKey) {
+	// the response is stored even if the client is gone, as the change has been made
+	ctx := context.WithoutCancel(r.Context())
+	completed := false
+	defer func() {
+		if completed {
+			return
+		}
+		if err := store.ReleaseIdempotencyKey(ctx, record.Scope, record.Key); err != nil {
+			logger.FromContext(ctx).ErrorContext(ctx, "failed to release idempotency key", slog.Any("error", err))
+		}
+	}()
+
+	var body bytes.Buffer
+	ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+	ww.Tee(&body)
+
+	next.ServeHTTP(ww, r)
+
+	status := ww.Status()
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if status < http.StatusOK || status >= http.StatusMultipleChoices {
+		return
+	}
+
+	record.StatusCode = status
+	record.Headers = storedHeaders(ww.Header())
+	record.Body = body.Bytes()
+	if err := store.CompleteIdempotencyKey(ctx, record); err != nil {
+		logger.FromContext(ctx).ErrorContext(ctx, "failed to store idempotent response", slog.Any("error", err))
+		return
+	}
+	completed = true
+}
+
+// unstoredHeaders are not replayed, as they describe the connection or the original message rather than the response.
+var unstoredHeaders = []string{"Connection", "Content-Length", "Date", "Keep-Alive", "Trailer", "Transfer-Encoding", ReplayedHeader}
+
+func storedHeaders(header http.Header) map[string][]string {
+	stored := header.Clone()
+	for _, name := range unstoredHeaders {
+		delete(stored, name)
+	}
+	return stored
+}
@@ -0,0 +1,191 @@
+package idempotency
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/auth"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/handler"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/storage/memory"
+)
+
+// countingHandler counts its calls and responds with the status and a body naming the call.
+type countingHandler struct {
+	calls  atomic.Int32
+	status int
+}
+
+func (h *countingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	call := h.calls.Add(1)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/api/v1/users/1")
+	w.Header().Set("ETag", `"v1"`)
+	w.Header().Set("X-Call", "first")
+	w.WriteHeader(h.status)
+	json.NewEncoder(w).Encode(map[string]int32{"call": call})
+}
+
+func newMiddleware(t *testing.T, next http.Handler) http.Handler {
+	t.Helper()
+	store, err := memory.NewIdempotencyStorage(memory.NewDB())
+	if err != nil {
+		t.Fatalf("NewIdempotencyStorage: %v", err)
+	}
+	return Middleware(store, time.Hour)(next)
+}
+
+func send(h http.Handler, path, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	if key != "" {
+		req.Header.Set(Header, key)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func requireConflict(t *testing.T, rec *httptest.ResponseRecorder) {
+	t.Helper()
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("status = %d, want 409: %s", rec.Code, rec.Body)
+	}
+	var response handler.ErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("failed to decode %s: %v", rec.Body, err)
+	}
+	if response.AppCode != handler.CodeIdempotencyConflict {
+		t.Fatalf("code = %d, want %d", response.AppCode, handler.CodeIdempotencyConflict)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	next := &countingHandler{status: http.StatusCreated}
+	h := newMiddleware(t, next)
+
+	first := send(h, "/users", "key", `{"username":"ivan"}`)
+	if first.Code != http.StatusCreated || first.Header().Get(ReplayedHeader) != "" {
+		t.Fatalf("first response = %d %v, want 201 not replayed", first.Code, first.Header())
+	}
+
+	replayed := send(h, "/users", "key", `{"username":"ivan"}`)
+	if replayed.Code != http.StatusCreated || replayed.Body.String() != first.Body.String() {
+		t.Fatalf("replayed response = %d %s, want %d %s", replayed.Code, replayed.Body, first.Code, first.Body)
+	}
+	for _, name := range []string{"Content-Type", "Location", "ETag", "X-Call"} {
+		if got, want := replayed.Header().Get(name), first.Header().Get(name); got != want {
+			t.Errorf("replayed %s = %q, want %q", name, got, want)
+		}
+	}
+	if replayed.Header().Get(ReplayedHeader) != "true" {
+		t.Errorf("%s = %q, want true", ReplayedHeader, replayed.Header().Get(ReplayedHeader))
+	}
+	if calls := next.calls.Load(); calls != 1 {
+		t.Fatalf("handler calls = %d, want 1", calls)
+	}
+}
+
+func TestKeyOfAnotherRequest(t *testing.T) {
+	next := &countingHandler{status: http.StatusCreated}
+	h := newMiddleware(t, next)
+	send(h, "/users", "key", `{"username":"ivan"}`)
+
+	requireConflict(t, send(h, "/users", "key", `{"username":"petr"}`))
+	requireConflict(t, send(h, "/segments", "key", `{"username":"ivan"}`))
+	if calls := next.calls.Load(); calls != 1 {
+		t.Fatalf("handler calls = %d, want 1", calls)
+	}
+}
+
+func TestRequestInProgress(t *testing.T) {
+	entered, release := make(chan struct{}), make(chan struct{})
+	h := newMiddleware(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-release
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- send(h, "/users", "key", `{}`) }()
+	<-entered
+
+	requireConflict(t, send(h, "/users", "key", `{}`))
+
+	close(release)
+	if rec := <-done; rec.Code != http.StatusCreated {
+		t.Fatalf("status of the first request = %d, want 201", rec.Code)
+	}
+	if rec := send(h, "/users", "key", `{}`); rec.Code != http.StatusCreated || rec.Header().Get(ReplayedHeader) != "true" {
+		t.Fatalf("response after completion = %d %v, want the replayed 201", rec.Code, rec.Header())
+	}
+}
+
+func TestUnsuccessfulResponsesAreNotStored(t *testing.T) {
+	for _, status := range []int{http.StatusBadRequest, http.StatusConflict, http.StatusInternalServerError} {
+		t.Run(http.StatusText(status), func(t *testing.T) {
+			next := &countingHandler{status: status}
+			h := newMiddleware(t, next)
+
+			for i := 0; i < 2; i++ {
+				if rec := send(h, "/users", "key", `{}`); rec.Code != status || rec.Header().Get(ReplayedHeader) != "" {
+					t.Fatalf("response %d = %d %v, want %d not replayed", i, rec.Code, rec.Header(), status)
+				}
+			}
+			if calls := next.calls.Load(); calls != 2 {
+				t.Fatalf("handler calls = %d, want 2", calls)
+			}
+		})
+	}
+}
+
+func TestPanicReleasesKey(t *testing.T) {
+	var calls atomic.Int32
+	h := newMiddleware(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			panic("handler failed")
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	func() {
+		defer func() { recover() }()
+		send(h, "/users", "key", `{}`)
+	}()
+	if rec := send(h, "/users", "key", `{}`); rec.Code != http.StatusCreated || rec.Header().Get(ReplayedHeader) != "" {
+		t.Fatalf("retry after the panic = %d %v, want 201 not replayed", rec.Code, rec.Header())
+	}
+}
+
+func TestKeysOfPrincipals(t *testing.T) {
+	next := &countingHandler{status: http.StatusCreated}
+	h := newMiddleware(t, next)
+
+	for _, subject := range []string{"api-key:1", "user:42"} {
+		req := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(`{}`))
+		req.Header.Set(Header, "key")
+		req = req.WithContext(auth.WithPrincipal(req.Context(), &auth.Principal{Subject: subject}))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusCreated || rec.Header().Get(ReplayedHeader) != "" {
+			t.Fatalf("response to %s = %d %v, want 201 not replayed", subject, rec.Code, rec.Header())
+		}
+	}
+}
+
+func TestWithoutKey(t *testing.T) {
+	next := &countingHandler{status: http.StatusCreated}
+	h := newMiddleware(t, next)
+
+	send(h, "/users", "", `{}`)
+	send(h, "/users", "", `{}`)
+	if calls := next.calls.Load(); calls != 2 {
+		t.Fatalf("handler calls = %d, want 2", calls)
+	}
+	if rec := send(h, "/users", strings.Repeat("k", maxKeyLength+1), `{}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("status of a long key = %d, want 400", rec.Code)
+	}
+}
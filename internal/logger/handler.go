@@ -0,0 +1,47 @@
+package logger
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// contextHandler adds the trace ID, the route and the user ID and segment of the request
+// to records logged with its context. They are read when a record is logged, because chi
+// fills the route and URL parameters only after the request logger is created.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h *contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		record.AddAttrs(slog.String("trace_id", span.TraceID().String()))
+	}
+
+	if rctx := chi.RouteContext(ctx); rctx != nil {
+		if route := rctx.RoutePattern(); route != "" {
+			record.AddAttrs(slog.String("route", route))
+		}
+		// every {id} and {user_id} parameter of the API is a user ID and every {slug} is a segment
+		for i, key := range rctx.URLParams.Keys {
+			switch key {
+			case "id", "user_id":
+				record.AddAttrs(slog.String("user_id", rctx.URLParams.Values[i]))
+			case "slug":
+				record.AddAttrs(slog.String("segment", rctx.URLParams.Values[i]))
+			}
+		}
+	}
+
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,72 @@
+// Package logger builds the slog logger of the service and passes a request-scoped logger through the context.
+package logger
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/config"
+)
+
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// sensitiveKeys are attribute keys whose values are replaced, so credentials never get into the logs.
+var sensitiveKeys = map[string]bool{
+	"password":      true,
+	"pass":          true,
+	"secret":        true,
+	"token":         true,
+	"authorization": true,
+	"api_key":       true,
+	"dsn":           true,
+}
+
+// New creates a logger writing records of the configured level and format to w.
+func New(w io.Writer, cfg config.Config) (*slog.Logger, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.Log.Level)); err != nil {
+		return nil, fmt.Errorf("invalid log level '%s': %w", cfg.Log.Level, err)
+	}
+
+	options := &slog.HandlerOptions{Level: level, ReplaceAttr: redact}
+
+	var handler slog.Handler
+	switch cfg.Log.Format {
+	case FormatText:
+		handler = slog.NewTextHandler(w, options)
+	case FormatJSON:
+		handler = slog.NewJSONHandler(w, options)
+	default:
+		return nil, fmt.Errorf("unknown log format '%s'", cfg.Log.Format)
+	}
+
+	return slog.New(&contextHandler{Handler: handler}), nil
+}
+
+func redact(_ []string, a slog.Attr) slog.Attr {
+	if sensitiveKeys[strings.ToLower(a.Key)] {
+		return slog.String(a.Key, "[REDACTED]")
+	}
+	return a
+}
+
+type contextKey struct{}
+
+// WithContext returns a copy of the context carrying the logger.
+func WithContext(ctx context.Context, log *slog.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, log)
+}
+
+// FromContext returns the logger of the context, the default logger when there is none.
+func FromContext(ctx context.Context) *slog.Logger {
+	if log, ok := ctx.Value(contextKey{}).(*slog.Logger); ok {
+		return log
+	}
+	return slog.Default()
+}
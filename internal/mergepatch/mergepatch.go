@@ -0,0 +1,78 @@
+// Package mergepatch applies JSON merge patch documents described in RFC 7396:
+// members of a patch object replace the members of the target, objects are merged recursively
+// and null removes the member.
+package mergepatch
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ContentType is the media type of merge patch documents.
+const ContentType = "application/merge-patch+json"
+
+// ErrNotObject is returned for a patch which is not a JSON object, such a patch would replace the whole resource.
+var ErrNotObject = errors.New("merge patch must be a JSON object")
+
+// Validate returns an error unless the patch is a JSON object.
+func Validate(patch []byte) error {
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(patch, &object); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return ErrNotObject
+		}
+		return fmt.Errorf("invalid merge patch: %w", err)
+	}
+	if object == nil {
+		return ErrNotObject
+	}
+	return nil
+}
+
+// Apply returns the target document with the patch applied.
+func Apply(target, patch []byte) ([]byte, error) {
+	targetValue, err := decode(target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid merge patch target: %w", err)
+	}
+	patchValue, err := decode(patch)
+	if err != nil {
+		return nil, fmt.Errorf("invalid merge patch: %w", err)
+	}
+	return json.Marshal(merge(targetValue, patchValue))
+}
+
+// decode keeps numbers as json.Number, so they are not rounded through float64.
+func decode(data []byte) (any, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+func merge(target, patch any) any {
+	patchObject, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+
+	targetObject, ok := target.(map[string]any)
+	if !ok {
+		targetObject = make(map[string]any, len(patchObject))
+	}
+	for name, value := range patchObject {
+		if value == nil {
+			delete(targetObject, name)
+			continue
+		}
+		targetObject[name] = merge(targetObject[name], value)
+	}
+	return targetObject
+}
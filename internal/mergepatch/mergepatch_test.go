@@ -0,0 +1,104 @@
+package mergepatch
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// TestApplyRFC7396 runs the examples of RFC 7396 appendix A.
+func TestApplyRFC7396(t *testing.T) {
+	tests := []struct {
+		target, patch, want string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.target+" "+tt.patch, func(t *testing.T) {
+			got, err := Apply([]byte(tt.target), []byte(tt.patch))
+			if err != nil {
+				t.Fatalf("Apply: %v", err)
+			}
+			if !equalJSON(t, got, []byte(tt.want)) {
+				t.Fatalf("Apply = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyKeepsNumbers(t *testing.T) {
+	got, err := Apply([]byte(`{"id":9007199254740993,"percent":12.5}`), []byte(`{"percent":0.1}`))
+	if err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	// 2^53+1 is not representable as float64
+	if want := `{"id":9007199254740993,"percent":0.1}`; string(got) != want {
+		t.Fatalf("Apply = %s, want %s", got, want)
+	}
+}
+
+func TestApplyInvalidJSON(t *testing.T) {
+	if _, err := Apply([]byte(`{"a":`), []byte(`{}`)); err == nil {
+		t.Error("Apply to an invalid target succeeded")
+	}
+	if _, err := Apply([]byte(`{}`), []byte(`{"a":`)); err == nil {
+		t.Error("Apply of an invalid patch succeeded")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		patch     string
+		notObject bool
+		invalid   bool
+	}{
+		{patch: `{}`},
+		{patch: `{"rule":null}`},
+		{patch: `[]`, notObject: true},
+		{patch: `"a"`, notObject: true},
+		{patch: `1`, notObject: true},
+		{patch: `null`, notObject: true},
+		{patch: `{"a":`, invalid: true},
+		{patch: ``, invalid: true},
+	}
+
+	for _, tt := range tests {
+		err := Validate([]byte(tt.patch))
+		switch {
+		case tt.notObject && !errors.Is(err, ErrNotObject):
+			t.Errorf("Validate(%s) = %v, want ErrNotObject", tt.patch, err)
+		case tt.invalid && (err == nil || errors.Is(err, ErrNotObject)):
+			t.Errorf("Validate(%s) = %v, want a syntax error", tt.patch, err)
+		case !tt.notObject && !tt.invalid && err != nil:
+			t.Errorf("Validate(%s) = %v, want nil", tt.patch, err)
+		}
+	}
+}
+
+func equalJSON(t *testing.T, a, b []byte) bool {
+	t.Helper()
+	var av, bv any
+	if err := json.Unmarshal(a, &av); err != nil {
+		t.Fatalf("json.Unmarshal(%s): %v", a, err)
+	}
+	if err := json.Unmarshal(b, &bv); err != nil {
+		t.Fatalf("json.Unmarshal(%s): %v", b, err)
+	}
+	return reflect.DeepEqual(av, bv)
+}
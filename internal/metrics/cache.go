@@ -0,0 +1,30 @@
+package metrics
+
+import (
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/storage/cache"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// RegisterCache exposes the hits, misses and errors of the lookup cache.
+func RegisterCache(layer *cache.Layer) error {
+	results := map[string]func(cache.Stats) int64{
+		"hit":   func(stats cache.Stats) int64 { return stats.Hits },
+		"miss":  func(stats cache.Stats) int64 { return stats.Misses },
+		"error": func(stats cache.Stats) int64 { return stats.Errors },
+	}
+
+	for result, value := range results {
+		value := value
+		counter := prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name:        "cache_lookups_total",
+			Help:        "Number of user segment lookups by the cache result.",
+			ConstLabels: prometheus.Labels{"result": result},
+		}, func() float64 {
+			return float64(value(layer.Stats()))
+		})
+		if err := prometheus.Register(counter); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package metrics
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/models"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/storage/cache"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/storage/memory"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestRegisterCache(t *testing.T) {
+	ctx := context.Background()
+	db := memory.NewDB()
+	us, err := memory.NewUserStorage(db)
+	if err != nil {
+		t.Fatalf("NewUserStorage: %v", err)
+	}
+	layer := cache.NewLayer(cache.NewLRU(10), time.Minute)
+	cached, err := cache.NewUserStorage(us, layer)
+	if err != nil {
+		t.Fatalf("NewUserStorage: %v", err)
+	}
+	if err := RegisterCache(layer); err != nil {
+		t.Fatalf("RegisterCache: %v", err)
+	}
+
+	user := &models.User{FirstName: "Ivan", LastName: "Ivanov", Username: "ivan"}
+	if err := cached.CreateUser(ctx, user); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := cached.GetUserSegments(ctx, user.ID); err != nil {
+			t.Fatalf("GetUserSegments: %v", err)
+		}
+	}
+
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatalf("Gather: %v", err)
+	}
+	got := make(map[string]float64)
+	for _, family := range families {
+		if family.GetName() != "cache_lookups_total" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "result" {
+					got[label.GetValue()] = metric.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+
+	want := map[string]float64{"hit": 2, "miss": 1, "error": 0}
+	for result, value := range want {
+		if got[result] != value {
+			t.Errorf("cache_lookups_total{result=%q} = %v, want %v", result, got[result], value)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package metrics
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/storage"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// collectTimeout bounds counting segment users on a scrape.
+const collectTimeout = 5 * time.Second
+
+var (
+	segmentsDesc = prometheus.NewDesc("segments", "Number of segments.", nil, nil)
+	usersDesc    = prometheus.NewDesc("segment_users", "Number of active members of the segment.", []string{"segment"}, nil)
+)
+
+// segmentsCollector counts the segments and their users at most once per ttl. Counting scans the users
+// of segments with computed members, so scrapes in between are served the last counts.
+type segmentsCollector struct {
+	ss  storage.SegmentStorage
+	ttl time.Duration
+
+	mu        sync.Mutex
+	counts    map[string]int64
+	err       error
+	countedAt time.Time
+}
+
+// RegisterSegments exposes the number of segments and users per segment counted at most once per ttl.
+func RegisterSegments(ss storage.SegmentStorage, ttl time.Duration) error {
+	return prometheus.Register(&segmentsCollector{ss: ss, ttl: ttl})
+}
+
+func (c *segmentsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- segmentsDesc
+	ch <- usersDesc
+}
+
+func (c *segmentsCollector) Collect(ch chan<- prometheus.Metric) {
+	counts, err := c.count()
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(segmentsDesc, err)
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(segmentsDesc, prometheus.GaugeValue, float64(len(counts)))
+	for segment, count := range counts {
+		ch <- prometheus.MustNewConstMetric(usersDesc, prometheus.GaugeValue, float64(count), segment)
+	}
+}
+
+// count returns the last counts, concurrent scrapes wait for a single refresh instead of counting again.
+// A failure is kept for the ttl as well, so a failing database is not queried on every scrape.
+func (c *segmentsCollector) count() (map[string]int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.countedAt.IsZero() && time.Since(c.countedAt) < c.ttl {
+		return c.counts, c.err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), collectTimeout)
+	defer cancel()
+
+	c.counts, c.err = c.ss.CountSegmentUsers(ctx)
+	c.countedAt = time.Now()
+	return c.counts, c.err
+}
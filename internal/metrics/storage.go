@@ -0,0 +1,175 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/models"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/storage"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var storageDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "storage_operation_duration_seconds",
+	Help:    "Latency of storage methods by method and outcome.",
+	Buckets: prometheus.DefBuckets,
+}, []string{"method", "outcome"})
+
+// outcome tells errors caused by the request from failures of the storage.
+func outcome(err error) string {
+	switch {
+	case err == nil:
+		return "ok"
+	case errors.Is(err, storage.ErrNotFound):
+		return "not_found"
+	case errors.Is(err, storage.ErrValidation), errors.Is(err, storage.ErrAlreadyExists), errors.Is(err, storage.ErrConflict),
+		errors.Is(err, storage.ErrVersionMismatch):
+		return "rejected"
+	default:
+		return "error"
+	}
+}
+
+func observe(method string, start time.Time, err error) {
+	storageDuration.WithLabelValues(method, outcome(err)).Observe(time.Since(start).Seconds())
+}
+
+// userStorage records the latency of every method of the decorated storage.
+type userStorage struct {
+	us storage.UserStorage
+}
+
+func NewUserStorage(us storage.UserStorage) (storage.UserStorage, error) {
+	return &userStorage{us: us}, nil
+}
+
+func (s *userStorage) CreateUser(ctx context.Context, user *models.User) (err error) {
+	defer func(start time.Time) { observe("CreateUser", start, err) }(time.Now())
+	return s.us.CreateUser(ctx, user)
+}
+
+func (s *userStorage) GetUserByID(ctx context.Context, id int64) (_ *models.User, err error) {
+	defer func(start time.Time) { observe("GetUserByID", start, err) }(time.Now())
+	return s.us.GetUserByID(ctx, id)
+}
+
+func (s *userStorage) GetUserSegments(ctx context.Context, id int64) (_ []models.Segment, err error) {
+	defer func(start time.Time) { observe("GetUserSegments", start, err) }(time.Now())
+	return s.us.GetUserSegments(ctx, id)
+}
+
+func (s *userStorage) EvaluateUsers(ctx context.Context, ids []int64) (_ []models.Evaluation, err error) {
+	defer func(start time.Time) { observe("EvaluateUsers", start, err) }(time.Now())
+	return s.us.EvaluateUsers(ctx, ids)
+}
+
+func (s *userStorage) GetUsers(ctx context.Context, filter storage.UserFilter, params storage.ListParams) (_ *storage.Page[models.User], err error) {
+	defer func(start time.Time) { observe("GetUsers", start, err) }(time.Now())
+	return s.us.GetUsers(ctx, filter, params)
+}
+
+func (s *userStorage) UpdateUser(ctx context.Context, user *models.User, ifMatch string) (_ *models.User, err error) {
+	defer func(start time.Time) { observe("UpdateUser", start, err) }(time.Now())
+	return s.us.UpdateUser(ctx, user, ifMatch)
+}
+
+func (s *userStorage) PatchUser(ctx context.Context, id int64, ifMatch string, patch func(user *models.User) error) (_ *models.User, err error) {
+	defer func(start time.Time) { observe("PatchUser", start, err) }(time.Now())
+	return s.us.PatchUser(ctx, id, ifMatch, patch)
+}
+
+func (s *userStorage) DeleteUser(ctx context.Context, id int64, ifMatch string) (err error) {
+	defer func(start time.Time) { observe("DeleteUser", start, err) }(time.Now())
+	return s.us.DeleteUser(ctx, id, ifMatch)
+}
+
+func (s *userStorage) UpdateUserSegments(ctx context.Context, id int64, segmentsToAdd []models.SegmentAssignment, segmentsToRemove []string) (err error) {
+	defer func(start time.Time) { observe("UpdateUserSegments", start, err) }(time.Now())
+	return s.us.UpdateUserSegments(ctx, id, segmentsToAdd, segmentsToRemove)
+}
+
+func (s *userStorage) GetUserSegmentsHistory(ctx context.Context, id int64, from, to time.Time) (_ []*models.UserSegmentHistory, err error) {
+	defer func(start time.Time) { observe("GetUserSegmentsHistory", start, err) }(time.Now())
+	return s.us.GetUserSegmentsHistory(ctx, id, from, to)
+}
+
+func (s *userStorage) DeleteExpiredSegments(ctx context.Context, now time.Time) (_ int64, err error) {
+	defer func(start time.Time) { observe("DeleteExpiredSegments", start, err) }(time.Now())
+	return s.us.DeleteExpiredSegments(ctx, now)
+}
+
+// segmentStorage records the latency of every method of the decorated storage.
+type segmentStorage struct {
+	ss storage.SegmentStorage
+}
+
+func NewSegmentStorage(ss storage.SegmentStorage) (storage.SegmentStorage, error) {
+	return &segmentStorage{ss: ss}, nil
+}
+
+func (s *segmentStorage) CreateSegment(ctx context.Context, segment *models.Segment) (err error) {
+	defer func(start time.Time) { observe("CreateSegment", start, err) }(time.Now())
+	return s.ss.CreateSegment(ctx, segment)
+}
+
+func (s *segmentStorage) GetSegments(ctx context.Context, filter storage.SegmentFilter, params storage.ListParams) (_ *storage.Page[models.Segment], err error) {
+	defer func(start time.Time) { observe("GetSegments", start, err) }(time.Now())
+	return s.ss.GetSegments(ctx, filter, params)
+}
+
+func (s *segmentStorage) GetSegmentByName(ctx context.Context, slug string) (_ *models.Segment, err error) {
+	defer func(start time.Time) { observe("GetSegmentByName", start, err) }(time.Now())
+	return s.ss.GetSegmentByName(ctx, slug)
+}
+
+func (s *segmentStorage) UpdateSegment(ctx context.Context, segment *models.Segment, ifMatch string) (_ *models.Segment, err error) {
+	defer func(start time.Time) { observe("UpdateSegment", start, err) }(time.Now())
+	return s.ss.UpdateSegment(ctx, segment, ifMatch)
+}
+
+func (s *segmentStorage) PatchSegment(ctx context.Context, slug string, ifMatch string, patch func(segment *models.Segment) error) (_ *models.Segment, err error) {
+	defer func(start time.Time) { observe("PatchSegment", start, err) }(time.Now())
+	return s.ss.PatchSegment(ctx, slug, ifMatch, patch)
+}
+
+func (s *segmentStorage) RenameSegment(ctx context.Context, slug, name string, ifMatch string, aliasTTL time.Duration) (_ *models.Segment, err error) {
+	defer func(start time.Time) { observe("RenameSegment", start, err) }(time.Now())
+	return s.ss.RenameSegment(ctx, slug, name, ifMatch, aliasTTL)
+}
+
+func (s *segmentStorage) DeleteExpiredAliases(ctx context.Context, now time.Time) (_ int64, err error) {
+	defer func(start time.Time) { observe("DeleteExpiredAliases", start, err) }(time.Now())
+	return s.ss.DeleteExpiredAliases(ctx, now)
+}
+
+func (s *segmentStorage) DeleteSegmentBySlug(ctx context.Context, slug string, ifMatch string) (err error) {
+	defer func(start time.Time) { observe("DeleteSegmentBySlug", start, err) }(time.Now())
+	return s.ss.DeleteSegmentBySlug(ctx, slug, ifMatch)
+}
+
+func (s *segmentStorage) GetUsersInSegment(ctx context.Context, slug string, filter storage.UserFilter, params storage.ListParams) (_ *storage.Page[models.User], err error) {
+	defer func(start time.Time) { observe("GetUsersInSegment", start, err) }(time.Now())
+	return s.ss.GetUsersInSegment(ctx, slug, filter, params)
+}
+
+func (s *segmentStorage) CountSegmentUsers(ctx context.Context) (_ map[string]int64, err error) {
+	defer func(start time.Time) { observe("CountSegmentUsers", start, err) }(time.Now())
+	return s.ss.CountSegmentUsers(ctx)
+}
+
+func (s *segmentStorage) PreviewRule(ctx context.Context, rule *models.Rule, params storage.ListParams) (_ *storage.Page[models.User], err error) {
+	defer func(start time.Time) { observe("PreviewRule", start, err) }(time.Now())
+	return s.ss.PreviewRule(ctx, rule, params)
+}
+
+func (s *segmentStorage) AddUserToSegment(ctx context.Context, slug string, userID int64, expiresAt *time.Time, variant string) (err error) {
+	defer func(start time.Time) { observe("AddUserToSegment", start, err) }(time.Now())
+	return s.ss.AddUserToSegment(ctx, slug, userID, expiresAt, variant)
+}
+
+func (s *segmentStorage) DeleteUserFromSegment(ctx context.Context, slug string, userID int64) (err error) {
+	defer func(start time.Time) { observe("DeleteUserFromSegment", start, err) }(time.Now())
+	return s.ss.DeleteUserFromSegment(ctx, slug, userID)
+}
@@ -0,0 +1,30 @@
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/storage"
+)
+
+func TestOutcome(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{nil, "ok"},
+		{fmt.Errorf("user 1 %w", storage.ErrNotFound), "not_found"},
+		{fmt.Errorf("username %w", storage.ErrValidation), "rejected"},
+		{fmt.Errorf("segment %w", storage.ErrAlreadyExists), "rejected"},
+		{fmt.Errorf("segment %w", storage.ErrConflict), "rejected"},
+		{fmt.Errorf("user 1 %w", storage.ErrVersionMismatch), "rejected"},
+		{errors.New("connection refused"), "error"},
+	}
+
+	for _, tt := range tests {
+		if got := outcome(tt.err); got != tt.want {
+			t.Errorf("outcome(%v) = %s, want %s", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package models
+
+import "time"
+
+// Scope is a permission granted to an API key.
+type Scope string
+
+const (
+	ScopeUsersRead     Scope = "users:read"
+	ScopeUsersWrite    Scope = "users:write"
+	ScopeSegmentsRead  Scope = "segments:read"
+	ScopeSegmentsWrite Scope = "segments:write"
+	ScopeEvaluate      Scope = "evaluate"
+	// ScopeAdmin grants every other scope and managing API keys.
+	ScopeAdmin Scope = "admin"
+)
+
+// Scopes are all known scopes.
+var Scopes = []Scope{ScopeUsersRead, ScopeUsersWrite, ScopeSegmentsRead, ScopeSegmentsWrite, ScopeEvaluate, ScopeAdmin}
+
+// APIKey authenticates a client. Only the hash of the key is stored, the key is shown once when it is created.
+type APIKey struct {
+	ID        int64     `gorm:"primary_key" json:"id"`
+	Name      string    `gorm:"size:128" json:"name" example:"mobile-backend"`
+	Prefix    string    `gorm:"size:16" json:"prefix" example:"sk_Zx3kTq"` // first characters of the key identifying it
+	Hash      string    `gorm:"size:64;uniqueIndex" json:"-"`              // hex SHA-256 of the key
+	Scopes    []Scope   `gorm:"serializer:json" json:"scopes"`
+	CreatedAt time.Time `gorm:"default:now()" json:"created_at"`
+}
+
+func (APIKey) TableName() string {
+	return "api_keys"
+}
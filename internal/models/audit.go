@@ -0,0 +1,55 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// AuditAction is a kind of change recorded in the audit log.
+type AuditAction string
+
+const (
+	AuditUserCreate         AuditAction = "user.create"
+	AuditUserUpdate         AuditAction = "user.update"
+	AuditUserDelete         AuditAction = "user.delete"
+	AuditUserSegmentsUpdate AuditAction = "user.segments.update"
+	AuditSegmentCreate      AuditAction = "segment.create"
+	AuditSegmentUpdate      AuditAction = "segment.update"
+	AuditSegmentDelete      AuditAction = "segment.delete"
+	AuditSegmentRename      AuditAction = "segment.rename"
+	AuditMembershipAdd      AuditAction = "membership.add"
+	AuditMembershipRemove   AuditAction = "membership.remove"
+)
+
+// Audited resource types.
+const (
+	AuditResourceUser       = "user"
+	AuditResourceSegment    = "segment"
+	AuditResourceMembership = "membership" // identified by "<segment>:<user ID>"
+)
+
+// AuditEntry is an immutable record of a change made through the API, written in the transaction of the change.
+// Before and After are the JSON of the resource, null when it did not exist before or does not exist after.
+type AuditEntry struct {
+	ID           int64           `gorm:"primary_key" json:"id"`
+	Actor        string          `json:"actor" example:"api-key:3"` // subject of the authenticated principal, "system" otherwise
+	ActorName    string          `json:"actor_name,omitempty" example:"mobile-backend"`
+	Action       AuditAction     `json:"action" example:"segment.create"`
+	ResourceType string          `json:"resource_type" example:"segment"`
+	ResourceID   string          `json:"resource_id" example:"AVITO_VOICE_MESSAGES"`
+	Before       json.RawMessage `gorm:"serializer:json" json:"before" swaggertype:"object"`
+	After        json.RawMessage `gorm:"serializer:json" json:"after" swaggertype:"object"`
+	RequestID    string          `json:"request_id,omitempty"`
+	CreatedAt    time.Time       `gorm:"default:now()" json:"timestamp"`
+}
+
+func (AuditEntry) TableName() string {
+	return "audit_log"
+}
+
+// AuditMembership is a user's explicit membership in a segment as recorded in audit entries.
+type AuditMembership struct {
+	Segment   string     `json:"segment"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Variant   *string    `json:"variant,omitempty"`
+}
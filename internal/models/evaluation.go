@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// Evaluation is the flat list of the active segments of a user, as served to client SDKs.
+type Evaluation struct {
+	UserID   int64    `json:"user_id" example:"1"`
+	Segments []string `json:"segments" example:"AVITO_VOICE_MESSAGES,AVITO_DISCOUNT_30"` // sorted slugs
+	// Variants maps the slugs of segments with variants to the variant of the user
+	Variants map[string]string `json:"variants,omitempty"`
+	// ExpiresAt is the earliest expiration of the user's memberships, the evaluation may change then
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
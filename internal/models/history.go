@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+type Operation string
+
+const (
+	OperationAdd    Operation = "add"
+	OperationRemove Operation = "remove"
+)
+
+// UserSegmentHistory is a record of a user entering or leaving a segment. Records are never deleted,
+// and only renaming a segment changes them: the segment name is rewritten, so the history follows the segment.
+// It is not linked to users and segments by foreign keys so the history survives their deletion.
+type UserSegmentHistory struct {
+	ID          int64     `gorm:"primary_key" json:"-"`
+	UserID      int64     `json:"user_id"`
+	SegmentName string    `json:"segment"`
+	Operation   Operation `json:"operation"`
+	CreatedAt   time.Time `gorm:"default:now()" json:"timestamp"`
+}
+
+func (UserSegmentHistory) TableName() string {
+	return "user_segments_history"
+}
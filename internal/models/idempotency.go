@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// IdempotencyKey is a request made with an Idempotency-Key header and, once it is complete, its response.
+// Keys are scoped by the principal making the request, so clients cannot replay each other's responses.
+type IdempotencyKey struct {
+	Scope       string `gorm:"primary_key"`
+	Key         string `gorm:"primary_key"`
+	RequestHash string // hex SHA-256 of the method, the path and the body of the request
+	// StatusCode is 0 while the first request with the key is in progress
+	StatusCode int
+	// Headers are the response headers by their canonical names
+	Headers   map[string][]string `gorm:"serializer:json"`
+	Body      []byte
+	CreatedAt time.Time `gorm:"default:now()"`
+	ExpiresAt time.Time
+}
+
+func (IdempotencyKey) TableName() string {
+	return "idempotency_keys"
+}
+
+// Completed reports whether the response of the first request with the key is stored.
+func (k *IdempotencyKey) Completed() bool {
+	return k.StatusCode != 0
+}
@@ -0,0 +1,27 @@
+package models
+
+// RuleOp is a comparison operator of a targeting rule.
+type RuleOp string
+
+const (
+	RuleOpEq  RuleOp = "eq"
+	RuleOpNe  RuleOp = "ne"
+	RuleOpGt  RuleOp = "gt"
+	RuleOpGte RuleOp = "gte"
+	RuleOpLt  RuleOp = "lt"
+	RuleOpLte RuleOp = "lte"
+	RuleOpIn  RuleOp = "in"
+)
+
+// Rule is a targeting rule matching users by their attributes. A rule is either a combination
+// of rules with And, Or or Not, or a comparison of the Attribute with the Value by the Op, e.g.
+//
+//	{"and": [{"attribute": "country", "op": "eq", "value": "RU"}, {"attribute": "app_version", "op": "gte", "value": "7.2"}]}
+type Rule struct {
+	And       []Rule `json:"and,omitempty"`
+	Or        []Rule `json:"or,omitempty"`
+	Not       *Rule  `json:"not,omitempty"`
+	Attribute string `json:"attribute,omitempty" example:"country"`
+	Op        RuleOp `json:"op,omitempty" enums:"eq,ne,gt,gte,lt,lte,in" example:"eq"`
+	Value     any    `json:"value,omitempty" swaggertype:"object"`
+}
@@ -2,12 +2,80 @@ package models
 
 import "time"
 
+// SegmentType defines how users get into a segment.
+type SegmentType string
+
+const (
+	// SegmentTypeStatic segments contain users put into them explicitly or by auto percent
+	// and users matching the rule if there is one.
+	SegmentTypeStatic SegmentType = "static"
+	// SegmentTypeExperiment segments also contain every user whose hash bucket of the segment salt
+	// and the user ID is within the percent and who matches the rule if there is one,
+	// such memberships are computed and never stored.
+	SegmentTypeExperiment SegmentType = "experiment"
+)
+
 type Segment struct {
-	Name      string    `gorm:"primary_key" json:"name"`
-	Users     []User    `gorm:"many2many:user_segments" json:"users,omitempty"`
+	Name        string      `gorm:"primary_key" json:"name"`
+	Type        SegmentType `gorm:"default:static" json:"type,omitempty"`
+	AutoPercent float64     `json:"auto_percent,omitempty" validate:"min=0,max=100"` // percentage of all users automatically put into the segment
+	Salt        string      `json:"salt,omitempty" validate:"max=128"`               // experiment hash salt, generated when empty
+	Percent     float64     `json:"percent,omitempty" validate:"min=0,max=100"`      // percentage of users in an experiment
+	Variants    []Variant   `gorm:"serializer:json" json:"variants,omitempty"`
+	Rule        *Rule       `gorm:"serializer:json" json:"rule,omitempty"` // users matching the rule are in the segment
+	Users       []User      `gorm:"many2many:user_segments" json:"users,omitempty"`
+	ExpiresAt   *time.Time  `gorm:"-" json:"expires_at,omitempty"` // set when the segment is loaded as a user's membership with TTL
+	Variant     string      `gorm:"-" json:"variant,omitempty"`    // set when the segment with variants is loaded as a user's membership
+	// VariantSeed is set when a segment with variants is renamed to the seed of the variant hash
+	// of the former name, so members keep their variants
+	VariantSeed string `json:"-"`
+	// VariantCounts is the number of members in each variant, set when the segment is loaded with its users
+	VariantCounts map[string]int64 `gorm:"-" json:"variant_counts,omitempty"`
+	// Version is incremented by every update of the segment, so its entity tag changes with every update
+	Version   int64     `gorm:"not null;default:1" json:"version"`
 	CreatedAt time.Time `gorm:"default:now()" json:"-"`
 }
 
+// Variant is a branch of a segment. Every member of a segment with variants is in exactly one of them,
+// chosen by the hash of the user ID with the probability proportional to the weight unless set explicitly.
+type Variant struct {
+	Name   string `json:"name" example:"control" validate:"required,max=128"`
+	Weight int    `json:"weight" example:"50" validate:"min=1"`
+}
+
 func (Segment) TableName() string {
 	return "segment"
 }
+
+// SegmentAlias is a former name of a renamed segment which resolves to the segment until ExpiresAt.
+type SegmentAlias struct {
+	Alias       string    `gorm:"primary_key"`
+	SegmentName string    `json:"segment_name"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	CreatedAt   time.Time `gorm:"default:now()" json:"-"`
+}
+
+func (SegmentAlias) TableName() string {
+	return "segment_aliases"
+}
+
+// UserSegment is a user's membership in a segment. A membership with ExpiresAt set
+// is hidden once the time has passed and removed by the expired segments reaper.
+type UserSegment struct {
+	UserID      int64      `gorm:"primary_key"`
+	SegmentName string     `gorm:"primary_key"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	Variant     *string    `json:"variant,omitempty"` // explicitly assigned variant, the hashed one when nil
+}
+
+func (UserSegment) TableName() string {
+	return "user_segments"
+}
+
+// SegmentAssignment describes adding a user to a segment, optionally until ExpiresAt
+// and into the Variant instead of the hashed one.
+type SegmentAssignment struct {
+	Name      string
+	ExpiresAt *time.Time
+	Variant   string
+}
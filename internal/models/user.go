@@ -5,10 +5,13 @@ import (
 )
 
 type User struct {
-	ID        int64     `gorm:"primary_key"`
-	FirstName string    `gorm:"column:firstname" json:"firstname" validate:"required"`
-	LastName  string    `gorm:"column:lastname" json:"lastname" validate:"required"`
-	Username  string    `gorm:"size:128;uniqueIndex" json:"username" validate:"required"`
-	Segments  []Segment `gorm:"many2many:user_segments" json:"segments,omitempty" validate:"required"`
+	ID         int64          `gorm:"primary_key"`
+	FirstName  string         `gorm:"column:firstname" json:"firstname" validate:"required,max=128"`
+	LastName   string         `gorm:"column:lastname" json:"lastname" validate:"required,max=128"`
+	Username   string         `gorm:"size:128;uniqueIndex" json:"username" validate:"required,max=128"`
+	Attributes map[string]any `gorm:"serializer:json" json:"attributes,omitempty"` // free-form properties matched by segment rules
+	Segments   []Segment      `gorm:"many2many:user_segments" json:"segments,omitempty"`
+	// Version is incremented by every update of the user, so its entity tag changes with every update
+	Version   int64     `gorm:"not null;default:1" json:"version"`
 	CreatedAt time.Time `gorm:"default:now()" json:"-"`
 }
@@ -0,0 +1,24 @@
+package router
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/auth"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/storage"
+)
+
+// anonymousActor is the audit actor of requests when authentication is disabled.
+const anonymousActor = "anonymous"
+
+// auditInfo makes the storages record the changes of the request on behalf of its principal.
+func auditInfo(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info := storage.AuditInfo{Actor: anonymousActor, RequestID: middleware.GetReqID(r.Context())}
+		if principal := auth.FromContext(r.Context()); principal != nil {
+			info.Actor = principal.Subject
+			info.ActorName = principal.Name
+		}
+		next.ServeHTTP(w, r.WithContext(storage.WithAuditInfo(r.Context(), info)))
+	})
+}
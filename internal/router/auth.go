@@ -0,0 +1,87 @@
+package router
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/render"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/auth"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/handler"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/logger"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/models"
+)
+
+// APIKeyHeader is the request header carrying the API key.
+const APIKeyHeader = "X-API-Key"
+
+// scopeMiddleware returns the middleware rejecting requests of principals without the scope.
+type scopeMiddleware func(scope models.Scope) func(http.Handler) http.Handler
+
+// authenticate puts the principal of the request bearer token or API key into the request context,
+// requests without valid credentials are rejected with 401.
+func authenticate(a *auth.Authenticator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var principal *auth.Principal
+			var err error
+			if token, ok := bearerToken(r); ok {
+				principal, err = a.AuthenticateToken(r.Context(), token)
+			} else {
+				principal, err = a.AuthenticateKey(r.Context(), r.Header.Get(APIKeyHeader))
+			}
+
+			if errors.Is(err, auth.ErrInvalidCredentials) {
+				if a.TokensEnabled() {
+					w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+				}
+				render.Render(w, r, handler.ErrUnauthorized(err))
+				return
+			}
+			if err != nil {
+				render.Render(w, r, handler.ErrInternalServer(err))
+				return
+			}
+
+			ctx := auth.WithPrincipal(r.Context(), principal)
+			ctx = logger.WithContext(ctx, logger.FromContext(ctx).With(slog.String("principal", principal.Subject)))
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// bearerToken returns the token of the Authorization header with the Bearer scheme.
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+// requireScope enforces scopes on authenticated requests, with a nil authenticator every request is allowed.
+func requireScope(a *auth.Authenticator) scopeMiddleware {
+	if a == nil {
+		return func(models.Scope) func(http.Handler) http.Handler {
+			return func(next http.Handler) http.Handler { return next }
+		}
+	}
+
+	return func(scope models.Scope) func(http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				principal := auth.FromContext(r.Context())
+				if principal == nil {
+					render.Render(w, r, handler.ErrUnauthorized(auth.ErrInvalidCredentials))
+					return
+				}
+				if !principal.Has(scope) {
+					render.Render(w, r, handler.ErrForbidden(string(scope)))
+					return
+				}
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+}
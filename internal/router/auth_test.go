@@ -0,0 +1,159 @@
+package router
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/auth"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/handler"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/health"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/storage/memory"
+)
+
+// newTokenRouter returns the router over memory storages accepting tokens signed by the returned key.
+func newTokenRouter(t *testing.T) (http.Handler, *rsa.PrivateKey) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey: %v", err)
+	}
+	encode := base64.RawURLEncoding.EncodeToString
+	jwks, err := json.Marshal(map[string]any{"keys": []map[string]string{{
+		"kid": "k1", "kty": "RSA", "use": "sig", "alg": "RS256",
+		"n": encode(key.N.Bytes()), "e": encode(big.NewInt(int64(key.E)).Bytes()),
+	}}})
+	if err != nil {
+		t.Fatalf("json.Marshal: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, jwks, 0o600); err != nil {
+		t.Fatalf("os.WriteFile: %v", err)
+	}
+	keys, err := auth.NewKeySet(context.Background(), path, time.Minute)
+	if err != nil {
+		t.Fatalf("NewKeySet: %v", err)
+	}
+
+	db := memory.NewDB()
+	us, err := memory.NewUserStorage(db)
+	if err != nil {
+		t.Fatalf("NewUserStorage: %v", err)
+	}
+	ss, err := memory.NewSegmentStorage(db, us)
+	if err != nil {
+		t.Fatalf("NewSegmentStorage: %v", err)
+	}
+	ks, err := memory.NewAPIKeyStorage(db)
+	if err != nil {
+		t.Fatalf("NewAPIKeyStorage: %v", err)
+	}
+	as, err := memory.NewAuditStorage(db)
+	if err != nil {
+		t.Fatalf("NewAuditStorage: %v", err)
+	}
+
+	authenticator := auth.New(ks, "", auth.NewTokenVerifier(keys, auth.TokenConfig{}))
+	pass := func(next http.Handler) http.Handler { return next }
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	r := GetRouter(log, health.New(), authenticator, pass, pass,
+		handler.NewUserHandler(us), handler.NewSegmentHandler(ss), handler.NewAPIKeyHandler(ks), handler.NewAuditHandler(as))
+	return r, key
+}
+
+// roleToken returns a token of the role signed by the key with ID k1.
+func roleToken(t *testing.T, key *rsa.PrivateKey, role string) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"sub":   role,
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"roles": []string{role},
+	})
+	token.Header["kid"] = "k1"
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("SignedString: %v", err)
+	}
+	return signed
+}
+
+func TestRoleRoutes(t *testing.T) {
+	r, key := newTokenRouter(t)
+
+	routes := []struct {
+		method, path, body string
+	}{
+		{http.MethodGet, "/api/v1/segments", ""},
+		{http.MethodPost, "/api/v1/segments", `{"name":"SEGMENT_%s"}`},
+		{http.MethodGet, "/api/v1/users", ""},
+		{http.MethodPost, "/api/v1/users", `{"firstname":"A","lastname":"B","username":"%s"}`},
+		{http.MethodPost, "/api/v1/evaluate", `{"user_ids":[1]}`},
+		{http.MethodGet, "/api/v1/api-keys", ""},
+		{http.MethodGet, "/api/v1/audit", ""},
+	}
+
+	// allowed[role] are the routes of the role by index in routes
+	allowed := map[string][]bool{
+		"viewer": {true, false, true, false, true, false, false},
+		"editor": {true, true, true, true, true, false, false},
+		"admin":  {true, true, true, true, true, true, true},
+		"owner":  {false, false, false, false, false, false, false},
+	}
+
+	for role, want := range allowed {
+		for i, route := range routes {
+			t.Run(fmt.Sprintf("%s %s %s", role, route.method, route.path), func(t *testing.T) {
+				body := route.body
+				if strings.Contains(body, "%s") {
+					body = fmt.Sprintf(body, strings.ToUpper(role))
+				}
+				req := httptest.NewRequest(route.method, route.path, strings.NewReader(body))
+				req.Header.Set("Authorization", "Bearer "+roleToken(t, key, role))
+				rec := httptest.NewRecorder()
+				r.ServeHTTP(rec, req)
+
+				if forbidden := rec.Code == http.StatusForbidden; forbidden == want[i] {
+					t.Fatalf("status = %d, allowed %t: %s", rec.Code, want[i], rec.Body)
+				}
+				if rec.Code == http.StatusUnauthorized || rec.Code >= http.StatusInternalServerError {
+					t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+				}
+			})
+		}
+	}
+}
+
+func TestRoutesRejectInvalidTokens(t *testing.T) {
+	r, _ := newTokenRouter(t)
+
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/segments", nil)
+	req.Header.Set("Authorization", "Bearer "+roleToken(t, other, "admin"))
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want 401: %s", rec.Code, rec.Body)
+	}
+	if got := rec.Header().Get("WWW-Authenticate"); got != `Bearer realm="api"` {
+		t.Fatalf("WWW-Authenticate = %q, want the bearer challenge", got)
+	}
+}
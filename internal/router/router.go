@@ -3,54 +3,125 @@ package router
 import (
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/auth"
 	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/handler"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/health"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/logger"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/metrics"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/models"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/tracing"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"log/slog"
 	"net/http"
 	"time"
 )
 
 const TIMEOUT = 60 * time.Second
 
-func GetRouter(userController *handler.UserHandler, segmentController *handler.SegmentHandler) *chi.Mux {
+// GetRouter builds the router of the service. With a nil authenticator the API is served without authentication.
+// The idempotent middleware is applied to the creating requests retried by clients,
+// the preconditions middleware to the updates, patches and deletes of users and segments.
+func GetRouter(log *slog.Logger, h *health.Health, authenticator *auth.Authenticator, idempotent, preconditions func(http.Handler) http.Handler, userController *handler.UserHandler, segmentController *handler.SegmentHandler, apiKeyController *handler.APIKeyHandler, auditController *handler.AuditHandler) *chi.Mux {
 	r := chi.NewRouter()
 
 	r.Use(middleware.RequestID)
-	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
-	r.Use(middleware.URLFormat)
-	r.Use(middleware.Timeout(TIMEOUT))
 
-	buildTree(r, userController, segmentController)
+	// probes are neither traced, logged nor counted, as they are polled every few seconds
+	r.Get("/healthz", h.Liveness)
+	r.Get("/readyz", h.Readiness)
+
+	r.Group(func(r chi.Router) {
+		r.Use(tracing.Middleware)
+		r.Use(logger.Middleware(log))
+		r.Use(metrics.Middleware)
+		r.Use(middleware.URLFormat)
+		r.Use(middleware.Timeout(TIMEOUT))
+
+		r.Handle("/metrics", promhttp.Handler())
+		buildTree(r, authenticator, idempotent, preconditions, userController, segmentController, apiKeyController, auditController)
+	})
 
 	return r
 }
 
-func buildTree(r *chi.Mux, userController *handler.UserHandler, segmentController *handler.SegmentHandler) {
+func buildTree(r chi.Router, authenticator *auth.Authenticator, idempotent, preconditions func(http.Handler) http.Handler, userController *handler.UserHandler, segmentController *handler.SegmentHandler, apiKeyController *handler.APIKeyHandler, auditController *handler.AuditHandler) {
+	require := requireScope(authenticator)
+
 	r.Route("/api/v1", func(r chi.Router) {
-		r.Mount("/users", userRouter(userController))
-		r.Mount("/segments", segmentRouter(segmentController))
+		if authenticator != nil {
+			r.Use(authenticate(authenticator))
+		}
+		r.Use(auditInfo)
+
+		r.Mount("/users", userRouter(userController, require, idempotent, preconditions))
+		r.Mount("/segments", segmentRouter(segmentController, require, idempotent, preconditions))
+		r.With(require(models.ScopeEvaluate)).Mount("/evaluate", evaluateRouter(userController))
+		r.With(require(models.ScopeAdmin)).Mount("/api-keys", apiKeyRouter(apiKeyController))
+		r.With(require(models.ScopeAdmin)).Get("/audit", auditController.ListAudit)
 	})
 }
 
-func userRouter(userController *handler.UserHandler) http.Handler {
+func userRouter(userController *handler.UserHandler, require scopeMiddleware, idempotent, preconditions func(http.Handler) http.Handler) http.Handler {
 	r := chi.NewRouter()
-	r.Get("/", userController.ListUsers)
-	r.Post("/", userController.CreateUser)
-	r.Get("/{id}", userController.ReadUser)
-	r.Put("/{id}", userController.UpdateUser)
-	r.Delete("/{id}", userController.DeleteUser)
-	r.Put("/{id}/segments", userController.UpdateUserSegments)
+
+	r.Group(func(r chi.Router) {
+		r.Use(require(models.ScopeUsersRead))
+		r.Get("/", userController.ListUsers)
+		r.Get("/{id}", userController.ReadUser)
+		r.Get("/{id}/segments", userController.ReadUserSegments)
+		r.Get("/{id}/segments/history", userController.ReadUserSegmentsHistory)
+	})
+
+	r.Group(func(r chi.Router) {
+		r.Use(require(models.ScopeUsersWrite))
+		r.With(idempotent).Post("/", userController.CreateUser)
+		r.With(preconditions).Put("/{id}", userController.UpdateUser)
+		r.With(preconditions).Patch("/{id}", userController.PatchUser)
+		r.With(preconditions).Delete("/{id}", userController.DeleteUser)
+		r.With(idempotent).Put("/{id}/segments", userController.UpdateUserSegments)
+	})
+
+	return r
+}
+
+func evaluateRouter(userController *handler.UserHandler) http.Handler {
+	r := chi.NewRouter()
+	r.Post("/", userController.EvaluateBatch)
+	r.Get("/{user_id}", userController.Evaluate)
+	return r
+}
+
+func segmentRouter(segmentController *handler.SegmentHandler, require scopeMiddleware, idempotent, preconditions func(http.Handler) http.Handler) http.Handler {
+	r := chi.NewRouter()
+
+	r.Group(func(r chi.Router) {
+		r.Use(require(models.ScopeSegmentsRead))
+		r.Get("/", segmentController.ListSegments)
+		// previewing writes nothing, it only reads the users matching the rule
+		r.Post("/preview", segmentController.PreviewRule)
+		r.Get("/{slug}", segmentController.ReadSegment)
+		r.Get("/{slug}/users", segmentController.ListUsersInSegment)
+	})
+
+	r.Group(func(r chi.Router) {
+		r.Use(require(models.ScopeSegmentsWrite))
+		r.With(idempotent).Post("/", segmentController.CreateSegment)
+		r.With(preconditions).Put("/{slug}", segmentController.UpdateSegment)
+		r.With(preconditions).Patch("/{slug}", segmentController.PatchSegment)
+		r.With(preconditions).Delete("/{slug}", segmentController.DeleteSegment)
+		r.With(preconditions).Post("/{slug}/rename", segmentController.RenameSegment)
+		r.Put("/{slug}/users/{id}", segmentController.AddUserToSegment)
+		r.Delete("/{slug}/users/{id}", segmentController.DeleteUserFromSegment)
+	})
+
 	return r
 }
 
-func segmentRouter(segmentController *handler.SegmentHandler) http.Handler {
+func apiKeyRouter(apiKeyController *handler.APIKeyHandler) http.Handler {
 	r := chi.NewRouter()
-	r.Get("/", segmentController.ListSegments)
-	r.Post("/", segmentController.CreateSegment)
-	r.Get("/{slug}", segmentController.ReadSegment)
-	r.Put("/{slug}", segmentController.UpdateSegment)
-	r.Delete("/{slug}", segmentController.DeleteSegment)
-	r.Get("/{slug}/users", segmentController.ListUsersInSegment)
-	r.Put("/{slug}/users/{id}", segmentController.AddUserToSegment)
-	r.Delete("/{slug}/users/{id}", segmentController.DeleteUserFromSegment)
+	r.Get("/", apiKeyController.ListAPIKeys)
+	r.Post("/", apiKeyController.CreateAPIKey)
+	r.Delete("/{id}", apiKeyController.DeleteAPIKey)
 	return r
 }
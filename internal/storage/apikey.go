@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/models"
+)
+
+// MaxAPIKeyName limits the length of an API key name.
+const MaxAPIKeyName = 128
+
+type APIKeyStorage interface {
+	CreateAPIKey(ctx context.Context, key *models.APIKey) error
+	// GetAPIKeyByHash returns the key with the hash, it is used to authenticate every request
+	GetAPIKeyByHash(ctx context.Context, hash string) (*models.APIKey, error)
+	GetAPIKeys(ctx context.Context) ([]*models.APIKey, error)
+	DeleteAPIKey(ctx context.Context, id int64) error
+}
+
+// ValidateAPIKey checks the name and the scopes of the key and removes repeated scopes.
+func ValidateAPIKey(key *models.APIKey) error {
+	if key.Name == "" || len(key.Name) > MaxAPIKeyName {
+		return Invalid("name", "max", fmt.Sprintf("must be 1 to %d characters long", MaxAPIKeyName))
+	}
+	if len(key.Hash) != 64 {
+		return Invalid("hash", "sha256", "must be a hex SHA-256")
+	}
+	if len(key.Scopes) == 0 {
+		return Invalid("scopes", "required", "must not be empty")
+	}
+
+	scopes := make([]models.Scope, 0, len(key.Scopes))
+	for i, scope := range key.Scopes {
+		if !slices.Contains(models.Scopes, scope) {
+			return Invalid(fmt.Sprintf("scopes[%d]", i), "oneof", fmt.Sprintf("must be one of %v", models.Scopes))
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	key.Scopes = scopes
+
+	return nil
+}
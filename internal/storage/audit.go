@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/models"
+)
+
+// SystemActor is the actor of changes made without an authenticated principal.
+const SystemActor = "system"
+
+// AuditInfo identifies who makes the changes recorded in the audit log.
+type AuditInfo struct {
+	Actor     string
+	ActorName string
+	RequestID string
+}
+
+type auditInfoKey struct{}
+
+// WithAuditInfo returns the context making the storages record its changes on behalf of the actor.
+func WithAuditInfo(ctx context.Context, info AuditInfo) context.Context {
+	return context.WithValue(ctx, auditInfoKey{}, info)
+}
+
+// AuditInfoFromContext returns the audit info of the context, changes are made by SystemActor without one.
+func AuditInfoFromContext(ctx context.Context) AuditInfo {
+	info, _ := ctx.Value(auditInfoKey{}).(AuditInfo)
+	if info.Actor == "" {
+		info.Actor = SystemActor
+	}
+	return info
+}
+
+// AuditFilter narrows down the audit log, zero fields are ignored.
+type AuditFilter struct {
+	Actor        string
+	Action       models.AuditAction
+	ResourceType string
+	ResourceID   string
+	From         *time.Time // only entries created at or after the time
+	To           *time.Time // only entries created before the time
+}
+
+type AuditStorage interface {
+	// GetAuditEntries returns a page of audit entries sorted by ID, which is the order of the changes
+	GetAuditEntries(ctx context.Context, filter AuditFilter, params ListParams) (*Page[models.AuditEntry], error)
+}
+
+// NewAuditEntry returns the entry of the change by the actor of the context, a nil before or after is stored as null.
+func NewAuditEntry(ctx context.Context, action models.AuditAction, resourceType, resourceID string, before, after any) (*models.AuditEntry, error) {
+	info := AuditInfoFromContext(ctx)
+	entry := &models.AuditEntry{
+		Actor:        info.Actor,
+		ActorName:    info.ActorName,
+		Action:       action,
+		ResourceType: resourceType,
+		ResourceID:   resourceID,
+		RequestID:    info.RequestID,
+	}
+
+	var err error
+	if entry.Before, err = auditJSON(before); err != nil {
+		return nil, err
+	}
+	if entry.After, err = auditJSON(after); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+func auditJSON(value any) (json.RawMessage, error) {
+	if value == nil {
+		return json.RawMessage("null"), nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode audit entry: %w", err)
+	}
+	return data, nil
+}
+
+// AuditUser returns the user as recorded in audit entries, without segments.
+func AuditUser(user *models.User) *models.User {
+	if user == nil {
+		return nil
+	}
+	copied := *user
+	copied.Segments = nil
+	return &copied
+}
+
+// AuditSegment returns the segment as recorded in audit entries, without users and membership fields.
+func AuditSegment(segment *models.Segment) *models.Segment {
+	if segment == nil {
+		return nil
+	}
+	copied := *segment
+	copied.Users = nil
+	copied.ExpiresAt = nil
+	copied.Variant = ""
+	copied.VariantCounts = nil
+	return &copied
+}
+
+// MembershipResourceID returns the audit resource ID of the user's membership in the segment.
+func MembershipResourceID(segment string, userID int64) string {
+	return fmt.Sprintf("%s:%d", segment, userID)
+}
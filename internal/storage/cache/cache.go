@@ -0,0 +1,22 @@
+// Package cache implements a read-through cache of user segment lookups decorating the user and segment storages.
+//
+// Cached entries are keyed by generation tokens: every user has one and there is a global one.
+// A mutation of a user's segments replaces the user's token, a mutation of a segment replaces the global token,
+// so entries read before the mutation are never found again and expire by TTL.
+// A lookup takes the tokens before reading the storage, so a concurrent mutation cannot leave a stale entry behind.
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// Cache is a key-value store with the semantics of the Redis GET and SET EX commands,
+// so it can be backed by Redis as well as by the in-process LRU.
+type Cache interface {
+	// Get returns the value of the key, ok is false when the key is missing or expired
+	Get(ctx context.Context, key string) (value []byte, ok bool, err error)
+	// Set stores the value of the key, the key never expires with zero TTL.
+	// Keys without TTL must not be evicted, as with the volatile-lru or volatile-ttl policy of Redis.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+}
@@ -0,0 +1,385 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/models"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/storage"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/storage/memory"
+)
+
+// fakeRedis is a Cache with the semantics of Redis GET and SET EX on a clock moved by the test.
+type fakeRedis struct {
+	mu     sync.Mutex
+	now    time.Time
+	values map[string]fakeValue
+	err    error // returned by every command when set
+}
+
+type fakeValue struct {
+	data      []byte
+	expiresAt time.Time // zero when the key never expires
+}
+
+func newFakeRedis() *fakeRedis {
+	return &fakeRedis{now: time.Now(), values: make(map[string]fakeValue)}
+}
+
+func (r *fakeRedis) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil {
+		return nil, false, r.err
+	}
+	value, ok := r.values[key]
+	if !ok || !value.expiresAt.IsZero() && !r.now.Before(value.expiresAt) {
+		return nil, false, nil
+	}
+	return value.data, true, nil
+}
+
+func (r *fakeRedis) Set(ctx context.Context, key string, data []byte, ttl time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil {
+		return r.err
+	}
+	value := fakeValue{data: data}
+	if ttl > 0 {
+		value.expiresAt = r.now.Add(ttl)
+	}
+	r.values[key] = value
+	return nil
+}
+
+func (r *fakeRedis) advance(d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.now = r.now.Add(d)
+}
+
+func (r *fakeRedis) fail(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.err = err
+}
+
+// countingUsers counts the lookups reaching the decorated storage.
+type countingUsers struct {
+	storage.UserStorage
+	mu    sync.Mutex
+	reads int
+}
+
+func (s *countingUsers) GetUserSegments(ctx context.Context, id int64) ([]models.Segment, error) {
+	s.mu.Lock()
+	s.reads++
+	s.mu.Unlock()
+	return s.UserStorage.GetUserSegments(ctx, id)
+}
+
+func (s *countingUsers) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.reads
+}
+
+type fixture struct {
+	redis  *fakeRedis
+	layer  *Layer
+	origin *countingUsers
+	users  storage.UserStorage
+	segs   storage.SegmentStorage
+	user   *models.User
+}
+
+// newFixture returns cached memory storages with user 1 in segment A and segments B and C.
+func newFixture(t *testing.T) *fixture {
+	t.Helper()
+	ctx := context.Background()
+
+	db := memory.NewDB()
+	us, err := memory.NewUserStorage(db)
+	if err != nil {
+		t.Fatalf("NewUserStorage: %v", err)
+	}
+	ss, err := memory.NewSegmentStorage(db, us)
+	if err != nil {
+		t.Fatalf("NewSegmentStorage: %v", err)
+	}
+
+	f := &fixture{redis: newFakeRedis(), origin: &countingUsers{UserStorage: us}}
+	f.layer = NewLayer(f.redis, time.Minute)
+	if f.users, err = NewUserStorage(f.origin, f.layer); err != nil {
+		t.Fatalf("NewUserStorage: %v", err)
+	}
+	if f.segs, err = NewSegmentStorage(ss, f.layer); err != nil {
+		t.Fatalf("NewSegmentStorage: %v", err)
+	}
+
+	for _, name := range []string{"A", "B", "C"} {
+		if err := f.segs.CreateSegment(ctx, &models.Segment{Name: name}); err != nil {
+			t.Fatalf("CreateSegment(%s): %v", name, err)
+		}
+	}
+	f.user = &models.User{FirstName: "Ivan", LastName: "Ivanov", Username: "ivan"}
+	if err := f.users.CreateUser(ctx, f.user); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	if err := f.users.UpdateUserSegments(ctx, f.user.ID, []models.SegmentAssignment{{Name: "A"}}, nil); err != nil {
+		t.Fatalf("UpdateUserSegments: %v", err)
+	}
+	return f
+}
+
+// segments looks up the segment names of the user and reports whether the lookup reached the storage.
+func (f *fixture) segments(t *testing.T) ([]string, bool) {
+	t.Helper()
+	reads := f.origin.count()
+	segments, err := f.users.GetUserSegments(context.Background(), f.user.ID)
+	if err != nil {
+		t.Fatalf("GetUserSegments: %v", err)
+	}
+	names := make([]string, 0, len(segments))
+	for _, segment := range segments {
+		names = append(names, segment.Name)
+	}
+	slices.Sort(names)
+	return names, f.origin.count() > reads
+}
+
+func TestLookupHitAndMiss(t *testing.T) {
+	f := newFixture(t)
+
+	if names, read := f.segments(t); !read || !slices.Equal(names, []string{"A"}) {
+		t.Fatalf("first lookup = %v, read %t, want [A] from the storage", names, read)
+	}
+	if names, read := f.segments(t); read || !slices.Equal(names, []string{"A"}) {
+		t.Fatalf("second lookup = %v, read %t, want [A] from the cache", names, read)
+	}
+	if got, want := f.layer.Stats(), (Stats{Hits: 1, Misses: 1}); got != want {
+		t.Fatalf("stats = %+v, want %+v", got, want)
+	}
+}
+
+func TestLookupExpires(t *testing.T) {
+	f := newFixture(t)
+	f.segments(t)
+
+	f.redis.advance(59 * time.Second)
+	if _, read := f.segments(t); read {
+		t.Fatal("lookup within the TTL reached the storage")
+	}
+	f.redis.advance(time.Second)
+	if _, read := f.segments(t); !read {
+		t.Fatal("lookup after the TTL was served from the cache")
+	}
+}
+
+func TestLookupExpiresWithMembership(t *testing.T) {
+	f := newFixture(t)
+	expiresAt := time.Now().Add(10 * time.Second)
+	if err := f.segs.AddUserToSegment(context.Background(), "B", f.user.ID, &expiresAt, ""); err != nil {
+		t.Fatalf("AddUserToSegment: %v", err)
+	}
+	f.segments(t)
+
+	// the entry lives until the membership expires, not for the whole TTL
+	f.redis.advance(11 * time.Second)
+	if _, read := f.segments(t); !read {
+		t.Fatal("lookup after the membership expired was served from the cache")
+	}
+}
+
+func TestMutationsInvalidate(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name   string
+		mutate func(f *fixture) error
+		want   []string
+	}{
+		{"UpdateUserSegments", func(f *fixture) error {
+			return f.users.UpdateUserSegments(ctx, f.user.ID, []models.SegmentAssignment{{Name: "B"}}, []string{"A"})
+		}, []string{"B"}},
+		{"AddUserToSegment", func(f *fixture) error {
+			return f.segs.AddUserToSegment(ctx, "C", f.user.ID, nil, "")
+		}, []string{"A", "C"}},
+		{"DeleteUserFromSegment", func(f *fixture) error {
+			return f.segs.DeleteUserFromSegment(ctx, "A", f.user.ID)
+		}, []string{}},
+		{"DeleteSegmentBySlug", func(f *fixture) error {
+			return f.segs.DeleteSegmentBySlug(ctx, "A", "")
+		}, []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFixture(t)
+			f.segments(t)
+
+			if err := tt.mutate(f); err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			if names, read := f.segments(t); !read || !slices.Equal(names, tt.want) {
+				t.Fatalf("lookup = %v, read %t, want %v from the storage", names, read, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeleteUserInvalidates(t *testing.T) {
+	f := newFixture(t)
+	f.segments(t)
+
+	if err := f.users.DeleteUser(context.Background(), f.user.ID, ""); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+	if _, err := f.users.GetUserSegments(context.Background(), f.user.ID); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("GetUserSegments of a deleted user = %v, want ErrNotFound", err)
+	}
+}
+
+func TestMutationOfOtherUserKeepsEntry(t *testing.T) {
+	f := newFixture(t)
+	f.segments(t)
+
+	other := &models.User{FirstName: "Petr", LastName: "Petrov", Username: "petr"}
+	if err := f.users.CreateUser(context.Background(), other); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	if err := f.segs.AddUserToSegment(context.Background(), "B", other.ID, nil, ""); err != nil {
+		t.Fatalf("AddUserToSegment: %v", err)
+	}
+	if _, read := f.segments(t); read {
+		t.Fatal("lookup after a change of another user reached the storage")
+	}
+}
+
+func TestCacheErrorsFallBackToStorage(t *testing.T) {
+	f := newFixture(t)
+	f.redis.fail(errors.New("connection refused"))
+
+	for i := 0; i < 2; i++ {
+		if names, read := f.segments(t); !read || !slices.Equal(names, []string{"A"}) {
+			t.Fatalf("lookup = %v, read %t, want [A] from the storage", names, read)
+		}
+	}
+	if got, want := f.layer.Stats(), (Stats{Errors: 2}); got != want {
+		t.Fatalf("stats = %+v, want %+v", got, want)
+	}
+}
+
+func TestEvaluateUsersStats(t *testing.T) {
+	f := newFixture(t)
+	ctx := context.Background()
+
+	if _, err := f.users.EvaluateUsers(ctx, []int64{f.user.ID}); err != nil {
+		t.Fatalf("EvaluateUsers: %v", err)
+	}
+	evaluations, err := f.users.EvaluateUsers(ctx, []int64{f.user.ID, f.user.ID})
+	if err != nil {
+		t.Fatalf("EvaluateUsers: %v", err)
+	}
+	if len(evaluations) != 2 || !slices.Equal(evaluations[1].Segments, []string{"A"}) {
+		t.Fatalf("evaluations = %+v, want two evaluations of [A]", evaluations)
+	}
+	if got, want := f.layer.Stats(), (Stats{Hits: 2, Misses: 1}); got != want {
+		t.Fatalf("stats = %+v, want %+v", got, want)
+	}
+}
+
+func TestLRUEvictsLeastRecentlyUsed(t *testing.T) {
+	ctx := context.Background()
+	c := NewLRU(2)
+
+	c.Set(ctx, "a", []byte("1"), time.Minute)
+	c.Set(ctx, "b", []byte("2"), time.Minute)
+	c.Get(ctx, "a")
+	c.Set(ctx, "c", []byte("3"), time.Minute)
+
+	if _, ok, _ := c.Get(ctx, "b"); ok {
+		t.Fatal("least recently used key b was not evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok, _ := c.Get(ctx, key); !ok {
+			t.Fatalf("key %s was evicted", key)
+		}
+	}
+}
+
+func TestLRUExpires(t *testing.T) {
+	ctx := context.Background()
+	c := NewLRU(10)
+
+	c.Set(ctx, "short", []byte("1"), 10*time.Millisecond)
+	c.Set(ctx, "long", []byte("2"), time.Minute)
+	time.Sleep(20 * time.Millisecond)
+
+	if _, ok, _ := c.Get(ctx, "short"); ok {
+		t.Fatal("expired key was found")
+	}
+	if value, ok, _ := c.Get(ctx, "long"); !ok || string(value) != "2" {
+		t.Fatalf("Get(long) = %s, %t, want 2", value, ok)
+	}
+}
+
+func TestLRUKeepsKeysWithoutTTL(t *testing.T) {
+	ctx := context.Background()
+	c := NewLRU(2)
+
+	c.Set(ctx, "token", []byte("t1"), 0)
+	for _, key := range []string{"a", "b", "c", "d"} {
+		c.Set(ctx, key, []byte(key), time.Minute)
+	}
+
+	if value, ok, _ := c.Get(ctx, "token"); !ok || string(value) != "t1" {
+		t.Fatalf("Get(token) = %s, %t, want t1", value, ok)
+	}
+	if got := c.Len(); got != 3 {
+		t.Fatalf("Len = %d, want 2 entries and the token", got)
+	}
+
+	// a key moves between the maps when its TTL changes
+	c.Set(ctx, "token", []byte("t2"), time.Minute)
+	c.Set(ctx, "e", []byte("e"), time.Minute)
+	c.Set(ctx, "f", []byte("f"), time.Minute)
+	if _, ok, _ := c.Get(ctx, "token"); ok {
+		t.Fatal("token with TTL was not evicted")
+	}
+	c.Set(ctx, "e", []byte("e"), 0)
+	if got := c.Len(); got != 2 {
+		t.Fatalf("Len = %d, want the entry f and the key e", got)
+	}
+}
+
+func TestLayerTokensSurviveEviction(t *testing.T) {
+	ctx := context.Background()
+	layer := NewLayer(NewLRU(1), time.Minute)
+
+	before, err := layer.key(ctx, "segments", 1)
+	if err != nil {
+		t.Fatalf("key: %v", err)
+	}
+	for id := int64(2); id < 10; id++ {
+		key, err := layer.key(ctx, "segments", id)
+		if err != nil {
+			t.Fatalf("key: %v", err)
+		}
+		layer.set(ctx, key, []string{"A"}, nil)
+	}
+
+	after, err := layer.key(ctx, "segments", 1)
+	if err != nil {
+		t.Fatalf("key: %v", err)
+	}
+	if before != after {
+		t.Fatalf("key of user 1 changed from %s to %s after evictions", before, after)
+	}
+}
@@ -0,0 +1,176 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+func init() {
+	// user attributes and rule values are decoded from JSON into these types
+	gob.Register(map[string]any{})
+	gob.Register([]any{})
+}
+
+// globalTokenKey is the key of the generation token replaced by every segment mutation.
+const globalTokenKey = "token"
+
+func userTokenKey(id int64) string {
+	return "token:user:" + strconv.FormatInt(id, 10)
+}
+
+// Stats are the lookups served from the cache and from the storage.
+// Errors count failed cache operations, a lookup falls back to the storage on them.
+type Stats struct {
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+	Errors int64 `json:"errors"`
+}
+
+// Layer is shared by the user and segment storage decorators, so a mutation through either
+// invalidates the lookups of both.
+type Layer struct {
+	cache Cache
+	ttl   time.Duration
+
+	hits   atomic.Int64
+	misses atomic.Int64
+	errors atomic.Int64
+}
+
+// NewLayer creates a layer caching lookups for at most ttl.
+func NewLayer(c Cache, ttl time.Duration) *Layer {
+	return &Layer{cache: c, ttl: ttl}
+}
+
+func (l *Layer) Stats() Stats {
+	return Stats{
+		Hits:   l.hits.Load(),
+		Misses: l.misses.Load(),
+		Errors: l.errors.Load(),
+	}
+}
+
+func newToken() string {
+	token := make([]byte, 8)
+	if _, err := rand.Read(token); err != nil {
+		panic(fmt.Sprintf("failed to generate cache token: %v", err))
+	}
+	return hex.EncodeToString(token)
+}
+
+// token returns the generation token stored at the key, storing a new one when it is missing or evicted.
+func (l *Layer) token(ctx context.Context, key string) (string, error) {
+	value, ok, err := l.cache.Get(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	if ok {
+		return string(value), nil
+	}
+
+	token := newToken()
+	if err := l.cache.Set(ctx, key, []byte(token), 0); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// key returns the key of the user's entry of the kind, which is not found again once the user or any segment changes.
+func (l *Layer) key(ctx context.Context, kind string, userID int64) (string, error) {
+	global, err := l.token(ctx, globalTokenKey)
+	if err != nil {
+		return "", err
+	}
+	user, err := l.token(ctx, userTokenKey(userID))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s:%d:%s:%s", kind, userID, global, user), nil
+}
+
+// get decodes the cached entry into value and reports whether it was found.
+func (l *Layer) get(ctx context.Context, key string, value any) bool {
+	data, ok, err := l.cache.Get(ctx, key)
+	if err != nil {
+		l.errors.Add(1)
+		return false
+	}
+	if !ok {
+		return false
+	}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(value); err != nil {
+		l.errors.Add(1)
+		return false
+	}
+	return true
+}
+
+// set caches the entry until the TTL passes or the earliest membership in it expires.
+func (l *Layer) set(ctx context.Context, key string, value any, expiresAt *time.Time) {
+	ttl := l.ttl
+	if expiresAt != nil {
+		if until := time.Until(*expiresAt); until < ttl {
+			ttl = until
+		}
+	}
+	if ttl <= 0 {
+		return
+	}
+
+	var data bytes.Buffer
+	if err := gob.NewEncoder(&data).Encode(value); err != nil {
+		l.errors.Add(1)
+		return
+	}
+	if err := l.cache.Set(ctx, key, data.Bytes(), ttl); err != nil {
+		l.errors.Add(1)
+	}
+}
+
+// lookup returns the user's entry of the kind from the cache or reads it from the storage and caches it.
+func lookup[T any](ctx context.Context, l *Layer, kind string, userID int64, read func() (T, *time.Time, error)) (T, error) {
+	key, err := l.key(ctx, kind, userID)
+	if err != nil {
+		l.errors.Add(1)
+		value, _, err := read()
+		return value, err
+	}
+
+	var value T
+	if l.get(ctx, key, &value) {
+		l.hits.Add(1)
+		return value, nil
+	}
+	l.misses.Add(1)
+
+	value, expiresAt, err := read()
+	if err != nil {
+		return value, err
+	}
+	l.set(ctx, key, value, expiresAt)
+	return value, nil
+}
+
+// invalidateUser makes the cached lookups of the user stale. The mutation has already happened,
+// so the cache is updated even if the request is cancelled.
+func (l *Layer) invalidateUser(ctx context.Context, id int64) {
+	l.invalidate(context.WithoutCancel(ctx), userTokenKey(id))
+}
+
+// invalidateAll makes all cached lookups stale, as a segment mutation may change the segments of any user.
+func (l *Layer) invalidateAll(ctx context.Context) {
+	l.invalidate(context.WithoutCancel(ctx), globalTokenKey)
+}
+
+func (l *Layer) invalidate(ctx context.Context, key string) {
+	if err := l.cache.Set(ctx, key, []byte(newToken()), 0); err != nil {
+		l.errors.Add(1)
+	}
+}
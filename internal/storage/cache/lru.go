@@ -0,0 +1,100 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// LRU is an in-process Cache evicting the least recently used keys with TTL once it holds size of them.
+// Like Redis with the volatile-lru policy it never evicts keys without TTL, they are the generation tokens,
+// and evicting a token would make every entry read with it stale.
+type LRU struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List // front is the most recently used entry
+	entries map[string]*list.Element
+	// persistent are the keys without TTL, they are not counted in size
+	persistent map[string][]byte
+}
+
+type lruEntry struct {
+	key       string
+	value     []byte
+	expiresAt time.Time
+}
+
+func NewLRU(size int) *LRU {
+	return &LRU{
+		size:       size,
+		order:      list.New(),
+		entries:    make(map[string]*list.Element),
+		persistent: make(map[string][]byte),
+	}
+}
+
+func (c *LRU) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if value, ok := c.persistent[key]; ok {
+		return value, true, nil
+	}
+
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+
+	entry := element.Value.(*lruEntry)
+	if !entry.expiresAt.After(time.Now()) {
+		c.remove(element)
+		return nil, false, nil
+	}
+
+	c.order.MoveToFront(element)
+	return entry.value, true, nil
+}
+
+func (c *LRU) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if ttl <= 0 {
+		if element, ok := c.entries[key]; ok {
+			c.remove(element)
+		}
+		c.persistent[key] = value
+		return nil
+	}
+	delete(c.persistent, key)
+
+	expiresAt := time.Now().Add(ttl)
+	if element, ok := c.entries[key]; ok {
+		entry := element.Value.(*lruEntry)
+		entry.value = value
+		entry.expiresAt = expiresAt
+		c.order.MoveToFront(element)
+		return nil
+	}
+
+	c.entries[key] = c.order.PushFront(&lruEntry{key: key, value: value, expiresAt: expiresAt})
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+	return nil
+}
+
+// Len returns the number of keys, including expired keys not yet evicted and keys without TTL.
+func (c *LRU) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.order.Len() + len(c.persistent)
+}
+
+func (c *LRU) remove(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.entries, element.Value.(*lruEntry).key)
+}
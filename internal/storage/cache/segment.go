@@ -0,0 +1,79 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/models"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/storage"
+)
+
+// segmentStorage invalidates user segment lookups on mutations, reads are passed through to the decorated storage.
+type segmentStorage struct {
+	storage.SegmentStorage
+	layer *Layer
+}
+
+func NewSegmentStorage(ss storage.SegmentStorage, layer *Layer) (storage.SegmentStorage, error) {
+	return &segmentStorage{SegmentStorage: ss, layer: layer}, nil
+}
+
+// CreateSegment invalidates all lookups, as the segment may get users by auto percent, experiment or rule.
+func (s *segmentStorage) CreateSegment(ctx context.Context, segment *models.Segment) error {
+	if err := s.SegmentStorage.CreateSegment(ctx, segment); err != nil {
+		return err
+	}
+	s.layer.invalidateAll(ctx)
+	return nil
+}
+
+func (s *segmentStorage) UpdateSegment(ctx context.Context, segment *models.Segment, ifMatch string) (*models.Segment, error) {
+	updated, err := s.SegmentStorage.UpdateSegment(ctx, segment, ifMatch)
+	if err != nil {
+		return nil, err
+	}
+	s.layer.invalidateAll(ctx)
+	return updated, nil
+}
+
+func (s *segmentStorage) PatchSegment(ctx context.Context, slug string, ifMatch string, patch func(segment *models.Segment) error) (*models.Segment, error) {
+	segment, err := s.SegmentStorage.PatchSegment(ctx, slug, ifMatch, patch)
+	if err != nil {
+		return nil, err
+	}
+	s.layer.invalidateAll(ctx)
+	return segment, nil
+}
+
+func (s *segmentStorage) RenameSegment(ctx context.Context, slug, name string, ifMatch string, aliasTTL time.Duration) (*models.Segment, error) {
+	segment, err := s.SegmentStorage.RenameSegment(ctx, slug, name, ifMatch, aliasTTL)
+	if err != nil {
+		return nil, err
+	}
+	s.layer.invalidateAll(ctx)
+	return segment, nil
+}
+
+func (s *segmentStorage) DeleteSegmentBySlug(ctx context.Context, slug string, ifMatch string) error {
+	if err := s.SegmentStorage.DeleteSegmentBySlug(ctx, slug, ifMatch); err != nil {
+		return err
+	}
+	s.layer.invalidateAll(ctx)
+	return nil
+}
+
+func (s *segmentStorage) AddUserToSegment(ctx context.Context, slug string, userID int64, expiresAt *time.Time, variant string) error {
+	if err := s.SegmentStorage.AddUserToSegment(ctx, slug, userID, expiresAt, variant); err != nil {
+		return err
+	}
+	s.layer.invalidateUser(ctx, userID)
+	return nil
+}
+
+func (s *segmentStorage) DeleteUserFromSegment(ctx context.Context, slug string, userID int64) error {
+	if err := s.SegmentStorage.DeleteUserFromSegment(ctx, slug, userID); err != nil {
+		return err
+	}
+	s.layer.invalidateUser(ctx, userID)
+	return nil
+}
@@ -0,0 +1,134 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/models"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/storage"
+)
+
+// userStorage caches user segment lookups, other methods are passed through to the decorated storage.
+type userStorage struct {
+	storage.UserStorage
+	layer *Layer
+}
+
+func NewUserStorage(us storage.UserStorage, layer *Layer) (storage.UserStorage, error) {
+	return &userStorage{UserStorage: us, layer: layer}, nil
+}
+
+// earliestExpiration returns the earliest expiration of the memberships in the segments.
+func earliestExpiration(segments []models.Segment) *time.Time {
+	var earliest *time.Time
+	for _, segment := range segments {
+		if segment.ExpiresAt != nil && (earliest == nil || segment.ExpiresAt.Before(*earliest)) {
+			earliest = segment.ExpiresAt
+		}
+	}
+	return earliest
+}
+
+func (s *userStorage) GetUserByID(ctx context.Context, id int64) (*models.User, error) {
+	return lookup(ctx, s.layer, "user", id, func() (*models.User, *time.Time, error) {
+		user, err := s.UserStorage.GetUserByID(ctx, id)
+		if err != nil {
+			return nil, nil, err
+		}
+		return user, earliestExpiration(user.Segments), nil
+	})
+}
+
+func (s *userStorage) GetUserSegments(ctx context.Context, id int64) ([]models.Segment, error) {
+	return lookup(ctx, s.layer, "segments", id, func() ([]models.Segment, *time.Time, error) {
+		segments, err := s.UserStorage.GetUserSegments(ctx, id)
+		if err != nil {
+			return nil, nil, err
+		}
+		return segments, earliestExpiration(segments), nil
+	})
+}
+
+// EvaluateUsers evaluates the users missing in the cache with a single call of the decorated storage.
+func (s *userStorage) EvaluateUsers(ctx context.Context, ids []int64) ([]models.Evaluation, error) {
+	if err := storage.CheckEvaluateUsers(ids); err != nil {
+		return nil, err
+	}
+
+	evaluations := make([]models.Evaluation, len(ids))
+	keys := make(map[int64]string, len(ids))
+	var missing []int64
+	for i, id := range ids {
+		key, err := s.layer.key(ctx, "evaluation", id)
+		if err != nil {
+			s.layer.errors.Add(1)
+		} else if s.layer.get(ctx, key, &evaluations[i]) {
+			s.layer.hits.Add(1)
+			continue
+		}
+		s.layer.misses.Add(1)
+
+		if _, ok := keys[id]; !ok {
+			keys[id] = key
+			missing = append(missing, id)
+		}
+	}
+
+	if len(missing) == 0 {
+		return evaluations, nil
+	}
+
+	evaluated, err := s.UserStorage.EvaluateUsers(ctx, missing)
+	if err != nil {
+		return nil, err
+	}
+
+	evaluatedByID := make(map[int64]models.Evaluation, len(evaluated))
+	for _, evaluation := range evaluated {
+		evaluatedByID[evaluation.UserID] = evaluation
+		if key := keys[evaluation.UserID]; key != "" {
+			s.layer.set(ctx, key, evaluation, evaluation.ExpiresAt)
+		}
+	}
+	for i, id := range ids {
+		if evaluation, ok := evaluatedByID[id]; ok {
+			evaluations[i] = evaluation
+		}
+	}
+
+	return evaluations, nil
+}
+
+func (s *userStorage) UpdateUser(ctx context.Context, user *models.User, ifMatch string) (*models.User, error) {
+	updated, err := s.UserStorage.UpdateUser(ctx, user, ifMatch)
+	if err != nil {
+		return nil, err
+	}
+	s.layer.invalidateUser(ctx, user.ID)
+	return updated, nil
+}
+
+func (s *userStorage) PatchUser(ctx context.Context, id int64, ifMatch string, patch func(user *models.User) error) (*models.User, error) {
+	user, err := s.UserStorage.PatchUser(ctx, id, ifMatch, patch)
+	if err != nil {
+		return nil, err
+	}
+	s.layer.invalidateUser(ctx, id)
+	return user, nil
+}
+
+func (s *userStorage) DeleteUser(ctx context.Context, id int64, ifMatch string) error {
+	if err := s.UserStorage.DeleteUser(ctx, id, ifMatch); err != nil {
+		return err
+	}
+	s.layer.invalidateUser(ctx, id)
+	return nil
+}
+
+func (s *userStorage) UpdateUserSegments(ctx context.Context, id int64, segmentsToAdd []models.SegmentAssignment, segmentsToRemove []string) error {
+	if err := s.UserStorage.UpdateUserSegments(ctx, id, segmentsToAdd, segmentsToRemove); err != nil {
+		return err
+	}
+	s.layer.invalidateUser(ctx, id)
+	return nil
+}
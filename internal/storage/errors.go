@@ -0,0 +1,36 @@
+package storage
+
+import (
+	"errors"
+
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/validate"
+)
+
+// Errors returned by storages wrap one of these, so callers can check them with errors.Is.
+var (
+	ErrNotFound      = errors.New("not found")
+	ErrAlreadyExists = errors.New("already exists")
+	ErrConflict      = errors.New("conflicts with the current state")
+	ErrValidation    = errors.New("is invalid")
+	// ErrVersionMismatch is returned when a resource was changed since the representation the caller expects
+	ErrVersionMismatch = errors.New("version does not match")
+)
+
+// Invalid returns ErrValidation of a field of a model or a query parameter, which handlers list like the violations
+// of request bodies. The field is the JSON path of the model in requests or the name of the parameter.
+func Invalid(field, rule, message string) error {
+	return &violationError{violations: validate.Errors{{Field: field, Rule: rule, Message: message}}}
+}
+
+// violationError is ErrValidation with the violations, it matches both with errors.Is and errors.As.
+type violationError struct {
+	violations validate.Errors
+}
+
+func (e *violationError) Error() string {
+	return e.violations.Error()
+}
+
+func (e *violationError) Unwrap() []error {
+	return []error{ErrValidation, e.violations}
+}
@@ -0,0 +1,32 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+// ETag returns the strong entity tag of a representation, the hash of its JSON encoding.
+// Memberships in the representation change without changing the version of the resource
+// (rules, experiments, expiring TTL), so the tag covers the whole representation.
+func ETag(v any) string {
+	hash := sha256.New()
+	if err := json.NewEncoder(hash).Encode(v); err != nil {
+		return ""
+	}
+	return `"` + hex.EncodeToString(hash.Sum(nil)[:16]) + `"`
+}
+
+// CheckETag returns ErrVersionMismatch when the expected entity tag is set and is not the tag
+// of the current representation of the resource. An empty tag matches any representation.
+func CheckETag(resource string, current any, expected string) error {
+	if expected == "" {
+		return nil
+	}
+	// the strong comparison, weak tags never match
+	if etag := ETag(current); etag == "" || etag != expected {
+		return fmt.Errorf("%s %w: expected %s, current %s", resource, ErrVersionMismatch, expected, etag)
+	}
+	return nil
+}
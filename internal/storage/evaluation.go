@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/models"
+)
+
+// CheckEvaluateUsers validates the number of users evaluated at once.
+func CheckEvaluateUsers(ids []int64) error {
+	if len(ids) == 0 {
+		return Invalid("user_ids", "required", "must not be empty")
+	}
+	if len(ids) > MaxEvaluateUsers {
+		return Invalid("user_ids", "max", fmt.Sprintf("must have at most %d items", MaxEvaluateUsers))
+	}
+	return nil
+}
+
+// NewEvaluation flattens the active segments of the user, each segment is listed once.
+// The segments must have ExpiresAt set for memberships with TTL.
+func NewEvaluation(userID int64, segments []models.Segment) models.Evaluation {
+	evaluation := models.Evaluation{UserID: userID, Segments: make([]string, 0, len(segments))}
+	seen := make(map[string]bool, len(segments))
+	for _, segment := range segments {
+		if seen[segment.Name] {
+			continue
+		}
+		seen[segment.Name] = true
+
+		evaluation.Segments = append(evaluation.Segments, segment.Name)
+		if segment.ExpiresAt != nil && (evaluation.ExpiresAt == nil || segment.ExpiresAt.Before(*evaluation.ExpiresAt)) {
+			evaluation.ExpiresAt = segment.ExpiresAt
+		}
+		if segment.Variant != "" {
+			if evaluation.Variants == nil {
+				evaluation.Variants = make(map[string]string)
+			}
+			evaluation.Variants[segment.Name] = segment.Variant
+		}
+	}
+	sort.Strings(evaluation.Segments)
+	return evaluation
+}
@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/models"
+)
+
+// Buckets is the number of hash buckets users of an experiment are spread over,
+// so an experiment percent has a precision of two decimal places.
+const Buckets = 10000
+
+// Bucket returns the experiment bucket of the user in [0, Buckets). It is the first 4 bytes
+// of md5(salt + ":" + user ID) taken as a big-endian number, which PostgreSQL computes as
+// ('x' || substr(md5(salt || ':' || id::text), 1, 8))::bit(32)::bigint % 10000.
+func Bucket(salt string, userID int64) int {
+	return int(hash(salt, userID) % Buckets)
+}
+
+func hash(salt string, userID int64) uint32 {
+	sum := md5.Sum([]byte(salt + ":" + strconv.FormatInt(userID, 10)))
+	return binary.BigEndian.Uint32(sum[:4])
+}
+
+// InExperiment reports whether the user is in an experiment with the salt and percent.
+// Buckets below the percent are in, so raising the percent keeps all existing members.
+func InExperiment(salt string, percent float64, userID int64) bool {
+	return Bucket(salt, userID) < BucketsBelow(percent)
+}
+
+// BucketsBelow returns the number of buckets in an experiment with the percent.
+func BucketsBelow(percent float64) int {
+	return int(math.Round(percent * Buckets / 100))
+}
+
+// HasComputedMembers reports whether users get into the segment without explicit memberships.
+func HasComputedMembers(segment *models.Segment) bool {
+	return segment.Type == models.SegmentTypeExperiment || segment.Rule != nil
+}
+
+// IsComputedMember reports whether the user is in the segment without an explicit membership:
+// an experiment contains users falling into its percent, a segment with a rule contains users
+// matching the rule, and an experiment with a rule contains users matching it and falling into the percent.
+func IsComputedMember(segment *models.Segment, user *models.User) bool {
+	if !HasComputedMembers(segment) {
+		return false
+	}
+	if segment.Type == models.SegmentTypeExperiment && !InExperiment(segment.Salt, segment.Percent, user.ID) {
+		return false
+	}
+	return segment.Rule == nil || MatchRule(segment.Rule, user.Attributes)
+}
+
+// NewSalt returns a random experiment salt.
+func NewSalt() string {
+	salt := make([]byte, 8)
+	if _, err := rand.Read(salt); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(salt)
+}
+
+// ValidateSegmentUpdate validates the fields of an existing segment which can be changed against its type.
+func ValidateSegmentUpdate(segment *models.Segment) error {
+	if err := checkPercent("auto_percent", segment.AutoPercent); err != nil {
+		return err
+	}
+	if err := checkPercent("percent", segment.Percent); err != nil {
+		return err
+	}
+	if segment.Type == models.SegmentTypeStatic && segment.Percent != 0 {
+		return Invalid("percent", "type", "only experiments have it")
+	}
+	if segment.Type == models.SegmentTypeExperiment && segment.AutoPercent != 0 {
+		return Invalid("auto_percent", "type", "experiments use percent instead")
+	}
+	if segment.Rule != nil {
+		return ValidateRule(segment.Rule)
+	}
+	return nil
+}
+
+// checkPercent validates a percentage field of a segment.
+func checkPercent(field string, percent float64) error {
+	if percent < 0 {
+		return Invalid(field, "min", "must be at least 0")
+	}
+	if percent > 100 {
+		return Invalid(field, "max", "must be at most 100")
+	}
+	return nil
+}
+
+// NormalizeSegment validates a new segment, defaults its type to static
+// and generates the salt of an experiment without one.
+func NormalizeSegment(segment *models.Segment) error {
+	if segment.Name == "" {
+		return Invalid("name", "required", "must not be empty")
+	}
+	if err := checkPercent("auto_percent", segment.AutoPercent); err != nil {
+		return err
+	}
+	if err := checkPercent("percent", segment.Percent); err != nil {
+		return err
+	}
+
+	switch segment.Type {
+	case "", models.SegmentTypeStatic:
+		segment.Type = models.SegmentTypeStatic
+		if segment.Percent != 0 {
+			return Invalid("percent", "type", "only experiments have it")
+		}
+		if segment.Salt != "" {
+			return Invalid("salt", "type", "only experiments have it")
+		}
+	case models.SegmentTypeExperiment:
+		if segment.AutoPercent != 0 {
+			return Invalid("auto_percent", "type", "experiments use percent instead")
+		}
+		if segment.Salt == "" {
+			segment.Salt = NewSalt()
+		}
+	default:
+		return Invalid("type", "oneof", fmt.Sprintf("must be one of %s, %s", models.SegmentTypeStatic, models.SegmentTypeExperiment))
+	}
+
+	if segment.Rule != nil {
+		if err := ValidateRule(segment.Rule); err != nil {
+			return err
+		}
+	}
+
+	return validateVariants(segment.Variants)
+}
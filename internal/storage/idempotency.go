@@ -0,0 +1,19 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/models"
+)
+
+type IdempotencyStorage interface {
+	// ReserveIdempotencyKey stores the key without a response unless an unexpired key with the same scope
+	// and key exists, which is returned then. A nil key means the caller has reserved it and handles the request.
+	ReserveIdempotencyKey(ctx context.Context, key *models.IdempotencyKey) (*models.IdempotencyKey, error)
+	// CompleteIdempotencyKey stores the response of the reserved key
+	CompleteIdempotencyKey(ctx context.Context, key *models.IdempotencyKey) error
+	// ReleaseIdempotencyKey deletes the reserved key whose request failed, so it can be retried
+	ReleaseIdempotencyKey(ctx context.Context, scope, key string) error
+	DeleteExpiredIdempotencyKeys(ctx context.Context, now time.Time) (int64, error)
+}
@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/models"
+)
+
+const (
+	DefaultLimit = 100
+	MaxLimit     = 1000
+)
+
+// Sort fields supported by the list methods.
+const (
+	SortByID        = "id"
+	SortByUsername  = "username"
+	SortByName      = "name"
+	SortByCreatedAt = "created_at"
+)
+
+// ListParams are the keyset pagination parameters of the list methods.
+type ListParams struct {
+	Limit     int    // page size, DefaultLimit when zero
+	Cursor    string // NextCursor of the previous page, empty for the first page
+	Sort      string // sort field, the default one of the method when empty
+	Desc      bool   // sort in descending order
+	WithTotal bool   // count all records matching the filter
+}
+
+// UserFilter narrows down the users list, zero fields are ignored.
+type UserFilter struct {
+	UsernamePrefix string
+	CreatedAfter   *time.Time
+	Segment        string       // only users with an active membership in the segment or computed members of it
+	Rule           *models.Rule // only users matching the targeting rule
+}
+
+// SegmentFilter narrows down the segments list, zero fields are ignored.
+type SegmentFilter struct {
+	NamePrefix   string
+	CreatedAfter *time.Time
+}
+
+// Page is a single page of a list. NextCursor is empty on the last page.
+type Page[T any] struct {
+	Items      []*T
+	NextCursor string
+	Total      *int64 // set only when ListParams.WithTotal is requested
+}
+
+// Cursor points right after the last record of a page. Key is the unique key of the record
+// breaking ties between records with equal sort values.
+type Cursor struct {
+	Sort  string `json:"s"`
+	Desc  bool   `json:"d,omitempty"`
+	Value string `json:"v"`
+	Key   string `json:"k"`
+}
+
+// ErrInvalidCursor is returned for a cursor which was not returned as the next cursor of a page.
+var ErrInvalidCursor = Invalid("cursor", "cursor", "must be the next cursor of a previous page")
+
+func (c Cursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// Normalize validates the params against the sort fields of a list method and decodes the cursor.
+// The first of the sorts is the default one. A nil cursor means the first page.
+func (p *ListParams) Normalize(sorts ...string) (*Cursor, error) {
+	if p.Limit == 0 {
+		p.Limit = DefaultLimit
+	}
+	if p.Limit < 0 || p.Limit > MaxLimit {
+		return nil, Invalid("limit", "max", fmt.Sprintf("must be between 1 and %d", MaxLimit))
+	}
+
+	if p.Sort == "" {
+		p.Sort = sorts[0]
+	}
+	if !slices.Contains(sorts, p.Sort) {
+		return nil, Invalid("sort", "oneof", fmt.Sprintf("must be one of %s", strings.Join(sorts, ", ")))
+	}
+
+	if p.Cursor == "" {
+		return nil, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(p.Cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var cursor Cursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	if cursor.Sort != p.Sort || cursor.Desc != p.Desc {
+		return nil, Invalid("cursor", "sort", "was issued for another sort order")
+	}
+
+	return &cursor, nil
+}
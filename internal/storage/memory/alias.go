@@ -0,0 +1,38 @@
+package memory
+
+import (
+	"context"
+	"time"
+)
+
+// resolveSegmentName returns the name of the segment the slug is an active alias of, the slug itself otherwise.
+func (db *DB) resolveSegmentName(slug string, now time.Time) string {
+	if alias, ok := db.aliases[slug]; ok && alias.ExpiresAt.After(now) {
+		return alias.SegmentName
+	}
+	return slug
+}
+
+// deleteAliases deletes the aliases of the segment, like the postgres foreign key does when it is deleted.
+func (db *DB) deleteAliases(segment string) {
+	for name, alias := range db.aliases {
+		if alias.SegmentName == segment {
+			delete(db.aliases, name)
+		}
+	}
+}
+
+func (s *segmentStorage) DeleteExpiredAliases(ctx context.Context, now time.Time) (int64, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	var deleted int64
+	for name, alias := range s.db.aliases {
+		if !alias.ExpiresAt.After(now) {
+			delete(s.db.aliases, name)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
@@ -0,0 +1,87 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/models"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/storage"
+)
+
+type apiKeyStorage struct {
+	db *DB
+}
+
+func NewAPIKeyStorage(db *DB) (storage.APIKeyStorage, error) {
+	return &apiKeyStorage{db: db}, nil
+}
+
+func copyAPIKey(key *models.APIKey) *models.APIKey {
+	copied := *key
+	copied.Scopes = slices.Clone(key.Scopes)
+	return &copied
+}
+
+func (s *apiKeyStorage) CreateAPIKey(ctx context.Context, key *models.APIKey) error {
+	if err := storage.ValidateAPIKey(key); err != nil {
+		return err
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	for _, stored := range s.db.apiKeys {
+		if stored.Hash == key.Hash {
+			return fmt.Errorf("API key %w", storage.ErrAlreadyExists)
+		}
+	}
+
+	s.db.lastAPIKeyID++
+	key.ID = s.db.lastAPIKeyID
+	key.CreatedAt = time.Now()
+	s.db.apiKeys[key.ID] = copyAPIKey(key)
+
+	return nil
+}
+
+func (s *apiKeyStorage) GetAPIKeyByHash(ctx context.Context, hash string) (*models.APIKey, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	for _, key := range s.db.apiKeys {
+		if key.Hash == hash {
+			return copyAPIKey(key), nil
+		}
+	}
+	return nil, fmt.Errorf("API key %w", storage.ErrNotFound)
+}
+
+func (s *apiKeyStorage) GetAPIKeys(ctx context.Context) ([]*models.APIKey, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	keys := make([]*models.APIKey, 0, len(s.db.apiKeys))
+	for _, key := range s.db.apiKeys {
+		keys = append(keys, copyAPIKey(key))
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].ID < keys[j].ID
+	})
+
+	return keys, nil
+}
+
+func (s *apiKeyStorage) DeleteAPIKey(ctx context.Context, id int64) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	if _, ok := s.db.apiKeys[id]; !ok {
+		return fmt.Errorf("API key with ID %d %w", id, storage.ErrNotFound)
+	}
+	delete(s.db.apiKeys, id)
+
+	return nil
+}
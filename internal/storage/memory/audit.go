@@ -0,0 +1,111 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/models"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/storage"
+)
+
+type auditStorage struct {
+	db *DB
+}
+
+func NewAuditStorage(db *DB) (storage.AuditStorage, error) {
+	return &auditStorage{db: db}, nil
+}
+
+// recordAudit appends the audit entry of a change. The before and after values are encoded right away,
+// so later changes of the stored records do not leak into the entry.
+func (db *DB) recordAudit(ctx context.Context, action models.AuditAction, resourceType, resourceID string, before, after any, now time.Time) error {
+	entry, err := storage.NewAuditEntry(ctx, action, resourceType, resourceID, before, after)
+	if err != nil {
+		return err
+	}
+
+	db.lastAuditID++
+	entry.ID = db.lastAuditID
+	entry.CreatedAt = now
+	db.audit = append(db.audit, entry)
+	return nil
+}
+
+// auditMemberships returns the active memberships of the user sorted by segment.
+func (db *DB) auditMemberships(userID int64, now time.Time) []models.AuditMembership {
+	memberships := make([]models.AuditMembership, 0, len(db.memberships[userID]))
+	for _, name := range sortedKeys(db.memberships[userID]) {
+		if membership := db.auditMembership(userID, name, now); membership != nil {
+			memberships = append(memberships, *membership)
+		}
+	}
+	return memberships
+}
+
+// auditMembership returns the active membership of the user in the segment, nil when there is none.
+func (db *DB) auditMembership(userID int64, segment string, now time.Time) *models.AuditMembership {
+	membership, ok := db.memberships[userID][segment]
+	if !ok || !isActive(membership, now) {
+		return nil
+	}
+	return &models.AuditMembership{Segment: segment, ExpiresAt: membership.ExpiresAt, Variant: membership.Variant}
+}
+
+func (s *auditStorage) GetAuditEntries(ctx context.Context, filter storage.AuditFilter, params storage.ListParams) (*storage.Page[models.AuditEntry], error) {
+	cursor, err := params.Normalize(storage.SortByID)
+	if err != nil {
+		return nil, err
+	}
+
+	var after int64
+	if cursor != nil {
+		if after, err = strconv.ParseInt(cursor.Key, 10, 64); err != nil {
+			return nil, storage.ErrInvalidCursor
+		}
+	}
+
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	var matched []*models.AuditEntry
+	for _, entry := range s.db.audit {
+		if matchAudit(entry, filter) {
+			matched = append(matched, entry)
+		}
+	}
+	if params.Desc {
+		sort.Slice(matched, func(i, j int) bool { return matched[i].ID > matched[j].ID })
+	}
+
+	page := &storage.Page[models.AuditEntry]{Items: make([]*models.AuditEntry, 0)}
+	if params.WithTotal {
+		total := int64(len(matched))
+		page.Total = &total
+	}
+
+	for _, entry := range matched {
+		if cursor != nil && (!params.Desc && entry.ID <= after || params.Desc && entry.ID >= after) {
+			continue
+		}
+		if len(page.Items) == params.Limit {
+			last := page.Items[len(page.Items)-1]
+			page.NextCursor = storage.Cursor{Sort: params.Sort, Desc: params.Desc, Key: strconv.FormatInt(last.ID, 10)}.Encode()
+			break
+		}
+		copied := *entry
+		page.Items = append(page.Items, &copied)
+	}
+
+	return page, nil
+}
+
+func matchAudit(entry *models.AuditEntry, filter storage.AuditFilter) bool {
+	return (filter.Actor == "" || entry.Actor == filter.Actor) &&
+		(filter.Action == "" || entry.Action == filter.Action) &&
+		(filter.ResourceType == "" || entry.ResourceType == filter.ResourceType) &&
+		(filter.ResourceID == "" || entry.ResourceID == filter.ResourceID) &&
+		(filter.From == nil || !entry.CreatedAt.Before(*filter.From)) &&
+		(filter.To == nil || entry.CreatedAt.Before(*filter.To))
+}
@@ -0,0 +1,215 @@
+package memory
+
+import (
+	"maps"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/models"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/storage"
+)
+
+// DB is an in-memory database shared by the user and segment storages.
+// Every storage method holds the lock for its whole duration, which makes it transactional.
+type DB struct {
+	mu sync.RWMutex
+
+	users       map[int64]*models.User
+	usernames   map[string]int64
+	segments    map[string]*models.Segment
+	aliases     map[string]*models.SegmentAlias
+	memberships map[int64]map[string]*models.UserSegment // user ID -> segment name -> membership
+	history     []*models.UserSegmentHistory
+	apiKeys     map[int64]*models.APIKey
+	audit       []*models.AuditEntry
+
+	idempotencyKeys map[idempotencyKeyID]*models.IdempotencyKey
+
+	lastUserID    int64
+	lastHistoryID int64
+	lastAPIKeyID  int64
+	lastAuditID   int64
+}
+
+func NewDB() *DB {
+	return &DB{
+		users:       make(map[int64]*models.User),
+		usernames:   make(map[string]int64),
+		segments:    make(map[string]*models.Segment),
+		aliases:     make(map[string]*models.SegmentAlias),
+		memberships: make(map[int64]map[string]*models.UserSegment),
+		apiKeys:     make(map[int64]*models.APIKey),
+
+		idempotencyKeys: make(map[idempotencyKeyID]*models.IdempotencyKey),
+	}
+}
+
+// isActive reports whether the membership TTL has not passed.
+func isActive(membership *models.UserSegment, now time.Time) bool {
+	return membership.ExpiresAt == nil || membership.ExpiresAt.After(now)
+}
+
+func (db *DB) recordHistory(userID int64, segment string, op models.Operation, at time.Time) {
+	db.lastHistoryID++
+	db.history = append(db.history, &models.UserSegmentHistory{
+		ID:          db.lastHistoryID,
+		UserID:      userID,
+		SegmentName: segment,
+		Operation:   op,
+		CreatedAt:   at,
+	})
+}
+
+// addMembership puts the user into the segment or replaces the expiration of the existing membership
+// and its variant when one is given. It reports whether the user was not a member of the segment.
+func (db *DB) addMembership(userID int64, assignment models.SegmentAssignment, now time.Time) bool {
+	memberships, ok := db.memberships[userID]
+	if !ok {
+		memberships = make(map[string]*models.UserSegment)
+		db.memberships[userID] = memberships
+	}
+
+	var variant *string
+	if assignment.Variant != "" {
+		variant = &assignment.Variant
+	}
+
+	if membership, ok := memberships[assignment.Name]; ok {
+		membership.ExpiresAt = assignment.ExpiresAt
+		if variant != nil {
+			membership.Variant = variant
+		}
+		return false
+	}
+
+	memberships[assignment.Name] = &models.UserSegment{
+		UserID:      userID,
+		SegmentName: assignment.Name,
+		ExpiresAt:   assignment.ExpiresAt,
+		Variant:     variant,
+	}
+	db.recordHistory(userID, assignment.Name, models.OperationAdd, now)
+	return true
+}
+
+// deleteMembership removes the user from the segment and reports whether the user was a member of it.
+func (db *DB) deleteMembership(userID int64, segment string, now time.Time) bool {
+	if _, ok := db.memberships[userID][segment]; !ok {
+		return false
+	}
+
+	delete(db.memberships[userID], segment)
+	if len(db.memberships[userID]) == 0 {
+		delete(db.memberships, userID)
+	}
+	db.recordHistory(userID, segment, models.OperationRemove, now)
+	return true
+}
+
+// expireMemberships removes memberships matched by the filter whose TTL has passed
+// and records their removal at the expiration time.
+func (db *DB) expireMemberships(now time.Time, match func(*models.UserSegment) bool) int64 {
+	var expired []*models.UserSegment
+	for _, memberships := range db.memberships {
+		for _, membership := range memberships {
+			if !isActive(membership, now) && match(membership) {
+				expired = append(expired, membership)
+			}
+		}
+	}
+
+	sort.Slice(expired, func(i, j int) bool {
+		return expired[i].ExpiresAt.Before(*expired[j].ExpiresAt)
+	})
+
+	for _, membership := range expired {
+		db.deleteMembership(membership.UserID, membership.SegmentName, *membership.ExpiresAt)
+	}
+
+	return int64(len(expired))
+}
+
+// inSegment reports whether the user has an active membership in the segment or is a computed member of it.
+func (db *DB) inSegment(userID int64, segment *models.Segment, now time.Time) bool {
+	if membership, ok := db.memberships[userID][segment.Name]; ok && isActive(membership, now) {
+		return true
+	}
+	return storage.IsComputedMember(segment, db.users[userID])
+}
+
+// variant returns the variant of a member of the segment.
+func (db *DB) variant(userID int64, segment *models.Segment, now time.Time) string {
+	var assigned *string
+	if membership, ok := db.memberships[userID][segment.Name]; ok && isActive(membership, now) {
+		assigned = membership.Variant
+	}
+	return storage.AssignVariant(segment, userID, assigned)
+}
+
+// userWithSegments returns a copy of the user with active segments sorted by name.
+func (db *DB) userWithSegments(user *models.User, now time.Time) *models.User {
+	result := *user
+	result.Attributes = maps.Clone(user.Attributes)
+	result.Segments = nil
+
+	for _, stored := range db.segments {
+		if !db.inSegment(user.ID, stored, now) {
+			continue
+		}
+		segment := *stored
+		segment.Users = nil
+		segment.Variant = db.variant(user.ID, stored, now)
+		if membership, ok := db.memberships[user.ID][segment.Name]; ok && isActive(membership, now) {
+			segment.ExpiresAt = membership.ExpiresAt
+		}
+		result.Segments = append(result.Segments, segment)
+	}
+
+	sort.Slice(result.Segments, func(i, j int) bool {
+		return result.Segments[i].Name < result.Segments[j].Name
+	})
+
+	return &result
+}
+
+// usersInSegment returns copies of users in the segment sorted by ID.
+func (db *DB) usersInSegment(segment *models.Segment, now time.Time) []*models.User {
+	users := make([]*models.User, 0)
+	for _, stored := range db.users {
+		if !db.inSegment(stored.ID, segment, now) {
+			continue
+		}
+		user := *stored
+		user.Attributes = maps.Clone(stored.Attributes)
+		user.Segments = nil
+		users = append(users, &user)
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].ID < users[j].ID
+	})
+
+	return users
+}
+
+// segmentWithUsers returns a copy of the segment with its active users and the members count of each variant.
+func (db *DB) segmentWithUsers(segment *models.Segment, now time.Time) *models.Segment {
+	result := *segment
+	result.Users = nil
+
+	if len(segment.Variants) > 0 {
+		result.VariantCounts = make(map[string]int64, len(segment.Variants))
+		for _, variant := range segment.Variants {
+			result.VariantCounts[variant.Name] = 0
+		}
+	}
+
+	for _, user := range db.usersInSegment(segment, now) {
+		result.Users = append(result.Users, *user)
+		if result.VariantCounts != nil {
+			result.VariantCounts[db.variant(user.ID, segment, now)]++
+		}
+	}
+	return &result
+}
@@ -0,0 +1,98 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/models"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/storage"
+)
+
+type idempotencyKeyID struct {
+	scope, key string
+}
+
+type idempotencyStorage struct {
+	db *DB
+}
+
+func NewIdempotencyStorage(db *DB) (storage.IdempotencyStorage, error) {
+	return &idempotencyStorage{db: db}, nil
+}
+
+func cloneHeaders(headers map[string][]string) map[string][]string {
+	return http.Header(headers).Clone()
+}
+
+func copyIdempotencyKey(key *models.IdempotencyKey) *models.IdempotencyKey {
+	copied := *key
+	copied.Headers = cloneHeaders(key.Headers)
+	copied.Body = slices.Clone(key.Body)
+	return &copied
+}
+
+func (s *idempotencyStorage) ReserveIdempotencyKey(ctx context.Context, key *models.IdempotencyKey) (*models.IdempotencyKey, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	now := time.Now()
+	id := idempotencyKeyID{key.Scope, key.Key}
+	if existing, ok := s.db.idempotencyKeys[id]; ok && existing.ExpiresAt.After(now) {
+		return copyIdempotencyKey(existing), nil
+	}
+
+	reserved := copyIdempotencyKey(key)
+	reserved.StatusCode = 0
+	reserved.Headers = nil
+	reserved.Body = nil
+	reserved.CreatedAt = now
+	s.db.idempotencyKeys[id] = reserved
+
+	return nil, nil
+}
+
+func (s *idempotencyStorage) CompleteIdempotencyKey(ctx context.Context, key *models.IdempotencyKey) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	stored, ok := s.db.idempotencyKeys[idempotencyKeyID{key.Scope, key.Key}]
+	if !ok || stored.Completed() {
+		return fmt.Errorf("reserved idempotency key '%s' %w", key.Key, storage.ErrNotFound)
+	}
+
+	stored.StatusCode = key.StatusCode
+	stored.Headers = cloneHeaders(key.Headers)
+	stored.Body = slices.Clone(key.Body)
+
+	return nil
+}
+
+func (s *idempotencyStorage) ReleaseIdempotencyKey(ctx context.Context, scope, key string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	id := idempotencyKeyID{scope, key}
+	if stored, ok := s.db.idempotencyKeys[id]; ok && !stored.Completed() {
+		delete(s.db.idempotencyKeys, id)
+	}
+
+	return nil
+}
+
+func (s *idempotencyStorage) DeleteExpiredIdempotencyKeys(ctx context.Context, now time.Time) (int64, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	var deleted int64
+	for id, key := range s.db.idempotencyKeys {
+		if !key.ExpiresAt.After(now) {
+			delete(s.db.idempotencyKeys, id)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
@@ -0,0 +1,185 @@
+package memory
+
+import (
+	"cmp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/models"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/storage"
+)
+
+// paginate sorts the records the same way the postgres storage does, skips the records
+// up to the cursor and cuts the page. compare orders records by their cursors.
+func paginate[T any](
+	items []*T,
+	params storage.ListParams,
+	cursor *storage.Cursor,
+	cursorOf func(*T) storage.Cursor,
+	compare func(a, b storage.Cursor) (int, error),
+) (*storage.Page[T], error) {
+	page := &storage.Page[T]{}
+	if params.WithTotal {
+		total := int64(len(items))
+		page.Total = &total
+	}
+
+	direction := 1
+	if params.Desc {
+		direction = -1
+	}
+
+	var sortErr error
+	slices.SortFunc(items, func(a, b *T) int {
+		c, err := compare(cursorOf(a), cursorOf(b))
+		if err != nil {
+			sortErr = err
+		}
+		return c * direction
+	})
+	if sortErr != nil {
+		return nil, sortErr
+	}
+
+	if cursor != nil {
+		start := len(items)
+		for i, item := range items {
+			c, err := compare(cursorOf(item), *cursor)
+			if err != nil {
+				return nil, err
+			}
+			if c*direction > 0 {
+				start = i
+				break
+			}
+		}
+		items = items[start:]
+	}
+
+	if len(items) > params.Limit {
+		items = items[:params.Limit]
+		page.NextCursor = cursorOf(items[len(items)-1]).Encode()
+	}
+	page.Items = items
+
+	return page, nil
+}
+
+// listUsers returns a page of users matching the filter, the users are not copied.
+func (db *DB) listUsers(filter storage.UserFilter, params storage.ListParams, now time.Time) (*storage.Page[models.User], error) {
+	cursor, err := params.Normalize(storage.SortByID, storage.SortByUsername, storage.SortByCreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if filter.Rule != nil {
+		if err := storage.ValidateRule(filter.Rule); err != nil {
+			return nil, err
+		}
+	}
+
+	var users []*models.User
+	for _, user := range db.users {
+		if filter.UsernamePrefix != "" && !strings.HasPrefix(user.Username, filter.UsernamePrefix) {
+			continue
+		}
+		if filter.CreatedAfter != nil && !user.CreatedAt.After(*filter.CreatedAfter) {
+			continue
+		}
+		if filter.Segment != "" {
+			segment, ok := db.segments[filter.Segment]
+			if !ok || !db.inSegment(user.ID, segment, now) {
+				continue
+			}
+		}
+		if filter.Rule != nil && !storage.MatchRule(filter.Rule, user.Attributes) {
+			continue
+		}
+		users = append(users, user)
+	}
+
+	cursorOf := func(user *models.User) storage.Cursor {
+		c := storage.Cursor{Sort: params.Sort, Desc: params.Desc, Key: strconv.FormatInt(user.ID, 10)}
+		switch params.Sort {
+		case storage.SortByUsername:
+			c.Value = user.Username
+		case storage.SortByCreatedAt:
+			c.Value = user.CreatedAt.Format(time.RFC3339Nano)
+		}
+		return c
+	}
+
+	compare := func(a, b storage.Cursor) (int, error) {
+		aID, errA := strconv.ParseInt(a.Key, 10, 64)
+		bID, errB := strconv.ParseInt(b.Key, 10, 64)
+		if errA != nil || errB != nil {
+			return 0, storage.ErrInvalidCursor
+		}
+
+		c, err := compareValues(params.Sort, a.Value, b.Value)
+		if err != nil {
+			return 0, err
+		}
+		if c != 0 {
+			return c, nil
+		}
+		return cmp.Compare(aID, bID), nil
+	}
+
+	return paginate(users, params, cursor, cursorOf, compare)
+}
+
+// listSegments returns a page of segments matching the filter, the segments are not copied.
+func (db *DB) listSegments(filter storage.SegmentFilter, params storage.ListParams) (*storage.Page[models.Segment], error) {
+	cursor, err := params.Normalize(storage.SortByName, storage.SortByCreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	var segments []*models.Segment
+	for _, segment := range db.segments {
+		if filter.NamePrefix != "" && !strings.HasPrefix(segment.Name, filter.NamePrefix) {
+			continue
+		}
+		if filter.CreatedAfter != nil && !segment.CreatedAt.After(*filter.CreatedAfter) {
+			continue
+		}
+		segments = append(segments, segment)
+	}
+
+	cursorOf := func(segment *models.Segment) storage.Cursor {
+		c := storage.Cursor{Sort: params.Sort, Desc: params.Desc, Key: segment.Name}
+		if params.Sort == storage.SortByCreatedAt {
+			c.Value = segment.CreatedAt.Format(time.RFC3339Nano)
+		}
+		return c
+	}
+
+	compare := func(a, b storage.Cursor) (int, error) {
+		c, err := compareValues(params.Sort, a.Value, b.Value)
+		if err != nil {
+			return 0, err
+		}
+		if c != 0 {
+			return c, nil
+		}
+		return strings.Compare(a.Key, b.Key), nil
+	}
+
+	return paginate(segments, params, cursor, cursorOf, compare)
+}
+
+// compareValues compares the sort values of two cursors, values of the key sort fields are empty.
+func compareValues(sort, a, b string) (int, error) {
+	if sort != storage.SortByCreatedAt {
+		return strings.Compare(a, b), nil
+	}
+
+	aTime, errA := time.Parse(time.RFC3339Nano, a)
+	bTime, errB := time.Parse(time.RFC3339Nano, b)
+	if errA != nil || errB != nil {
+		return 0, storage.ErrInvalidCursor
+	}
+	return aTime.Compare(bTime), nil
+}
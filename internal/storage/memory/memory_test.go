@@ -0,0 +1,58 @@
+package memory
+
+import (
+	"testing"
+
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/storage"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/storage/storagetest"
+)
+
+func newStorages(t *testing.T, db *DB) (storage.UserStorage, storage.SegmentStorage) {
+	us, err := NewUserStorage(db)
+	if err != nil {
+		t.Fatalf("NewUserStorage: %v", err)
+	}
+	ss, err := NewSegmentStorage(db, us)
+	if err != nil {
+		t.Fatalf("NewSegmentStorage: %v", err)
+	}
+	return us, ss
+}
+
+func TestConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) (storage.UserStorage, storage.SegmentStorage) {
+		return newStorages(t, NewDB())
+	})
+}
+
+func TestAPIKeyConformance(t *testing.T) {
+	storagetest.RunAPIKeys(t, func(t *testing.T) storage.APIKeyStorage {
+		ks, err := NewAPIKeyStorage(NewDB())
+		if err != nil {
+			t.Fatalf("NewAPIKeyStorage: %v", err)
+		}
+		return ks
+	})
+}
+
+func TestAuditConformance(t *testing.T) {
+	storagetest.RunAudit(t, func(t *testing.T) (storage.UserStorage, storage.SegmentStorage, storage.AuditStorage) {
+		db := NewDB()
+		us, ss := newStorages(t, db)
+		as, err := NewAuditStorage(db)
+		if err != nil {
+			t.Fatalf("NewAuditStorage: %v", err)
+		}
+		return us, ss, as
+	})
+}
+
+func TestIdempotencyConformance(t *testing.T) {
+	storagetest.RunIdempotency(t, func(t *testing.T) storage.IdempotencyStorage {
+		ks, err := NewIdempotencyStorage(NewDB())
+		if err != nil {
+			t.Fatalf("NewIdempotencyStorage: %v", err)
+		}
+		return ks
+	})
+}
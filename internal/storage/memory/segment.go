@@ -0,0 +1,394 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"math/rand"
+	"slices"
+	"time"
+
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/models"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/storage"
+)
+
+type segmentStorage struct {
+	db          *DB
+	userStorage storage.UserStorage
+}
+
+func NewSegmentStorage(db *DB, us storage.UserStorage) (storage.SegmentStorage, error) {
+	return &segmentStorage{db: db, userStorage: us}, nil
+}
+
+func (s *segmentStorage) CreateSegment(ctx context.Context, segment *models.Segment) error {
+	if err := storage.NormalizeSegment(segment); err != nil {
+		return err
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	if _, ok := s.db.segments[segment.Name]; ok {
+		return fmt.Errorf("segment with name '%s' %w", segment.Name, storage.ErrAlreadyExists)
+	}
+	// the new segment takes over an alias with its name
+	delete(s.db.aliases, segment.Name)
+
+	now := time.Now()
+	segment.CreatedAt = now
+	segment.Version = 1
+
+	stored := *segment
+	stored.Users = nil
+	stored.Variants = slices.Clone(segment.Variants)
+	s.db.segments[stored.Name] = &stored
+
+	if err := s.db.recordAudit(ctx, models.AuditSegmentCreate, models.AuditResourceSegment, stored.Name, nil, storage.AuditSegment(&stored), now); err != nil {
+		return err
+	}
+
+	sampleSize := storage.SampleSize(int64(len(s.db.users)), stored.AutoPercent)
+	if sampleSize == 0 {
+		return nil
+	}
+
+	ids := make([]int64, 0, len(s.db.users))
+	for id := range s.db.users {
+		ids = append(ids, id)
+	}
+	rand.Shuffle(len(ids), func(i, j int) {
+		ids[i], ids[j] = ids[j], ids[i]
+	})
+
+	for _, id := range ids[:sampleSize] {
+		s.db.addMembership(id, models.SegmentAssignment{Name: stored.Name}, now)
+		after := &models.AuditMembership{Segment: stored.Name}
+		if err := s.db.recordAudit(ctx, models.AuditMembershipAdd, models.AuditResourceMembership, storage.MembershipResourceID(stored.Name, id), nil, after, now); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *segmentStorage) GetSegmentByName(ctx context.Context, slug string) (*models.Segment, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	now := time.Now()
+	name := s.db.resolveSegmentName(slug, now)
+	segment, ok := s.db.segments[name]
+	if !ok {
+		return nil, fmt.Errorf("segment with name '%s' %w", name, storage.ErrNotFound)
+	}
+	return s.db.segmentWithUsers(segment, now), nil
+}
+
+func (s *segmentStorage) GetSegments(ctx context.Context, filter storage.SegmentFilter, params storage.ListParams) (*storage.Page[models.Segment], error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	page, err := s.db.listSegments(filter, params)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, segment := range page.Items {
+		copied := *segment
+		page.Items[i] = &copied
+	}
+
+	return page, nil
+}
+
+func (s *segmentStorage) UpdateSegment(ctx context.Context, segment *models.Segment, ifMatch string) (*models.Segment, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	now := time.Now()
+	segment.Name = s.db.resolveSegmentName(segment.Name, now)
+	stored, ok := s.db.segments[segment.Name]
+	if !ok {
+		return nil, fmt.Errorf("segment with name '%s' %w", segment.Name, storage.ErrNotFound)
+	}
+	if err := storage.CheckETag(fmt.Sprintf("segment with name '%s'", segment.Name), s.db.segmentWithUsers(stored, now), ifMatch); err != nil {
+		return nil, err
+	}
+
+	// the type, the salt and the variants are kept like the postgres storage does,
+	// so the update is validated against the stored type
+	updated := *segment
+	updated.Type = stored.Type
+	if err := storage.ValidateSegmentUpdate(&updated); err != nil {
+		return nil, err
+	}
+
+	// the rule is replaced and never modified, so the copy keeps the state before the update
+	before := *stored
+
+	// only non-zero fields are updated like gorm Updates does
+	if segment.AutoPercent != 0 {
+		stored.AutoPercent = segment.AutoPercent
+	}
+	if segment.Percent != 0 {
+		stored.Percent = segment.Percent
+	}
+	if segment.Rule != nil {
+		stored.Rule = segment.Rule
+	}
+	stored.Version++
+
+	if err := s.db.recordAudit(ctx, models.AuditSegmentUpdate, models.AuditResourceSegment, stored.Name, &before, stored, now); err != nil {
+		return nil, err
+	}
+	return s.db.segmentWithUsers(stored, now), nil
+}
+
+func (s *segmentStorage) PatchSegment(ctx context.Context, slug string, ifMatch string, patch func(segment *models.Segment) error) (*models.Segment, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	now := time.Now()
+	slug = s.db.resolveSegmentName(slug, now)
+	stored, ok := s.db.segments[slug]
+	if !ok {
+		return nil, fmt.Errorf("segment with name '%s' %w", slug, storage.ErrNotFound)
+	}
+	if err := storage.CheckETag(fmt.Sprintf("segment with name '%s'", slug), s.db.segmentWithUsers(stored, now), ifMatch); err != nil {
+		return nil, err
+	}
+
+	segment := *stored
+	segment.Variants = slices.Clone(stored.Variants)
+	if err := patch(&segment); err != nil {
+		return nil, err
+	}
+	// the type is kept, so it is validated against the stored one
+	segment.Type = stored.Type
+	if err := storage.ValidateSegmentUpdate(&segment); err != nil {
+		return nil, err
+	}
+
+	// the rule is replaced and never modified, so the copy keeps the state before the update
+	before := *stored
+	stored.AutoPercent = segment.AutoPercent
+	stored.Percent = segment.Percent
+	stored.Rule = segment.Rule
+	stored.Version++
+
+	if err := s.db.recordAudit(ctx, models.AuditSegmentUpdate, models.AuditResourceSegment, slug, &before, stored, now); err != nil {
+		return nil, err
+	}
+	return s.db.segmentWithUsers(stored, now), nil
+}
+
+func (s *segmentStorage) DeleteSegmentBySlug(ctx context.Context, slug string, ifMatch string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	now := time.Now()
+	slug = s.db.resolveSegmentName(slug, now)
+	segment, ok := s.db.segments[slug]
+	if !ok {
+		return fmt.Errorf("segment with name '%s' %w", slug, storage.ErrNotFound)
+	}
+	if err := storage.CheckETag(fmt.Sprintf("segment with name '%s'", slug), s.db.segmentWithUsers(segment, now), ifMatch); err != nil {
+		return err
+	}
+
+	s.db.expireMemberships(now, func(membership *models.UserSegment) bool {
+		return membership.SegmentName == slug
+	})
+	for userID, memberships := range s.db.memberships {
+		if _, ok := memberships[slug]; ok {
+			s.db.deleteMembership(userID, slug, now)
+		}
+	}
+
+	delete(s.db.segments, slug)
+	s.db.deleteAliases(slug)
+
+	return s.db.recordAudit(ctx, models.AuditSegmentDelete, models.AuditResourceSegment, slug, segment, nil, now)
+}
+
+func (s *segmentStorage) RenameSegment(ctx context.Context, slug, name string, ifMatch string, aliasTTL time.Duration) (*models.Segment, error) {
+	if name == "" {
+		return nil, storage.Invalid("name", "required", "must not be empty")
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	now := time.Now()
+	slug = s.db.resolveSegmentName(slug, now)
+	stored, ok := s.db.segments[slug]
+	if !ok {
+		return nil, fmt.Errorf("segment with name '%s' %w", slug, storage.ErrNotFound)
+	}
+	if err := storage.CheckETag(fmt.Sprintf("segment with name '%s'", slug), s.db.segmentWithUsers(stored, now), ifMatch); err != nil {
+		return nil, err
+	}
+	if name == slug {
+		return nil, storage.Invalid("name", "changed", "must differ from the current one")
+	}
+	if _, ok := s.db.segments[name]; ok {
+		return nil, fmt.Errorf("segment with name '%s' %w", name, storage.ErrAlreadyExists)
+	}
+	delete(s.db.aliases, name)
+
+	before := *stored
+	if len(stored.Variants) > 0 {
+		stored.VariantSeed = storage.VariantSeed(&before)
+	}
+	stored.Name = name
+	stored.Version++
+	delete(s.db.segments, slug)
+	s.db.segments[name] = stored
+
+	for _, memberships := range s.db.memberships {
+		if membership, ok := memberships[slug]; ok {
+			membership.SegmentName = name
+			memberships[name] = membership
+			delete(memberships, slug)
+		}
+	}
+	for _, event := range s.db.history {
+		if event.SegmentName == slug {
+			event.SegmentName = name
+		}
+	}
+	for _, alias := range s.db.aliases {
+		if alias.SegmentName == slug {
+			alias.SegmentName = name
+		}
+	}
+	if aliasTTL > 0 {
+		s.db.aliases[slug] = &models.SegmentAlias{Alias: slug, SegmentName: name, ExpiresAt: now.Add(aliasTTL), CreatedAt: now}
+	}
+
+	if err := s.db.recordAudit(ctx, models.AuditSegmentRename, models.AuditResourceSegment, slug, &before, stored, now); err != nil {
+		return nil, err
+	}
+	return s.db.segmentWithUsers(stored, now), nil
+}
+
+func (s *segmentStorage) GetUsersInSegment(ctx context.Context, slug string, filter storage.UserFilter, params storage.ListParams) (*storage.Page[models.User], error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	now := time.Now()
+	slug = s.db.resolveSegmentName(slug, now)
+	if _, ok := s.db.segments[slug]; !ok {
+		return nil, fmt.Errorf("segment with name '%s' %w", slug, storage.ErrNotFound)
+	}
+
+	filter.Segment = slug
+	page, err := s.db.listUsers(filter, params, now)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, user := range page.Items {
+		copied := *user
+		page.Items[i] = &copied
+	}
+
+	return page, nil
+}
+
+func (s *segmentStorage) CountSegmentUsers(ctx context.Context) (map[string]int64, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	now := time.Now()
+	counts := make(map[string]int64, len(s.db.segments))
+	for name, segment := range s.db.segments {
+		var count int64
+		for id := range s.db.users {
+			if s.db.inSegment(id, segment, now) {
+				count++
+			}
+		}
+		counts[name] = count
+	}
+
+	return counts, nil
+}
+
+func (s *segmentStorage) PreviewRule(ctx context.Context, rule *models.Rule, params storage.ListParams) (*storage.Page[models.User], error) {
+	if rule == nil {
+		return nil, storage.Invalid("rule", "required", "must be set")
+	}
+
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	page, err := s.db.listUsers(storage.UserFilter{Rule: rule}, params, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	for i, user := range page.Items {
+		copied := *user
+		copied.Attributes = maps.Clone(user.Attributes)
+		page.Items[i] = &copied
+	}
+
+	return page, nil
+}
+
+func (s *segmentStorage) AddUserToSegment(ctx context.Context, slug string, userID int64, expiresAt *time.Time, variant string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	now := time.Now()
+	slug = s.db.resolveSegmentName(slug, now)
+	segment, ok := s.db.segments[slug]
+	if !ok {
+		return fmt.Errorf("segment with name '%s' %w", slug, storage.ErrNotFound)
+	}
+	if err := storage.CheckVariant(segment, variant); err != nil {
+		return err
+	}
+
+	if _, ok := s.db.users[userID]; !ok {
+		return fmt.Errorf("user with ID %d %w", userID, storage.ErrNotFound)
+	}
+
+	s.db.expireMemberships(now, func(membership *models.UserSegment) bool {
+		return membership.UserID == userID && membership.SegmentName == slug
+	})
+	before := s.db.auditMembership(userID, slug, now)
+	s.db.addMembership(userID, models.SegmentAssignment{Name: slug, ExpiresAt: expiresAt, Variant: variant}, now)
+
+	after := s.db.auditMembership(userID, slug, now)
+	return s.db.recordAudit(ctx, models.AuditMembershipAdd, models.AuditResourceMembership, storage.MembershipResourceID(slug, userID), before, after, now)
+}
+
+func (s *segmentStorage) DeleteUserFromSegment(ctx context.Context, slug string, userID int64) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	now := time.Now()
+	slug = s.db.resolveSegmentName(slug, now)
+	if _, ok := s.db.segments[slug]; !ok {
+		return fmt.Errorf("segment with name '%s' %w", slug, storage.ErrNotFound)
+	}
+
+	if _, ok := s.db.users[userID]; !ok {
+		return fmt.Errorf("user with ID %d %w", userID, storage.ErrNotFound)
+	}
+
+	s.db.expireMemberships(now, func(membership *models.UserSegment) bool {
+		return membership.UserID == userID && membership.SegmentName == slug
+	})
+	before := s.db.auditMembership(userID, slug, now)
+
+	// the user is not in the segment, nothing happened
+	if !s.db.deleteMembership(userID, slug, now) {
+		return nil
+	}
+
+	return s.db.recordAudit(ctx, models.AuditMembershipRemove, models.AuditResourceMembership, storage.MembershipResourceID(slug, userID), before, nil, now)
+}
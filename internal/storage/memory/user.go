@@ -0,0 +1,333 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/models"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/storage"
+)
+
+type userStorage struct {
+	db *DB
+}
+
+func NewUserStorage(db *DB) (storage.UserStorage, error) {
+	return &userStorage{db: db}, nil
+}
+
+func (s *userStorage) CreateUser(ctx context.Context, user *models.User) error {
+	if err := storage.ValidateUser(user); err != nil {
+		return err
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	if _, ok := s.db.usernames[user.Username]; ok {
+		return fmt.Errorf("user with username '%s' %w", user.Username, storage.ErrAlreadyExists)
+	}
+
+	if user.ID == 0 {
+		user.ID = s.db.lastUserID + 1
+	}
+	if _, ok := s.db.users[user.ID]; ok {
+		return fmt.Errorf("user with ID %d %w", user.ID, storage.ErrAlreadyExists)
+	}
+	if user.ID > s.db.lastUserID {
+		s.db.lastUserID = user.ID
+	}
+
+	now := time.Now()
+	user.CreatedAt = now
+	user.Version = 1
+
+	stored := *user
+	stored.Attributes = maps.Clone(user.Attributes)
+	stored.Segments = nil
+	s.db.users[stored.ID] = &stored
+	s.db.usernames[stored.Username] = stored.ID
+
+	if err := s.db.recordAudit(ctx, models.AuditUserCreate, models.AuditResourceUser, strconv.FormatInt(stored.ID, 10), nil, &stored, now); err != nil {
+		return err
+	}
+
+	for _, name := range sortedKeys(s.db.segments) {
+		if !storage.InSample(s.db.segments[name].AutoPercent) {
+			continue
+		}
+		s.db.addMembership(stored.ID, models.SegmentAssignment{Name: name}, now)
+		after := &models.AuditMembership{Segment: name}
+		if err := s.db.recordAudit(ctx, models.AuditMembershipAdd, models.AuditResourceMembership, storage.MembershipResourceID(name, stored.ID), nil, after, now); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *userStorage) GetUserByID(ctx context.Context, id int64) (*models.User, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	user, ok := s.db.users[id]
+	if !ok {
+		return nil, fmt.Errorf("user with ID %d %w", id, storage.ErrNotFound)
+	}
+	return s.db.userWithSegments(user, time.Now()), nil
+}
+
+func (s *userStorage) GetUserSegments(ctx context.Context, id int64) ([]models.Segment, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	user, ok := s.db.users[id]
+	if !ok {
+		return nil, fmt.Errorf("user with ID %d %w", id, storage.ErrNotFound)
+	}
+	return s.db.userWithSegments(user, time.Now()).Segments, nil
+}
+
+func (s *userStorage) EvaluateUsers(ctx context.Context, ids []int64) ([]models.Evaluation, error) {
+	if err := storage.CheckEvaluateUsers(ids); err != nil {
+		return nil, err
+	}
+
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	now := time.Now()
+	evaluations := make([]models.Evaluation, 0, len(ids))
+	for _, id := range ids {
+		user, ok := s.db.users[id]
+		if !ok {
+			return nil, fmt.Errorf("user with ID %d %w", id, storage.ErrNotFound)
+		}
+		evaluations = append(evaluations, storage.NewEvaluation(id, s.db.userWithSegments(user, now).Segments))
+	}
+
+	return evaluations, nil
+}
+
+func (s *userStorage) GetUsers(ctx context.Context, filter storage.UserFilter, params storage.ListParams) (*storage.Page[models.User], error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	now := time.Now()
+	page, err := s.db.listUsers(filter, params, now)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, user := range page.Items {
+		page.Items[i] = s.db.userWithSegments(user, now)
+	}
+
+	return page, nil
+}
+
+func (s *userStorage) UpdateUser(ctx context.Context, user *models.User, ifMatch string) (*models.User, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	now := time.Now()
+	stored, ok := s.db.users[user.ID]
+	if !ok {
+		return nil, fmt.Errorf("user with ID %d %w", user.ID, storage.ErrNotFound)
+	}
+	if err := storage.CheckETag(fmt.Sprintf("user with ID %d", user.ID), s.db.userWithSegments(stored, now), ifMatch); err != nil {
+		return nil, err
+	}
+	// the attributes map is replaced and never modified, so the copy keeps the state before the update
+	before := *stored
+
+	// only non-zero fields are updated like gorm Updates does
+	if user.Username != "" && user.Username != stored.Username {
+		if _, ok := s.db.usernames[user.Username]; ok {
+			return nil, fmt.Errorf("user with username '%s' %w", user.Username, storage.ErrAlreadyExists)
+		}
+		delete(s.db.usernames, stored.Username)
+		s.db.usernames[user.Username] = stored.ID
+		stored.Username = user.Username
+	}
+	if user.FirstName != "" {
+		stored.FirstName = user.FirstName
+	}
+	if user.LastName != "" {
+		stored.LastName = user.LastName
+	}
+	if user.Attributes != nil {
+		stored.Attributes = maps.Clone(user.Attributes)
+	}
+	stored.Version++
+
+	if err := s.db.recordAudit(ctx, models.AuditUserUpdate, models.AuditResourceUser, strconv.FormatInt(stored.ID, 10), &before, stored, now); err != nil {
+		return nil, err
+	}
+	return s.db.userWithSegments(stored, now), nil
+}
+
+func (s *userStorage) PatchUser(ctx context.Context, id int64, ifMatch string, patch func(user *models.User) error) (*models.User, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	now := time.Now()
+	stored, ok := s.db.users[id]
+	if !ok {
+		return nil, fmt.Errorf("user with ID %d %w", id, storage.ErrNotFound)
+	}
+	if err := storage.CheckETag(fmt.Sprintf("user with ID %d", id), s.db.userWithSegments(stored, now), ifMatch); err != nil {
+		return nil, err
+	}
+
+	user := *stored
+	user.Attributes = maps.Clone(stored.Attributes)
+	if err := patch(&user); err != nil {
+		return nil, err
+	}
+	if err := storage.ValidateUser(&user); err != nil {
+		return nil, err
+	}
+
+	if user.Username != stored.Username {
+		if _, ok := s.db.usernames[user.Username]; ok {
+			return nil, fmt.Errorf("user with username '%s' %w", user.Username, storage.ErrAlreadyExists)
+		}
+		delete(s.db.usernames, stored.Username)
+		s.db.usernames[user.Username] = stored.ID
+	}
+
+	before := *stored
+	stored.FirstName = user.FirstName
+	stored.LastName = user.LastName
+	stored.Username = user.Username
+	stored.Attributes = user.Attributes
+	stored.Version++
+
+	if err := s.db.recordAudit(ctx, models.AuditUserUpdate, models.AuditResourceUser, strconv.FormatInt(id, 10), &before, stored, now); err != nil {
+		return nil, err
+	}
+	return s.db.userWithSegments(stored, now), nil
+}
+
+func (s *userStorage) DeleteUser(ctx context.Context, id int64, ifMatch string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	now := time.Now()
+	user, ok := s.db.users[id]
+	if !ok {
+		return fmt.Errorf("user with ID %d %w", id, storage.ErrNotFound)
+	}
+	if err := storage.CheckETag(fmt.Sprintf("user with ID %d", id), s.db.userWithSegments(user, now), ifMatch); err != nil {
+		return err
+	}
+
+	s.db.expireMemberships(now, func(membership *models.UserSegment) bool {
+		return membership.UserID == id
+	})
+	for segment := range s.db.memberships[id] {
+		s.db.deleteMembership(id, segment, now)
+	}
+
+	delete(s.db.usernames, user.Username)
+	delete(s.db.users, id)
+
+	return s.db.recordAudit(ctx, models.AuditUserDelete, models.AuditResourceUser, strconv.FormatInt(id, 10), user, nil, now)
+}
+
+func (s *userStorage) UpdateUserSegments(ctx context.Context, id int64, segmentsToAdd []models.SegmentAssignment, segmentsToRemove []string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	if _, ok := s.db.users[id]; !ok {
+		return fmt.Errorf("user with ID %d %w", id, storage.ErrNotFound)
+	}
+
+	now := time.Now()
+	segmentsToAddSet := make(map[string]models.SegmentAssignment)
+	for _, segment := range segmentsToAdd {
+		segment.Name = s.db.resolveSegmentName(segment.Name, now)
+		segmentsToAddSet[segment.Name] = segment
+	}
+
+	segmentsToRemoveSet := make(map[string]bool)
+	for _, segment := range segmentsToRemove {
+		segmentsToRemoveSet[s.db.resolveSegmentName(segment, now)] = true
+	}
+
+	// segments in both sets are left untouched
+	for segment := range segmentsToAddSet {
+		if segmentsToRemoveSet[segment] {
+			delete(segmentsToAddSet, segment)
+			delete(segmentsToRemoveSet, segment)
+		}
+	}
+
+	// everything is checked before the first change, so a failed update changes nothing
+	for _, name := range sortedKeys(segmentsToAddSet) {
+		segment, ok := s.db.segments[name]
+		if !ok {
+			return fmt.Errorf("segment with name '%s' %w", name, storage.ErrNotFound)
+		}
+		if err := storage.CheckVariant(segment, segmentsToAddSet[name].Variant); err != nil {
+			return err
+		}
+	}
+
+	before := s.db.auditMemberships(id, now)
+	s.db.expireMemberships(now, func(membership *models.UserSegment) bool {
+		return membership.UserID == id
+	})
+
+	for _, segment := range sortedKeys(segmentsToAddSet) {
+		s.db.addMembership(id, segmentsToAddSet[segment], now)
+	}
+
+	for _, segment := range sortedKeys(segmentsToRemoveSet) {
+		s.db.deleteMembership(id, segment, now)
+	}
+
+	after := s.db.auditMemberships(id, now)
+	return s.db.recordAudit(ctx, models.AuditUserSegmentsUpdate, models.AuditResourceUser, strconv.FormatInt(id, 10), before, after, now)
+}
+
+func (s *userStorage) GetUserSegmentsHistory(ctx context.Context, id int64, from, to time.Time) ([]*models.UserSegmentHistory, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	var history []*models.UserSegmentHistory
+	for _, event := range s.db.history {
+		if event.UserID != id || event.CreatedAt.Before(from) || !event.CreatedAt.Before(to) {
+			continue
+		}
+		e := *event
+		history = append(history, &e)
+	}
+
+	sort.SliceStable(history, func(i, j int) bool {
+		return history[i].CreatedAt.Before(history[j].CreatedAt)
+	})
+
+	return history, nil
+}
+
+func (s *userStorage) DeleteExpiredSegments(ctx context.Context, now time.Time) (int64, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	return s.db.expireMemberships(now, func(*models.UserSegment) bool { return true }), nil
+}
+
+func sortedKeys[V any](set map[string]V) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,69 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// resolveSegmentName returns the name of the segment the slug is an active alias of, the slug itself otherwise.
+func resolveSegmentName(tx *gorm.DB, slug string) (string, error) {
+	names, err := resolveSegmentNames(tx, []string{slug})
+	if err != nil {
+		return "", err
+	}
+	return names[slug], nil
+}
+
+// resolveSegmentNames maps every slug to the name of the segment it is an active alias of, or to itself.
+func resolveSegmentNames(tx *gorm.DB, slugs []string) (map[string]string, error) {
+	names := make(map[string]string, len(slugs))
+	for _, slug := range slugs {
+		names[slug] = slug
+	}
+	if len(slugs) == 0 {
+		return names, nil
+	}
+
+	var aliases []*models.SegmentAlias
+	if err := tx.Where("alias IN ? AND expires_at > ?", slugs, time.Now()).Find(&aliases).Error; err != nil {
+		return nil, fmt.Errorf("failed to resolve segment aliases: %w", mapError(err))
+	}
+	for _, alias := range aliases {
+		names[alias.Alias] = alias.SegmentName
+	}
+	return names, nil
+}
+
+// takeOverAlias deletes the alias with the name of a segment being created or renamed, so the name resolves to it.
+func takeOverAlias(tx *gorm.DB, name string) error {
+	if err := tx.Where("alias = ?", name).Delete(&models.SegmentAlias{}).Error; err != nil {
+		return fmt.Errorf("failed to delete segment alias: %w", mapError(err))
+	}
+	return nil
+}
+
+// createAlias makes the former name of a renamed segment resolve to it until expiresAt,
+// replacing an expired alias with the same name.
+func createAlias(tx *gorm.DB, alias, name string, expiresAt time.Time) error {
+	err := tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "alias"}},
+		DoUpdates: clause.AssignmentColumns([]string{"segment_name", "expires_at", "created_at"}),
+	}).Create(&models.SegmentAlias{Alias: alias, SegmentName: name, ExpiresAt: expiresAt, CreatedAt: time.Now()}).Error
+	if err != nil {
+		return fmt.Errorf("failed to create segment alias: %w", mapError(err))
+	}
+	return nil
+}
+
+func (s *segmentStorage) DeleteExpiredAliases(ctx context.Context, now time.Time) (int64, error) {
+	result := s.db.WithContext(ctx).Where("expires_at <= ?", now).Delete(&models.SegmentAlias{})
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to delete expired segment aliases: %w", mapError(result.Error))
+	}
+	return result.RowsAffected, nil
+}
@@ -0,0 +1,60 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/models"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/storage"
+	"gorm.io/gorm"
+)
+
+type apiKeyStorage struct {
+	db *gorm.DB
+}
+
+func NewAPIKeyStorage(db *gorm.DB) (storage.APIKeyStorage, error) {
+	return &apiKeyStorage{db: db}, nil
+}
+
+func (s *apiKeyStorage) CreateAPIKey(ctx context.Context, key *models.APIKey) error {
+	if err := storage.ValidateAPIKey(key); err != nil {
+		return err
+	}
+
+	if err := s.db.WithContext(ctx).Create(key).Error; err != nil {
+		return fmt.Errorf("failed to create API key: %w", mapError(err))
+	}
+	return nil
+}
+
+func (s *apiKeyStorage) GetAPIKeyByHash(ctx context.Context, hash string) (*models.APIKey, error) {
+	key := &models.APIKey{}
+	if err := s.db.WithContext(ctx).Where("hash = ?", hash).First(key).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("API key %w", storage.ErrNotFound)
+		}
+		return nil, fmt.Errorf("failed to get API key: %w", mapError(err))
+	}
+	return key, nil
+}
+
+func (s *apiKeyStorage) GetAPIKeys(ctx context.Context) ([]*models.APIKey, error) {
+	var keys []*models.APIKey
+	if err := s.db.WithContext(ctx).Order("id").Find(&keys).Error; err != nil {
+		return nil, fmt.Errorf("failed to get API keys: %w", mapError(err))
+	}
+	return keys, nil
+}
+
+func (s *apiKeyStorage) DeleteAPIKey(ctx context.Context, id int64) error {
+	result := s.db.WithContext(ctx).Delete(&models.APIKey{}, id)
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete API key: %w", mapError(result.Error))
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("API key with ID %d %w", id, storage.ErrNotFound)
+	}
+	return nil
+}
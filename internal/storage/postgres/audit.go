@@ -0,0 +1,178 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/models"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/storage"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type auditStorage struct {
+	db *gorm.DB
+}
+
+func NewAuditStorage(db *gorm.DB) (storage.AuditStorage, error) {
+	return &auditStorage{db: db}, nil
+}
+
+// recordAudit writes the audit entry of a change in the transaction of the change,
+// so the change is rolled back when the entry cannot be written.
+func recordAudit(ctx context.Context, tx *gorm.DB, action models.AuditAction, resourceType, resourceID string, before, after any) error {
+	entry, err := storage.NewAuditEntry(ctx, action, resourceType, resourceID, before, after)
+	if err != nil {
+		return err
+	}
+	if err := tx.Create(entry).Error; err != nil {
+		return fmt.Errorf("failed to record audit entry: %w", mapError(err))
+	}
+	return nil
+}
+
+// lockUser returns the user locked until the end of the transaction, so its before state of the audit entry
+// is not changed by a concurrent transaction.
+func lockUser(tx *gorm.DB, id int64) (*models.User, error) {
+	user := &models.User{}
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(user, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("user with ID %d %w", id, storage.ErrNotFound)
+		}
+		return nil, fmt.Errorf("failed to get user by ID: %w", mapError(err))
+	}
+	return user, nil
+}
+
+// checkUserETag compares ifMatch with the entity tag of the locked user with its segments.
+func checkUserETag(tx *gorm.DB, user *models.User, ifMatch string) error {
+	if ifMatch == "" {
+		return nil
+	}
+	current := *user
+	if err := preloadSegments(tx, &current); err != nil {
+		return err
+	}
+	return storage.CheckETag(fmt.Sprintf("user with ID %d", user.ID), &current, ifMatch)
+}
+
+// lockSegment returns the segment locked until the end of the transaction.
+func lockSegment(tx *gorm.DB, name string) (*models.Segment, error) {
+	segment := &models.Segment{}
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("name = ?", name).First(segment).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("segment with name '%s' %w", name, storage.ErrNotFound)
+		}
+		return nil, fmt.Errorf("failed to get segment by name: %w", mapError(err))
+	}
+	return segment, nil
+}
+
+// checkSegmentETag compares ifMatch with the entity tag of the locked segment with its users and variant counts.
+func checkSegmentETag(tx *gorm.DB, segment *models.Segment, ifMatch string) error {
+	if ifMatch == "" {
+		return nil
+	}
+	current := *segment
+	if err := preloadUsers(tx, &current); err != nil {
+		return err
+	}
+	if err := countVariants(tx, &current); err != nil {
+		return err
+	}
+	return storage.CheckETag(fmt.Sprintf("segment with name '%s'", segment.Name), &current, ifMatch)
+}
+
+// auditMemberships returns the explicit active memberships of the user matching the condition sorted by segment.
+func auditMemberships(tx *gorm.DB, userID int64, condition string, args ...any) ([]models.AuditMembership, error) {
+	var memberships []*models.UserSegment
+	query := tx.Where("user_id = ?", userID).Where(activeMembership)
+	if condition != "" {
+		query = query.Where(condition, args...)
+	}
+	if err := query.Order("segment_name").Find(&memberships).Error; err != nil {
+		return nil, fmt.Errorf("failed to get user segments: %w", mapError(err))
+	}
+
+	result := make([]models.AuditMembership, 0, len(memberships))
+	for _, membership := range memberships {
+		result = append(result, models.AuditMembership{
+			Segment:   membership.SegmentName,
+			ExpiresAt: membership.ExpiresAt,
+			Variant:   membership.Variant,
+		})
+	}
+	return result, nil
+}
+
+// auditMembership returns the explicit active membership of the user in the segment, nil when there is none.
+func auditMembership(tx *gorm.DB, userID int64, segment string) (*models.AuditMembership, error) {
+	memberships, err := auditMemberships(tx, userID, "segment_name = ?", segment)
+	if err != nil || len(memberships) == 0 {
+		return nil, err
+	}
+	return &memberships[0], nil
+}
+
+func (s *auditStorage) GetAuditEntries(ctx context.Context, filter storage.AuditFilter, params storage.ListParams) (*storage.Page[models.AuditEntry], error) {
+	cursor, err := params.Normalize(storage.SortByID)
+	if err != nil {
+		return nil, err
+	}
+
+	query := s.db.WithContext(ctx).Model(&models.AuditEntry{})
+	if filter.Actor != "" {
+		query = query.Where("actor = ?", filter.Actor)
+	}
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if filter.ResourceType != "" {
+		query = query.Where("resource_type = ?", filter.ResourceType)
+	}
+	if filter.ResourceID != "" {
+		query = query.Where("resource_id = ?", filter.ResourceID)
+	}
+	if filter.From != nil {
+		query = query.Where("created_at >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		query = query.Where("created_at < ?", *filter.To)
+	}
+	query = query.Session(&gorm.Session{})
+
+	page := &storage.Page[models.AuditEntry]{}
+	if params.WithTotal {
+		var total int64
+		if err := query.Count(&total).Error; err != nil {
+			return nil, fmt.Errorf("failed to count audit entries: %w", mapError(err))
+		}
+		page.Total = &total
+	}
+
+	op, direction := keysetOrder(params.Desc)
+	if cursor != nil {
+		id, err := strconv.ParseInt(cursor.Key, 10, 64)
+		if err != nil {
+			return nil, storage.ErrInvalidCursor
+		}
+		query = query.Where("id "+op+" ?", id)
+	}
+
+	var entries []*models.AuditEntry
+	result := query.Order("id " + direction).Limit(params.Limit + 1).Find(&entries)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to get audit entries: %w", mapError(result.Error))
+	}
+
+	if len(entries) > params.Limit {
+		entries = entries[:params.Limit]
+		last := entries[len(entries)-1]
+		page.NextCursor = storage.Cursor{Sort: params.Sort, Desc: params.Desc, Key: strconv.FormatInt(last.ID, 10)}.Encode()
+	}
+	page.Items = entries
+
+	return page, nil
+}
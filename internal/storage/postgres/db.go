@@ -2,7 +2,6 @@ package postgres
 
 import (
 	"fmt"
-	"log"
 
 	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/config"
 	"gorm.io/driver/postgres"
@@ -19,12 +18,14 @@ func NewConnection(conf config.Config) (*gorm.DB, error) {
 		conf.Database.Port,
 	)
 
-	log.Println(connString)
-
-	db, err := gorm.Open(postgres.Open(connString), &gorm.Config{})
+	db, err := gorm.Open(postgres.Open(connString), &gorm.Config{Logger: gormLogger{}})
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
 
+	if err := db.Use(&tracingPlugin{}); err != nil {
+		return nil, fmt.Errorf("failed to register tracing: %w", err)
+	}
+
 	return db, nil
 }
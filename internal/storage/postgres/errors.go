@@ -0,0 +1,47 @@
+package postgres
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/storage"
+	"gorm.io/gorm"
+)
+
+// PostgreSQL error codes, see https://www.postgresql.org/docs/current/errcodes-appendix.html
+const (
+	stringDataRightTruncation = "22001"
+	notNullViolation          = "23502"
+	foreignKeyViolation       = "23503"
+	uniqueViolation           = "23505"
+	checkViolation            = "23514"
+	undefinedTable            = "42P01"
+)
+
+// mapError translates gorm and PostgreSQL errors into storage errors, other errors are returned as is.
+func mapError(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("record %w", storage.ErrNotFound)
+	}
+
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return err
+	}
+
+	switch pgErr.Code {
+	case uniqueViolation:
+		return fmt.Errorf("%w: %s", storage.ErrAlreadyExists, pgErr.Message)
+	case foreignKeyViolation:
+		return fmt.Errorf("%w: %s", storage.ErrConflict, pgErr.Message)
+	case stringDataRightTruncation:
+		return storage.Invalid(pgErr.ColumnName, "max", pgErr.Message)
+	case notNullViolation:
+		return storage.Invalid(pgErr.ColumnName, "required", pgErr.Message)
+	case checkViolation:
+		return storage.Invalid(pgErr.ColumnName, pgErr.ConstraintName, pgErr.Message)
+	default:
+		return err
+	}
+}
@@ -0,0 +1,102 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/models"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/storage"
+)
+
+// evaluatedMembership is an active membership with the segment columns needed to resolve its variant.
+type evaluatedMembership struct {
+	UserID      int64
+	SegmentName string
+	ExpiresAt   *time.Time
+	Variant     *string
+	Salt        string
+	Variants    []models.Variant `gorm:"serializer:json"`
+	VariantSeed string
+}
+
+// EvaluateUsers reads only the columns needed to resolve segment slugs and variants:
+// memberships joined with their segments and the few segments with computed members.
+func (s *userStorage) EvaluateUsers(ctx context.Context, ids []int64) ([]models.Evaluation, error) {
+	if err := storage.CheckEvaluateUsers(ids); err != nil {
+		return nil, err
+	}
+
+	db := s.db.WithContext(ctx)
+
+	var users []*models.User
+	if err := db.Select("id", "attributes").Where("id IN ?", ids).Find(&users).Error; err != nil {
+		return nil, fmt.Errorf("failed to evaluate users: %w", mapError(err))
+	}
+
+	usersByID := make(map[int64]*models.User, len(users))
+	for _, user := range users {
+		usersByID[user.ID] = user
+	}
+	for _, id := range ids {
+		if _, ok := usersByID[id]; !ok {
+			return nil, fmt.Errorf("user with ID %d %w", id, storage.ErrNotFound)
+		}
+	}
+
+	var memberships []evaluatedMembership
+	err := db.Table("user_segments").
+		Select("user_segments.user_id, user_segments.segment_name, user_segments.expires_at, user_segments.variant, segment.salt, segment.variants, segment.variant_seed").
+		Joins("JOIN segment ON segment.name = user_segments.segment_name").
+		Where("user_segments.user_id IN ?", ids).
+		Where(activeMembership).
+		Find(&memberships).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to evaluate users: %w", mapError(err))
+	}
+
+	var computed []*models.Segment
+	err = db.Select("name", "type", "salt", "percent", "variants", "variant_seed", "rule").
+		Where("type = ?", models.SegmentTypeExperiment).
+		Or("rule IS NOT NULL").
+		Find(&computed).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to evaluate users: %w", mapError(err))
+	}
+
+	segments := make(map[int64][]models.Segment, len(users))
+	explicit := make(map[int64]map[string]bool, len(users))
+	for _, membership := range memberships {
+		segment := models.Segment{
+			Name:        membership.SegmentName,
+			Salt:        membership.Salt,
+			Variants:    membership.Variants,
+			VariantSeed: membership.VariantSeed,
+			ExpiresAt:   membership.ExpiresAt,
+		}
+		segment.Variant = storage.AssignVariant(&segment, membership.UserID, membership.Variant)
+		segments[membership.UserID] = append(segments[membership.UserID], segment)
+
+		if explicit[membership.UserID] == nil {
+			explicit[membership.UserID] = make(map[string]bool)
+		}
+		explicit[membership.UserID][membership.SegmentName] = true
+	}
+
+	evaluations := make([]models.Evaluation, 0, len(ids))
+	for _, id := range ids {
+		user := usersByID[id]
+		for _, segment := range computed {
+			if explicit[id][segment.Name] || !storage.IsComputedMember(segment, user) {
+				continue
+			}
+			segments[id] = append(segments[id], models.Segment{
+				Name:    segment.Name,
+				Variant: storage.AssignVariant(segment, id, nil),
+			})
+		}
+		evaluations = append(evaluations, storage.NewEvaluation(id, segments[id]))
+	}
+
+	return evaluations, nil
+}
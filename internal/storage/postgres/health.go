@@ -0,0 +1,42 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// Ping returns a check of the database being reachable.
+func Ping(db *gorm.DB) func(context.Context) error {
+	return func(ctx context.Context) error {
+		sqlDB, err := db.DB()
+		if err != nil {
+			return err
+		}
+		if err := sqlDB.PingContext(ctx); err != nil {
+			return fmt.Errorf("failed to ping database: %w", mapError(err))
+		}
+		return nil
+	}
+}
+
+// CheckApplied fails while any of the migrations is pending, e.g. when auto migration is disabled
+// and the schema is older than the service.
+func (m *Migrator) CheckApplied(ctx context.Context) error {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get migrations status: %w", err)
+	}
+
+	var pending int
+	for _, status := range statuses {
+		if status.AppliedAt == nil {
+			pending++
+		}
+	}
+	if pending > 0 {
+		return fmt.Errorf("%d migrations are pending", pending)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package postgres
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/models"
+	"gorm.io/gorm"
+)
+
+// recordHistory writes one history event per segment. It must be called with the
+// transaction that changes user_segments so the event is committed together with the change.
+func recordHistory(tx *gorm.DB, userID int64, segments []string, op models.Operation) error {
+	if len(segments) == 0 {
+		return nil
+	}
+
+	events := make([]*models.UserSegmentHistory, 0, len(segments))
+	for _, segment := range segments {
+		events = append(events, &models.UserSegmentHistory{
+			UserID:      userID,
+			SegmentName: segment,
+			Operation:   op,
+		})
+	}
+
+	if err := tx.Create(&events).Error; err != nil {
+		return fmt.Errorf("failed to record segments history: %w", mapError(err))
+	}
+	return nil
+}
+
+// recordRemovalHistory writes remove events for all memberships matched by the condition
+// before they are deleted, e.g. when a user or a segment is deleted with cascade.
+func recordRemovalHistory(tx *gorm.DB, query string, args ...any) error {
+	result := tx.Exec(
+		"INSERT INTO user_segments_history (user_id, segment_name, operation) "+
+			"SELECT user_id, segment_name, ? FROM user_segments WHERE "+query,
+		append([]any{models.OperationRemove}, args...)...,
+	)
+	if result.Error != nil {
+		return fmt.Errorf("failed to record segments history: %w", mapError(result.Error))
+	}
+	return nil
+}
+
+// expireMemberships removes memberships matched by the condition whose TTL has passed
+// and records their removal at the expiration time. It returns the number of removed memberships.
+func expireMemberships(tx *gorm.DB, now time.Time, query string, args ...any) (int64, error) {
+	values := make([]any, 0, len(args)+2)
+	values = append(values, now)
+	values = append(values, args...)
+	values = append(values, models.OperationRemove)
+
+	result := tx.Exec(
+		"WITH expired AS ("+
+			"DELETE FROM user_segments WHERE expires_at <= ? AND "+query+
+			" RETURNING user_id, segment_name, expires_at) "+
+			"INSERT INTO user_segments_history (user_id, segment_name, operation, created_at) "+
+			"SELECT user_id, segment_name, ?, expires_at FROM expired",
+		values...,
+	)
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to delete expired segments: %w", mapError(result.Error))
+	}
+	return result.RowsAffected, nil
+}
@@ -0,0 +1,79 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/models"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/storage"
+	"gorm.io/gorm"
+)
+
+type idempotencyStorage struct {
+	db *gorm.DB
+}
+
+func NewIdempotencyStorage(db *gorm.DB) (storage.IdempotencyStorage, error) {
+	return &idempotencyStorage{db: db}, nil
+}
+
+// ReserveIdempotencyKey inserts the key or takes over an expired one with a single statement,
+// so of concurrent requests with the same key exactly one reserves it.
+func (s *idempotencyStorage) ReserveIdempotencyKey(ctx context.Context, key *models.IdempotencyKey) (*models.IdempotencyKey, error) {
+	db := s.db.WithContext(ctx)
+
+	var reserved []bool
+	result := db.Raw(
+		"INSERT INTO idempotency_keys (scope, key, request_hash, expires_at) VALUES (?, ?, ?, ?) "+
+			"ON CONFLICT (scope, key) DO UPDATE SET request_hash = EXCLUDED.request_hash, status_code = 0, "+
+			"headers = '{}', body = NULL, created_at = now(), expires_at = EXCLUDED.expires_at "+
+			"WHERE idempotency_keys.expires_at <= now() "+
+			"RETURNING true",
+		key.Scope, key.Key, key.RequestHash, key.ExpiresAt,
+	).Scan(&reserved)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to reserve idempotency key: %w", mapError(result.Error))
+	}
+	if len(reserved) > 0 {
+		return nil, nil
+	}
+
+	existing := &models.IdempotencyKey{}
+	if err := db.Where("scope = ? AND key = ?", key.Scope, key.Key).First(existing).Error; err != nil {
+		return nil, fmt.Errorf("failed to get idempotency key: %w", mapError(err))
+	}
+	return existing, nil
+}
+
+func (s *idempotencyStorage) CompleteIdempotencyKey(ctx context.Context, key *models.IdempotencyKey) error {
+	result := s.db.WithContext(ctx).Model(&models.IdempotencyKey{}).
+		Where("scope = ? AND key = ? AND status_code = 0", key.Scope, key.Key).
+		Select("status_code", "headers", "body").
+		Updates(&models.IdempotencyKey{StatusCode: key.StatusCode, Headers: key.Headers, Body: key.Body})
+	if result.Error != nil {
+		return fmt.Errorf("failed to complete idempotency key: %w", mapError(result.Error))
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("reserved idempotency key '%s' %w", key.Key, storage.ErrNotFound)
+	}
+	return nil
+}
+
+func (s *idempotencyStorage) ReleaseIdempotencyKey(ctx context.Context, scope, key string) error {
+	result := s.db.WithContext(ctx).
+		Where("scope = ? AND key = ? AND status_code = 0", scope, key).
+		Delete(&models.IdempotencyKey{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to release idempotency key: %w", mapError(result.Error))
+	}
+	return nil
+}
+
+func (s *idempotencyStorage) DeleteExpiredIdempotencyKeys(ctx context.Context, now time.Time) (int64, error) {
+	result := s.db.WithContext(ctx).Where("expires_at <= ?", now).Delete(&models.IdempotencyKey{})
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to delete expired idempotency keys: %w", mapError(result.Error))
+	}
+	return result.RowsAffected, nil
+}
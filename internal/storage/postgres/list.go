@@ -0,0 +1,196 @@
+package postgres
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/models"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/storage"
+	"gorm.io/gorm"
+)
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// prefixPattern returns a LIKE pattern matching strings starting with the prefix.
+func prefixPattern(prefix string) string {
+	return likeEscaper.Replace(prefix) + "%"
+}
+
+// keysetOrder returns the comparison operator of the cursor condition and the order direction.
+func keysetOrder(desc bool) (string, string) {
+	if desc {
+		return "<", "DESC"
+	}
+	return ">", "ASC"
+}
+
+// listUsers returns a page of users without their segments.
+func listUsers(db *gorm.DB, filter storage.UserFilter, params storage.ListParams) (*storage.Page[models.User], error) {
+	cursor, err := params.Normalize(storage.SortByID, storage.SortByUsername, storage.SortByCreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if filter.Rule != nil {
+		if err := storage.ValidateRule(filter.Rule); err != nil {
+			return nil, err
+		}
+	}
+
+	query := db.Model(&models.User{})
+	if filter.UsernamePrefix != "" {
+		query = query.Where("users.username LIKE ?", prefixPattern(filter.UsernamePrefix))
+	}
+	if filter.CreatedAfter != nil {
+		query = query.Where("users.created_at > ?", *filter.CreatedAfter)
+	}
+	if filter.Segment != "" {
+		var segments []*models.Segment
+		if err := db.Where("name = ?", filter.Segment).Limit(1).Find(&segments).Error; err != nil {
+			return nil, fmt.Errorf("failed to get segment by name: %w", mapError(err))
+		}
+
+		var segment *models.Segment
+		if len(segments) > 0 {
+			segment = segments[0]
+		}
+		condition, args := segmentMembers(filter.Segment, segment)
+		query = query.Where(condition, args...)
+	}
+	if filter.Rule != nil {
+		condition, args := ruleCondition(filter.Rule)
+		query = query.Where(condition, args...)
+	}
+	query = query.Session(&gorm.Session{})
+
+	page := &storage.Page[models.User]{}
+	if params.WithTotal {
+		var total int64
+		if err := query.Count(&total).Error; err != nil {
+			return nil, fmt.Errorf("failed to count users: %w", mapError(err))
+		}
+		page.Total = &total
+	}
+
+	op, direction := keysetOrder(params.Desc)
+	column := "users." + params.Sort
+
+	if cursor != nil {
+		id, err := strconv.ParseInt(cursor.Key, 10, 64)
+		if err != nil {
+			return nil, storage.ErrInvalidCursor
+		}
+
+		switch params.Sort {
+		case storage.SortByID:
+			query = query.Where("users.id "+op+" ?", id)
+		case storage.SortByCreatedAt:
+			createdAt, err := time.Parse(time.RFC3339Nano, cursor.Value)
+			if err != nil {
+				return nil, storage.ErrInvalidCursor
+			}
+			query = query.Where("(users.created_at, users.id) "+op+" (?, ?)", createdAt, id)
+		default:
+			query = query.Where("("+column+", users.id) "+op+" (?, ?)", cursor.Value, id)
+		}
+	}
+
+	query = query.Order(column + " " + direction)
+	if params.Sort != storage.SortByID {
+		query = query.Order("users.id " + direction)
+	}
+
+	var users []*models.User
+	result := query.Limit(params.Limit + 1).Find(&users)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to get users: %w", mapError(result.Error))
+	}
+
+	if len(users) > params.Limit {
+		users = users[:params.Limit]
+		page.NextCursor = userCursor(users[len(users)-1], params).Encode()
+	}
+	page.Items = users
+
+	return page, nil
+}
+
+func userCursor(user *models.User, params storage.ListParams) storage.Cursor {
+	cursor := storage.Cursor{Sort: params.Sort, Desc: params.Desc, Key: strconv.FormatInt(user.ID, 10)}
+	switch params.Sort {
+	case storage.SortByUsername:
+		cursor.Value = user.Username
+	case storage.SortByCreatedAt:
+		cursor.Value = user.CreatedAt.Format(time.RFC3339Nano)
+	}
+	return cursor
+}
+
+// listSegments returns a page of segments without their users.
+func listSegments(db *gorm.DB, filter storage.SegmentFilter, params storage.ListParams) (*storage.Page[models.Segment], error) {
+	cursor, err := params.Normalize(storage.SortByName, storage.SortByCreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	query := db.Model(&models.Segment{})
+	if filter.NamePrefix != "" {
+		query = query.Where("name LIKE ?", prefixPattern(filter.NamePrefix))
+	}
+	if filter.CreatedAfter != nil {
+		query = query.Where("created_at > ?", *filter.CreatedAfter)
+	}
+	query = query.Session(&gorm.Session{})
+
+	page := &storage.Page[models.Segment]{}
+	if params.WithTotal {
+		var total int64
+		if err := query.Count(&total).Error; err != nil {
+			return nil, fmt.Errorf("failed to count segments: %w", mapError(err))
+		}
+		page.Total = &total
+	}
+
+	op, direction := keysetOrder(params.Desc)
+
+	if cursor != nil {
+		switch params.Sort {
+		case storage.SortByName:
+			query = query.Where("name "+op+" ?", cursor.Key)
+		case storage.SortByCreatedAt:
+			createdAt, err := time.Parse(time.RFC3339Nano, cursor.Value)
+			if err != nil {
+				return nil, storage.ErrInvalidCursor
+			}
+			query = query.Where("(created_at, name) "+op+" (?, ?)", createdAt, cursor.Key)
+		}
+	}
+
+	query = query.Order(params.Sort + " " + direction)
+	if params.Sort != storage.SortByName {
+		query = query.Order("name " + direction)
+	}
+
+	var segments []*models.Segment
+	result := query.Limit(params.Limit + 1).Find(&segments)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to get segments: %w", mapError(result.Error))
+	}
+
+	if len(segments) > params.Limit {
+		segments = segments[:params.Limit]
+		page.NextCursor = segmentCursor(segments[len(segments)-1], params).Encode()
+	}
+	page.Items = segments
+
+	return page, nil
+}
+
+func segmentCursor(segment *models.Segment, params storage.ListParams) storage.Cursor {
+	cursor := storage.Cursor{Sort: params.Sort, Desc: params.Desc, Key: segment.Name}
+	if params.Sort == storage.SortByCreatedAt {
+		cursor.Value = segment.CreatedAt.Format(time.RFC3339Nano)
+	}
+	return cursor
+}
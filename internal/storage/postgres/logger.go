@@ -0,0 +1,61 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/logger"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+// slowQueryThreshold is the duration of a query logged as slow.
+const slowQueryThreshold = 200 * time.Millisecond
+
+// gormLogger writes gorm messages to the request logger of the context: failed queries as errors,
+// slow ones as warnings and the rest at debug level. Queries are logged without their parameters,
+// which hold user data.
+type gormLogger struct{}
+
+// LogMode is ignored, the level of the request logger decides what is written.
+func (l gormLogger) LogMode(gormlogger.LogLevel) gormlogger.Interface {
+	return l
+}
+
+func (gormLogger) Info(ctx context.Context, msg string, args ...any) {
+	logger.FromContext(ctx).InfoContext(ctx, fmt.Sprintf(msg, args...))
+}
+
+func (gormLogger) Warn(ctx context.Context, msg string, args ...any) {
+	logger.FromContext(ctx).WarnContext(ctx, fmt.Sprintf(msg, args...))
+}
+
+func (gormLogger) Error(ctx context.Context, msg string, args ...any) {
+	logger.FromContext(ctx).ErrorContext(ctx, fmt.Sprintf(msg, args...))
+}
+
+func (gormLogger) Trace(ctx context.Context, begin time.Time, fc func() (sql string, rowsAffected int64), err error) {
+	log := logger.FromContext(ctx)
+	elapsed := time.Since(begin)
+
+	switch {
+	case err != nil && !errors.Is(err, gorm.ErrRecordNotFound):
+		sql, rows := fc()
+		log.ErrorContext(ctx, "query failed",
+			slog.String("sql", sql), slog.Int64("rows", rows), slog.Duration("elapsed", elapsed), slog.Any("error", err))
+	case elapsed > slowQueryThreshold:
+		sql, rows := fc()
+		log.WarnContext(ctx, "slow query", slog.String("sql", sql), slog.Int64("rows", rows), slog.Duration("elapsed", elapsed))
+	case log.Enabled(ctx, slog.LevelDebug):
+		sql, rows := fc()
+		log.DebugContext(ctx, "query", slog.String("sql", sql), slog.Int64("rows", rows), slog.Duration("elapsed", elapsed))
+	}
+}
+
+// ParamsFilter drops the parameters of a logged query, so it is written with placeholders.
+func (gormLogger) ParamsFilter(_ context.Context, sql string, _ ...any) (string, []any) {
+	return sql, nil
+}
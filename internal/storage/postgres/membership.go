@@ -0,0 +1,276 @@
+package postgres
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/models"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/storage"
+	"gorm.io/gorm"
+)
+
+// activeMembership hides memberships whose TTL has passed but which are not yet removed by the reaper.
+const activeMembership = "(user_segments.expires_at IS NULL OR user_segments.expires_at > now())"
+
+// experimentBucket matches users of the users table whose bucket computed like storage.Bucket
+// with the salt is below the number of the experiment buckets.
+var experimentBucket = fmt.Sprintf(
+	"('x' || substr(md5(? || ':' || users.id::text), 1, 8))::bit(32)::bigint %% %d < ?", storage.Buckets,
+)
+
+// variantPoint is the point of users of the users table on the scale of the summed variant weights,
+// computed like storage.AssignVariant does from the variant seed.
+const variantPoint = "('x' || substr(md5(? || ':' || users.id::text), 1, 8))::bit(32)::bigint % ?"
+
+// computedMembers returns a condition on the users table matching the same users as
+// storage.IsComputedMember does, ok is false when the segment has no computed members.
+func computedMembers(segment *models.Segment) (condition string, args []any, ok bool) {
+	if !storage.HasComputedMembers(segment) {
+		return "", nil, false
+	}
+
+	var conditions []string
+	if segment.Type == models.SegmentTypeExperiment {
+		conditions = append(conditions, experimentBucket)
+		args = append(args, segment.Salt, storage.BucketsBelow(segment.Percent))
+	}
+	if segment.Rule != nil {
+		condition, ruleArgs := ruleCondition(segment.Rule)
+		conditions = append(conditions, condition)
+		args = append(args, ruleArgs...)
+	}
+
+	return "(" + strings.Join(conditions, " AND ") + ")", args, true
+}
+
+// segmentMembers returns a condition on the users table matching the users having an active membership
+// in the segment with the name or being its computed members, the segment is nil when it does not exist.
+func segmentMembers(name string, segment *models.Segment) (string, []any) {
+	condition := "EXISTS (SELECT 1 FROM user_segments WHERE user_segments.user_id = users.id " +
+		"AND user_segments.segment_name = ? AND " + activeMembership + ")"
+	args := []any{name}
+	if segment != nil {
+		if computed, computedArgs, ok := computedMembers(segment); ok {
+			condition += " OR " + computed
+			args = append(args, computedArgs...)
+		}
+	}
+	return "(" + condition + ")", args
+}
+
+// explicitVariant returns the value of the user_segments variant column, NULL for the hashed variant.
+func explicitVariant(variant string) *string {
+	if variant == "" {
+		return nil
+	}
+	return &variant
+}
+
+// preloadSegments loads active segments of the users, including segments the users are computed members of.
+// The users must be loaded with their attributes.
+func preloadSegments(db *gorm.DB, users ...*models.User) error {
+	if len(users) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, 0, len(users))
+	for _, user := range users {
+		ids = append(ids, user.ID)
+	}
+
+	var memberships []*models.UserSegment
+	if err := db.Where("user_id IN ?", ids).Where(activeMembership).Find(&memberships).Error; err != nil {
+		return fmt.Errorf("failed to get user segments: %w", mapError(err))
+	}
+
+	names := make([]string, 0, len(memberships))
+	for _, membership := range memberships {
+		names = append(names, membership.SegmentName)
+	}
+
+	// segments with computed members are few, so they are all loaded and matched here
+	var segments []*models.Segment
+	err := db.Where("name IN ?", names).
+		Or("type = ?", models.SegmentTypeExperiment).
+		Or("rule IS NOT NULL").
+		Find(&segments).Error
+	if err != nil {
+		return fmt.Errorf("failed to get user segments: %w", mapError(err))
+	}
+
+	segmentsByName := make(map[string]*models.Segment, len(segments))
+	for _, segment := range segments {
+		segmentsByName[segment.Name] = segment
+	}
+
+	usersByID := make(map[int64]*models.User, len(users))
+	for _, user := range users {
+		usersByID[user.ID] = user
+	}
+
+	explicit := make(map[int64]map[string]bool, len(users))
+	for _, membership := range memberships {
+		segment, ok := segmentsByName[membership.SegmentName]
+		if !ok {
+			continue
+		}
+		user := usersByID[membership.UserID]
+
+		userSegment := *segment
+		userSegment.ExpiresAt = membership.ExpiresAt
+		userSegment.Variant = storage.AssignVariant(segment, user.ID, membership.Variant)
+
+		user.Segments = append(user.Segments, userSegment)
+
+		if explicit[user.ID] == nil {
+			explicit[user.ID] = make(map[string]bool)
+		}
+		explicit[user.ID][segment.Name] = true
+	}
+
+	for _, user := range users {
+		for _, segment := range segments {
+			if explicit[user.ID][segment.Name] {
+				continue
+			}
+			if storage.IsComputedMember(segment, user) {
+				userSegment := *segment
+				userSegment.Variant = storage.AssignVariant(segment, user.ID, nil)
+				user.Segments = append(user.Segments, userSegment)
+			}
+		}
+		sort.Slice(user.Segments, func(i, j int) bool {
+			return user.Segments[i].Name < user.Segments[j].Name
+		})
+	}
+
+	return nil
+}
+
+// preloadUsers loads users having an active membership in the segments or being their computed members.
+func preloadUsers(db *gorm.DB, segments ...*models.Segment) error {
+	if len(segments) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(segments))
+	for _, segment := range segments {
+		names = append(names, segment.Name)
+	}
+
+	var memberships []*models.UserSegment
+	if err := db.Where("segment_name IN ?", names).Where(activeMembership).Find(&memberships).Error; err != nil {
+		return fmt.Errorf("failed to get segment users: %w", mapError(err))
+	}
+
+	for _, segment := range segments {
+		condition, args, ok := computedMembers(segment)
+		if !ok {
+			continue
+		}
+
+		var ids []int64
+		if err := db.Model(&models.User{}).Where(condition, args...).Pluck("users.id", &ids).Error; err != nil {
+			return fmt.Errorf("failed to get computed segment users: %w", mapError(err))
+		}
+
+		for _, id := range ids {
+			memberships = append(memberships, &models.UserSegment{UserID: id, SegmentName: segment.Name})
+		}
+	}
+
+	if len(memberships) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, 0, len(memberships))
+	for _, membership := range memberships {
+		ids = append(ids, membership.UserID)
+	}
+
+	var users []*models.User
+	if err := db.Where("id IN ?", ids).Find(&users).Error; err != nil {
+		return fmt.Errorf("failed to get segment users: %w", mapError(err))
+	}
+
+	usersByID := make(map[int64]*models.User, len(users))
+	for _, user := range users {
+		usersByID[user.ID] = user
+	}
+
+	segmentsByName := make(map[string]*models.Segment, len(segments))
+	for _, segment := range segments {
+		segmentsByName[segment.Name] = segment
+	}
+
+	// a computed member put into the segment explicitly is listed once
+	seen := make(map[string]map[int64]bool, len(segments))
+	for _, membership := range memberships {
+		user, ok := usersByID[membership.UserID]
+		if !ok || seen[membership.SegmentName][user.ID] {
+			continue
+		}
+		if seen[membership.SegmentName] == nil {
+			seen[membership.SegmentName] = make(map[int64]bool)
+		}
+		seen[membership.SegmentName][user.ID] = true
+
+		segment := segmentsByName[membership.SegmentName]
+		segment.Users = append(segment.Users, *user)
+	}
+
+	// users are listed in a stable order, so the entity tag of an unchanged segment stays the same
+	for _, segment := range segments {
+		sort.Slice(segment.Users, func(i, j int) bool {
+			return segment.Users[i].ID < segment.Users[j].ID
+		})
+	}
+
+	return nil
+}
+
+// countVariants counts the members of each variant of a segment with variants in the database.
+// A member is in the variant of the membership when it is assigned, otherwise in the one
+// of its point like storage.AssignVariant chooses it.
+func countVariants(db *gorm.DB, segment *models.Segment) error {
+	if len(segment.Variants) == 0 {
+		return nil
+	}
+
+	var cases strings.Builder
+	var args []any
+	var total int64
+	for _, variant := range segment.Variants[:len(segment.Variants)-1] {
+		total += int64(variant.Weight)
+		cases.WriteString("WHEN point < ? THEN ? ")
+		args = append(args, total, variant.Name)
+	}
+	last := segment.Variants[len(segment.Variants)-1]
+	total += int64(last.Weight)
+
+	members, membersArgs := segmentMembers(segment.Name, segment)
+	query := "SELECT COALESCE(assigned, CASE " + cases.String() + "ELSE ? END) AS variant, count(*) AS count FROM (" +
+		"SELECT user_segments.variant AS assigned, " + variantPoint + " AS point FROM users " +
+		"LEFT JOIN user_segments ON user_segments.user_id = users.id AND user_segments.segment_name = ? AND " + activeMembership +
+		" WHERE " + members + ") AS members GROUP BY 1"
+	args = append(args, last.Name, storage.VariantSeed(segment), total, segment.Name)
+	args = append(args, membersArgs...)
+
+	var counts []struct {
+		Variant string
+		Count   int64
+	}
+	if err := db.Raw(query, args...).Scan(&counts).Error; err != nil {
+		return fmt.Errorf("failed to count segment variants: %w", mapError(err))
+	}
+
+	segment.VariantCounts = make(map[string]int64, len(segment.Variants))
+	for _, variant := range segment.Variants {
+		segment.VariantCounts[variant.Name] = 0
+	}
+	for _, count := range counts {
+		segment.VariantCounts[count.Variant] = count.Count
+	}
+	return nil
+}
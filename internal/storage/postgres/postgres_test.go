@@ -0,0 +1,102 @@
+package postgres
+
+import (
+	"context"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/storage"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/storage/storagetest"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/schema"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// testDSNEnv names the connection string of a disposable database the conformance suite runs against,
+// the suite is skipped without it. Every test drops and recreates the public schema of that database.
+const testDSNEnv = "POSTGRES_TEST_DSN"
+
+var (
+	testDBOnce sync.Once
+	testDB     *gorm.DB
+	testDBErr  error
+)
+
+// newTestDB returns the test database with the public schema recreated and all migrations applied.
+func newTestDB(t *testing.T) *gorm.DB {
+	dsn := os.Getenv(testDSNEnv)
+	if dsn == "" {
+		t.Skipf("%s is not set", testDSNEnv)
+	}
+
+	testDBOnce.Do(func() {
+		testDB, testDBErr = gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	})
+	if testDBErr != nil {
+		t.Fatalf("failed to connect to the test database: %v", testDBErr)
+	}
+
+	if err := testDB.Exec("DROP SCHEMA IF EXISTS public CASCADE; CREATE SCHEMA public").Error; err != nil {
+		t.Fatalf("failed to reset the test database: %v", err)
+	}
+	migrator, err := NewMigrator(testDB, schema.Migrations)
+	if err != nil {
+		t.Fatalf("NewMigrator: %v", err)
+	}
+	if _, err := migrator.Up(context.Background()); err != nil {
+		t.Fatalf("failed to migrate the test database: %v", err)
+	}
+	return testDB
+}
+
+func newStorages(t *testing.T, db *gorm.DB) (storage.UserStorage, storage.SegmentStorage) {
+	us, err := NewUserStorage(db)
+	if err != nil {
+		t.Fatalf("NewUserStorage: %v", err)
+	}
+	ss, err := NewSegmentStorage(db, us)
+	if err != nil {
+		t.Fatalf("NewSegmentStorage: %v", err)
+	}
+	return us, ss
+}
+
+func TestConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) (storage.UserStorage, storage.SegmentStorage) {
+		return newStorages(t, newTestDB(t))
+	})
+}
+
+func TestAPIKeyConformance(t *testing.T) {
+	storagetest.RunAPIKeys(t, func(t *testing.T) storage.APIKeyStorage {
+		ks, err := NewAPIKeyStorage(newTestDB(t))
+		if err != nil {
+			t.Fatalf("NewAPIKeyStorage: %v", err)
+		}
+		return ks
+	})
+}
+
+func TestAuditConformance(t *testing.T) {
+	storagetest.RunAudit(t, func(t *testing.T) (storage.UserStorage, storage.SegmentStorage, storage.AuditStorage) {
+		db := newTestDB(t)
+		us, ss := newStorages(t, db)
+		as, err := NewAuditStorage(db)
+		if err != nil {
+			t.Fatalf("NewAuditStorage: %v", err)
+		}
+		return us, ss, as
+	})
+}
+
+func TestIdempotencyConformance(t *testing.T) {
+	storagetest.RunIdempotency(t, func(t *testing.T) storage.IdempotencyStorage {
+		ks, err := NewIdempotencyStorage(newTestDB(t))
+		if err != nil {
+			t.Fatalf("NewIdempotencyStorage: %v", err)
+		}
+		return ks
+	})
+}
@@ -0,0 +1,96 @@
+package postgres
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/models"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/storage"
+)
+
+var rangeOperators = map[models.RuleOp]string{
+	models.RuleOpGt:  ">",
+	models.RuleOpGte: ">=",
+	models.RuleOpLt:  "<",
+	models.RuleOpLte: "<=",
+}
+
+// ruleCondition translates a valid rule into a condition on the users table
+// matching the same users as storage.MatchRule does.
+func ruleCondition(rule *models.Rule) (string, []any) {
+	switch {
+	case rule.And != nil:
+		return combineRules(rule.And, " AND ")
+	case rule.Or != nil:
+		return combineRules(rule.Or, " OR ")
+	case rule.Not != nil:
+		condition, args := ruleCondition(rule.Not)
+		return "(NOT " + condition + ")", args
+	default:
+		return comparisonCondition(rule)
+	}
+}
+
+func combineRules(rules []models.Rule, operator string) (string, []any) {
+	conditions := make([]string, 0, len(rules))
+	var args []any
+	for i := range rules {
+		condition, ruleArgs := ruleCondition(&rules[i])
+		conditions = append(conditions, condition)
+		args = append(args, ruleArgs...)
+	}
+	return "(" + strings.Join(conditions, operator) + ")", args
+}
+
+// comparisonCondition never returns NULL, so negating a comparison of a missing attribute matches
+// like storage.MatchRule does. Casts are guarded by CASE, as AND does not guarantee the evaluation order.
+func comparisonCondition(rule *models.Rule) (string, []any) {
+	const (
+		value = "(users.attributes -> ?)"
+		text  = "(users.attributes ->> ?)"
+	)
+	name := rule.Attribute
+
+	var condition string
+	var args []any
+	switch rule.Op {
+	case models.RuleOpEq:
+		condition = value + " = ?::jsonb"
+		args = []any{name, jsonValue(rule.Value)}
+	case models.RuleOpNe:
+		condition = value + " <> ?::jsonb"
+		args = []any{name, jsonValue(rule.Value)}
+	case models.RuleOpIn:
+		condition = "jsonb_build_array" + value + " <@ ?::jsonb"
+		args = []any{name, jsonValue(rule.Value)}
+	default:
+		operator := rangeOperators[rule.Op]
+		switch v := rule.Value.(type) {
+		case float64:
+			condition = "CASE WHEN jsonb_typeof" + value + " = 'number' " +
+				"THEN " + text + "::numeric " + operator + " ?::numeric ELSE FALSE END"
+			args = []any{name, name, strconv.FormatFloat(v, 'f', -1, 64)}
+		case string:
+			bytewise := text + ` COLLATE "C" ` + operator + ` ?::text COLLATE "C"`
+			if storage.VersionPattern.MatchString(v) {
+				condition = "CASE WHEN jsonb_typeof" + value + " <> 'string' THEN FALSE " +
+					"WHEN " + text + " ~ ? " +
+					"THEN string_to_array(" + text + ", '.')::numeric[] " + operator + " string_to_array(?, '.')::numeric[] " +
+					"ELSE " + bytewise + " END"
+				args = []any{name, name, storage.VersionPattern.String(), name, v, name, v}
+			} else {
+				condition = "CASE WHEN jsonb_typeof" + value + " = 'string' THEN " + bytewise + " ELSE FALSE END"
+				args = []any{name, name, v}
+			}
+		}
+	}
+
+	condition = "COALESCE(jsonb_typeof" + value + " IN ('string', 'number', 'boolean') AND " + condition + ", FALSE)"
+	return condition, append([]any{name}, args...)
+}
+
+func jsonValue(value any) string {
+	data, _ := json.Marshal(value)
+	return string(data)
+}
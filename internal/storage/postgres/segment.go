@@ -1,12 +1,15 @@
 package postgres
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/models"
 	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/storage"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type segmentStorage struct {
@@ -18,106 +21,482 @@ func NewSegmentStorage(db *gorm.DB, us storage.UserStorage) (storage.SegmentStor
 	return &segmentStorage{db: db, userStorage: us}, nil
 }
 
-func (s *segmentStorage) CreateSegment(segment *models.Segment) error {
-	return s.db.Create(segment).Error
+func (s *segmentStorage) CreateSegment(ctx context.Context, segment *models.Segment) error {
+	if err := storage.NormalizeSegment(segment); err != nil {
+		return err
+	}
+	segment.Version = 1
+
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := takeOverAlias(tx, segment.Name); err != nil {
+			return err
+		}
+		if err := tx.Omit(clause.Associations).Create(segment).Error; err != nil {
+			return fmt.Errorf("failed to create segment: %w", mapError(err))
+		}
+		if err := recordAudit(ctx, tx, models.AuditSegmentCreate, models.AuditResourceSegment, segment.Name, nil, storage.AuditSegment(segment)); err != nil {
+			return err
+		}
+
+		if segment.AutoPercent <= 0 {
+			return nil
+		}
+
+		var total int64
+		if err := tx.Model(&models.User{}).Count(&total).Error; err != nil {
+			return fmt.Errorf("failed to count users: %w", mapError(err))
+		}
+
+		sampleSize := storage.SampleSize(total, segment.AutoPercent)
+		if sampleSize == 0 {
+			return nil
+		}
+
+		// every sampled membership is audited like an added one, the entry only lacks the resource ID here
+		entry, err := storage.NewAuditEntry(ctx, models.AuditMembershipAdd, models.AuditResourceMembership, "", nil, nil)
+		if err != nil {
+			return err
+		}
+
+		result := tx.Exec(
+			"WITH added AS ("+
+				"INSERT INTO user_segments (user_id, segment_name) "+
+				"SELECT id, ? FROM users ORDER BY random() LIMIT ? "+
+				"RETURNING user_id, segment_name), "+
+				"history AS ("+
+				"INSERT INTO user_segments_history (user_id, segment_name, operation) "+
+				"SELECT user_id, segment_name, ? FROM added) "+
+				"INSERT INTO audit_log (actor, actor_name, action, resource_type, resource_id, before, after, request_id) "+
+				"SELECT ?, ?, ?, ?, segment_name || ':' || user_id, 'null', jsonb_build_object('segment', segment_name), ? "+
+				"FROM added",
+			segment.Name, sampleSize, models.OperationAdd,
+			entry.Actor, entry.ActorName, entry.Action, entry.ResourceType, entry.RequestID,
+		)
+		if result.Error != nil {
+			return fmt.Errorf("failed to add users to segment: %w", mapError(result.Error))
+		}
+
+		return nil
+	})
+}
+
+func (s *segmentStorage) GetSegmentByName(ctx context.Context, slug string) (*models.Segment, error) {
+	db := s.db.WithContext(ctx)
+
+	name, err := resolveSegmentName(db, slug)
+	if err != nil {
+		return nil, err
+	}
+	return getSegment(db, name)
 }
 
-func (s *segmentStorage) GetSegmentByName(name string) (*models.Segment, error) {
+// getSegment returns the segment with its users and variant counts, the representation its entity tag is computed of.
+func getSegment(db *gorm.DB, name string) (*models.Segment, error) {
 	segment := &models.Segment{}
-	if err := s.db.Preload("Users").Where("name = ?", name).First(segment).Error; err != nil {
+	if err := db.Where("name = ?", name).First(segment).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, fmt.Errorf("segment with name '%s' not found", name)
+			return nil, fmt.Errorf("segment with name '%s' %w", name, storage.ErrNotFound)
 		}
 		return nil, fmt.Errorf("failed to get segment by name '%s': %w", name, err)
 	}
+	if err := preloadUsers(db, segment); err != nil {
+		return nil, err
+	}
+	if err := countVariants(db, segment); err != nil {
+		return nil, err
+	}
 	return segment, nil
 }
 
-func (s *segmentStorage) GetSegments() ([]*models.Segment, error) {
-	var segments []*models.Segment
-	result := s.db.Preload("Users").Find(&segments)
+func (s *segmentStorage) GetSegments(ctx context.Context, filter storage.SegmentFilter, params storage.ListParams) (*storage.Page[models.Segment], error) {
+	return listSegments(s.db.WithContext(ctx), filter, params)
+}
 
-	if result.Error != nil {
-		return nil, fmt.Errorf("failed to get segments: %w", result.Error)
-	}
+func (s *segmentStorage) UpdateSegment(ctx context.Context, segment *models.Segment, ifMatch string) (*models.Segment, error) {
+	var updated *models.Segment
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		name, err := resolveSegmentName(tx, segment.Name)
+		if err != nil {
+			return err
+		}
+		segment.Name = name
 
-	return segments, nil
-}
+		before, err := lockSegment(tx, segment.Name)
+		if err != nil {
+			return err
+		}
+		if err := checkSegmentETag(tx, before, ifMatch); err != nil {
+			return err
+		}
+
+		// the update is validated against the stored type, which it cannot change
+		validated := *segment
+		validated.Type = before.Type
+		if err := storage.ValidateSegmentUpdate(&validated); err != nil {
+			return err
+		}
+
+		segment.Version = before.Version + 1
+		// changing the type, the salt or the variants would reshuffle the segment, so they are kept,
+		// and members are changed only by the membership methods, which record their history
+		if err := tx.Omit("type", "salt", "variants", "variant_seed", clause.Associations).Updates(segment).Error; err != nil {
+			return fmt.Errorf("failed to update segment: %w", mapError(err))
+		}
 
-func (s *segmentStorage) UpdateSegment(segment *models.Segment) error {
-	result := s.db.Updates(segment)
-	if result.Error != nil {
-		return fmt.Errorf("failed to update segment: %w", result.Error)
+		if updated, err = getSegment(tx, segment.Name); err != nil {
+			return err
+		}
+
+		return recordAudit(ctx, tx, models.AuditSegmentUpdate, models.AuditResourceSegment, segment.Name, before, storage.AuditSegment(updated))
+	})
+	if err != nil {
+		return nil, err
 	}
+	return updated, nil
+}
+
+func (s *segmentStorage) PatchSegment(ctx context.Context, slug string, ifMatch string, patch func(segment *models.Segment) error) (*models.Segment, error) {
+	var patched *models.Segment
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		name, err := resolveSegmentName(tx, slug)
+		if err != nil {
+			return err
+		}
+
+		before, err := lockSegment(tx, name)
+		if err != nil {
+			return err
+		}
+		if err := checkSegmentETag(tx, before, ifMatch); err != nil {
+			return err
+		}
 
-	if result.RowsAffected == 0 {
-		return errors.New("no rows affected when updating segment")
+		segment := *before
+		if err := patch(&segment); err != nil {
+			return err
+		}
+		// the name, the type, the salt and the variants are kept like UpdateSegment does
+		segment.Name, segment.Type, segment.Salt, segment.Variants, segment.VariantSeed = before.Name, before.Type, before.Salt, before.Variants, before.VariantSeed
+		if err := storage.ValidateSegmentUpdate(&segment); err != nil {
+			return err
+		}
+
+		segment.Version = before.Version + 1
+		// selected fields are written even when they are zero, so the patch can clear them
+		result := tx.Model(&models.Segment{}).Where("name = ?", name).
+			Select("auto_percent", "percent", "rule", "version").
+			Updates(&segment)
+		if result.Error != nil {
+			return fmt.Errorf("failed to patch segment: %w", mapError(result.Error))
+		}
+
+		if patched, err = getSegment(tx, name); err != nil {
+			return err
+		}
+		return recordAudit(ctx, tx, models.AuditSegmentUpdate, models.AuditResourceSegment, name, before, &segment)
+	})
+	if err != nil {
+		return nil, err
 	}
+	return patched, nil
+}
 
-	return nil
+func (s *segmentStorage) DeleteSegmentBySlug(ctx context.Context, slug string, ifMatch string) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		slug, err := resolveSegmentName(tx, slug)
+		if err != nil {
+			return err
+		}
+
+		before, err := lockSegment(tx, slug)
+		if err != nil {
+			return err
+		}
+		if err := checkSegmentETag(tx, before, ifMatch); err != nil {
+			return err
+		}
+
+		if _, err := expireMemberships(tx, time.Now(), "segment_name = ?", slug); err != nil {
+			return err
+		}
+		if err := recordRemovalHistory(tx, "segment_name = ?", slug); err != nil {
+			return err
+		}
+
+		if err := tx.Where("name = ?", slug).Delete(&models.Segment{}).Error; err != nil {
+			return fmt.Errorf("failed to delete segment: %w", mapError(err))
+		}
+
+		return recordAudit(ctx, tx, models.AuditSegmentDelete, models.AuditResourceSegment, slug, before, nil)
+	})
 }
 
-func (s *segmentStorage) DeleteSegmentBySlug(slug string) error {
-	result := s.db.Where("slug = ?", slug).Delete(&models.Segment{})
-	if result.Error != nil {
-		return fmt.Errorf("failed to delete segment: %w", result.Error)
+func (s *segmentStorage) RenameSegment(ctx context.Context, slug, name string, ifMatch string, aliasTTL time.Duration) (*models.Segment, error) {
+	if name == "" {
+		return nil, storage.Invalid("name", "required", "must not be empty")
 	}
 
-	if result.RowsAffected == 0 {
-		return errors.New("no rows affected when deleting segment")
-	}
+	var renamed *models.Segment
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		slug, err := resolveSegmentName(tx, slug)
+		if err != nil {
+			return err
+		}
+
+		before, err := lockSegment(tx, slug)
+		if err != nil {
+			return err
+		}
+		if err := checkSegmentETag(tx, before, ifMatch); err != nil {
+			return err
+		}
+		if name == before.Name {
+			return storage.Invalid("name", "changed", "must differ from the current one")
+		}
+
+		var count int64
+		if err := tx.Model(&models.Segment{}).Where("name = ?", name).Count(&count).Error; err != nil {
+			return fmt.Errorf("failed to get segment by name: %w", mapError(err))
+		}
+		if count > 0 {
+			return fmt.Errorf("segment with name '%s' %w", name, storage.ErrAlreadyExists)
+		}
+		if err := takeOverAlias(tx, name); err != nil {
+			return err
+		}
+
+		segment := *before
+		segment.Name = name
+		segment.Version = before.Version + 1
+		if len(before.Variants) > 0 {
+			segment.VariantSeed = storage.VariantSeed(before)
+		}
+
+		// memberships and aliases of the segment follow the name with ON UPDATE CASCADE
+		result := tx.Model(&models.Segment{}).Where("name = ?", before.Name).Updates(map[string]any{
+			"name":         segment.Name,
+			"variant_seed": segment.VariantSeed,
+			"version":      segment.Version,
+		})
+		if result.Error != nil {
+			return fmt.Errorf("failed to rename segment: %w", mapError(result.Error))
+		}
+
+		result = tx.Model(&models.UserSegmentHistory{}).Where("segment_name = ?", before.Name).Update("segment_name", name)
+		if result.Error != nil {
+			return fmt.Errorf("failed to rename segment in history: %w", mapError(result.Error))
+		}
 
-	return nil
+		if aliasTTL > 0 {
+			if err := createAlias(tx, before.Name, name, time.Now().Add(aliasTTL)); err != nil {
+				return err
+			}
+		}
+
+		if renamed, err = getSegment(tx, name); err != nil {
+			return err
+		}
+		return recordAudit(ctx, tx, models.AuditSegmentRename, models.AuditResourceSegment, before.Name, before, &segment)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return renamed, nil
 }
 
-func (s *segmentStorage) GetUsersInSegment(slug string) ([]*models.User, error) {
-	var users []*models.User
-	err := s.db.Model(&models.Segment{}).Where("name = ?", slug).Association("Users").Find(&users)
+func (s *segmentStorage) GetUsersInSegment(ctx context.Context, slug string, filter storage.UserFilter, params storage.ListParams) (*storage.Page[models.User], error) {
+	db := s.db.WithContext(ctx)
 
+	slug, err := resolveSegmentName(db, slug)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get users in segment: %w", err)
+		return nil, err
+	}
+
+	var count int64
+	if err := db.Model(&models.Segment{}).Where("name = ?", slug).Count(&count).Error; err != nil {
+		return nil, fmt.Errorf("failed to get segment by name: %w", mapError(err))
+	}
+	if count == 0 {
+		return nil, fmt.Errorf("segment with name '%s' %w", slug, storage.ErrNotFound)
 	}
 
-	return users, nil
+	filter.Segment = slug
+	return listUsers(db, filter, params)
 }
 
-func (s *segmentStorage) AddUserToSegment(slug string, userID int64) error {
-	segment := &models.Segment{}
-	if err := s.db.Where("name = ?", slug).First(segment).Error; err != nil {
-		return fmt.Errorf("failed to get segment by name: %w", err)
+// CountSegmentUsers counts explicit members of all segments with one query,
+// segments with computed members are counted one by one.
+func (s *segmentStorage) CountSegmentUsers(ctx context.Context) (map[string]int64, error) {
+	db := s.db.WithContext(ctx)
+
+	var segments []*models.Segment
+	if err := db.Select("name", "type", "salt", "percent", "rule").Find(&segments).Error; err != nil {
+		return nil, fmt.Errorf("failed to count segment users: %w", mapError(err))
 	}
 
-	user := &models.User{}
-	if err := s.db.First(user, userID).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return fmt.Errorf("user with ID %d not found", userID)
+	var explicit []struct {
+		SegmentName string
+		Count       int64
+	}
+	err := db.Model(&models.UserSegment{}).
+		Select("segment_name, count(*) AS count").
+		Where(activeMembership).
+		Group("segment_name").
+		Scan(&explicit).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to count segment users: %w", mapError(err))
+	}
+
+	counts := make(map[string]int64, len(segments))
+	for _, segment := range segments {
+		counts[segment.Name] = 0
+	}
+	for _, row := range explicit {
+		counts[row.SegmentName] = row.Count
+	}
+
+	for _, segment := range segments {
+		if !storage.HasComputedMembers(segment) {
+			continue
+		}
+
+		var count int64
+		condition, args := segmentMembers(segment.Name, segment)
+		if err := db.Model(&models.User{}).Where(condition, args...).Count(&count).Error; err != nil {
+			return nil, fmt.Errorf("failed to count segment users: %w", mapError(err))
 		}
-		return fmt.Errorf("failed to get user by ID %d: %w", userID, err)
+		counts[segment.Name] = count
 	}
 
-	if err := s.db.Model(segment).Association("Users").Append(user); err != nil {
-		return fmt.Errorf("failed to add user to segment: %w", err)
+	return counts, nil
+}
+
+func (s *segmentStorage) PreviewRule(ctx context.Context, rule *models.Rule, params storage.ListParams) (*storage.Page[models.User], error) {
+	if rule == nil {
+		return nil, storage.Invalid("rule", "required", "must be set")
 	}
+	return listUsers(s.db.WithContext(ctx), storage.UserFilter{Rule: rule}, params)
+}
+
+func (s *segmentStorage) AddUserToSegment(ctx context.Context, slug string, userID int64, expiresAt *time.Time, variant string) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		slug, err := resolveSegmentName(tx, slug)
+		if err != nil {
+			return err
+		}
+
+		segment := &models.Segment{}
+		if err := tx.Where("name = ?", slug).First(segment).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return fmt.Errorf("segment with name '%s' %w", slug, storage.ErrNotFound)
+			}
+			return fmt.Errorf("failed to get segment by name: %w", mapError(err))
+		}
+		if err := storage.CheckVariant(segment, variant); err != nil {
+			return err
+		}
+
+		user := &models.User{}
+		if err := tx.First(user, userID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return fmt.Errorf("user with ID %d %w", userID, storage.ErrNotFound)
+			}
+			return fmt.Errorf("failed to get user by ID %d: %w", userID, err)
+		}
+
+		// an expired membership is removed first, so the user enters the segment again
+		_, err = expireMemberships(tx, time.Now(), "user_id = ? AND segment_name = ?", user.ID, segment.Name)
+		if err != nil {
+			return err
+		}
+
+		before, err := auditMembership(tx, user.ID, segment.Name)
+		if err != nil {
+			return err
+		}
+
+		var inserted bool
+		result := tx.Raw(
+			"INSERT INTO user_segments (user_id, segment_name, expires_at, variant) VALUES (?, ?, ?, ?) "+
+				"ON CONFLICT (user_id, segment_name) DO UPDATE SET expires_at = EXCLUDED.expires_at, "+
+				"variant = COALESCE(EXCLUDED.variant, user_segments.variant) "+
+				"RETURNING (xmax = 0) AS inserted",
+			user.ID, segment.Name, expiresAt, explicitVariant(variant),
+		).Scan(&inserted)
+		if result.Error != nil {
+			return fmt.Errorf("failed to add user to segment: %w", mapError(result.Error))
+		}
+
+		after, err := auditMembership(tx, user.ID, segment.Name)
+		if err != nil {
+			return err
+		}
+		resourceID := storage.MembershipResourceID(segment.Name, user.ID)
+		if err := recordAudit(ctx, tx, models.AuditMembershipAdd, models.AuditResourceMembership, resourceID, before, after); err != nil {
+			return err
+		}
+
+		// the user is already in the segment, only the expiration and the variant are updated
+		if !inserted {
+			return nil
+		}
 
-	return nil
+		return recordHistory(tx, user.ID, []string{segment.Name}, models.OperationAdd)
+	})
 }
 
-func (s *segmentStorage) DeleteUserFromSegment(slug string, userID int64) error {
-	segment := &models.Segment{}
-	if err := s.db.Where("name = ?", slug).Preload("Users").First(segment).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return fmt.Errorf("segment with name '%s' not found", slug)
+func (s *segmentStorage) DeleteUserFromSegment(ctx context.Context, slug string, userID int64) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		slug, err := resolveSegmentName(tx, slug)
+		if err != nil {
+			return err
 		}
-		return fmt.Errorf("failed to get segment by slug: %w", err)
-	}
 
-	user := &models.User{ID: userID}
-	if err := s.db.First(user).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return fmt.Errorf("user with ID %d not found", userID)
+		segment := &models.Segment{}
+		if err := tx.Where("name = ?", slug).First(segment).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return fmt.Errorf("segment with name '%s' %w", slug, storage.ErrNotFound)
+			}
+			return fmt.Errorf("failed to get segment by slug: %w", mapError(err))
+		}
+
+		user := &models.User{ID: userID}
+		if err := tx.First(user).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return fmt.Errorf("user with ID %d %w", userID, storage.ErrNotFound)
+			}
+			return fmt.Errorf("failed to get user by ID %d: %w", userID, err)
+		}
+
+		// an expired membership is removed at the expiration time
+		_, err = expireMemberships(tx, time.Now(), "user_id = ? AND segment_name = ?", user.ID, segment.Name)
+		if err != nil {
+			return err
+		}
+
+		before, err := auditMembership(tx, user.ID, segment.Name)
+		if err != nil {
+			return err
+		}
+
+		result := tx.Exec(
+			"DELETE FROM user_segments WHERE user_id = ? AND segment_name = ?",
+			user.ID, segment.Name,
+		)
+		if result.Error != nil {
+			return fmt.Errorf("failed to delete user from segment: %w", mapError(result.Error))
+		}
+
+		// the user is not in the segment, nothing happened
+		if result.RowsAffected == 0 {
+			return nil
+		}
+
+		resourceID := storage.MembershipResourceID(segment.Name, user.ID)
+		if err := recordAudit(ctx, tx, models.AuditMembershipRemove, models.AuditResourceMembership, resourceID, before, nil); err != nil {
+			return err
 		}
-		return fmt.Errorf("failed to get user by ID %d: %w", userID, err)
-	}
 
-	return s.db.Model(segment).Association("Users").Delete(user)
+		return recordHistory(tx, user.ID, []string{segment.Name}, models.OperationRemove)
+	})
 }
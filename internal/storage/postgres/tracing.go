@@ -0,0 +1,94 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+const (
+	tracerName     = "github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/storage/postgres"
+	tracingSpanKey = "tracing:span"
+)
+
+// tracingPlugin traces every gorm statement as a child span of the span in the statement context.
+// The SQL is recorded with placeholders, so the span carries no user data.
+type tracingPlugin struct {
+	tracer trace.Tracer
+}
+
+func (p *tracingPlugin) Name() string {
+	return "tracing"
+}
+
+func (p *tracingPlugin) Initialize(db *gorm.DB) error {
+	p.tracer = otel.Tracer(tracerName)
+
+	callbacks := db.Callback()
+	for _, operation := range []struct {
+		name          string
+		before, after func(string, func(*gorm.DB)) error
+	}{
+		{"create", callbacks.Create().Before("gorm:create").Register, callbacks.Create().After("gorm:create").Register},
+		{"query", callbacks.Query().Before("gorm:query").Register, callbacks.Query().After("gorm:query").Register},
+		{"update", callbacks.Update().Before("gorm:update").Register, callbacks.Update().After("gorm:update").Register},
+		{"delete", callbacks.Delete().Before("gorm:delete").Register, callbacks.Delete().After("gorm:delete").Register},
+		{"row", callbacks.Row().Before("gorm:row").Register, callbacks.Row().After("gorm:row").Register},
+		{"raw", callbacks.Raw().Before("gorm:raw").Register, callbacks.Raw().After("gorm:raw").Register},
+	} {
+		if err := operation.before("tracing:before_"+operation.name, p.start(operation.name)); err != nil {
+			return err
+		}
+		if err := operation.after("tracing:after_"+operation.name, p.end(operation.name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *tracingPlugin) start(operation string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		ctx := db.Statement.Context
+		if ctx == nil {
+			ctx = context.Background()
+		}
+
+		ctx, span := p.tracer.Start(ctx, operation,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(semconv.DBSystemPostgreSQL, semconv.DBOperation(operation)),
+		)
+		db.Statement.Context = ctx
+		db.InstanceSet(tracingSpanKey, span)
+	}
+}
+
+func (p *tracingPlugin) end(operation string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		value, ok := db.InstanceGet(tracingSpanKey)
+		if !ok {
+			return
+		}
+		span := value.(trace.Span)
+		defer span.End()
+
+		if table := db.Statement.Table; table != "" {
+			span.SetName(operation + " " + table)
+			span.SetAttributes(semconv.DBSQLTable(table))
+		}
+		span.SetAttributes(
+			semconv.DBStatement(db.Statement.SQL.String()),
+			attribute.Int64("db.rows_affected", db.Statement.RowsAffected),
+		)
+
+		if err := db.Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+	}
+}
@@ -1,14 +1,19 @@
 package postgres
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"log"
+	"maps"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/models"
 	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/storage"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type userStorage struct {
@@ -19,55 +24,197 @@ func NewUserStorage(db *gorm.DB) (storage.UserStorage, error) {
 	return &userStorage{db: db}, nil
 }
 
-func (s *userStorage) CreateUser(user *models.User) error {
-	return s.db.Create(user).Error
+func (s *userStorage) CreateUser(ctx context.Context, user *models.User) error {
+	if err := storage.ValidateUser(user); err != nil {
+		return err
+	}
+	user.Version = 1
+
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit(clause.Associations).Create(user).Error; err != nil {
+			return fmt.Errorf("failed to create user: %w", mapError(err))
+		}
+		if err := recordAudit(ctx, tx, models.AuditUserCreate, models.AuditResourceUser, strconv.FormatInt(user.ID, 10), nil, storage.AuditUser(user)); err != nil {
+			return err
+		}
+
+		var segments []*models.Segment
+		if err := tx.Where("auto_percent > 0").Find(&segments).Error; err != nil {
+			return fmt.Errorf("failed to get segments with auto percent: %w", mapError(err))
+		}
+
+		segmentsToAddSet := make(map[string]models.SegmentAssignment)
+		for _, segment := range segments {
+			if storage.InSample(segment.AutoPercent) {
+				segmentsToAddSet[segment.Name] = models.SegmentAssignment{Name: segment.Name}
+			}
+		}
+
+		if len(segmentsToAddSet) == 0 {
+			return nil
+		}
+
+		added, err := s.bulkUpsert(user.ID, segmentsToAddSet, tx)
+		if err != nil {
+			return err
+		}
+		for _, name := range added {
+			after := &models.AuditMembership{Segment: name}
+			if err := recordAudit(ctx, tx, models.AuditMembershipAdd, models.AuditResourceMembership, storage.MembershipResourceID(name, user.ID), nil, after); err != nil {
+				return err
+			}
+		}
+		return recordHistory(tx, user.ID, added, models.OperationAdd)
+	})
+}
+
+func (s *userStorage) GetUserByID(ctx context.Context, id int64) (*models.User, error) {
+	return getUser(s.db.WithContext(ctx), id)
 }
 
-func (s *userStorage) GetUserByID(id int64) (*models.User, error) {
+// getUser returns the user with its segments, the representation its entity tag is computed of.
+func getUser(db *gorm.DB, id int64) (*models.User, error) {
 	user := &models.User{}
-	result := s.db.Preload("Segments").First(user, id)
+	result := db.First(user, id)
 	if result.Error != nil {
 		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-			return nil, nil // no user found
+			return nil, fmt.Errorf("user with ID %d %w", id, storage.ErrNotFound)
 		}
-		return nil, fmt.Errorf("failed to get user by ID: %w", result.Error)
+		return nil, fmt.Errorf("failed to get user by ID: %w", mapError(result.Error))
+	}
+	if err := preloadSegments(db, user); err != nil {
+		return nil, err
 	}
 	return user, nil
 }
 
-func (s *userStorage) GetUsers() ([]*models.User, error) {
-	var users []*models.User
-	result := s.db.Preload("Segments").Find(&users)
+func (s *userStorage) GetUserSegments(ctx context.Context, id int64) ([]models.Segment, error) {
+	db := s.db.WithContext(ctx)
+
+	user := &models.User{}
+	result := db.Select("id", "attributes").First(user, id)
 	if result.Error != nil {
-		return nil, fmt.Errorf("failed to get users: %w", result.Error)
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("user with ID %d %w", id, storage.ErrNotFound)
+		}
+		return nil, fmt.Errorf("failed to get user by ID: %w", mapError(result.Error))
 	}
-	return users, nil
+	if err := preloadSegments(db, user); err != nil {
+		return nil, err
+	}
+	return user.Segments, nil
 }
 
-func (s *userStorage) UpdateUser(user *models.User) error {
-	result := s.db.Model(user).Updates(user)
-	if result.Error != nil {
-		return fmt.Errorf("failed to update user: %w", result.Error)
+func (s *userStorage) GetUsers(ctx context.Context, filter storage.UserFilter, params storage.ListParams) (*storage.Page[models.User], error) {
+	db := s.db.WithContext(ctx)
+
+	page, err := listUsers(db, filter, params)
+	if err != nil {
+		return nil, err
 	}
-	if result.RowsAffected == 0 {
-		return errors.New("no rows affected when updating user")
+	if err := preloadSegments(db, page.Items...); err != nil {
+		return nil, err
 	}
-	return nil
+	return page, nil
 }
 
-func (s *userStorage) DeleteUser(id int64) error {
-	result := s.db.Delete(&models.User{}, id)
-	if result.Error != nil {
-		return fmt.Errorf("failed to delete user: %w", result.Error)
+func (s *userStorage) UpdateUser(ctx context.Context, user *models.User, ifMatch string) (*models.User, error) {
+	var updated *models.User
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		before, err := lockUser(tx, user.ID)
+		if err != nil {
+			return err
+		}
+		if err := checkUserETag(tx, before, ifMatch); err != nil {
+			return err
+		}
+
+		user.Version = before.Version + 1
+		// memberships are changed only by UpdateUserSegments, which records their history
+		if err := tx.Model(user).Omit(clause.Associations).Updates(user).Error; err != nil {
+			return fmt.Errorf("failed to update user: %w", mapError(err))
+		}
+
+		if updated, err = getUser(tx, user.ID); err != nil {
+			return err
+		}
+
+		return recordAudit(ctx, tx, models.AuditUserUpdate, models.AuditResourceUser, strconv.FormatInt(user.ID, 10), before, storage.AuditUser(updated))
+	})
+	if err != nil {
+		return nil, err
 	}
-	if result.RowsAffected == 0 {
-		return errors.New("no rows affected when deleting user")
+	return updated, nil
+}
+
+func (s *userStorage) PatchUser(ctx context.Context, id int64, ifMatch string, patch func(user *models.User) error) (*models.User, error) {
+	var patched *models.User
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		before, err := lockUser(tx, id)
+		if err != nil {
+			return err
+		}
+		if err := checkUserETag(tx, before, ifMatch); err != nil {
+			return err
+		}
+
+		user := *before
+		user.Attributes = maps.Clone(before.Attributes)
+		if err := patch(&user); err != nil {
+			return err
+		}
+		if err := storage.ValidateUser(&user); err != nil {
+			return err
+		}
+
+		user.ID = before.ID
+		user.Version = before.Version + 1
+		// selected fields are written even when they are zero, so the patch can clear them
+		result := tx.Model(&models.User{ID: id}).
+			Select("firstname", "lastname", "username", "attributes", "version").
+			Updates(&user)
+		if result.Error != nil {
+			return fmt.Errorf("failed to patch user: %w", mapError(result.Error))
+		}
+
+		if patched, err = getUser(tx, id); err != nil {
+			return err
+		}
+		return recordAudit(ctx, tx, models.AuditUserUpdate, models.AuditResourceUser, strconv.FormatInt(id, 10), before, &user)
+	})
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	return patched, nil
+}
+
+func (s *userStorage) DeleteUser(ctx context.Context, id int64, ifMatch string) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		before, err := lockUser(tx, id)
+		if err != nil {
+			return err
+		}
+		if err := checkUserETag(tx, before, ifMatch); err != nil {
+			return err
+		}
+
+		if _, err := expireMemberships(tx, time.Now(), "user_id = ?", id); err != nil {
+			return err
+		}
+		if err := recordRemovalHistory(tx, "user_id = ?", id); err != nil {
+			return err
+		}
+
+		if err := tx.Delete(&models.User{}, id).Error; err != nil {
+			return fmt.Errorf("failed to delete user: %w", mapError(err))
+		}
+
+		return recordAudit(ctx, tx, models.AuditUserDelete, models.AuditResourceUser, strconv.FormatInt(id, 10), before, nil)
+	})
 }
 
-func (s *userStorage) UpdateUserSegments(id int64, segmentsToAdd, segmentsToRemove []string) error {
-	tx := s.db.Begin()
+func (s *userStorage) UpdateUserSegments(ctx context.Context, id int64, segmentsToAdd []models.SegmentAssignment, segmentsToRemove []string) error {
+	tx := s.db.WithContext(ctx).Begin()
 
 	if err := tx.Error; err != nil {
 		return fmt.Errorf("failed to begin transaction: %w", tx.Error)
@@ -75,24 +222,44 @@ func (s *userStorage) UpdateUserSegments(id int64, segmentsToAdd, segmentsToRemo
 
 	defer tx.Rollback()
 
-	// Get existing segments for user
-	user, err := s.GetUserByID(id)
+	if _, err := lockUser(tx, id); err != nil {
+		return err
+	}
+
+	before, err := auditMemberships(tx, id, "")
 	if err != nil {
 		return err
 	}
 
-	segmentsToAddSet := make(map[string]bool)
+	// Memberships which are expired but not yet reaped are removed first,
+	// so adding such a segment again is recorded as a new entry
+	if _, err := expireMemberships(tx, time.Now(), "user_id = ?", id); err != nil {
+		return err
+	}
+
+	slugs := make([]string, 0, len(segmentsToAdd)+len(segmentsToRemove))
 	for _, segment := range segmentsToAdd {
-		segmentsToAddSet[segment] = true
+		slugs = append(slugs, segment.Name)
+	}
+	slugs = append(slugs, segmentsToRemove...)
+	names, err := resolveSegmentNames(tx, slugs)
+	if err != nil {
+		return err
 	}
 
-	for _, segment := range user.Segments {
-		delete(segmentsToAddSet, segment.Name)
+	segmentsToAddSet := make(map[string]models.SegmentAssignment)
+	for _, segment := range segmentsToAdd {
+		segment.Name = names[segment.Name]
+		segmentsToAddSet[segment.Name] = segment
 	}
 
 	segmentsToRemoveSet := make(map[string]bool)
 	for _, segment := range segmentsToRemove {
-		segmentsToRemoveSet[segment] = true
+		segmentsToRemoveSet[names[segment]] = true
+	}
+
+	if err := checkSegmentsExist(tx, segmentsToAddSet); err != nil {
+		return err
 	}
 
 	// Find segments in both sets (i.e., intersection)
@@ -111,24 +278,87 @@ func (s *userStorage) UpdateUserSegments(id int64, segmentsToAdd, segmentsToRemo
 
 	// Add user to non-intersecting segments using single query
 	if len(segmentsToAddSet) > 0 {
-		err := s.bulkInsertUnique(id, segmentsToAddSet, tx)
+		added, err := s.bulkUpsert(id, segmentsToAddSet, tx)
 		if err != nil {
 			return err
 		}
+		if err := recordHistory(tx, id, added, models.OperationAdd); err != nil {
+			return err
+		}
 	}
 
 	// Remove user from non-intersecting segments using single query
 	if len(segmentsToRemoveSet) > 0 {
-		err := s.bulkDeleteUnique(id, segmentsToRemoveSet, tx)
+		removed, err := s.bulkDeleteUnique(id, segmentsToRemoveSet, tx)
 		if err != nil {
 			return err
 		}
+		if err := recordHistory(tx, id, removed, models.OperationRemove); err != nil {
+			return err
+		}
+	}
+
+	after, err := auditMemberships(tx, id, "")
+	if err != nil {
+		return err
+	}
+	if err := recordAudit(ctx, tx, models.AuditUserSegmentsUpdate, models.AuditResourceUser, strconv.FormatInt(id, 10), before, after); err != nil {
+		return err
 	}
 
 	return tx.Commit().Error
 }
 
-func (s *userStorage) bulkDeleteUnique(id int64, segmentsToRemoveSet map[string]bool, tx *gorm.DB) error {
+func (s *userStorage) GetUserSegmentsHistory(ctx context.Context, id int64, from, to time.Time) ([]*models.UserSegmentHistory, error) {
+	var history []*models.UserSegmentHistory
+	result := s.db.WithContext(ctx).
+		Where("user_id = ? AND created_at >= ? AND created_at < ?", id, from, to).
+		Order("created_at, id").
+		Find(&history)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to get user segments history: %w", mapError(result.Error))
+	}
+	return history, nil
+}
+
+// checkSegmentsExist returns storage.ErrNotFound naming the first missing segment
+// and storage.ErrValidation for a variant the segment does not have.
+func checkSegmentsExist(tx *gorm.DB, segmentsSet map[string]models.SegmentAssignment) error {
+	if len(segmentsSet) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(segmentsSet))
+	for segment := range segmentsSet {
+		names = append(names, segment)
+	}
+
+	var existing []*models.Segment
+	if err := tx.Select("name", "variants").Where("name IN ?", names).Find(&existing).Error; err != nil {
+		return fmt.Errorf("failed to get segments: %w", mapError(err))
+	}
+
+	existingSet := make(map[string]*models.Segment, len(existing))
+	for _, segment := range existing {
+		existingSet[segment.Name] = segment
+	}
+
+	sort.Strings(names)
+	for _, name := range names {
+		segment, ok := existingSet[name]
+		if !ok {
+			return fmt.Errorf("segment with name '%s' %w", name, storage.ErrNotFound)
+		}
+		if err := storage.CheckVariant(segment, segmentsSet[name].Variant); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bulkDeleteUnique removes the user from the given segments and returns the segments
+// the user was actually a member of.
+func (s *userStorage) bulkDeleteUnique(id int64, segmentsToRemoveSet map[string]bool, tx *gorm.DB) ([]string, error) {
 	segmentNames := make([]string, 0, len(segmentsToRemoveSet))
 	values := make([]interface{}, 0, 1+len(segmentsToRemoveSet))
 	values = append(values, id)
@@ -139,34 +369,55 @@ func (s *userStorage) bulkDeleteUnique(id int64, segmentsToRemoveSet map[string]
 		idx++
 	}
 	query := fmt.Sprintf(
-		"DELETE FROM user_segments WHERE user_id = $1 AND segment_name IN (%s)",
+		"DELETE FROM user_segments WHERE user_id = $1 AND segment_name IN (%s) RETURNING segment_name",
 		strings.Join(segmentNames, ","),
 	)
-	result := tx.Exec(query, values...)
+	var removed []string
+	result := tx.Raw(query, values...).Scan(&removed)
 	if result.Error != nil {
-		return fmt.Errorf("failed to remove user from segments: %w", result.Error)
+		return nil, fmt.Errorf("failed to remove user from segments: %w", mapError(result.Error))
 	}
-	return nil
+	return removed, nil
 }
 
-func (s *userStorage) bulkInsertUnique(id int64, segmentsToAddSet map[string]bool, tx *gorm.DB) error {
+// bulkUpsert adds the user to the given segments and returns the segments the user was not a member of.
+// Expiration of existing memberships is replaced with the given one, the variant only when it is given.
+func (s *userStorage) bulkUpsert(id int64, segmentsToAddSet map[string]models.SegmentAssignment, tx *gorm.DB) ([]string, error) {
 	valueStrings := make([]string, 0, len(segmentsToAddSet))
-	valueArgs := make([]any, 0, len(segmentsToAddSet)*2)
+	valueArgs := make([]any, 0, len(segmentsToAddSet)*4)
 	i := 0
-	for segment := range segmentsToAddSet {
-		valueStrings = append(valueStrings, fmt.Sprintf("($%d, $%d)", i*2+1, i*2+2))
-		valueArgs = append(valueArgs, id)
-		valueArgs = append(valueArgs, segment)
+	for segment, assignment := range segmentsToAddSet {
+		valueStrings = append(valueStrings, fmt.Sprintf("($%d, $%d, $%d, $%d)", i*4+1, i*4+2, i*4+3, i*4+4))
+		valueArgs = append(valueArgs, id, segment, assignment.ExpiresAt, explicitVariant(assignment.Variant))
 		i++
 	}
 
 	query := fmt.Sprintf(
-		"INSERT INTO user_segments (user_id, segment_name) VALUES %s", strings.Join(valueStrings, ","),
+		"INSERT INTO user_segments (user_id, segment_name, expires_at, variant) VALUES %s "+
+			"ON CONFLICT (user_id, segment_name) DO UPDATE SET expires_at = EXCLUDED.expires_at, "+
+			"variant = COALESCE(EXCLUDED.variant, user_segments.variant) "+
+			"RETURNING segment_name, (xmax = 0) AS inserted",
+		strings.Join(valueStrings, ","),
 	)
-	log.Println(query)
-	result := tx.Exec(query, valueArgs...)
+
+	var rows []struct {
+		SegmentName string
+		Inserted    bool
+	}
+	result := tx.Raw(query, valueArgs...).Scan(&rows)
 	if result.Error != nil {
-		return fmt.Errorf("failed to add user to segments: %w", result.Error)
+		return nil, fmt.Errorf("failed to add user to segments: %w", mapError(result.Error))
 	}
-	return nil
+
+	added := make([]string, 0, len(rows))
+	for _, row := range rows {
+		if row.Inserted {
+			added = append(added, row.SegmentName)
+		}
+	}
+	return added, nil
+}
+
+func (s *userStorage) DeleteExpiredSegments(ctx context.Context, now time.Time) (int64, error) {
+	return expireMemberships(s.db.WithContext(ctx), now, "TRUE")
 }
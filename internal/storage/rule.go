@@ -0,0 +1,200 @@
+package storage
+
+import (
+	"cmp"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/models"
+)
+
+// MaxRuleDepth limits nesting of targeting rules.
+const MaxRuleDepth = 16
+
+// VersionPattern matches strings compared as versions, i.e. dot-separated numbers like 7.2 or 10.0.1.
+var VersionPattern = regexp.MustCompile(`^[0-9]+(\.[0-9]+)*$`)
+
+// ValidateRule returns ErrValidation for a malformed targeting rule, the fields of its violations are under "rule".
+func ValidateRule(rule *models.Rule) error {
+	return validateRule(rule, "rule", 1)
+}
+
+func validateRule(rule *models.Rule, path string, depth int) error {
+	if depth > MaxRuleDepth {
+		return Invalid(path, "max_depth", fmt.Sprintf("must be nested at most %d deep", MaxRuleDepth))
+	}
+
+	kinds := 0
+	if rule.And != nil {
+		kinds++
+	}
+	if rule.Or != nil {
+		kinds++
+	}
+	if rule.Not != nil {
+		kinds++
+	}
+	if rule.Attribute != "" || rule.Op != "" || rule.Value != nil {
+		kinds++
+	}
+	if kinds != 1 {
+		return Invalid(path, "exactly_one", "must set exactly one of and, or, not or a comparison")
+	}
+
+	switch {
+	case rule.And != nil || rule.Or != nil:
+		rules, field := rule.And, "and"
+		if rule.Or != nil {
+			rules, field = rule.Or, "or"
+		}
+		if len(rules) == 0 {
+			return Invalid(path+"."+field, "required", "must not be empty")
+		}
+		for i := range rules {
+			if err := validateRule(&rules[i], fmt.Sprintf("%s.%s[%d]", path, field, i), depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	case rule.Not != nil:
+		return validateRule(rule.Not, path+".not", depth+1)
+	}
+
+	if rule.Attribute == "" {
+		return Invalid(path+".attribute", "required", "must not be empty")
+	}
+
+	switch rule.Op {
+	case models.RuleOpEq, models.RuleOpNe:
+		if !isScalar(rule.Value) {
+			return Invalid(path+".value", "type", "must be a string, a number or a boolean")
+		}
+	case models.RuleOpGt, models.RuleOpGte, models.RuleOpLt, models.RuleOpLte:
+		switch rule.Value.(type) {
+		case string, float64:
+		default:
+			return Invalid(path+".value", "type", "must be a string or a number")
+		}
+	case models.RuleOpIn:
+		values, ok := rule.Value.([]any)
+		if !ok || len(values) == 0 {
+			return Invalid(path+".value", "type", "must be a non-empty list")
+		}
+		for i, value := range values {
+			if !isScalar(value) {
+				return Invalid(fmt.Sprintf("%s.value[%d]", path, i), "type", "must be a string, a number or a boolean")
+			}
+		}
+	default:
+		return Invalid(path+".op", "oneof", "must be one of eq, ne, gt, gte, lt, lte, in")
+	}
+
+	return nil
+}
+
+// MatchRule reports whether the user attributes match the rule. A comparison never matches an attribute
+// which is missing or is not a string, a number or a boolean, and values of different types never match.
+// Strings which both look like versions are compared as versions, other strings are compared bytewise.
+func MatchRule(rule *models.Rule, attributes map[string]any) bool {
+	switch {
+	case rule.And != nil:
+		for i := range rule.And {
+			if !MatchRule(&rule.And[i], attributes) {
+				return false
+			}
+		}
+		return true
+	case rule.Or != nil:
+		for i := range rule.Or {
+			if MatchRule(&rule.Or[i], attributes) {
+				return true
+			}
+		}
+		return false
+	case rule.Not != nil:
+		return !MatchRule(rule.Not, attributes)
+	}
+
+	value, ok := attributes[rule.Attribute]
+	if !ok || !isScalar(value) {
+		return false
+	}
+
+	switch rule.Op {
+	case models.RuleOpEq:
+		return value == rule.Value
+	case models.RuleOpNe:
+		return value != rule.Value
+	case models.RuleOpIn:
+		values, _ := rule.Value.([]any)
+		for _, v := range values {
+			if value == v {
+				return true
+			}
+		}
+		return false
+	}
+
+	c, ok := compareValues(value, rule.Value)
+	if !ok {
+		return false
+	}
+
+	switch rule.Op {
+	case models.RuleOpGt:
+		return c > 0
+	case models.RuleOpGte:
+		return c >= 0
+	case models.RuleOpLt:
+		return c < 0
+	case models.RuleOpLte:
+		return c <= 0
+	default:
+		return false
+	}
+}
+
+func isScalar(value any) bool {
+	switch value.(type) {
+	case string, float64, bool:
+		return true
+	default:
+		return false
+	}
+}
+
+func compareValues(a, b any) (int, bool) {
+	switch a := a.(type) {
+	case float64:
+		b, ok := b.(float64)
+		return cmp.Compare(a, b), ok
+	case string:
+		b, ok := b.(string)
+		if !ok {
+			return 0, false
+		}
+		if VersionPattern.MatchString(a) && VersionPattern.MatchString(b) {
+			return compareVersions(a, b), true
+		}
+		return strings.Compare(a, b), true
+	default:
+		return 0, false
+	}
+}
+
+// compareVersions compares versions by their numbers like PostgreSQL compares numeric arrays,
+// so 7.10 > 7.2 and 7 < 7.0.
+func compareVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		x, y := strings.TrimLeft(as[i], "0"), strings.TrimLeft(bs[i], "0")
+		if c := cmp.Compare(len(x), len(y)); c != 0 {
+			return c
+		}
+		if c := strings.Compare(x, y); c != 0 {
+			return c
+		}
+	}
+	return cmp.Compare(len(as), len(bs))
+}
@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/models"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/validate"
+)
+
+func TestValidateRuleViolations(t *testing.T) {
+	tests := []struct {
+		name     string
+		rule     *models.Rule
+		field    string
+		violated string
+	}{
+		{"empty", &models.Rule{}, "rule", "exactly_one"},
+		{"empty and", &models.Rule{And: []models.Rule{}}, "rule.and", "required"},
+		{"nested op", &models.Rule{Or: []models.Rule{{Attribute: "country", Op: models.RuleOpEq, Value: "RU"}, {Attribute: "plan", Op: "like", Value: "pro"}}}, "rule.or[1].op", "oneof"},
+		{"negated attribute", &models.Rule{Not: &models.Rule{Op: models.RuleOpEq, Value: "RU"}}, "rule.not.attribute", "required"},
+		{"list item", &models.Rule{Attribute: "country", Op: models.RuleOpIn, Value: []any{"RU", []any{}}}, "rule.value[1]", "type"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateRule(tt.rule)
+			if !errors.Is(err, ErrValidation) {
+				t.Fatalf("ValidateRule = %v, want ErrValidation", err)
+			}
+			var violations validate.Errors
+			if !errors.As(err, &violations) || len(violations) != 1 {
+				t.Fatalf("ValidateRule = %v, want one violation", err)
+			}
+			if violations[0].Field != tt.field || violations[0].Rule != tt.violated {
+				t.Fatalf("violation = %+v, want %s of %s", violations[0], tt.violated, tt.field)
+			}
+		})
+	}
+}
+
+func TestValidateVariantsViolations(t *testing.T) {
+	err := validateVariants([]models.Variant{{Name: "a", Weight: 1}, {Name: "b", Weight: 0}})
+	var violations validate.Errors
+	if !errors.Is(err, ErrValidation) || !errors.As(err, &violations) {
+		t.Fatalf("validateVariants = %v, want ErrValidation with violations", err)
+	}
+	if len(violations) != 1 || violations[0].Field != "variants[1].weight" || violations[0].Rule != "min" {
+		t.Fatalf("violations = %+v, want min of variants[1].weight", violations)
+	}
+}
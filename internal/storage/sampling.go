@@ -0,0 +1,28 @@
+package storage
+
+import (
+	"math"
+	"math/rand"
+)
+
+// SampleSize returns the number of users put into a segment with auto percent when it is created.
+// The exact share is rounded to the nearest integer with halves rounded up, so with 1 user
+// a segment gets the user only for 50% and more and an empty users table always gives 0.
+func SampleSize(total int64, percent float64) int64 {
+	if total <= 0 || percent <= 0 {
+		return 0
+	}
+	if percent >= 100 {
+		return total
+	}
+	return int64(math.Floor(float64(total)*percent/100 + 0.5))
+}
+
+// InSample decides whether a user created after a segment with auto percent gets into it.
+// Each user is drawn independently, so the share of the segment stays at percent on average.
+func InSample(percent float64) bool {
+	if percent <= 0 {
+		return false
+	}
+	return rand.Float64()*100 < percent
+}
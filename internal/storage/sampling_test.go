@@ -0,0 +1,46 @@
+package storage
+
+import "testing"
+
+func TestSampleSize(t *testing.T) {
+	tests := []struct {
+		name    string
+		total   int64
+		percent float64
+		want    int64
+	}{
+		{"no users", 0, 50, 0},
+		{"no users at 100%", 0, 100, 0},
+		{"one user below half", 1, 49.9, 0},
+		{"one user at half", 1, 50, 1},
+		{"one user at 100%", 1, 100, 1},
+		{"zero percent", 10, 0, 0},
+		{"negative percent", 10, -5, 0},
+		{"all users", 10, 100, 10},
+		{"above 100%", 10, 150, 10},
+		{"exact", 10, 30, 3},
+		{"exact half rounds up", 10, 35, 4},
+		{"odd half rounds up", 3, 50, 2},
+		{"below half rounds down", 10, 34.9, 3},
+		{"small share of many users", 1000, 0.05, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SampleSize(tt.total, tt.percent); got != tt.want {
+				t.Fatalf("SampleSize(%d, %v) = %d, want %d", tt.total, tt.percent, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInSample(t *testing.T) {
+	for i := 0; i < 1000; i++ {
+		if InSample(0) || InSample(-1) {
+			t.Fatal("InSample of a non-positive percent = true, want false")
+		}
+		if !InSample(100) {
+			t.Fatal("InSample(100) = false, want true")
+		}
+	}
+}
@@ -1,14 +1,36 @@
 package storage
 
-import "github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/models"
+import (
+	"context"
+	"time"
 
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/models"
+)
+
+// Segments are looked up by their name or by an active alias of a renamed segment in the methods taking a slug
+// and in UserStorage.UpdateUserSegments.
 type SegmentStorage interface {
-	CreateSegment(segment *models.Segment) error
-	GetSegments() ([]*models.Segment, error)
-	GetSegmentByName(slug string) (*models.Segment, error)
-	UpdateSegment(segment *models.Segment) error
-	DeleteSegmentBySlug(slug string) error
-	GetUsersInSegment(slug string) ([]*models.User, error)
-	AddUserToSegment(slug string, userID int64) error
-	DeleteUserFromSegment(slug string, userID int64) error
+	CreateSegment(ctx context.Context, segment *models.Segment) error
+	GetSegments(ctx context.Context, filter SegmentFilter, params ListParams) (*Page[models.Segment], error)
+	GetSegmentByName(ctx context.Context, slug string) (*models.Segment, error)
+	// UpdateSegment updates the segment and returns it as GetSegmentByName does. With a non-empty ifMatch the segment
+	// is updated only while ifMatch is the ETag of its representation, which is compared while the segment is locked.
+	UpdateSegment(ctx context.Context, segment *models.Segment, ifMatch string) (*models.Segment, error)
+	// PatchSegment applies the patch to the segment while it is locked, checking ifMatch like UpdateSegment does,
+	// and writes the auto percent, the percent and the rule even when they are cleared
+	PatchSegment(ctx context.Context, slug string, ifMatch string, patch func(segment *models.Segment) error) (*models.Segment, error)
+	// DeleteSegmentBySlug deletes the segment, checking ifMatch like UpdateSegment does
+	DeleteSegmentBySlug(ctx context.Context, slug string, ifMatch string) error
+	// RenameSegment renames the segment together with its memberships and history, checking ifMatch like
+	// UpdateSegment does. With a positive aliasTTL the former name keeps resolving to the segment until the TTL passes.
+	RenameSegment(ctx context.Context, slug, name string, ifMatch string, aliasTTL time.Duration) (*models.Segment, error)
+	// DeleteExpiredAliases deletes the aliases of renamed segments whose TTL has passed by now
+	DeleteExpiredAliases(ctx context.Context, now time.Time) (int64, error)
+	GetUsersInSegment(ctx context.Context, slug string, filter UserFilter, params ListParams) (*Page[models.User], error)
+	// CountSegmentUsers returns the number of active members of every segment
+	CountSegmentUsers(ctx context.Context) (map[string]int64, error)
+	// PreviewRule returns a page of users a segment with the targeting rule would contain
+	PreviewRule(ctx context.Context, rule *models.Rule, params ListParams) (*Page[models.User], error)
+	AddUserToSegment(ctx context.Context, slug string, userID int64, expiresAt *time.Time, variant string) error
+	DeleteUserFromSegment(ctx context.Context, slug string, userID int64) error
 }
@@ -0,0 +1,111 @@
+package storagetest
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/models"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/storage"
+)
+
+// APIKeyFactory returns an API key storage backed by an empty database.
+type APIKeyFactory func(t *testing.T) storage.APIKeyStorage
+
+// RunAPIKeys runs the conformance tests of storage.APIKeyStorage.
+func RunAPIKeys(t *testing.T, newStorage APIKeyFactory) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, ks storage.APIKeyStorage)
+	}{
+		{"CreateAndGetAPIKey", testCreateAndGetAPIKey},
+		{"APIKeyValidation", testAPIKeyValidation},
+		{"DeleteAPIKey", testDeleteAPIKey},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newStorage(t))
+		})
+	}
+}
+
+func hash(c string) string {
+	return strings.Repeat(c, 64)
+}
+
+func testCreateAndGetAPIKey(t *testing.T, ks storage.APIKeyStorage) {
+	key := &models.APIKey{
+		Name:   "reader",
+		Prefix: "sk_abcdefg",
+		Hash:   hash("a"),
+		Scopes: []models.Scope{models.ScopeSegmentsRead, models.ScopeEvaluate, models.ScopeSegmentsRead},
+	}
+	if err := ks.CreateAPIKey(ctx, key); err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+	if key.ID == 0 {
+		t.Fatal("CreateAPIKey: ID is not assigned")
+	}
+
+	got, err := ks.GetAPIKeyByHash(ctx, hash("a"))
+	if err != nil {
+		t.Fatalf("GetAPIKeyByHash: %v", err)
+	}
+	wantScopes := []models.Scope{models.ScopeSegmentsRead, models.ScopeEvaluate}
+	if got.ID != key.ID || got.Name != "reader" || got.Prefix != "sk_abcdefg" || !slices.Equal(got.Scopes, wantScopes) {
+		t.Errorf("GetAPIKeyByHash = %+v, want %+v with scopes %v", got, key, wantScopes)
+	}
+
+	if _, err := ks.GetAPIKeyByHash(ctx, hash("b")); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("GetAPIKeyByHash(unknown) error = %v, want ErrNotFound", err)
+	}
+
+	duplicate := &models.APIKey{Name: "duplicate", Hash: hash("a"), Scopes: []models.Scope{models.ScopeAdmin}}
+	if err := ks.CreateAPIKey(ctx, duplicate); !errors.Is(err, storage.ErrAlreadyExists) {
+		t.Errorf("CreateAPIKey(same hash) error = %v, want ErrAlreadyExists", err)
+	}
+
+	if err := ks.CreateAPIKey(ctx, &models.APIKey{Name: "admin", Hash: hash("c"), Scopes: []models.Scope{models.ScopeAdmin}}); err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+
+	keys, err := ks.GetAPIKeys(ctx)
+	if err != nil {
+		t.Fatalf("GetAPIKeys: %v", err)
+	}
+	if len(keys) != 2 || keys[0].Name != "reader" || keys[1].Name != "admin" {
+		t.Errorf("GetAPIKeys = %+v, want reader and admin", keys)
+	}
+}
+
+func testAPIKeyValidation(t *testing.T, ks storage.APIKeyStorage) {
+	for name, key := range map[string]*models.APIKey{
+		"empty name":    {Hash: hash("a"), Scopes: []models.Scope{models.ScopeAdmin}},
+		"no scopes":     {Name: "key", Hash: hash("a")},
+		"unknown scope": {Name: "key", Hash: hash("a"), Scopes: []models.Scope{"users:delete"}},
+		"invalid hash":  {Name: "key", Hash: "abc", Scopes: []models.Scope{models.ScopeAdmin}},
+	} {
+		if err := ks.CreateAPIKey(ctx, key); !errors.Is(err, storage.ErrValidation) {
+			t.Errorf("CreateAPIKey(%s) error = %v, want ErrValidation", name, err)
+		}
+	}
+}
+
+func testDeleteAPIKey(t *testing.T, ks storage.APIKeyStorage) {
+	key := &models.APIKey{Name: "key", Hash: hash("a"), Scopes: []models.Scope{models.ScopeUsersWrite}}
+	if err := ks.CreateAPIKey(ctx, key); err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+
+	if err := ks.DeleteAPIKey(ctx, key.ID); err != nil {
+		t.Fatalf("DeleteAPIKey: %v", err)
+	}
+	if _, err := ks.GetAPIKeyByHash(ctx, hash("a")); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("GetAPIKeyByHash(deleted) error = %v, want ErrNotFound", err)
+	}
+	if err := ks.DeleteAPIKey(ctx, key.ID); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("DeleteAPIKey(deleted) error = %v, want ErrNotFound", err)
+	}
+}
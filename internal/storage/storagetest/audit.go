@@ -0,0 +1,225 @@
+package storagetest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/models"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/storage"
+)
+
+// AuditFactory returns storages backed by the same empty database.
+type AuditFactory func(t *testing.T) (storage.UserStorage, storage.SegmentStorage, storage.AuditStorage)
+
+// RunAudit runs the conformance tests of the audit log written by the user and segment storages.
+func RunAudit(t *testing.T, newStorages AuditFactory) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, us storage.UserStorage, ss storage.SegmentStorage, as storage.AuditStorage)
+	}{
+		{"AuditMutations", testAuditMutations},
+		{"AuditFailedMutation", testAuditFailedMutation},
+		{"AuditAutoPercent", testAuditAutoPercent},
+		{"AuditFilterAndPagination", testAuditFilterAndPagination},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			us, ss, as := newStorages(t)
+			tt.test(t, us, ss, as)
+		})
+	}
+}
+
+func auditEntries(t *testing.T, as storage.AuditStorage, filter storage.AuditFilter) []*models.AuditEntry {
+	t.Helper()
+
+	page, err := as.GetAuditEntries(ctx, filter, storage.ListParams{Limit: storage.MaxLimit})
+	if err != nil {
+		t.Fatalf("GetAuditEntries: %v", err)
+	}
+	return page.Items
+}
+
+func decodeAudit(t *testing.T, data json.RawMessage) map[string]any {
+	t.Helper()
+
+	var value map[string]any
+	if err := json.Unmarshal(data, &value); err != nil {
+		t.Fatalf("audit JSON %s: %v", data, err)
+	}
+	return value
+}
+
+func testAuditMutations(t *testing.T, us storage.UserStorage, ss storage.SegmentStorage, as storage.AuditStorage) {
+	actx := storage.WithAuditInfo(context.Background(), storage.AuditInfo{Actor: "api-key:1", ActorName: "ops", RequestID: "req-1"})
+
+	user := &models.User{FirstName: "Ivan", LastName: "Ivanov", Username: "ivan"}
+	if err := us.CreateUser(actx, user); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	if _, err := us.UpdateUser(actx, &models.User{ID: user.ID, FirstName: "Petr"}, ""); err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+	if err := ss.CreateSegment(actx, &models.Segment{Name: "AVITO_VOICE_MESSAGES"}); err != nil {
+		t.Fatalf("CreateSegment: %v", err)
+	}
+	if _, err := ss.UpdateSegment(actx, &models.Segment{Name: "AVITO_VOICE_MESSAGES", AutoPercent: 10}, ""); err != nil {
+		t.Fatalf("UpdateSegment: %v", err)
+	}
+	if err := us.UpdateUserSegments(actx, user.ID, []models.SegmentAssignment{{Name: "AVITO_VOICE_MESSAGES"}}, nil); err != nil {
+		t.Fatalf("UpdateUserSegments: %v", err)
+	}
+	if err := ss.DeleteUserFromSegment(actx, "AVITO_VOICE_MESSAGES", user.ID); err != nil {
+		t.Fatalf("DeleteUserFromSegment: %v", err)
+	}
+	if err := ss.AddUserToSegment(actx, "AVITO_VOICE_MESSAGES", user.ID, nil, ""); err != nil {
+		t.Fatalf("AddUserToSegment: %v", err)
+	}
+	if err := ss.DeleteSegmentBySlug(actx, "AVITO_VOICE_MESSAGES", ""); err != nil {
+		t.Fatalf("DeleteSegmentBySlug: %v", err)
+	}
+	if err := us.DeleteUser(ctx, user.ID, ""); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+
+	entries := auditEntries(t, as, storage.AuditFilter{})
+	want := []models.AuditAction{
+		models.AuditUserCreate, models.AuditUserUpdate, models.AuditSegmentCreate, models.AuditSegmentUpdate,
+		models.AuditUserSegmentsUpdate, models.AuditMembershipRemove, models.AuditMembershipAdd,
+		models.AuditSegmentDelete, models.AuditUserDelete,
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("got %d audit entries, want %d: %+v", len(entries), len(want), entries)
+	}
+	for i, entry := range entries {
+		if entry.Action != want[i] {
+			t.Errorf("entry %d action = %s, want %s", i, entry.Action, want[i])
+		}
+	}
+
+	update := entries[1]
+	if update.Actor != "api-key:1" || update.ActorName != "ops" || update.RequestID != "req-1" {
+		t.Errorf("user update actor = %s (%s) request %s, want api-key:1 (ops) request req-1", update.Actor, update.ActorName, update.RequestID)
+	}
+	if before, after := decodeAudit(t, update.Before), decodeAudit(t, update.After); before["firstname"] != "Ivan" || after["firstname"] != "Petr" {
+		t.Errorf("user update before = %v, after = %v, want firstname Ivan then Petr", before, after)
+	}
+
+	create := entries[0]
+	if string(create.Before) != "null" || decodeAudit(t, create.After)["username"] != "ivan" {
+		t.Errorf("user create before = %s, after = %s, want null and the user", create.Before, create.After)
+	}
+
+	var memberships []models.AuditMembership
+	if err := json.Unmarshal(entries[4].After, &memberships); err != nil || len(memberships) != 1 || memberships[0].Segment != "AVITO_VOICE_MESSAGES" {
+		t.Errorf("user segments update after = %s, want the membership in AVITO_VOICE_MESSAGES", entries[4].After)
+	}
+
+	if entries[5].ResourceType != models.AuditResourceMembership || entries[5].ResourceID != storage.MembershipResourceID("AVITO_VOICE_MESSAGES", user.ID) {
+		t.Errorf("membership removal resource = %s %s", entries[5].ResourceType, entries[5].ResourceID)
+	}
+
+	if deleted := entries[8]; deleted.Actor != storage.SystemActor || string(deleted.After) != "null" {
+		t.Errorf("user delete actor = %s, after = %s, want %s and null", deleted.Actor, deleted.After, storage.SystemActor)
+	}
+}
+
+func testAuditFailedMutation(t *testing.T, us storage.UserStorage, ss storage.SegmentStorage, as storage.AuditStorage) {
+	user := createUser(t, us, "ivan")
+
+	err := us.UpdateUserSegments(ctx, user.ID, []models.SegmentAssignment{{Name: "MISSING"}}, nil)
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("UpdateUserSegments(missing segment) error = %v, want ErrNotFound", err)
+	}
+	if err := ss.DeleteSegmentBySlug(ctx, "MISSING", ""); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("DeleteSegmentBySlug(missing) error = %v, want ErrNotFound", err)
+	}
+
+	entries := auditEntries(t, as, storage.AuditFilter{})
+	if len(entries) != 1 || entries[0].Action != models.AuditUserCreate {
+		t.Errorf("audit entries = %+v, want only the user creation", entries)
+	}
+}
+
+func testAuditAutoPercent(t *testing.T, us storage.UserStorage, ss storage.SegmentStorage, as storage.AuditStorage) {
+	actx := storage.WithAuditInfo(context.Background(), storage.AuditInfo{Actor: "api-key:1", RequestID: "req-1"})
+
+	first := createUser(t, us, "a")
+	second := createUser(t, us, "b")
+	if err := ss.CreateSegment(actx, &models.Segment{Name: "AUTO", AutoPercent: 100}); err != nil {
+		t.Fatalf("CreateSegment: %v", err)
+	}
+	third := createUser(t, us, "c")
+
+	filter := storage.AuditFilter{Action: models.AuditMembershipAdd}
+	entries := auditEntries(t, as, filter)
+	if len(entries) != 3 {
+		t.Fatalf("got %d membership additions, want 3: %+v", len(entries), entries)
+	}
+
+	ids := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		ids[entry.ResourceID] = true
+		if entry.ResourceType != models.AuditResourceMembership || string(entry.Before) != "null" {
+			t.Errorf("membership addition = %s %s before %s, want a membership from null", entry.ResourceType, entry.ResourceID, entry.Before)
+		}
+		if after := decodeAudit(t, entry.After); after["segment"] != "AUTO" {
+			t.Errorf("membership addition after = %s, want the membership in AUTO", entry.After)
+		}
+	}
+	for _, user := range []*models.User{first, second, third} {
+		if !ids[storage.MembershipResourceID("AUTO", user.ID)] {
+			t.Errorf("no membership addition of user %d, got %v", user.ID, ids)
+		}
+	}
+
+	// sampled memberships of a created segment are changes of its creator
+	if sampled := entries[0]; sampled.Actor != "api-key:1" || sampled.RequestID != "req-1" {
+		t.Errorf("sampled membership actor = %s request %s, want api-key:1 request req-1", sampled.Actor, sampled.RequestID)
+	}
+}
+
+func testAuditFilterAndPagination(t *testing.T, us storage.UserStorage, ss storage.SegmentStorage, as storage.AuditStorage) {
+	for _, username := range []string{"a", "b", "c"} {
+		createUser(t, us, username)
+	}
+	createSegment(t, ss, "SEGMENT")
+
+	if got := auditEntries(t, as, storage.AuditFilter{ResourceType: models.AuditResourceSegment}); len(got) != 1 || got[0].ResourceID != "SEGMENT" {
+		t.Errorf("segment entries = %+v, want the SEGMENT creation", got)
+	}
+	if got := auditEntries(t, as, storage.AuditFilter{Action: models.AuditUserCreate}); len(got) != 3 {
+		t.Errorf("got %d user creations, want 3", len(got))
+	}
+
+	var ids []int64
+	params := storage.ListParams{Limit: 3, Desc: true, WithTotal: true}
+	for {
+		page, err := as.GetAuditEntries(ctx, storage.AuditFilter{}, params)
+		if err != nil {
+			t.Fatalf("GetAuditEntries: %v", err)
+		}
+		if page.Total == nil || *page.Total != 4 {
+			t.Errorf("total = %v, want 4", page.Total)
+		}
+		for _, entry := range page.Items {
+			ids = append(ids, entry.ID)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		params.Cursor = page.NextCursor
+	}
+
+	if len(ids) != 4 {
+		t.Fatalf("got %d entries over pages, want 4", len(ids))
+	}
+	for i := 1; i < len(ids); i++ {
+		if ids[i] >= ids[i-1] {
+			t.Errorf("entries are not in descending order: %v", ids)
+		}
+	}
+}
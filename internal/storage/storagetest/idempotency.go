@@ -0,0 +1,123 @@
+package storagetest
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/models"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/storage"
+)
+
+// IdempotencyFactory returns an idempotency key storage backed by an empty database.
+type IdempotencyFactory func(t *testing.T) storage.IdempotencyStorage
+
+// RunIdempotency runs the conformance tests of storage.IdempotencyStorage.
+func RunIdempotency(t *testing.T, newStorage IdempotencyFactory) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, ks storage.IdempotencyStorage)
+	}{
+		{"ReserveAndComplete", testReserveAndComplete},
+		{"ReleaseIdempotencyKey", testReleaseIdempotencyKey},
+		{"ExpiredIdempotencyKeys", testExpiredIdempotencyKeys},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newStorage(t))
+		})
+	}
+}
+
+func newIdempotencyKey(scope, key string, ttl time.Duration) *models.IdempotencyKey {
+	return &models.IdempotencyKey{Scope: scope, Key: key, RequestHash: hash("a"), ExpiresAt: time.Now().Add(ttl)}
+}
+
+func reserve(t *testing.T, ks storage.IdempotencyStorage, key *models.IdempotencyKey) *models.IdempotencyKey {
+	t.Helper()
+
+	existing, err := ks.ReserveIdempotencyKey(ctx, key)
+	if err != nil {
+		t.Fatalf("ReserveIdempotencyKey(%s): %v", key.Key, err)
+	}
+	return existing
+}
+
+func testReserveAndComplete(t *testing.T, ks storage.IdempotencyStorage) {
+	key := newIdempotencyKey("api-key:1", "key", time.Hour)
+	if existing := reserve(t, ks, key); existing != nil {
+		t.Fatalf("first ReserveIdempotencyKey returned %+v, want nil", existing)
+	}
+
+	existing := reserve(t, ks, newIdempotencyKey("api-key:1", "key", time.Hour))
+	if existing == nil || existing.Completed() || existing.RequestHash != hash("a") {
+		t.Fatalf("ReserveIdempotencyKey(in progress) = %+v, want the pending key", existing)
+	}
+
+	if other := reserve(t, ks, newIdempotencyKey("api-key:2", "key", time.Hour)); other != nil {
+		t.Errorf("ReserveIdempotencyKey(other scope) = %+v, want nil", other)
+	}
+
+	key.StatusCode = 201
+	key.Headers = map[string][]string{"Content-Type": {"application/json"}, "Location": {"/api/v1/users/1"}}
+	key.Body = []byte(`{"id":1}`)
+	if err := ks.CompleteIdempotencyKey(ctx, key); err != nil {
+		t.Fatalf("CompleteIdempotencyKey: %v", err)
+	}
+	if err := ks.CompleteIdempotencyKey(ctx, key); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("CompleteIdempotencyKey(completed) error = %v, want ErrNotFound", err)
+	}
+
+	existing = reserve(t, ks, newIdempotencyKey("api-key:1", "key", time.Hour))
+	if existing == nil || existing.StatusCode != 201 || !reflect.DeepEqual(existing.Headers, key.Headers) || string(existing.Body) != `{"id":1}` {
+		t.Errorf("ReserveIdempotencyKey(completed) = %+v, want the stored response", existing)
+	}
+}
+
+func testReleaseIdempotencyKey(t *testing.T, ks storage.IdempotencyStorage) {
+	key := newIdempotencyKey("", "key", time.Hour)
+	reserve(t, ks, key)
+
+	if err := ks.ReleaseIdempotencyKey(ctx, "", "key"); err != nil {
+		t.Fatalf("ReleaseIdempotencyKey: %v", err)
+	}
+	if existing := reserve(t, ks, key); existing != nil {
+		t.Errorf("ReserveIdempotencyKey(released) = %+v, want nil", existing)
+	}
+
+	key.StatusCode = 200
+	if err := ks.CompleteIdempotencyKey(ctx, key); err != nil {
+		t.Fatalf("CompleteIdempotencyKey: %v", err)
+	}
+	if err := ks.ReleaseIdempotencyKey(ctx, "", "key"); err != nil {
+		t.Fatalf("ReleaseIdempotencyKey(completed): %v", err)
+	}
+	if existing := reserve(t, ks, key); existing == nil || !existing.Completed() {
+		t.Errorf("ReserveIdempotencyKey after releasing a completed key = %+v, want the completed key", existing)
+	}
+}
+
+func testExpiredIdempotencyKeys(t *testing.T, ks storage.IdempotencyStorage) {
+	expired := newIdempotencyKey("", "expired", time.Millisecond)
+	reserve(t, ks, expired)
+	reserve(t, ks, newIdempotencyKey("", "active", time.Hour))
+	time.Sleep(10 * time.Millisecond)
+
+	if existing := reserve(t, ks, newIdempotencyKey("", "expired", time.Millisecond)); existing != nil {
+		t.Errorf("ReserveIdempotencyKey(expired) = %+v, want nil", existing)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	deleted, err := ks.DeleteExpiredIdempotencyKeys(ctx, time.Now())
+	if err != nil {
+		t.Fatalf("DeleteExpiredIdempotencyKeys: %v", err)
+	}
+	if deleted != 1 {
+		t.Errorf("DeleteExpiredIdempotencyKeys = %d, want 1", deleted)
+	}
+	if existing := reserve(t, ks, newIdempotencyKey("", "active", time.Hour)); existing == nil {
+		t.Error("active key was deleted")
+	}
+}
@@ -0,0 +1,1193 @@
+// Package storagetest is a conformance test suite every storage.UserStorage and
+// storage.SegmentStorage implementation must pass. A backend runs it from its own tests:
+//
+//	func TestConformance(t *testing.T) {
+//		storagetest.Run(t, func(t *testing.T) (storage.UserStorage, storage.SegmentStorage) {
+//			// return storages backed by an empty database
+//		})
+//	}
+//
+// Implementations of storage.APIKeyStorage, storage.AuditStorage and storage.IdempotencyStorage
+// run RunAPIKeys, RunAudit and RunIdempotency the same way.
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/models"
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/storage"
+)
+
+// ctx is passed to the storage methods, the suite does not test cancellation.
+var ctx = context.Background()
+
+// Factory returns storages backed by an empty database.
+type Factory func(t *testing.T) (storage.UserStorage, storage.SegmentStorage)
+
+// Run runs the whole suite, every test gets fresh storages from newStorages.
+func Run(t *testing.T, newStorages Factory) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, us storage.UserStorage, ss storage.SegmentStorage)
+	}{
+		{"CreateAndGetUser", testCreateAndGetUser},
+		{"UniqueUsername", testUniqueUsername},
+		{"UpdateUser", testUpdateUser},
+		{"ETags", testETags},
+		{"Patch", testPatch},
+		{"DeleteUserCascade", testDeleteUserCascade},
+		{"DeleteSegmentCascade", testDeleteSegmentCascade},
+		{"UpdateUserSegments", testUpdateUserSegments},
+		{"UpdateUserSegmentsIsTransactional", testUpdateUserSegmentsIsTransactional},
+		{"AddAndDeleteUserInSegment", testAddAndDeleteUserInSegment},
+		{"SegmentsHistory", testSegmentsHistory},
+		{"ExpiredSegments", testExpiredSegments},
+		{"AutoPercent", testAutoPercent},
+		{"Experiment", testExperiment},
+		{"Variants", testVariants},
+		{"RenameSegment", testRenameSegment},
+		{"Rules", testRules},
+		{"Evaluate", testEvaluate},
+		{"ListUsersPagination", testListUsersPagination},
+		{"ListSegmentsPagination", testListSegmentsPagination},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			us, ss := newStorages(t)
+			tt.test(t, us, ss)
+		})
+	}
+}
+
+func createUser(t *testing.T, us storage.UserStorage, username string) *models.User {
+	t.Helper()
+
+	user := &models.User{FirstName: "Ivan", LastName: "Ivanov", Username: username}
+	if err := us.CreateUser(ctx, user); err != nil {
+		t.Fatalf("CreateUser(%s): %v", username, err)
+	}
+	if user.ID == 0 {
+		t.Fatalf("CreateUser(%s): ID is not assigned", username)
+	}
+	return user
+}
+
+func createSegment(t *testing.T, ss storage.SegmentStorage, name string) {
+	t.Helper()
+
+	if err := ss.CreateSegment(ctx, &models.Segment{Name: name}); err != nil {
+		t.Fatalf("CreateSegment(%s): %v", name, err)
+	}
+}
+
+func userSegments(t *testing.T, us storage.UserStorage, id int64) []string {
+	t.Helper()
+
+	user, err := us.GetUserByID(ctx, id)
+	if err != nil {
+		t.Fatalf("GetUserByID(%d): %v", id, err)
+	}
+
+	names := make([]string, 0, len(user.Segments))
+	for _, segment := range user.Segments {
+		names = append(names, segment.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func usersInSegment(t *testing.T, ss storage.SegmentStorage, slug string) []*models.User {
+	t.Helper()
+
+	page, err := ss.GetUsersInSegment(ctx, slug, storage.UserFilter{}, storage.ListParams{Limit: storage.MaxLimit})
+	if err != nil {
+		t.Fatalf("GetUsersInSegment(%s): %v", slug, err)
+	}
+	return page.Items
+}
+
+func assertSegments(t *testing.T, got []string, want ...string) {
+	t.Helper()
+
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("segments = %v, want %v", got, want)
+	}
+}
+
+func testCreateAndGetUser(t *testing.T, us storage.UserStorage, _ storage.SegmentStorage) {
+	created := createUser(t, us, "ivan")
+
+	user, err := us.GetUserByID(ctx, created.ID)
+	if err != nil {
+		t.Fatalf("GetUserByID: %v", err)
+	}
+	if user == nil || user.Username != "ivan" || user.FirstName != "Ivan" {
+		t.Fatalf("GetUserByID = %+v, want user 'ivan'", user)
+	}
+
+	page, err := us.GetUsers(ctx, storage.UserFilter{}, storage.ListParams{})
+	if err != nil {
+		t.Fatalf("GetUsers: %v", err)
+	}
+	if len(page.Items) != 1 {
+		t.Fatalf("GetUsers returned %d users, want 1", len(page.Items))
+	}
+}
+
+func testUniqueUsername(t *testing.T, us storage.UserStorage, _ storage.SegmentStorage) {
+	createUser(t, us, "ivan")
+
+	if err := us.CreateUser(ctx, &models.User{Username: "ivan"}); !errors.Is(err, storage.ErrAlreadyExists) {
+		t.Fatalf("CreateUser with a duplicate username: %v, want ErrAlreadyExists", err)
+	}
+
+	if err := us.CreateUser(ctx, &models.User{FirstName: "Ivan"}); !errors.Is(err, storage.ErrValidation) {
+		t.Fatalf("CreateUser without username: %v, want ErrValidation", err)
+	}
+
+	other := createUser(t, us, "petr")
+	if _, err := us.UpdateUser(ctx, &models.User{ID: other.ID, Username: "ivan"}, ""); !errors.Is(err, storage.ErrAlreadyExists) {
+		t.Fatalf("UpdateUser to a duplicate username: %v, want ErrAlreadyExists", err)
+	}
+}
+
+func testUpdateUser(t *testing.T, us storage.UserStorage, _ storage.SegmentStorage) {
+	created := createUser(t, us, "ivan")
+
+	if _, err := us.UpdateUser(ctx, &models.User{ID: created.ID, FirstName: "Petr"}, ""); err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+
+	user, err := us.GetUserByID(ctx, created.ID)
+	if err != nil {
+		t.Fatalf("GetUserByID: %v", err)
+	}
+	if user.FirstName != "Petr" || user.Username != "ivan" {
+		t.Fatalf("GetUserByID = %+v, want updated first name only", user)
+	}
+
+	if _, err := us.UpdateUser(ctx, &models.User{ID: created.ID + 1000, FirstName: "Petr"}, ""); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("UpdateUser of a missing user: %v, want ErrNotFound", err)
+	}
+}
+
+func testETags(t *testing.T, us storage.UserStorage, ss storage.SegmentStorage) {
+	created := createUser(t, us, "ivan")
+	if created.Version != 1 {
+		t.Fatalf("CreateUser version = %d, want 1", created.Version)
+	}
+	current, err := us.GetUserByID(ctx, created.ID)
+	if err != nil {
+		t.Fatalf("GetUserByID: %v", err)
+	}
+
+	updated, err := us.UpdateUser(ctx, &models.User{ID: created.ID, FirstName: "Petr"}, storage.ETag(current))
+	if err != nil {
+		t.Fatalf("UpdateUser of the current representation: %v", err)
+	}
+	if updated.FirstName != "Petr" || updated.Version != 2 {
+		t.Fatalf("UpdateUser = %+v, want the updated user of version 2", updated)
+	}
+
+	stale := storage.ETag(current)
+	if _, err := us.UpdateUser(ctx, &models.User{ID: created.ID, FirstName: "Ivan"}, stale); !errors.Is(err, storage.ErrVersionMismatch) {
+		t.Fatalf("UpdateUser of a stale representation: %v, want ErrVersionMismatch", err)
+	}
+	if _, err := us.PatchUser(ctx, created.ID, stale, func(*models.User) error { return nil }); !errors.Is(err, storage.ErrVersionMismatch) {
+		t.Fatalf("PatchUser of a stale representation: %v, want ErrVersionMismatch", err)
+	}
+	if err := us.DeleteUser(ctx, created.ID, stale); !errors.Is(err, storage.ErrVersionMismatch) {
+		t.Fatalf("DeleteUser of a stale representation: %v, want ErrVersionMismatch", err)
+	}
+
+	user, err := us.GetUserByID(ctx, created.ID)
+	if err != nil {
+		t.Fatalf("GetUserByID: %v", err)
+	}
+	if storage.ETag(user) != storage.ETag(updated) {
+		t.Fatalf("GetUserByID = %+v, want the representation UpdateUser returned %+v", user, updated)
+	}
+
+	// memberships change the representation without changing the version
+	createSegment(t, ss, "AVITO_VOICE_MESSAGES")
+	if err := ss.AddUserToSegment(ctx, "AVITO_VOICE_MESSAGES", created.ID, nil, ""); err != nil {
+		t.Fatalf("AddUserToSegment: %v", err)
+	}
+	if err := us.DeleteUser(ctx, created.ID, storage.ETag(updated)); !errors.Is(err, storage.ErrVersionMismatch) {
+		t.Fatalf("DeleteUser of the representation without the membership: %v, want ErrVersionMismatch", err)
+	}
+	if user, err = us.GetUserByID(ctx, created.ID); err != nil {
+		t.Fatalf("GetUserByID: %v", err)
+	}
+	if err := us.DeleteUser(ctx, created.ID, storage.ETag(user)); err != nil {
+		t.Fatalf("DeleteUser of the current representation: %v", err)
+	}
+
+	createSegment(t, ss, "EXPERIMENT")
+	segment, err := ss.GetSegmentByName(ctx, "EXPERIMENT")
+	if err != nil {
+		t.Fatalf("GetSegmentByName: %v", err)
+	}
+	stale = storage.ETag(segment)
+	if segment, err = ss.UpdateSegment(ctx, &models.Segment{Name: "EXPERIMENT", AutoPercent: 10}, stale); err != nil {
+		t.Fatalf("UpdateSegment of the current representation: %v", err)
+	}
+	if segment.AutoPercent != 10 || segment.Version != 2 {
+		t.Fatalf("UpdateSegment = %+v, want auto percent 10 of version 2", segment)
+	}
+	if _, err := ss.UpdateSegment(ctx, &models.Segment{Name: "EXPERIMENT", AutoPercent: 20}, stale); !errors.Is(err, storage.ErrVersionMismatch) {
+		t.Fatalf("UpdateSegment of a stale representation: %v, want ErrVersionMismatch", err)
+	}
+	if _, err := ss.PatchSegment(ctx, "EXPERIMENT", stale, func(*models.Segment) error { return nil }); !errors.Is(err, storage.ErrVersionMismatch) {
+		t.Fatalf("PatchSegment of a stale representation: %v, want ErrVersionMismatch", err)
+	}
+	if err := ss.DeleteSegmentBySlug(ctx, "EXPERIMENT", stale); !errors.Is(err, storage.ErrVersionMismatch) {
+		t.Fatalf("DeleteSegmentBySlug of a stale representation: %v, want ErrVersionMismatch", err)
+	}
+	if _, err := ss.UpdateSegment(ctx, &models.Segment{Name: "EXPERIMENT", AutoPercent: 20}, ""); err != nil {
+		t.Fatalf("UpdateSegment of any representation: %v", err)
+	}
+
+	stored, err := ss.GetSegmentByName(ctx, "EXPERIMENT")
+	if err != nil {
+		t.Fatalf("GetSegmentByName: %v", err)
+	}
+	if stored.AutoPercent != 20 || stored.Version != 3 {
+		t.Fatalf("GetSegmentByName = %+v, want auto percent 20 of version 3", stored)
+	}
+}
+
+func testPatch(t *testing.T, us storage.UserStorage, ss storage.SegmentStorage) {
+	created := createUser(t, us, "ivan")
+	createUser(t, us, "petr")
+
+	user, err := us.PatchUser(ctx, created.ID, "", func(user *models.User) error {
+		user.LastName = ""
+		user.Attributes = map[string]any{"plan": "pro"}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("PatchUser: %v", err)
+	}
+	if user.LastName != "" || user.FirstName != "Ivan" || user.Version != 2 {
+		t.Fatalf("PatchUser = %+v, want the last name cleared of version 2", user)
+	}
+
+	stored, err := us.GetUserByID(ctx, created.ID)
+	if err != nil {
+		t.Fatalf("GetUserByID: %v", err)
+	}
+	if stored.LastName != "" || stored.Attributes["plan"] != "pro" || storage.ETag(stored) != storage.ETag(user) {
+		t.Fatalf("GetUserByID = %+v, want the patched user %+v", stored, user)
+	}
+
+	if _, err := us.PatchUser(ctx, created.ID, storage.ETag(stored), func(user *models.User) error {
+		user.Username = ""
+		return nil
+	}); !errors.Is(err, storage.ErrValidation) {
+		t.Fatalf("PatchUser clearing the username: %v, want ErrValidation", err)
+	}
+	if _, err := us.PatchUser(ctx, created.ID, storage.ETag(stored), func(user *models.User) error {
+		user.Username = "petr"
+		return nil
+	}); !errors.Is(err, storage.ErrAlreadyExists) {
+		t.Fatalf("PatchUser to a duplicate username: %v, want ErrAlreadyExists", err)
+	}
+	if _, err := us.PatchUser(ctx, created.ID+1000, "", func(*models.User) error { return nil }); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("PatchUser of a missing user: %v, want ErrNotFound", err)
+	}
+
+	if err := ss.CreateSegment(ctx, &models.Segment{Name: "AVITO_PRO", AutoPercent: 10, Rule: &models.Rule{
+		Attribute: "plan", Op: models.RuleOpEq, Value: "pro",
+	}}); err != nil {
+		t.Fatalf("CreateSegment: %v", err)
+	}
+
+	segment, err := ss.PatchSegment(ctx, "AVITO_PRO", "", func(segment *models.Segment) error {
+		segment.AutoPercent = 0
+		segment.Rule = nil
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("PatchSegment: %v", err)
+	}
+	if segment.AutoPercent != 0 || segment.Rule != nil || segment.Version != 2 {
+		t.Fatalf("PatchSegment = %+v, want the auto percent and the rule cleared of version 2", segment)
+	}
+
+	storedSegment, err := ss.GetSegmentByName(ctx, "AVITO_PRO")
+	if err != nil {
+		t.Fatalf("GetSegmentByName: %v", err)
+	}
+	if storedSegment.AutoPercent != 0 || storedSegment.Rule != nil || storedSegment.Type != models.SegmentTypeStatic {
+		t.Fatalf("GetSegmentByName = %+v, want the patched segment", storedSegment)
+	}
+
+	if _, err := ss.PatchSegment(ctx, "AVITO_PRO", "", func(segment *models.Segment) error {
+		segment.Percent = 10
+		return nil
+	}); !errors.Is(err, storage.ErrValidation) {
+		t.Fatalf("PatchSegment setting the percent of a static segment: %v, want ErrValidation", err)
+	}
+	if _, err := ss.PatchSegment(ctx, "MISSING", "", func(*models.Segment) error { return nil }); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("PatchSegment of a missing segment: %v, want ErrNotFound", err)
+	}
+}
+
+func testDeleteUserCascade(t *testing.T, us storage.UserStorage, ss storage.SegmentStorage) {
+	user := createUser(t, us, "ivan")
+	createSegment(t, ss, "AVITO_VOICE_MESSAGES")
+
+	if err := ss.AddUserToSegment(ctx, "AVITO_VOICE_MESSAGES", user.ID, nil, ""); err != nil {
+		t.Fatalf("AddUserToSegment: %v", err)
+	}
+
+	if err := us.DeleteUser(ctx, user.ID, ""); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+
+	if _, err := us.GetUserByID(ctx, user.ID); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("GetUserByID of a deleted user: %v, want ErrNotFound", err)
+	}
+
+	users := usersInSegment(t, ss, "AVITO_VOICE_MESSAGES")
+	if len(users) != 0 {
+		t.Fatalf("GetUsersInSegment returned %d users after the user is deleted, want 0", len(users))
+	}
+
+	if err := us.DeleteUser(ctx, user.ID, ""); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("DeleteUser of a missing user: %v, want ErrNotFound", err)
+	}
+}
+
+func testDeleteSegmentCascade(t *testing.T, us storage.UserStorage, ss storage.SegmentStorage) {
+	user := createUser(t, us, "ivan")
+	createSegment(t, ss, "AVITO_VOICE_MESSAGES")
+	createSegment(t, ss, "AVITO_DISCOUNT_30")
+
+	err := us.UpdateUserSegments(ctx, user.ID, []models.SegmentAssignment{
+		{Name: "AVITO_VOICE_MESSAGES"},
+		{Name: "AVITO_DISCOUNT_30"},
+	}, nil)
+	if err != nil {
+		t.Fatalf("UpdateUserSegments: %v", err)
+	}
+
+	if err := ss.DeleteSegmentBySlug(ctx, "AVITO_VOICE_MESSAGES", ""); err != nil {
+		t.Fatalf("DeleteSegmentBySlug: %v", err)
+	}
+
+	assertSegments(t, userSegments(t, us, user.ID), "AVITO_DISCOUNT_30")
+
+	if _, err := ss.GetSegmentByName(ctx, "AVITO_VOICE_MESSAGES"); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("GetSegmentByName of a deleted segment: %v, want ErrNotFound", err)
+	}
+
+	if err := ss.DeleteSegmentBySlug(ctx, "AVITO_VOICE_MESSAGES", ""); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("DeleteSegmentBySlug of a missing segment: %v, want ErrNotFound", err)
+	}
+
+	if err := ss.CreateSegment(ctx, &models.Segment{Name: "AVITO_DISCOUNT_30"}); !errors.Is(err, storage.ErrAlreadyExists) {
+		t.Fatalf("CreateSegment with a duplicate name: %v, want ErrAlreadyExists", err)
+	}
+}
+
+func testUpdateUserSegments(t *testing.T, us storage.UserStorage, ss storage.SegmentStorage) {
+	user := createUser(t, us, "ivan")
+	createSegment(t, ss, "AVITO_VOICE_MESSAGES")
+	createSegment(t, ss, "AVITO_PERFORMANCE_VAS")
+	createSegment(t, ss, "AVITO_DISCOUNT_30")
+
+	err := us.UpdateUserSegments(ctx, user.ID, []models.SegmentAssignment{{Name: "AVITO_DISCOUNT_30"}}, nil)
+	if err != nil {
+		t.Fatalf("UpdateUserSegments: %v", err)
+	}
+
+	// a segment both added and removed is left untouched
+	err = us.UpdateUserSegments(ctx, user.ID, []models.SegmentAssignment{
+		{Name: "AVITO_VOICE_MESSAGES"},
+		{Name: "AVITO_PERFORMANCE_VAS"},
+	}, []string{"AVITO_DISCOUNT_30", "AVITO_PERFORMANCE_VAS"})
+	if err != nil {
+		t.Fatalf("UpdateUserSegments: %v", err)
+	}
+
+	assertSegments(t, userSegments(t, us, user.ID), "AVITO_VOICE_MESSAGES")
+
+	segment, err := ss.GetSegmentByName(ctx, "AVITO_VOICE_MESSAGES")
+	if err != nil {
+		t.Fatalf("GetSegmentByName: %v", err)
+	}
+	if len(segment.Users) != 1 || segment.Users[0].ID != user.ID {
+		t.Fatalf("segment users = %+v, want the user", segment.Users)
+	}
+
+	err = us.UpdateUserSegments(ctx, user.ID+1000, []models.SegmentAssignment{{Name: "AVITO_DISCOUNT_30"}}, nil)
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("UpdateUserSegments of a missing user: %v, want ErrNotFound", err)
+	}
+}
+
+func testUpdateUserSegmentsIsTransactional(t *testing.T, us storage.UserStorage, ss storage.SegmentStorage) {
+	user := createUser(t, us, "ivan")
+	createSegment(t, ss, "AVITO_VOICE_MESSAGES")
+	createSegment(t, ss, "AVITO_DISCOUNT_30")
+
+	if err := ss.AddUserToSegment(ctx, "AVITO_DISCOUNT_30", user.ID, nil, ""); err != nil {
+		t.Fatalf("AddUserToSegment: %v", err)
+	}
+
+	err := us.UpdateUserSegments(ctx, user.ID, []models.SegmentAssignment{
+		{Name: "AVITO_VOICE_MESSAGES"},
+		{Name: "MISSING_SEGMENT"},
+	}, []string{"AVITO_DISCOUNT_30"})
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("UpdateUserSegments with a missing segment: %v, want ErrNotFound", err)
+	}
+
+	assertSegments(t, userSegments(t, us, user.ID), "AVITO_DISCOUNT_30")
+}
+
+func testAddAndDeleteUserInSegment(t *testing.T, us storage.UserStorage, ss storage.SegmentStorage) {
+	user := createUser(t, us, "ivan")
+	createSegment(t, ss, "AVITO_VOICE_MESSAGES")
+
+	if err := ss.AddUserToSegment(ctx, "AVITO_VOICE_MESSAGES", user.ID, nil, ""); err != nil {
+		t.Fatalf("AddUserToSegment: %v", err)
+	}
+	// adding twice is not an error
+	if err := ss.AddUserToSegment(ctx, "AVITO_VOICE_MESSAGES", user.ID, nil, ""); err != nil {
+		t.Fatalf("AddUserToSegment: %v", err)
+	}
+
+	users := usersInSegment(t, ss, "AVITO_VOICE_MESSAGES")
+	if len(users) != 1 || users[0].ID != user.ID {
+		t.Fatalf("GetUsersInSegment = %+v, want the user", users)
+	}
+
+	if err := ss.AddUserToSegment(ctx, "MISSING_SEGMENT", user.ID, nil, ""); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("AddUserToSegment to a missing segment: %v, want ErrNotFound", err)
+	}
+	if err := ss.AddUserToSegment(ctx, "AVITO_VOICE_MESSAGES", user.ID+1000, nil, ""); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("AddUserToSegment of a missing user: %v, want ErrNotFound", err)
+	}
+
+	if err := ss.DeleteUserFromSegment(ctx, "AVITO_VOICE_MESSAGES", user.ID); err != nil {
+		t.Fatalf("DeleteUserFromSegment: %v", err)
+	}
+
+	assertSegments(t, userSegments(t, us, user.ID))
+}
+
+func testSegmentsHistory(t *testing.T, us storage.UserStorage, ss storage.SegmentStorage) {
+	from := time.Now().Add(-time.Minute)
+
+	user := createUser(t, us, "ivan")
+	createSegment(t, ss, "AVITO_VOICE_MESSAGES")
+	createSegment(t, ss, "AVITO_DISCOUNT_30")
+
+	err := us.UpdateUserSegments(ctx, user.ID, []models.SegmentAssignment{
+		{Name: "AVITO_VOICE_MESSAGES"},
+		{Name: "AVITO_DISCOUNT_30"},
+	}, nil)
+	if err != nil {
+		t.Fatalf("UpdateUserSegments: %v", err)
+	}
+
+	// removing a segment the user is not in is not recorded
+	if err := ss.DeleteUserFromSegment(ctx, "AVITO_VOICE_MESSAGES", user.ID); err != nil {
+		t.Fatalf("DeleteUserFromSegment: %v", err)
+	}
+	if err := ss.DeleteUserFromSegment(ctx, "AVITO_VOICE_MESSAGES", user.ID); err != nil {
+		t.Fatalf("DeleteUserFromSegment: %v", err)
+	}
+
+	// deleting a segment records removal of its users
+	if err := ss.DeleteSegmentBySlug(ctx, "AVITO_DISCOUNT_30", ""); err != nil {
+		t.Fatalf("DeleteSegmentBySlug: %v", err)
+	}
+
+	history, err := us.GetUserSegmentsHistory(ctx, user.ID, from, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatalf("GetUserSegmentsHistory: %v", err)
+	}
+
+	count := make(map[models.Operation]int)
+	for _, event := range history {
+		if event.UserID != user.ID {
+			t.Fatalf("history event %+v of another user", event)
+		}
+		count[event.Operation]++
+	}
+	if len(history) != 4 || count[models.OperationAdd] != 2 || count[models.OperationRemove] != 2 {
+		t.Fatalf("history = %d events (%v), want 2 adds and 2 removes", len(history), count)
+	}
+
+	history, err = us.GetUserSegmentsHistory(ctx, user.ID, from.Add(-time.Hour), from)
+	if err != nil {
+		t.Fatalf("GetUserSegmentsHistory: %v", err)
+	}
+	if len(history) != 0 {
+		t.Fatalf("history before the period = %d events, want 0", len(history))
+	}
+}
+
+func testExpiredSegments(t *testing.T, us storage.UserStorage, ss storage.SegmentStorage) {
+	user := createUser(t, us, "ivan")
+	createSegment(t, ss, "AVITO_VOICE_MESSAGES")
+	createSegment(t, ss, "AVITO_DISCOUNT_30")
+
+	expiresAt := time.Now().Add(time.Second)
+	err := us.UpdateUserSegments(ctx, user.ID, []models.SegmentAssignment{
+		{Name: "AVITO_VOICE_MESSAGES"},
+		{Name: "AVITO_DISCOUNT_30", ExpiresAt: &expiresAt},
+	}, nil)
+	if err != nil {
+		t.Fatalf("UpdateUserSegments: %v", err)
+	}
+
+	assertSegments(t, userSegments(t, us, user.ID), "AVITO_DISCOUNT_30", "AVITO_VOICE_MESSAGES")
+
+	time.Sleep(time.Until(expiresAt) + 100*time.Millisecond)
+
+	// expired membership is hidden before it is deleted
+	assertSegments(t, userSegments(t, us, user.ID), "AVITO_VOICE_MESSAGES")
+
+	users := usersInSegment(t, ss, "AVITO_DISCOUNT_30")
+	if len(users) != 0 {
+		t.Fatalf("GetUsersInSegment returned %d users with expired membership, want 0", len(users))
+	}
+
+	deleted, err := us.DeleteExpiredSegments(ctx, time.Now())
+	if err != nil {
+		t.Fatalf("DeleteExpiredSegments: %v", err)
+	}
+	if deleted != 1 {
+		t.Fatalf("DeleteExpiredSegments = %d, want 1", deleted)
+	}
+
+	history, err := us.GetUserSegmentsHistory(ctx, user.ID, expiresAt.Add(-time.Millisecond), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatalf("GetUserSegmentsHistory: %v", err)
+	}
+	if len(history) != 1 || history[0].Operation != models.OperationRemove || history[0].SegmentName != "AVITO_DISCOUNT_30" {
+		t.Fatalf("history after expiration = %+v, want removal from AVITO_DISCOUNT_30", history)
+	}
+}
+
+func testAutoPercent(t *testing.T, us storage.UserStorage, ss storage.SegmentStorage) {
+	// an empty users table gives an empty segment
+	if err := ss.CreateSegment(ctx, &models.Segment{Name: "EMPTY", AutoPercent: 50}); err != nil {
+		t.Fatalf("CreateSegment: %v", err)
+	}
+	users := usersInSegment(t, ss, "EMPTY")
+	if len(users) != 0 {
+		t.Fatalf("GetUsersInSegment = %d users, want 0", len(users))
+	}
+
+	for i := 0; i < 10; i++ {
+		createUser(t, us, fmt.Sprintf("user%d", i))
+	}
+
+	for _, tt := range []struct {
+		name    string
+		percent float64
+		want    int
+	}{
+		{"AUTO_30", 30, 3},
+		{"AUTO_35", 35, 4},
+		{"AUTO_100", 100, 10},
+	} {
+		if err := ss.CreateSegment(ctx, &models.Segment{Name: tt.name, AutoPercent: tt.percent}); err != nil {
+			t.Fatalf("CreateSegment(%s): %v", tt.name, err)
+		}
+
+		users := usersInSegment(t, ss, tt.name)
+		if len(users) != tt.want {
+			t.Fatalf("GetUsersInSegment(%s) = %d users, want %d", tt.name, len(users), tt.want)
+		}
+	}
+
+	// users created later get into a 100% segment
+	user := createUser(t, us, "late")
+	users = usersInSegment(t, ss, "AUTO_100")
+	if len(users) != 11 {
+		t.Fatalf("GetUsersInSegment(AUTO_100) = %d users after user %d is created, want 11", len(users), user.ID)
+	}
+}
+
+func testExperiment(t *testing.T, us storage.UserStorage, ss storage.SegmentStorage) {
+	var users []*models.User
+	for i := 0; i < 100; i++ {
+		users = append(users, createUser(t, us, fmt.Sprintf("user%d", i)))
+	}
+
+	if err := ss.CreateSegment(ctx, &models.Segment{Name: "STATIC", Percent: 10}); !errors.Is(err, storage.ErrValidation) {
+		t.Fatalf("CreateSegment of a static segment with percent = %v, want ErrValidation", err)
+	}
+	experiment := &models.Segment{Name: "EXPERIMENT", Type: models.SegmentTypeExperiment, AutoPercent: 10}
+	if err := ss.CreateSegment(ctx, experiment); !errors.Is(err, storage.ErrValidation) {
+		t.Fatalf("CreateSegment of an experiment with auto percent = %v, want ErrValidation", err)
+	}
+
+	experiment = &models.Segment{Name: "EXPERIMENT", Type: models.SegmentTypeExperiment, Salt: "salt", Percent: 20}
+	if err := ss.CreateSegment(ctx, experiment); err != nil {
+		t.Fatalf("CreateSegment: %v", err)
+	}
+
+	members := func() map[int64]bool {
+		t.Helper()
+		set := make(map[int64]bool)
+		for _, user := range usersInSegment(t, ss, "EXPERIMENT") {
+			set[user.ID] = true
+		}
+		return set
+	}
+
+	before := members()
+	for _, user := range users {
+		want := storage.InExperiment("salt", 20, user.ID)
+		if before[user.ID] != want {
+			t.Fatalf("user %d in GetUsersInSegment = %t, want %t", user.ID, before[user.ID], want)
+		}
+
+		segments, err := us.GetUserSegments(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("GetUserSegments: %v", err)
+		}
+		if got := len(segments) == 1 && segments[0].Name == "EXPERIMENT"; got != want {
+			t.Fatalf("GetUserSegments(%d) = %+v, want in experiment %t", user.ID, segments, want)
+		}
+	}
+
+	for _, update := range []*models.Segment{
+		{Name: "EXPERIMENT", Percent: 150},
+		{Name: "EXPERIMENT", AutoPercent: 10},
+	} {
+		if _, err := ss.UpdateSegment(ctx, update, ""); !errors.Is(err, storage.ErrValidation) {
+			t.Fatalf("UpdateSegment(%+v) = %v, want ErrValidation", update, err)
+		}
+	}
+
+	// raising the percent keeps existing members
+	if _, err := ss.UpdateSegment(ctx, &models.Segment{Name: "EXPERIMENT", Percent: 50}, ""); err != nil {
+		t.Fatalf("UpdateSegment: %v", err)
+	}
+	after := members()
+	for id := range before {
+		if !after[id] {
+			t.Fatalf("user %d left the experiment after its percent was raised", id)
+		}
+	}
+	if len(after) <= len(before) {
+		t.Fatalf("experiment has %d members after raising the percent, had %d", len(after), len(before))
+	}
+
+	// a user put into an experiment explicitly is in it regardless of the bucket
+	var outsider *models.User
+	for _, user := range users {
+		if !after[user.ID] {
+			outsider = user
+			break
+		}
+	}
+	if err := ss.AddUserToSegment(ctx, "EXPERIMENT", outsider.ID, nil, ""); err != nil {
+		t.Fatalf("AddUserToSegment: %v", err)
+	}
+	assertSegments(t, userSegments(t, us, outsider.ID), "EXPERIMENT")
+	if got := members(); len(got) != len(after)+1 || !got[outsider.ID] {
+		t.Fatalf("experiment has %d members after adding user %d, want %d", len(got), outsider.ID, len(after)+1)
+	}
+
+	if _, err := us.GetUserSegments(ctx, users[len(users)-1].ID+1000); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("GetUserSegments of a missing user = %v, want ErrNotFound", err)
+	}
+}
+
+func testVariants(t *testing.T, us storage.UserStorage, ss storage.SegmentStorage) {
+	for _, variants := range [][]models.Variant{
+		{{Name: "control", Weight: 50}, {Name: "control", Weight: 50}},
+		{{Name: "control", Weight: 0}},
+		{{Name: "", Weight: 1}},
+	} {
+		err := ss.CreateSegment(ctx, &models.Segment{Name: "INVALID", Variants: variants})
+		if !errors.Is(err, storage.ErrValidation) {
+			t.Fatalf("CreateSegment with variants %+v = %v, want ErrValidation", variants, err)
+		}
+	}
+
+	segment := &models.Segment{Name: "CHECKOUT", Variants: []models.Variant{
+		{Name: "control", Weight: 50},
+		{Name: "treatment_a", Weight: 25},
+		{Name: "treatment_b", Weight: 25},
+	}}
+	if err := ss.CreateSegment(ctx, segment); err != nil {
+		t.Fatalf("CreateSegment: %v", err)
+	}
+
+	var users []*models.User
+	for i := 0; i < 40; i++ {
+		user := createUser(t, us, fmt.Sprintf("user%d", i))
+		if err := ss.AddUserToSegment(ctx, "CHECKOUT", user.ID, nil, ""); err != nil {
+			t.Fatalf("AddUserToSegment: %v", err)
+		}
+		users = append(users, user)
+	}
+
+	err := ss.AddUserToSegment(ctx, "CHECKOUT", users[0].ID, nil, "treatment_c")
+	if !errors.Is(err, storage.ErrValidation) {
+		t.Fatalf("AddUserToSegment into a missing variant = %v, want ErrValidation", err)
+	}
+	err = us.UpdateUserSegments(ctx, users[0].ID, []models.SegmentAssignment{{Name: "CHECKOUT", Variant: "treatment_c"}}, nil)
+	if !errors.Is(err, storage.ErrValidation) {
+		t.Fatalf("UpdateUserSegments into a missing variant = %v, want ErrValidation", err)
+	}
+
+	// an explicit variant is kept when the user is added again without one
+	explicit := storage.AssignVariant(segment, users[0].ID, nil)
+	if explicit == "treatment_b" {
+		explicit = "control"
+	} else {
+		explicit = "treatment_b"
+	}
+	err = us.UpdateUserSegments(ctx, users[0].ID, []models.SegmentAssignment{{Name: "CHECKOUT", Variant: explicit}}, nil)
+	if err != nil {
+		t.Fatalf("UpdateUserSegments: %v", err)
+	}
+	if err := ss.AddUserToSegment(ctx, "CHECKOUT", users[0].ID, nil, ""); err != nil {
+		t.Fatalf("AddUserToSegment: %v", err)
+	}
+
+	want := make(map[string]int64)
+	for i, user := range users {
+		wantVariant := storage.AssignVariant(segment, user.ID, nil)
+		if i == 0 {
+			wantVariant = explicit
+		}
+		want[wantVariant]++
+
+		got, err := us.GetUserByID(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("GetUserByID: %v", err)
+		}
+		if len(got.Segments) != 1 || got.Segments[0].Variant != wantVariant {
+			t.Fatalf("GetUserByID(%d).Segments = %+v, want CHECKOUT in variant %s", user.ID, got.Segments, wantVariant)
+		}
+	}
+
+	read, err := ss.GetSegmentByName(ctx, "CHECKOUT")
+	if err != nil {
+		t.Fatalf("GetSegmentByName: %v", err)
+	}
+	for _, variant := range segment.Variants {
+		if read.VariantCounts[variant.Name] != want[variant.Name] {
+			t.Fatalf("GetSegmentByName.VariantCounts = %v, want %v", read.VariantCounts, want)
+		}
+	}
+}
+
+func testRenameSegment(t *testing.T, us storage.UserStorage, ss storage.SegmentStorage) {
+	from := time.Now().Add(-time.Minute)
+
+	segment := &models.Segment{Name: "CHECKOUT", Variants: []models.Variant{
+		{Name: "control", Weight: 50},
+		{Name: "treatment", Weight: 50},
+	}}
+	if err := ss.CreateSegment(ctx, segment); err != nil {
+		t.Fatalf("CreateSegment: %v", err)
+	}
+	createSegment(t, ss, "AVITO_DISCOUNT_30")
+
+	var users []*models.User
+	for i := 0; i < 10; i++ {
+		user := createUser(t, us, fmt.Sprintf("user%d", i))
+		if err := ss.AddUserToSegment(ctx, "CHECKOUT", user.ID, nil, ""); err != nil {
+			t.Fatalf("AddUserToSegment: %v", err)
+		}
+		users = append(users, user)
+	}
+
+	_, err := ss.RenameSegment(ctx, "CHECKOUT", "AVITO_DISCOUNT_30", "", 0)
+	if !errors.Is(err, storage.ErrAlreadyExists) {
+		t.Fatalf("RenameSegment to an existing name = %v, want ErrAlreadyExists", err)
+	}
+	// the segment got users since it was created
+	_, err = ss.RenameSegment(ctx, "CHECKOUT", "CHECKOUT_V2", storage.ETag(segment), 0)
+	if !errors.Is(err, storage.ErrVersionMismatch) {
+		t.Fatalf("RenameSegment of a stale representation = %v, want ErrVersionMismatch", err)
+	}
+	_, err = ss.RenameSegment(ctx, "MISSING", "CHECKOUT_V2", "", 0)
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("RenameSegment of a missing segment = %v, want ErrNotFound", err)
+	}
+
+	current, err := ss.GetSegmentByName(ctx, "CHECKOUT")
+	if err != nil {
+		t.Fatalf("GetSegmentByName: %v", err)
+	}
+	renamed, err := ss.RenameSegment(ctx, "CHECKOUT", "CHECKOUT_V2", storage.ETag(current), time.Hour)
+	if err != nil {
+		t.Fatalf("RenameSegment: %v", err)
+	}
+	if renamed.Name != "CHECKOUT_V2" || renamed.Version != segment.Version+1 || len(renamed.Users) != len(users) {
+		t.Fatalf("RenameSegment = %s version %d with %d users, want CHECKOUT_V2 version %d with %d users",
+			renamed.Name, renamed.Version, len(renamed.Users), segment.Version+1, len(users))
+	}
+
+	// memberships and their variants follow the segment
+	for _, user := range users {
+		got, err := us.GetUserByID(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("GetUserByID: %v", err)
+		}
+		want := storage.AssignVariant(segment, user.ID, nil)
+		if len(got.Segments) != 1 || got.Segments[0].Name != "CHECKOUT_V2" || got.Segments[0].Variant != want {
+			t.Fatalf("GetUserByID(%d).Segments = %+v, want CHECKOUT_V2 in variant %s", user.ID, got.Segments, want)
+		}
+		if variant := storage.AssignVariant(renamed, user.ID, nil); variant != want {
+			t.Fatalf("AssignVariant of the renamed segment = %s, want %s", variant, want)
+		}
+	}
+
+	history, err := us.GetUserSegmentsHistory(ctx, users[0].ID, from, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatalf("GetUserSegmentsHistory: %v", err)
+	}
+	if len(history) != 1 || history[0].SegmentName != "CHECKOUT_V2" {
+		t.Fatalf("history = %+v, want an add to CHECKOUT_V2", history)
+	}
+
+	// the old name is an alias of the renamed segment
+	read, err := ss.GetSegmentByName(ctx, "CHECKOUT")
+	if err != nil {
+		t.Fatalf("GetSegmentByName by alias: %v", err)
+	}
+	if read.Name != "CHECKOUT_V2" {
+		t.Fatalf("GetSegmentByName by alias = %s, want CHECKOUT_V2", read.Name)
+	}
+	if err := ss.DeleteUserFromSegment(ctx, "CHECKOUT", users[0].ID); err != nil {
+		t.Fatalf("DeleteUserFromSegment by alias: %v", err)
+	}
+	if err := us.UpdateUserSegments(ctx, users[0].ID, []models.SegmentAssignment{{Name: "CHECKOUT"}}, nil); err != nil {
+		t.Fatalf("UpdateUserSegments by alias: %v", err)
+	}
+	assertSegments(t, userSegments(t, us, users[0].ID), "CHECKOUT_V2")
+
+	// creating a segment with the old name takes over the alias
+	createSegment(t, ss, "CHECKOUT")
+	if got := usersInSegment(t, ss, "CHECKOUT"); len(got) != 0 {
+		t.Fatalf("users in the new CHECKOUT = %d, want 0", len(got))
+	}
+
+	// without a TTL the old name is released
+	if _, err := ss.RenameSegment(ctx, "AVITO_DISCOUNT_30", "AVITO_DISCOUNT_50", "", 0); err != nil {
+		t.Fatalf("RenameSegment: %v", err)
+	}
+	if _, err := ss.GetSegmentByName(ctx, "AVITO_DISCOUNT_30"); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("GetSegmentByName of a released name = %v, want ErrNotFound", err)
+	}
+
+	// aliases are deleted once their TTL has passed
+	if _, err := ss.RenameSegment(ctx, "AVITO_DISCOUNT_50", "AVITO_DISCOUNT_70", "", time.Hour); err != nil {
+		t.Fatalf("RenameSegment: %v", err)
+	}
+	deleted, err := ss.DeleteExpiredAliases(ctx, time.Now())
+	if err != nil {
+		t.Fatalf("DeleteExpiredAliases: %v", err)
+	}
+	if deleted != 0 {
+		t.Fatalf("DeleteExpiredAliases before the TTL = %d, want 0", deleted)
+	}
+	deleted, err = ss.DeleteExpiredAliases(ctx, time.Now().Add(2*time.Hour))
+	if err != nil {
+		t.Fatalf("DeleteExpiredAliases: %v", err)
+	}
+	if deleted != 1 {
+		t.Fatalf("DeleteExpiredAliases after the TTL = %d, want 1", deleted)
+	}
+	if _, err := ss.GetSegmentByName(ctx, "AVITO_DISCOUNT_50"); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("GetSegmentByName of a deleted alias = %v, want ErrNotFound", err)
+	}
+}
+
+func testRules(t *testing.T, us storage.UserStorage, ss storage.SegmentStorage) {
+	for _, rule := range []*models.Rule{
+		{},
+		{Attribute: "country", Op: models.RuleOpEq, Value: "RU", And: []models.Rule{{Attribute: "plan", Op: models.RuleOpEq, Value: "pro"}}},
+		{Attribute: "country", Op: "like", Value: "RU"},
+		{Attribute: "country", Op: models.RuleOpIn, Value: []any{}},
+		{Attribute: "plan", Op: models.RuleOpGt, Value: true},
+		{Or: []models.Rule{}},
+	} {
+		err := ss.CreateSegment(ctx, &models.Segment{Name: "INVALID", Rule: rule})
+		if !errors.Is(err, storage.ErrValidation) {
+			t.Fatalf("CreateSegment with rule %+v = %v, want ErrValidation", rule, err)
+		}
+	}
+
+	users := map[string]map[string]any{
+		"ru_pro_new": {"country": "RU", "plan": "pro", "app_version": "7.10", "age": float64(30)},
+		"ru_pro_old": {"country": "RU", "plan": "pro", "app_version": "7.2", "age": float64(17)},
+		"kz_free":    {"country": "KZ", "plan": "free", "app_version": "8.0"},
+		"by_pro":     {"country": "BY", "plan": "pro", "app_version": "7.9"},
+		"none":       nil,
+	}
+	ids := make(map[string]int64, len(users))
+	for _, username := range []string{"ru_pro_new", "ru_pro_old", "kz_free", "by_pro", "none"} {
+		user := &models.User{FirstName: "Ivan", LastName: "Ivanov", Username: username, Attributes: users[username]}
+		if err := us.CreateUser(ctx, user); err != nil {
+			t.Fatalf("CreateUser(%s): %v", username, err)
+		}
+		ids[username] = user.ID
+	}
+
+	preview := func(rule *models.Rule) []string {
+		t.Helper()
+
+		page, err := ss.PreviewRule(ctx, rule, storage.ListParams{Limit: storage.MaxLimit, Sort: storage.SortByUsername})
+		if err != nil {
+			t.Fatalf("PreviewRule(%+v): %v", rule, err)
+		}
+		names := make([]string, 0, len(page.Items))
+		for _, user := range page.Items {
+			names = append(names, user.Username)
+		}
+		return names
+	}
+
+	for _, tt := range []struct {
+		rule *models.Rule
+		want []string
+	}{
+		{&models.Rule{Attribute: "country", Op: models.RuleOpEq, Value: "RU"}, []string{"ru_pro_new", "ru_pro_old"}},
+		{&models.Rule{Attribute: "country", Op: models.RuleOpNe, Value: "RU"}, []string{"by_pro", "kz_free"}},
+		{&models.Rule{Attribute: "country", Op: models.RuleOpIn, Value: []any{"KZ", "BY"}}, []string{"by_pro", "kz_free"}},
+		{&models.Rule{Attribute: "app_version", Op: models.RuleOpGte, Value: "7.9"}, []string{"by_pro", "kz_free", "ru_pro_new"}},
+		{&models.Rule{Attribute: "age", Op: models.RuleOpGte, Value: float64(18)}, []string{"ru_pro_new"}},
+		{&models.Rule{Not: &models.Rule{Attribute: "plan", Op: models.RuleOpEq, Value: "pro"}}, []string{"kz_free", "none"}},
+		{&models.Rule{Or: []models.Rule{
+			{Attribute: "country", Op: models.RuleOpEq, Value: "KZ"},
+			{Attribute: "age", Op: models.RuleOpLt, Value: float64(18)},
+		}}, []string{"kz_free", "ru_pro_old"}},
+	} {
+		if got := preview(tt.rule); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Fatalf("PreviewRule(%+v) = %v, want %v", tt.rule, got, tt.want)
+		}
+	}
+
+	rule := &models.Rule{And: []models.Rule{
+		{Attribute: "country", Op: models.RuleOpEq, Value: "RU"},
+		{Attribute: "plan", Op: models.RuleOpEq, Value: "pro"},
+		{Attribute: "app_version", Op: models.RuleOpGte, Value: "7.10"},
+	}}
+	if err := ss.CreateSegment(ctx, &models.Segment{Name: "NEW_CHECKOUT", Rule: rule}); err != nil {
+		t.Fatalf("CreateSegment: %v", err)
+	}
+
+	assertSegments(t, userSegments(t, us, ids["ru_pro_new"]), "NEW_CHECKOUT")
+	assertSegments(t, userSegments(t, us, ids["ru_pro_old"]))
+
+	segments, err := us.GetUserSegments(ctx, ids["ru_pro_new"])
+	if err != nil {
+		t.Fatalf("GetUserSegments: %v", err)
+	}
+	if len(segments) != 1 || segments[0].Name != "NEW_CHECKOUT" {
+		t.Fatalf("GetUserSegments = %+v, want NEW_CHECKOUT", segments)
+	}
+
+	// a user updating the app gets into the segment
+	update := &models.User{ID: ids["ru_pro_old"], Attributes: map[string]any{"country": "RU", "plan": "pro", "app_version": "7.11"}}
+	if _, err := us.UpdateUser(ctx, update, ""); err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+
+	members := usersInSegment(t, ss, "NEW_CHECKOUT")
+	if len(members) != 2 || members[0].ID != ids["ru_pro_new"] || members[1].ID != ids["ru_pro_old"] {
+		t.Fatalf("GetUsersInSegment = %+v, want ru_pro_new and ru_pro_old", members)
+	}
+
+	// an explicit member does not have to match the rule
+	if err := ss.AddUserToSegment(ctx, "NEW_CHECKOUT", ids["kz_free"], nil, ""); err != nil {
+		t.Fatalf("AddUserToSegment: %v", err)
+	}
+	assertSegments(t, userSegments(t, us, ids["kz_free"]), "NEW_CHECKOUT")
+	if members := usersInSegment(t, ss, "NEW_CHECKOUT"); len(members) != 3 {
+		t.Fatalf("GetUsersInSegment = %+v, want 3 users", members)
+	}
+
+	if _, err := ss.PreviewRule(ctx, &models.Rule{Attribute: "country"}, storage.ListParams{}); !errors.Is(err, storage.ErrValidation) {
+		t.Fatalf("PreviewRule with an invalid rule = %v, want ErrValidation", err)
+	}
+}
+
+func testEvaluate(t *testing.T, us storage.UserStorage, ss storage.SegmentStorage) {
+	ivan := createUser(t, us, "ivan")
+	petr := &models.User{FirstName: "Petr", LastName: "Petrov", Username: "petr", Attributes: map[string]any{"country": "RU"}}
+	if err := us.CreateUser(ctx, petr); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	createSegment(t, ss, "AVITO_VOICE_MESSAGES")
+	createSegment(t, ss, "AVITO_DISCOUNT_30")
+	checkout := &models.Segment{Name: "CHECKOUT", Variants: []models.Variant{{Name: "control", Weight: 1}, {Name: "treatment", Weight: 1}}}
+	if err := ss.CreateSegment(ctx, checkout); err != nil {
+		t.Fatalf("CreateSegment: %v", err)
+	}
+	if err := ss.CreateSegment(ctx, &models.Segment{Name: "EVERYONE", Type: models.SegmentTypeExperiment, Percent: 100}); err != nil {
+		t.Fatalf("CreateSegment: %v", err)
+	}
+	rule := &models.Rule{Attribute: "country", Op: models.RuleOpEq, Value: "RU"}
+	if err := ss.CreateSegment(ctx, &models.Segment{Name: "RUSSIA", Rule: rule}); err != nil {
+		t.Fatalf("CreateSegment: %v", err)
+	}
+
+	expired := time.Now().Add(-time.Minute)
+	err := us.UpdateUserSegments(ctx, ivan.ID, []models.SegmentAssignment{
+		{Name: "AVITO_VOICE_MESSAGES"},
+		{Name: "AVITO_DISCOUNT_30", ExpiresAt: &expired},
+		{Name: "CHECKOUT", Variant: "treatment"},
+	}, nil)
+	if err != nil {
+		t.Fatalf("UpdateUserSegments: %v", err)
+	}
+	// an explicit member of a computed segment is listed once
+	if err := ss.AddUserToSegment(ctx, "RUSSIA", petr.ID, nil, ""); err != nil {
+		t.Fatalf("AddUserToSegment: %v", err)
+	}
+
+	evaluations, err := us.EvaluateUsers(ctx, []int64{petr.ID, ivan.ID, petr.ID})
+	if err != nil {
+		t.Fatalf("EvaluateUsers: %v", err)
+	}
+	if len(evaluations) != 3 || evaluations[0].UserID != petr.ID || evaluations[1].UserID != ivan.ID || evaluations[2].UserID != petr.ID {
+		t.Fatalf("EvaluateUsers = %+v, want evaluations in the order of IDs", evaluations)
+	}
+
+	assertSegments(t, evaluations[0].Segments, "EVERYONE", "RUSSIA")
+	if len(evaluations[0].Variants) != 0 {
+		t.Fatalf("EvaluateUsers(%d).Variants = %v, want none", petr.ID, evaluations[0].Variants)
+	}
+	assertSegments(t, evaluations[1].Segments, "AVITO_VOICE_MESSAGES", "CHECKOUT", "EVERYONE")
+	if fmt.Sprint(evaluations[1].Variants) != "map[CHECKOUT:treatment]" {
+		t.Fatalf("EvaluateUsers(%d).Variants = %v, want CHECKOUT in treatment", ivan.ID, evaluations[1].Variants)
+	}
+
+	if _, err := us.EvaluateUsers(ctx, []int64{ivan.ID, ivan.ID + petr.ID + 1}); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("EvaluateUsers with a missing user = %v, want ErrNotFound", err)
+	}
+	if _, err := us.EvaluateUsers(ctx, nil); !errors.Is(err, storage.ErrValidation) {
+		t.Fatalf("EvaluateUsers without users = %v, want ErrValidation", err)
+	}
+	if _, err := us.EvaluateUsers(ctx, make([]int64, storage.MaxEvaluateUsers+1)); !errors.Is(err, storage.ErrValidation) {
+		t.Fatalf("EvaluateUsers with too many users = %v, want ErrValidation", err)
+	}
+}
+
+func testListUsersPagination(t *testing.T, us storage.UserStorage, ss storage.SegmentStorage) {
+	for _, username := range []string{"anna", "boris", "ivan", "igor", "petr"} {
+		createUser(t, us, username)
+	}
+
+	params := storage.ListParams{Limit: 2, Sort: storage.SortByUsername, Desc: true, WithTotal: true}
+	var usernames []string
+	for pages := 0; ; pages++ {
+		if pages > 3 {
+			t.Fatal("GetUsers does not stop paginating")
+		}
+
+		page, err := us.GetUsers(ctx, storage.UserFilter{}, params)
+		if err != nil {
+			t.Fatalf("GetUsers: %v", err)
+		}
+		if page.Total == nil || *page.Total != 5 {
+			t.Fatalf("GetUsers total = %v, want 5", page.Total)
+		}
+		for _, user := range page.Items {
+			usernames = append(usernames, user.Username)
+		}
+
+		if page.NextCursor == "" {
+			break
+		}
+		params.Cursor = page.NextCursor
+	}
+
+	if fmt.Sprint(usernames) != "[petr ivan igor boris anna]" {
+		t.Fatalf("paginated usernames = %v, want all users in descending order", usernames)
+	}
+
+	// a cursor is bound to the sort order it was issued for
+	params.Desc = false
+	if _, err := us.GetUsers(ctx, storage.UserFilter{}, params); !errors.Is(err, storage.ErrValidation) {
+		t.Fatalf("GetUsers with a cursor of another order: %v, want ErrValidation", err)
+	}
+	if _, err := us.GetUsers(ctx, storage.UserFilter{}, storage.ListParams{Cursor: "garbage"}); !errors.Is(err, storage.ErrValidation) {
+		t.Fatalf("GetUsers with an invalid cursor: %v, want ErrValidation", err)
+	}
+	if _, err := us.GetUsers(ctx, storage.UserFilter{}, storage.ListParams{Sort: "firstname"}); !errors.Is(err, storage.ErrValidation) {
+		t.Fatalf("GetUsers with an unknown sort: %v, want ErrValidation", err)
+	}
+
+	page, err := us.GetUsers(ctx, storage.UserFilter{UsernamePrefix: "i"}, storage.ListParams{WithTotal: true})
+	if err != nil {
+		t.Fatalf("GetUsers: %v", err)
+	}
+	if len(page.Items) != 2 || *page.Total != 2 || page.NextCursor != "" {
+		t.Fatalf("GetUsers with username prefix = %d users (total %d), want 2", len(page.Items), *page.Total)
+	}
+
+	createSegment(t, ss, "AVITO_VOICE_MESSAGES")
+	if err := ss.AddUserToSegment(ctx, "AVITO_VOICE_MESSAGES", page.Items[0].ID, nil, ""); err != nil {
+		t.Fatalf("AddUserToSegment: %v", err)
+	}
+
+	page, err = us.GetUsers(ctx, storage.UserFilter{Segment: "AVITO_VOICE_MESSAGES"}, storage.ListParams{})
+	if err != nil {
+		t.Fatalf("GetUsers: %v", err)
+	}
+	if len(page.Items) != 1 || len(page.Items[0].Segments) != 1 {
+		t.Fatalf("GetUsers in segment = %+v, want 1 user with the segment", page.Items)
+	}
+
+	if _, err := ss.GetUsersInSegment(ctx, "MISSING_SEGMENT", storage.UserFilter{}, storage.ListParams{}); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("GetUsersInSegment of a missing segment: %v, want ErrNotFound", err)
+	}
+}
+
+func testListSegmentsPagination(t *testing.T, _ storage.UserStorage, ss storage.SegmentStorage) {
+	for _, name := range []string{"AVITO_VOICE_MESSAGES", "AVITO_PERFORMANCE_VAS", "AVITO_DISCOUNT_30", "OTHER"} {
+		createSegment(t, ss, name)
+	}
+
+	params := storage.ListParams{Limit: 3}
+	page, err := ss.GetSegments(ctx, storage.SegmentFilter{}, params)
+	if err != nil {
+		t.Fatalf("GetSegments: %v", err)
+	}
+	if len(page.Items) != 3 || page.Items[0].Name != "AVITO_DISCOUNT_30" || page.NextCursor == "" {
+		t.Fatalf("GetSegments first page = %d segments, want 3 sorted by name and a cursor", len(page.Items))
+	}
+
+	params.Cursor = page.NextCursor
+	page, err = ss.GetSegments(ctx, storage.SegmentFilter{}, params)
+	if err != nil {
+		t.Fatalf("GetSegments: %v", err)
+	}
+	if len(page.Items) != 1 || page.Items[0].Name != "OTHER" || page.NextCursor != "" {
+		t.Fatalf("GetSegments last page = %+v, want the last segment only", page.Items)
+	}
+
+	page, err = ss.GetSegments(ctx, storage.SegmentFilter{NamePrefix: "AVITO_"}, storage.ListParams{Sort: storage.SortByCreatedAt})
+	if err != nil {
+		t.Fatalf("GetSegments: %v", err)
+	}
+	if len(page.Items) != 3 || page.Items[0].Name != "AVITO_VOICE_MESSAGES" {
+		t.Fatalf("GetSegments with name prefix = %+v, want 3 segments in creation order", page.Items)
+	}
+}
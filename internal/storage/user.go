@@ -1,12 +1,40 @@
 package storage
 
-import "github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/models"
+import (
+	"context"
+	"time"
+
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/models"
+)
+
+// MaxEvaluateUsers limits the number of users evaluated at once.
+const MaxEvaluateUsers = 1000
+
+// ValidateUser returns ErrValidation for a user which cannot be stored.
+func ValidateUser(user *models.User) error {
+	if user.Username == "" {
+		return Invalid("username", "required", "must not be empty")
+	}
+	return nil
+}
 
 type UserStorage interface {
-	CreateUser(user *models.User) error
-	GetUserByID(id int64) (*models.User, error)
-	GetUsers() ([]*models.User, error)
-	UpdateUser(user *models.User) error
-	DeleteUser(id int64) error
-	UpdateUserSegments(id int64, segmentsToAdd, segmentsToRemove []string) error
+	CreateUser(ctx context.Context, user *models.User) error
+	GetUserByID(ctx context.Context, id int64) (*models.User, error)
+	// GetUserSegments resolves the active segments of the user: explicit memberships and experiments
+	GetUserSegments(ctx context.Context, id int64) ([]models.Segment, error)
+	// EvaluateUsers resolves the active segment slugs of each user in the order of ids
+	EvaluateUsers(ctx context.Context, ids []int64) ([]models.Evaluation, error)
+	GetUsers(ctx context.Context, filter UserFilter, params ListParams) (*Page[models.User], error)
+	// UpdateUser updates the user and returns it as GetUserByID does. With a non-empty ifMatch the user is updated
+	// only while ifMatch is the ETag of its representation, which is compared while the user is locked.
+	UpdateUser(ctx context.Context, user *models.User, ifMatch string) (*models.User, error)
+	// PatchUser applies the patch to the user while it is locked, checking ifMatch like UpdateUser does,
+	// and writes the first name, the last name, the username and the attributes even when they are cleared
+	PatchUser(ctx context.Context, id int64, ifMatch string, patch func(user *models.User) error) (*models.User, error)
+	// DeleteUser deletes the user, checking ifMatch like UpdateUser does
+	DeleteUser(ctx context.Context, id int64, ifMatch string) error
+	UpdateUserSegments(ctx context.Context, id int64, segmentsToAdd []models.SegmentAssignment, segmentsToRemove []string) error
+	GetUserSegmentsHistory(ctx context.Context, id int64, from, to time.Time) ([]*models.UserSegmentHistory, error)
+	DeleteExpiredSegments(ctx context.Context, now time.Time) (int64, error)
 }
@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"fmt"
+
+	"github.com/lolwhatvvw/backend-trainee-assignment-2023/internal/models"
+)
+
+// Limits of segment variants, the sum of the weights never overflows.
+const (
+	MaxVariants      = 100
+	MaxVariantWeight = 1_000_000
+)
+
+// AssignVariant returns the variant of a member of the segment: the assigned one when it is set,
+// otherwise the one chosen by the hash of the segment and the user ID proportionally to the weights.
+// It is empty for segments without variants.
+func AssignVariant(segment *models.Segment, userID int64, assigned *string) string {
+	if len(segment.Variants) == 0 {
+		return ""
+	}
+	if assigned != nil {
+		return *assigned
+	}
+
+	var total uint32
+	for _, variant := range segment.Variants {
+		total += uint32(variant.Weight)
+	}
+
+	point := hash(VariantSeed(segment), userID) % total
+	for _, variant := range segment.Variants {
+		if point < uint32(variant.Weight) {
+			return variant.Name
+		}
+		point -= uint32(variant.Weight)
+	}
+
+	return segment.Variants[len(segment.Variants)-1].Name
+}
+
+// VariantSeed returns the seed of the variant hash of the segment. The segment name is a part of it,
+// so the variants of different segments are independent, and a renamed segment keeps the seed of its former name.
+func VariantSeed(segment *models.Segment) string {
+	if segment.VariantSeed != "" {
+		return segment.VariantSeed
+	}
+	return segment.Name + ":" + segment.Salt
+}
+
+// CheckVariant returns ErrValidation of the "variant" field unless the variant is empty or one of the segment variants.
+func CheckVariant(segment *models.Segment, variant string) error {
+	if variant == "" {
+		return nil
+	}
+	for _, v := range segment.Variants {
+		if v.Name == variant {
+			return nil
+		}
+	}
+	return Invalid("variant", "oneof", fmt.Sprintf("must be a variant of segment '%s', it has no variant '%s'", segment.Name, variant))
+}
+
+func validateVariants(variants []models.Variant) error {
+	if len(variants) > MaxVariants {
+		return Invalid("variants", "max", fmt.Sprintf("must have at most %d items", MaxVariants))
+	}
+
+	names := make(map[string]bool, len(variants))
+	for i, variant := range variants {
+		if variant.Name == "" {
+			return Invalid(fmt.Sprintf("variants[%d].name", i), "required", "must not be empty")
+		}
+		if names[variant.Name] {
+			return Invalid(fmt.Sprintf("variants[%d].name", i), "unique", fmt.Sprintf("must be unique, '%s' is repeated", variant.Name))
+		}
+		names[variant.Name] = true
+
+		if variant.Weight < 1 {
+			return Invalid(fmt.Sprintf("variants[%d].weight", i), "min", "must be at least 1")
+		}
+		if variant.Weight > MaxVariantWeight {
+			return Invalid(fmt.Sprintf("variants[%d].weight", i), "max", fmt.Sprintf("must be at most %d", MaxVariantWeight))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,269 @@
+// Package validate checks structs against the rules in their `validate` tags and reports
+// every violated rule with the JSON path of the field, e.g. segments_to_add[0].name.
+//
+// Rules of a tag are separated by commas, a rule with a parameter is written as name=param:
+//
+//	required     the value is not zero, a slice or a map is not empty
+//	omitempty    the other rules are skipped for a zero value
+//	min=N, max=N bounds of a number, the length in characters of a string or the number of items of a slice or a map
+//	oneof=A B    the string is one of the space-separated values
+//	slug         the string contains only A-Z, 0-9 and _
+//	duration     the string is a positive duration such as 720h
+//	dive         the following rules are applied to every item of a slice
+//
+// Nested structs and slices of structs are validated recursively, a struct implementing Validator
+// also reports violations of rules spanning several fields.
+package validate
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Violation is a rule a field does not pass.
+type Violation struct {
+	Field   string `json:"field" example:"segments_to_add[0].name"`
+	Rule    string `json:"rule" example:"slug"`
+	Message string `json:"message" example:"must contain only A-Z, 0-9 and _"`
+}
+
+// Errors are the violations of a validated struct.
+type Errors []Violation
+
+func (e Errors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, violation := range e {
+		messages = append(messages, fmt.Sprintf("%s %s", violation.Field, violation.Message))
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Validator is implemented by structs with rules spanning several fields.
+// Fields of the returned violations are relative to the struct.
+type Validator interface {
+	Validate() Errors
+}
+
+// rule returns the message of the violation or an empty string when the value passes.
+type rule func(value reflect.Value, param string) string
+
+var slugPattern = regexp.MustCompile(`^[A-Z0-9_]+$`)
+
+var rules = map[string]rule{
+	"required": required,
+	"min":      minimum,
+	"max":      maximum,
+	"oneof":    oneOf,
+	"slug":     slug,
+	"duration": duration,
+}
+
+// Struct validates the struct v points to, the returned error is Errors.
+func Struct(v any) error {
+	value := reflect.ValueOf(v)
+	for value.Kind() == reflect.Pointer {
+		if value.IsNil() {
+			return nil
+		}
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("validate: %T is not a struct", v))
+	}
+
+	var violations Errors
+	validateStruct(value, "", &violations)
+	if len(violations) > 0 {
+		return violations
+	}
+	return nil
+}
+
+func validateStruct(value reflect.Value, path string, violations *Errors) {
+	valueType := value.Type()
+	for i := 0; i < valueType.NumField(); i++ {
+		field := valueType.Field(i)
+		if !field.IsExported() && !field.Anonymous {
+			continue
+		}
+
+		// fields of embedded structs are flattened into the parent like encoding/json does
+		fieldPath := path
+		if !field.Anonymous {
+			fieldPath = join(path, fieldName(field))
+		}
+
+		fieldValue := value.Field(i)
+		if tag, ok := field.Tag.Lookup("validate"); ok {
+			validateValue(fieldValue, fieldPath, strings.Split(tag, ","), violations)
+		}
+		validateNested(fieldValue, fieldPath, violations)
+	}
+
+	if value.CanAddr() && value.Addr().CanInterface() {
+		if validator, ok := value.Addr().Interface().(Validator); ok {
+			for _, violation := range validator.Validate() {
+				violation.Field = join(path, violation.Field)
+				*violations = append(*violations, violation)
+			}
+		}
+	}
+}
+
+// validateValue applies the rules to the value and stops at the first violated one.
+func validateValue(value reflect.Value, path string, tagRules []string, violations *Errors) {
+	for i, tagRule := range tagRules {
+		name, param, _ := strings.Cut(strings.TrimSpace(tagRule), "=")
+		switch name {
+		case "":
+			continue
+		case "omitempty":
+			if value.IsZero() {
+				return
+			}
+			continue
+		case "dive":
+			if value.Kind() != reflect.Slice && value.Kind() != reflect.Array {
+				panic(fmt.Sprintf("validate: dive on %s of %s", value.Kind(), path))
+			}
+			for j := 0; j < value.Len(); j++ {
+				validateValue(value.Index(j), fmt.Sprintf("%s[%d]", path, j), tagRules[i+1:], violations)
+			}
+			return
+		}
+
+		check, ok := rules[name]
+		if !ok {
+			panic(fmt.Sprintf("validate: unknown rule '%s' of %s", name, path))
+		}
+		if message := check(value, param); message != "" {
+			*violations = append(*violations, Violation{Field: path, Rule: name, Message: message})
+			return
+		}
+	}
+}
+
+// validateNested validates structs the value holds.
+func validateNested(value reflect.Value, path string, violations *Errors) {
+	switch value.Kind() {
+	case reflect.Pointer:
+		if !value.IsNil() {
+			validateNested(value.Elem(), path, violations)
+		}
+	case reflect.Struct:
+		validateStruct(value, path, violations)
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < value.Len(); i++ {
+			item := value.Index(i)
+			if item.Kind() == reflect.Struct || item.Kind() == reflect.Pointer {
+				validateNested(item, fmt.Sprintf("%s[%d]", path, i), violations)
+			}
+		}
+	}
+}
+
+// fieldName returns the JSON name of the field.
+func fieldName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" || name == "-" {
+		return field.Name
+	}
+	return name
+}
+
+func join(path, field string) string {
+	if path == "" {
+		return field
+	}
+	return path + "." + field
+}
+
+func required(value reflect.Value, _ string) string {
+	switch value.Kind() {
+	case reflect.Slice, reflect.Map:
+		if value.Len() == 0 {
+			return "must not be empty"
+		}
+	case reflect.String:
+		if strings.TrimSpace(value.String()) == "" {
+			return "must not be empty"
+		}
+	default:
+		if value.IsZero() {
+			return "is required"
+		}
+	}
+	return ""
+}
+
+func minimum(value reflect.Value, param string) string {
+	return bound(value, param, func(got, limit float64) bool { return got >= limit }, "at least")
+}
+
+func maximum(value reflect.Value, param string) string {
+	return bound(value, param, func(got, limit float64) bool { return got <= limit }, "at most")
+}
+
+// bound compares the number, the length of the string or the number of items with the parameter.
+func bound(value reflect.Value, param string, ok func(got, limit float64) bool, relation string) string {
+	limit, err := strconv.ParseFloat(param, 64)
+	if err != nil {
+		panic(fmt.Sprintf("validate: invalid bound '%s'", param))
+	}
+
+	switch value.Kind() {
+	case reflect.String:
+		if !ok(float64(utf8.RuneCountInString(value.String())), limit) {
+			return fmt.Sprintf("must be %s %s characters long", relation, param)
+		}
+	case reflect.Slice, reflect.Map, reflect.Array:
+		if !ok(float64(value.Len()), limit) {
+			return fmt.Sprintf("must have %s %s items", relation, param)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if !ok(float64(value.Int()), limit) {
+			return fmt.Sprintf("must be %s %s", relation, param)
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if !ok(float64(value.Uint()), limit) {
+			return fmt.Sprintf("must be %s %s", relation, param)
+		}
+	case reflect.Float32, reflect.Float64:
+		if !ok(value.Float(), limit) {
+			return fmt.Sprintf("must be %s %s", relation, param)
+		}
+	default:
+		panic(fmt.Sprintf("validate: bound on %s", value.Kind()))
+	}
+	return ""
+}
+
+func oneOf(value reflect.Value, param string) string {
+	allowed := strings.Fields(param)
+	for _, option := range allowed {
+		if value.String() == option {
+			return ""
+		}
+	}
+	return fmt.Sprintf("must be one of %s", strings.Join(allowed, ", "))
+}
+
+func slug(value reflect.Value, _ string) string {
+	if !slugPattern.MatchString(value.String()) {
+		return "must contain only A-Z, 0-9 and _"
+	}
+	return ""
+}
+
+func duration(value reflect.Value, _ string) string {
+	d, err := time.ParseDuration(value.String())
+	if err != nil || d <= 0 {
+		return "must be a positive duration such as 720h"
+	}
+	return ""
+}
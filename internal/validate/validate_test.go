@@ -0,0 +1,263 @@
+package validate
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// violated runs Struct and returns the violations, failing when the error is of another type.
+func violated(t *testing.T, v any) Errors {
+	t.Helper()
+	err := Struct(v)
+	if err == nil {
+		return nil
+	}
+	var violations Errors
+	if !errors.As(err, &violations) {
+		t.Fatalf("Struct = %v, want Errors", err)
+	}
+	return violations
+}
+
+func TestRules(t *testing.T) {
+	type text struct {
+		Required string `json:"required" validate:"required"`
+		Optional string `json:"optional" validate:"omitempty,slug"`
+		Short    string `json:"short" validate:"max=3"`
+		Long     string `json:"long" validate:"min=2"`
+		Kind     string `json:"kind" validate:"omitempty,oneof=static experiment"`
+		TTL      string `json:"ttl" validate:"omitempty,duration"`
+	}
+	type numbers struct {
+		Count   int               `json:"count" validate:"required,max=10"`
+		Size    uint              `json:"size" validate:"min=1"`
+		Percent float64           `json:"percent" validate:"min=0,max=100"`
+		Items   []string          `json:"items" validate:"required,max=2"`
+		Labels  map[string]string `json:"labels" validate:"omitempty,min=2"`
+	}
+
+	tests := []struct {
+		name  string
+		value any
+		field string
+		rule  string
+	}{
+		{"required string", &text{Long: "ok"}, "required", "required"},
+		{"blank string", &text{Required: "  ", Long: "ok"}, "required", "required"},
+		{"slug", &text{Required: "a", Long: "ok", Optional: "lower"}, "optional", "slug"},
+		{"string max", &text{Required: "a", Long: "ok", Short: "четыре"}, "short", "max"},
+		{"string min counts characters", &text{Required: "a", Long: "я"}, "long", "min"},
+		{"oneof", &text{Required: "a", Long: "ok", Kind: "dynamic"}, "kind", "oneof"},
+		{"duration", &text{Required: "a", Long: "ok", TTL: "month"}, "ttl", "duration"},
+		{"negative duration", &text{Required: "a", Long: "ok", TTL: "-1h"}, "ttl", "duration"},
+		{"required number", &numbers{Size: 1, Items: []string{"a"}}, "count", "required"},
+		{"int max", &numbers{Count: 11, Size: 1, Items: []string{"a"}}, "count", "max"},
+		{"uint min", &numbers{Count: 1, Items: []string{"a"}}, "size", "min"},
+		{"float max", &numbers{Count: 1, Size: 1, Percent: 100.5, Items: []string{"a"}}, "percent", "max"},
+		{"float min", &numbers{Count: 1, Size: 1, Percent: -1, Items: []string{"a"}}, "percent", "min"},
+		{"required slice", &numbers{Count: 1, Size: 1, Items: []string{}}, "items", "required"},
+		{"slice max", &numbers{Count: 1, Size: 1, Items: []string{"a", "b", "c"}}, "items", "max"},
+		{"map min", &numbers{Count: 1, Size: 1, Items: []string{"a"}, Labels: map[string]string{"a": "b"}}, "labels", "min"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			violations := violated(t, tt.value)
+			if len(violations) != 1 || violations[0].Field != tt.field || violations[0].Rule != tt.rule {
+				t.Fatalf("violations = %+v, want %s of %s", violations, tt.rule, tt.field)
+			}
+		})
+	}
+}
+
+func TestValid(t *testing.T) {
+	type request struct {
+		Name    string   `json:"name" validate:"required,slug,max=128"`
+		Kind    string   `json:"kind" validate:"omitempty,oneof=static experiment"`
+		TTL     string   `json:"ttl" validate:"omitempty,duration"`
+		Percent float64  `json:"percent" validate:"min=0,max=100"`
+		Names   []string `json:"names" validate:"omitempty,dive,slug"`
+	}
+
+	for _, value := range []any{
+		&request{Name: "AVITO_VOICE_MESSAGES"},
+		&request{Name: "A", Kind: "experiment", TTL: "720h", Percent: 100, Names: []string{"B", "C_1"}},
+		request{Name: "BY_VALUE"},
+		(*request)(nil),
+	} {
+		if err := Struct(value); err != nil {
+			t.Errorf("Struct(%+v) = %v, want nil", value, err)
+		}
+	}
+}
+
+func TestRuleOrder(t *testing.T) {
+	type request struct {
+		Name string `json:"name" validate:"required,slug,max=3"`
+	}
+
+	// only the first violated rule of a field is reported
+	violations := violated(t, &request{Name: "lower_and_long"})
+	if len(violations) != 1 || violations[0].Rule != "slug" {
+		t.Fatalf("violations = %+v, want slug of name", violations)
+	}
+}
+
+func TestDive(t *testing.T) {
+	type request struct {
+		Names []string `json:"names" validate:"required,max=3,dive,required,slug"`
+	}
+
+	violations := violated(t, &request{Names: []string{"A", "", "b"}})
+	want := []Violation{
+		{Field: "names[1]", Rule: "required", Message: "must not be empty"},
+		{Field: "names[2]", Rule: "slug", Message: "must contain only A-Z, 0-9 and _"},
+	}
+	if len(violations) != len(want) {
+		t.Fatalf("violations = %+v, want %+v", violations, want)
+	}
+	for i := range want {
+		if violations[i] != want[i] {
+			t.Errorf("violation %d = %+v, want %+v", i, violations[i], want[i])
+		}
+	}
+
+	// rules before dive apply to the slice itself
+	violations = violated(t, &request{Names: []string{"A", "B", "C", "D"}})
+	if len(violations) != 1 || violations[0].Field != "names" || violations[0].Rule != "max" {
+		t.Fatalf("violations = %+v, want max of names", violations)
+	}
+}
+
+type item struct {
+	Name string `json:"name" validate:"required"`
+}
+
+type common struct {
+	Comment string `json:"comment" validate:"max=5"`
+}
+
+type nested struct {
+	common
+	Items []item `json:"a"`
+	Owner *item  `json:"owner"`
+	Plain item
+}
+
+func TestPaths(t *testing.T) {
+	violations := violated(t, &nested{
+		common: common{Comment: "too long"},
+		Items:  []item{{Name: "ok"}, {}},
+		Owner:  &item{},
+		Plain:  item{Name: "ok"},
+	})
+
+	fields := make([]string, 0, len(violations))
+	for _, violation := range violations {
+		fields = append(fields, violation.Field)
+	}
+	// the embedded struct is flattened, unexported embedded fields are still validated
+	if got, want := strings.Join(fields, " "), "comment a[1].name owner.name"; got != want {
+		t.Fatalf("fields = %s, want %s", got, want)
+	}
+
+	// without a JSON name the Go name is used
+	violations = violated(t, &nested{})
+	if len(violations) != 1 || violations[0].Field != "Plain.name" {
+		t.Fatalf("violations = %+v, want Plain.name", violations)
+	}
+}
+
+type window struct {
+	From int `json:"from"`
+	To   int `json:"to" validate:"min=0"`
+}
+
+func (w *window) Validate() Errors {
+	if w.From > w.To {
+		return Errors{{Field: "from", Rule: "lte_to", Message: "must not be after to"}}
+	}
+	return nil
+}
+
+func TestValidator(t *testing.T) {
+	type request struct {
+		Windows []window `json:"windows"`
+		Window  window   `json:"window"`
+	}
+
+	violations := violated(t, &request{
+		Windows: []window{{From: 1, To: 2}, {From: 3, To: 2}},
+		Window:  window{From: 0, To: -1},
+	})
+	want := []Violation{
+		{Field: "windows[1].from", Rule: "lte_to", Message: "must not be after to"},
+		{Field: "window.to", Rule: "min", Message: "must be at least 0"},
+		{Field: "window.from", Rule: "lte_to", Message: "must not be after to"},
+	}
+	if len(violations) != len(want) {
+		t.Fatalf("violations = %+v, want %+v", violations, want)
+	}
+	for i := range want {
+		if violations[i] != want[i] {
+			t.Errorf("violation %d = %+v, want %+v", i, violations[i], want[i])
+		}
+	}
+
+	// the hook of the validated struct itself reports fields without a prefix
+	violations = violated(t, &window{From: 2, To: 1})
+	if len(violations) != 1 || violations[0].Field != "from" {
+		t.Fatalf("violations = %+v, want from", violations)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	err := Errors{
+		{Field: "name", Rule: "required", Message: "must not be empty"},
+		{Field: "ttl", Rule: "duration", Message: "must be a positive duration such as 720h"},
+	}
+	if got, want := err.Error(), "name must not be empty; ttl must be a positive duration such as 720h"; got != want {
+		t.Fatalf("Error() = %q, want %q", got, want)
+	}
+}
+
+func TestPanics(t *testing.T) {
+	type unknownRule struct {
+		Name string `validate:"email"`
+	}
+	type diveOverString struct {
+		Name string `validate:"dive,required"`
+	}
+	type badBound struct {
+		Count int `validate:"max=ten"`
+	}
+	type boundOnBool struct {
+		Enabled bool `validate:"max=1"`
+	}
+
+	tests := []struct {
+		name  string
+		value any
+		want  string
+	}{
+		{"unknown rule", &unknownRule{}, "unknown rule 'email'"},
+		{"dive over a non-slice", &diveOverString{}, "dive on string"},
+		{"bad bound", &badBound{}, "invalid bound 'ten'"},
+		{"bound on an unsupported kind", &boundOnBool{}, "bound on bool"},
+		{"non-struct", new(int), "*int is not a struct"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				recovered := recover()
+				message, _ := recovered.(string)
+				if !strings.Contains(message, tt.want) {
+					t.Fatalf("panic = %v, want one containing %q", recovered, tt.want)
+				}
+			}()
+			Struct(tt.value)
+		})
+	}
+}
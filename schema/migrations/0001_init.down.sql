@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS "user_segments_history";
+
+DROP TABLE IF EXISTS "user_segments";
+
+DROP TABLE IF EXISTS "segment";
+
+DROP TABLE IF EXISTS "users";
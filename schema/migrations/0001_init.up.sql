@@ -0,0 +1,39 @@
+-- The baseline schema of the former schema/schema.sql. IF NOT EXISTS lets databases created from it adopt the migrations.
+
+CREATE TABLE IF NOT EXISTS "users" (
+  "id" bigserial PRIMARY KEY,
+  "firstname" varchar,
+  "lastname" varchar,
+  "username" varchar(128) NOT NULL UNIQUE,
+  "created_at" timestamptz DEFAULT (now())
+);
+
+CREATE TABLE IF NOT EXISTS "segment" (
+  "name" varchar PRIMARY KEY,
+  "created_at" timestamptz DEFAULT (now())
+);
+
+CREATE TABLE IF NOT EXISTS "user_segments" (
+  "user_id" bigint NOT NULL REFERENCES "users" ("id") ON DELETE CASCADE,
+  "segment_name" varchar NOT NULL REFERENCES "segment" ("name") ON DELETE CASCADE,
+  PRIMARY KEY ("user_id", "segment_name")
+);
+
+-- Later versions of schema/schema.sql added the history, the membership TTL and the auto percent.
+-- They are added only where missing, so a database created from any version ends up with the same schema.
+
+CREATE TABLE IF NOT EXISTS "user_segments_history" (
+  "id" bigserial PRIMARY KEY,
+  "user_id" bigint NOT NULL,
+  "segment_name" varchar NOT NULL,
+  "operation" varchar(16) NOT NULL,
+  "created_at" timestamptz NOT NULL DEFAULT (now())
+);
+
+CREATE INDEX IF NOT EXISTS "user_segments_history_user_id_created_at_idx" ON "user_segments_history" ("user_id", "created_at");
+
+ALTER TABLE "user_segments" ADD COLUMN IF NOT EXISTS "expires_at" timestamptz;
+
+CREATE INDEX IF NOT EXISTS "user_segments_expires_at_idx" ON "user_segments" ("expires_at") WHERE "expires_at" IS NOT NULL;
+
+ALTER TABLE "segment" ADD COLUMN IF NOT EXISTS "auto_percent" numeric(5, 2) NOT NULL DEFAULT 0 CHECK ("auto_percent" BETWEEN 0 AND 100);
@@ -0,0 +1,5 @@
+ALTER TABLE "segment"
+  DROP CONSTRAINT "segment_type_percent_check",
+  DROP COLUMN "percent",
+  DROP COLUMN "salt",
+  DROP COLUMN "type";
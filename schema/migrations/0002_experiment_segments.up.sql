@@ -0,0 +1,8 @@
+ALTER TABLE "segment"
+  ADD COLUMN "type" varchar(16) NOT NULL DEFAULT 'static' CHECK ("type" IN ('static', 'experiment')),
+  ADD COLUMN "salt" varchar(64) NOT NULL DEFAULT '',
+  ADD COLUMN "percent" numeric(5, 2) NOT NULL DEFAULT 0 CHECK ("percent" BETWEEN 0 AND 100),
+  -- auto percent samples users of static segments only, percent applies to experiments only
+  ADD CONSTRAINT "segment_type_percent_check" CHECK (
+    ("type" = 'static' AND "percent" = 0) OR ("type" = 'experiment' AND "auto_percent" = 0)
+  );
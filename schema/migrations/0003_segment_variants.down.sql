@@ -0,0 +1,3 @@
+ALTER TABLE "user_segments" DROP COLUMN "variant";
+
+ALTER TABLE "segment" DROP COLUMN "variants";
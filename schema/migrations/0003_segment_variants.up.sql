@@ -0,0 +1,4 @@
+ALTER TABLE "segment" ADD COLUMN "variants" jsonb;
+
+-- only explicitly assigned variants are stored, other members get the hashed variant
+ALTER TABLE "user_segments" ADD COLUMN "variant" varchar(64);
@@ -0,0 +1,3 @@
+ALTER TABLE "segment" DROP COLUMN "rule";
+
+ALTER TABLE "users" DROP COLUMN "attributes";
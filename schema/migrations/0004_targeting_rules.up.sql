@@ -0,0 +1,3 @@
+ALTER TABLE "users" ADD COLUMN "attributes" jsonb;
+
+ALTER TABLE "segment" ADD COLUMN "rule" jsonb;
@@ -0,0 +1,8 @@
+CREATE TABLE "api_keys" (
+  "id" bigserial PRIMARY KEY,
+  "name" varchar(128) NOT NULL,
+  "prefix" varchar(16) NOT NULL,
+  "hash" char(64) NOT NULL UNIQUE,
+  "scopes" jsonb NOT NULL,
+  "created_at" timestamptz DEFAULT (now())
+);
@@ -0,0 +1,17 @@
+-- the log is not linked to users and segments by foreign keys, so it survives their deletion
+CREATE TABLE "audit_log" (
+  "id" bigserial PRIMARY KEY,
+  "actor" varchar NOT NULL,
+  "actor_name" varchar NOT NULL DEFAULT '',
+  "action" varchar(64) NOT NULL,
+  "resource_type" varchar(32) NOT NULL,
+  "resource_id" varchar NOT NULL,
+  "before" jsonb,
+  "after" jsonb,
+  "request_id" varchar NOT NULL DEFAULT '',
+  "created_at" timestamptz NOT NULL DEFAULT (now())
+);
+
+CREATE INDEX "audit_log_resource_idx" ON "audit_log" ("resource_type", "resource_id", "id");
+CREATE INDEX "audit_log_actor_idx" ON "audit_log" ("actor", "id");
+CREATE INDEX "audit_log_created_at_idx" ON "audit_log" ("created_at");
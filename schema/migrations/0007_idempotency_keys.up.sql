@@ -0,0 +1,13 @@
+CREATE TABLE "idempotency_keys" (
+  "scope" varchar NOT NULL,
+  "key" varchar(255) NOT NULL,
+  "request_hash" char(64) NOT NULL,
+  "status_code" integer NOT NULL DEFAULT 0,
+  "content_type" varchar NOT NULL DEFAULT '',
+  "body" bytea,
+  "created_at" timestamptz NOT NULL DEFAULT (now()),
+  "expires_at" timestamptz NOT NULL,
+  PRIMARY KEY ("scope", "key")
+);
+
+CREATE INDEX "idempotency_keys_expires_at_idx" ON "idempotency_keys" ("expires_at");
@@ -0,0 +1,8 @@
+DROP TABLE "segment_aliases";
+
+ALTER TABLE "segment" DROP COLUMN "variant_seed";
+
+ALTER TABLE "user_segments"
+  DROP CONSTRAINT "user_segments_segment_name_fkey",
+  ADD CONSTRAINT "user_segments_segment_name_fkey"
+    FOREIGN KEY ("segment_name") REFERENCES "segment" ("name") ON DELETE CASCADE;
@@ -0,0 +1,17 @@
+-- renaming a segment updates the name of its memberships
+ALTER TABLE "user_segments"
+  DROP CONSTRAINT "user_segments_segment_name_fkey",
+  ADD CONSTRAINT "user_segments_segment_name_fkey"
+    FOREIGN KEY ("segment_name") REFERENCES "segment" ("name") ON DELETE CASCADE ON UPDATE CASCADE;
+
+-- the variant hash seed of the former name of a renamed segment, empty until the segment is renamed
+ALTER TABLE "segment" ADD COLUMN "variant_seed" varchar NOT NULL DEFAULT '';
+
+CREATE TABLE "segment_aliases" (
+  "alias" varchar PRIMARY KEY,
+  "segment_name" varchar NOT NULL REFERENCES "segment" ("name") ON DELETE CASCADE ON UPDATE CASCADE,
+  "expires_at" timestamptz NOT NULL,
+  "created_at" timestamptz NOT NULL DEFAULT (now())
+);
+
+CREATE INDEX "segment_aliases_segment_name_idx" ON "segment_aliases" ("segment_name");
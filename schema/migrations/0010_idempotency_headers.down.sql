@@ -0,0 +1,6 @@
+ALTER TABLE "idempotency_keys" ADD COLUMN "content_type" varchar NOT NULL DEFAULT '';
+
+UPDATE "idempotency_keys" SET "content_type" = "headers" -> 'Content-Type' ->> 0
+WHERE "headers" ? 'Content-Type';
+
+ALTER TABLE "idempotency_keys" DROP COLUMN "headers";
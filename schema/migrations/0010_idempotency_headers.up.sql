@@ -0,0 +1,7 @@
+-- every header of the stored response is replayed, not only its content type
+ALTER TABLE "idempotency_keys" ADD COLUMN "headers" jsonb NOT NULL DEFAULT '{}';
+
+UPDATE "idempotency_keys" SET "headers" = jsonb_build_object('Content-Type', jsonb_build_array("content_type"))
+WHERE "content_type" <> '';
+
+ALTER TABLE "idempotency_keys" DROP COLUMN "content_type";
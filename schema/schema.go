@@ -0,0 +1,9 @@
+// Package schema embeds the database migrations so the service binary can apply them.
+package schema
+
+import "embed"
+
+// Migrations holds the versioned migrations named <version>_<name>.up.sql and <version>_<name>.down.sql.
+//
+//go:embed migrations/*.sql
+var Migrations embed.FS